@@ -111,16 +111,31 @@ function build() {
 			CXX_PATH="mips64el-linux-musl-g++"
 		fi
 	fi
+	# pinned public key for upgrade packages
+	X_FLAGS=""
+	if [ ! -z "$UPGRADE_PUBLIC_KEY" ]; then
+		X_FLAGS="-X github.com/TeaOSLab/EdgeNode/internal/const.UpgradePublicKey=${UPGRADE_PUBLIC_KEY}"
+	fi
+
 	if [ ! -z $CC_PATH ]; then
-		env CC=$MUSL_DIR/$CC_PATH CXX=$MUSL_DIR/$CXX_PATH GOOS="${OS}" GOARCH="${ARCH}" CGO_ENABLED=1 go build -trimpath -tags $BUILD_TAG -o "$DIST"/bin/${NAME} -ldflags "-linkmode external -extldflags -static -s -w" "$ROOT"/../cmd/edge-node/main.go
+		env CC=$MUSL_DIR/$CC_PATH CXX=$MUSL_DIR/$CXX_PATH GOOS="${OS}" GOARCH="${ARCH}" CGO_ENABLED=1 go build -trimpath -tags $BUILD_TAG -o "$DIST"/bin/${NAME} -ldflags "-linkmode external -extldflags -static -s -w ${X_FLAGS}" "$ROOT"/../cmd/edge-node/main.go
 	else
-		env GOOS="${OS}" GOARCH="${ARCH}" CGO_ENABLED=1 go build -trimpath -tags $TAG -o "$DIST"/bin/${NAME} -ldflags="-s -w" "$ROOT"/../cmd/edge-node/main.go
+		env GOOS="${OS}" GOARCH="${ARCH}" CGO_ENABLED=1 go build -trimpath -tags $TAG -o "$DIST"/bin/${NAME} -ldflags="-s -w ${X_FLAGS}" "$ROOT"/../cmd/edge-node/main.go
 	fi
 
 	# delete hidden files
 	find "$DIST" -name ".DS_Store" -delete
 	find "$DIST" -name ".gitignore" -delete
 
+	# sign files with ed25519 private key (PEM)
+	if [ ! -z "$UPGRADE_PRIVATE_KEY" ]; then
+		echo "sign files"
+		cd "${DIST}" || exit
+		find . -type f ! -name "SHA256SUMS*" | sed 's#^\./##' | sort | xargs shasum -a 256 > SHA256SUMS
+		openssl pkeyutl -sign -inkey "$UPGRADE_PRIVATE_KEY" -rawin -in SHA256SUMS | base64 > SHA256SUMS.sig
+		cd - || exit
+	fi
+
 	echo "zip files"
 	cd "${DIST}/../" || exit
 	if [ -f "${ZIP}" ]; then
@@ -16,6 +16,7 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"path/filepath"
 	"sort"
 )
 
@@ -24,8 +25,17 @@ func main() {
 		Version(teaconst.Version).
 		Product(teaconst.ProductName).
 		Usage(teaconst.ProcessName + " [-v|start|stop|restart|status|quit|test|reload|service|daemon|pprof|accesslog]").
-		Usage(teaconst.ProcessName + " [trackers|goman|conns|gc]").
-		Usage(teaconst.ProcessName + " [ip.drop|ip.reject|ip.remove|ip.close] IP")
+		Usage(teaconst.ProcessName + " [trackers|goman|conns|gc|cache.admission]").
+		Usage(teaconst.ProcessName + " [ip.drop|ip.reject|ip.remove|ip.close] IP").
+		Usage(teaconst.ProcessName + " [config.history|config.rollback VERSION]").
+		Usage(teaconst.ProcessName + " cache.ls [--policy=ID] [--prefix=PREFIX] [--server=ID] [--limit=COUNT]").
+		Usage(teaconst.ProcessName + " [cache.inspect KEY|cache.export KEY FILE] [--policy=ID]").
+		Usage(teaconst.ProcessName + " cache.warmup [--file=PATH|--sitemap=URL] [--concurrency=COUNT] [--rate=COUNT_PER_SECOND] [--header=\"NAME: VALUE\"]").
+		Usage(teaconst.ProcessName + " [cache.warmup.status [ID]|cache.warmup.cancel ID]").
+		Usage(teaconst.ProcessName + " cache.fsck [--policy=ID] [--repair] [--rate=FILES_PER_SECOND]").
+		Usage(teaconst.ProcessName + " [cache.fsck.status|cache.fsck.cancel]").
+		Usage(teaconst.ProcessName + " cache.stat [--server=ID] [--reset]").
+		Usage(teaconst.ProcessName + " [quit|reload --binary] [--timeout=SECONDS]")
 
 	app.On("test", func() {
 		err := nodes.NewNode().Test()
@@ -35,7 +45,26 @@ func main() {
 	})
 	app.On("reload", func() {
 		var sock = gosock.NewTmpSock(teaconst.ProcessName)
-		reply, err := sock.Send(&gosock.Command{Code: "reload"})
+		var command = &gosock.Command{Code: "reload"}
+
+		// 使用新的可执行文件热升级
+		var options = app.ParseOptions(os.Args[2:])
+		_, ok := options["binary"]
+		if ok {
+			var timeoutSeconds = 0
+			timeout, ok := options["timeout"]
+			if ok && len(timeout) > 0 {
+				timeoutSeconds = types.Int(timeout[0])
+			}
+			command = &gosock.Command{
+				Code: "reload.binary",
+				Params: map[string]interface{}{
+					"timeout": timeoutSeconds,
+				},
+			}
+		}
+
+		reply, err := sock.Send(command)
 		if err != nil {
 			fmt.Println("[ERROR]" + err.Error())
 		} else {
@@ -59,8 +88,20 @@ func main() {
 		fmt.Println("done")
 	})
 	app.On("quit", func() {
+		var timeoutSeconds = 0
+		var options = app.ParseOptions(os.Args[2:])
+		timeout, ok := options["timeout"]
+		if ok && len(timeout) > 0 {
+			timeoutSeconds = types.Int(timeout[0])
+		}
+
 		var sock = gosock.NewTmpSock(teaconst.ProcessName)
-		_, err := sock.Send(&gosock.Command{Code: "quit"})
+		_, err := sock.Send(&gosock.Command{
+			Code: "quit",
+			Params: map[string]interface{}{
+				"timeout": timeoutSeconds,
+			},
+		})
 		if err != nil {
 			fmt.Println("[ERROR]quit failed: " + err.Error())
 			return
@@ -365,6 +406,387 @@ func main() {
 		}
 		fmt.Println(string(statsJSON))
 	})
+	app.On("cache.admission", func() {
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{Code: "cache.admission"})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		var statsMap = maps.NewMap(reply.Params).Get("stats")
+		statsJSON, err := json.MarshalIndent(statsMap, "", "  ")
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		fmt.Println(string(statsJSON))
+	})
+	app.On("cache.ls", func() {
+		var options = app.ParseOptions(os.Args[2:])
+		var params = map[string]any{}
+		for _, name := range []string{"policy", "prefix", "server", "limit"} {
+			values, ok := options[name]
+			if ok && len(values) > 0 {
+				switch name {
+				case "policy":
+					params["policyId"] = types.Int64(values[0])
+				case "server":
+					params["serverId"] = types.Int64(values[0])
+				case "limit":
+					params["limit"] = types.Int(values[0])
+				default:
+					params[name] = values[0]
+				}
+			}
+		}
+
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code:   "cache.ls",
+			Params: params,
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		var replyMap = maps.NewMap(reply.Params)
+		if len(replyMap.GetString("error")) > 0 {
+			fmt.Println("[ERROR]" + replyMap.GetString("error"))
+			return
+		}
+		resultJSON, err := json.MarshalIndent(reply.Params, "", "  ")
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		fmt.Println(string(resultJSON))
+	})
+	app.On("cache.inspect", func() {
+		var args = os.Args[2:]
+		if len(args) == 0 {
+			fmt.Println("Usage: edge-node cache.inspect KEY [--policy=ID]")
+			return
+		}
+		var options = app.ParseOptions(args[1:])
+		var params = map[string]any{
+			"key": args[0],
+		}
+		policy, ok := options["policy"]
+		if ok {
+			params["policyId"] = types.Int64(policy[0])
+		}
+
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code:   "cache.inspect",
+			Params: params,
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		var replyMap = maps.NewMap(reply.Params)
+		if len(replyMap.GetString("error")) > 0 {
+			fmt.Println("[ERROR]" + replyMap.GetString("error"))
+			return
+		}
+		var items = replyMap.GetSlice("items")
+		if len(items) == 0 {
+			fmt.Println("cache not found")
+			return
+		}
+		resultJSON, err := json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		fmt.Println(string(resultJSON))
+	})
+	app.On("cache.export", func() {
+		var args = os.Args[2:]
+		if len(args) < 2 {
+			fmt.Println("Usage: edge-node cache.export KEY FILE [--policy=ID]")
+			return
+		}
+
+		// 由节点进程写入文件，所以需要使用绝对路径
+		file, err := filepath.Abs(args[1])
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		var options = app.ParseOptions(args[2:])
+		var params = map[string]any{
+			"key":  args[0],
+			"file": file,
+		}
+		policy, ok := options["policy"]
+		if ok {
+			params["policyId"] = types.Int64(policy[0])
+		}
+
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code:   "cache.export",
+			Params: params,
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		var replyMap = maps.NewMap(reply.Params)
+		if len(replyMap.GetString("error")) > 0 {
+			fmt.Println("[ERROR]" + replyMap.GetString("error"))
+			return
+		}
+		fmt.Println("exported " + types.String(replyMap.GetInt64("size")) + " bytes to '" + file + "'")
+	})
+	app.On("cache.warmup", func() {
+		var options = app.ParseOptions(os.Args[2:])
+		var params = map[string]any{}
+		file, ok := options["file"]
+		if ok && len(file[0]) > 0 {
+			// 由节点进程读取文件，所以需要使用绝对路径
+			absFile, err := filepath.Abs(file[0])
+			if err != nil {
+				fmt.Println("[ERROR]" + err.Error())
+				return
+			}
+			params["file"] = absFile
+		}
+		sitemap, ok := options["sitemap"]
+		if ok {
+			params["sitemap"] = sitemap[0]
+		}
+		if params["file"] == nil && params["sitemap"] == nil {
+			fmt.Println("Usage: edge-node cache.warmup [--file=PATH|--sitemap=URL] [--concurrency=COUNT] [--rate=COUNT_PER_SECOND] [--header=\"NAME: VALUE\"]")
+			return
+		}
+		concurrency, ok := options["concurrency"]
+		if ok {
+			params["concurrency"] = types.Int(concurrency[0])
+		}
+		rate, ok := options["rate"]
+		if ok {
+			params["rate"] = types.Int(rate[0])
+		}
+		headers, ok := options["header"]
+		if ok {
+			params["headers"] = headers
+		}
+
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code:   "cache.warmup",
+			Params: params,
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		var replyMap = maps.NewMap(reply.Params)
+		if len(replyMap.GetString("error")) > 0 {
+			fmt.Println("[ERROR]" + replyMap.GetString("error"))
+			return
+		}
+		fmt.Println("warm-up job '" + types.String(replyMap.GetInt64("id")) + "' started, use 'edge-node cache.warmup.status " + types.String(replyMap.GetInt64("id")) + "' to view the progress")
+	})
+	app.On("cache.warmup.status", func() {
+		var params = map[string]any{}
+		if len(os.Args) > 2 {
+			params["id"] = types.Int64(os.Args[2])
+		}
+
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code:   "cache.warmup.status",
+			Params: params,
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		var replyMap = maps.NewMap(reply.Params)
+		if len(replyMap.GetString("error")) > 0 {
+			fmt.Println("[ERROR]" + replyMap.GetString("error"))
+			return
+		}
+		resultJSON, err := json.MarshalIndent(replyMap.Get("jobs"), "", "  ")
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		fmt.Println(string(resultJSON))
+	})
+	app.On("cache.warmup.cancel", func() {
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: edge-node cache.warmup.cancel ID")
+			return
+		}
+
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code: "cache.warmup.cancel",
+			Params: map[string]any{
+				"id": types.Int64(os.Args[2]),
+			},
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		var errString = maps.NewMap(reply.Params).GetString("error")
+		if len(errString) > 0 {
+			fmt.Println("[ERROR]" + errString)
+		} else {
+			fmt.Println("ok")
+		}
+	})
+	app.On("cache.fsck", func() {
+		var options = app.ParseOptions(os.Args[2:])
+		var params = map[string]any{}
+		policy, ok := options["policy"]
+		if ok && len(policy) > 0 {
+			params["policyId"] = types.Int64(policy[0])
+		}
+		_, ok = options["repair"]
+		if ok {
+			params["repair"] = true
+		}
+		rate, ok := options["rate"]
+		if ok && len(rate) > 0 {
+			params["rate"] = types.Int(rate[0])
+		}
+
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code:   "cache.fsck",
+			Params: params,
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		var replyMap = maps.NewMap(reply.Params)
+		if len(replyMap.GetString("error")) > 0 {
+			fmt.Println("[ERROR]" + replyMap.GetString("error"))
+			return
+		}
+		fmt.Println("fsck job '" + types.String(replyMap.GetInt64("id")) + "' started, use 'edge-node cache.fsck.status' to view the report")
+	})
+	app.On("cache.fsck.status", func() {
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code: "cache.fsck.status",
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		var replyMap = maps.NewMap(reply.Params)
+		if len(replyMap.GetString("error")) > 0 {
+			fmt.Println("[ERROR]" + replyMap.GetString("error"))
+			return
+		}
+		resultJSON, err := json.MarshalIndent(replyMap.Get("job"), "", "  ")
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		fmt.Println(string(resultJSON))
+	})
+	app.On("cache.fsck.cancel", func() {
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code: "cache.fsck.cancel",
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		var errString = maps.NewMap(reply.Params).GetString("error")
+		if len(errString) > 0 {
+			fmt.Println("[ERROR]" + errString)
+		} else {
+			fmt.Println("ok")
+		}
+	})
+	app.On("cache.stat", func() {
+		var options = app.ParseOptions(os.Args[2:])
+		var params = map[string]any{}
+		server, ok := options["server"]
+		if ok && len(server) > 0 {
+			params["serverId"] = types.Int64(server[0])
+		}
+		_, ok = options["reset"]
+		if ok {
+			params["reset"] = true
+		}
+
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code:   "cache.stat",
+			Params: params,
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		if params["reset"] != nil {
+			fmt.Println("ok")
+			return
+		}
+		resultJSON, err := json.MarshalIndent(reply.Params, "", "  ")
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		fmt.Println(string(resultJSON))
+	})
+	app.On("config.history", func() {
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{Code: "config.history"})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		resultJSON, err := json.MarshalIndent(reply.Params, "", "  ")
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+		fmt.Println(string(resultJSON))
+	})
+	app.On("config.rollback", func() {
+		var args = os.Args[2:]
+		if len(args) == 0 {
+			fmt.Println("Usage: edge-node config.rollback VERSION")
+			return
+		}
+		var version = types.Int64(args[0])
+		if version <= 0 {
+			fmt.Println("version '" + args[0] + "' is invalid")
+			return
+		}
+
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code: "config.rollback",
+			Params: map[string]any{
+				"version": version,
+			},
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+		} else {
+			var errString = maps.NewMap(reply.Params).GetString("error")
+			if len(errString) > 0 {
+				fmt.Println("[ERROR]" + errString)
+			} else {
+				fmt.Println("ok")
+			}
+		}
+	})
 	app.Run(func() {
 		var node = nodes.NewNode()
 		node.Start()
@@ -5,7 +5,9 @@ package caches
 const (
 	SuffixAll         = "@GOEDGE_"        // 通用后缀
 	SuffixWebP        = "@GOEDGE_WEBP"    // WebP后缀
+	SuffixImage       = "@GOEDGE_IMAGE_"  // 图片转换后缀 SuffixImage + 转换选项
 	SuffixCompression = "@GOEDGE_"        // 压缩后缀 SuffixCompression + Encoding
 	SuffixMethod      = "@GOEDGE_"        // 请求方法后缀 SuffixMethod + RequestMethod
 	SuffixPartial     = "@GOEDGE_partial" // 分区缓存后缀
+	SuffixSlice       = "@GOEDGE_slice_"  // 分片缓存后缀 SuffixSlice + 分片序号
 )
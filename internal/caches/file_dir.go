@@ -8,4 +8,6 @@ type FileDir struct {
 	Path     string
 	Capacity *shared.SizeCapacity
 	IsFull   bool
+
+	health *fileDirHealth
 }
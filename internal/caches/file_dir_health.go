@@ -0,0 +1,231 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+import (
+	"errors"
+	"github.com/TeaOSLab/EdgeNode/internal/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/iwind/TeaGo/Tea"
+	"github.com/iwind/TeaGo/types"
+	"io"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type fileDirStatus = int32
+
+const (
+	fileDirStatusHealthy  fileDirStatus = 0 // 正常
+	fileDirStatusDegraded fileDirStatus = 1 // 响应过慢，不再写入新内容，仍然可以读取
+	fileDirStatusFailed   fileDirStatus = 2 // 读写出错，不再读写
+)
+
+const (
+	fileDirMaxErrors     = 3                      // 连续出错多少次后停止使用
+	fileDirMaxSlowOps    = 5                      // 连续多少次慢操作后停止写入
+	fileDirSlowCost      = 500 * time.Millisecond // 慢操作耗时
+	fileDirProbeInterval = 10 * time.Second       // 检查停用目录的间隔
+	fileDirProbeFile     = ".health"              // 检查时写入的文件
+)
+
+var fileDirProbeData = make([]byte, 4096)
+
+// 缓存目录健康状态
+type fileDirHealth struct {
+	dir string
+
+	status       fileDirStatus
+	countErrors  int32 // 连续出错次数
+	countSlowOps int32 // 连续慢操作次数
+	readmittedAt int64 // 最近一次恢复使用的时间
+}
+
+func newFileDirHealth(dir string) *fileDirHealth {
+	return &fileDirHealth{
+		dir: dir,
+	}
+}
+
+// IsWritable 是否可以写入新内容
+func (this *fileDirHealth) IsWritable() bool {
+	return this == nil || atomic.LoadInt32(&this.status) == fileDirStatusHealthy
+}
+
+// IsReadable 是否可以读取
+func (this *fileDirHealth) IsReadable() bool {
+	return this == nil || atomic.LoadInt32(&this.status) != fileDirStatusFailed
+}
+
+// Status 当前状态
+func (this *fileDirHealth) Status() fileDirStatus {
+	if this == nil {
+		return fileDirStatusHealthy
+	}
+	return atomic.LoadInt32(&this.status)
+}
+
+// ReadmittedAt 最近一次恢复使用的时间，从未停用过时返回0
+func (this *fileDirHealth) ReadmittedAt() int64 {
+	if this == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&this.readmittedAt)
+}
+
+// Success 记录一次成功的操作
+// 返回状态是否有变化
+func (this *fileDirHealth) Success(cost time.Duration) (changed bool) {
+	if this == nil {
+		return false
+	}
+
+	if atomic.LoadInt32(&this.countErrors) > 0 {
+		atomic.StoreInt32(&this.countErrors, 0)
+	}
+
+	if cost < fileDirSlowCost {
+		if atomic.LoadInt32(&this.countSlowOps) > 0 {
+			atomic.StoreInt32(&this.countSlowOps, 0)
+		}
+		return false
+	}
+
+	if atomic.AddInt32(&this.countSlowOps, 1) >= fileDirMaxSlowOps {
+		return atomic.CompareAndSwapInt32(&this.status, fileDirStatusHealthy, fileDirStatusDegraded)
+	}
+	return false
+}
+
+// Fail 记录一次出错的操作
+// 返回状态是否有变化
+func (this *fileDirHealth) Fail() (changed bool) {
+	if this == nil {
+		return false
+	}
+
+	if atomic.AddInt32(&this.countErrors, 1) >= fileDirMaxErrors {
+		return atomic.SwapInt32(&this.status, fileDirStatusFailed) != fileDirStatusFailed
+	}
+	return false
+}
+
+// Readmit 恢复使用
+// 返回状态是否有变化
+func (this *fileDirHealth) Readmit() (changed bool) {
+	if this == nil {
+		return false
+	}
+
+	atomic.StoreInt32(&this.countErrors, 0)
+	atomic.StoreInt32(&this.countSlowOps, 0)
+	if atomic.SwapInt32(&this.status, fileDirStatusHealthy) == fileDirStatusHealthy {
+		return false
+	}
+	atomic.StoreInt64(&this.readmittedAt, time.Now().Unix())
+	return true
+}
+
+// 判断是否为磁盘读写错误
+func isDiskError(err error) bool {
+	return errors.Is(err, syscall.EIO) || errors.Is(err, syscall.EROFS)
+}
+
+// 记录某个目录的操作结果
+func (this *FileStorage) reportDir(health *fileDirHealth, cost time.Duration, err error) {
+	if health == nil {
+		return
+	}
+	if err != nil {
+		if health.Fail() {
+			remotelogs.Warn("CACHE", "disk '"+health.dir+"' of policy '"+types.String(this.policy.Id)+"' failed: "+err.Error()+", stop reading and writing it")
+		}
+		return
+	}
+	if health.Success(cost) {
+		remotelogs.Warn("CACHE", "disk '"+health.dir+"' of policy '"+types.String(this.policy.Id)+"' is too slow, stop writing new caches to it")
+	}
+}
+
+// 所有缓存目录的健康状态
+func (this *FileStorage) allDirHealths() []*fileDirHealth {
+	var result = []*fileDirHealth{this.mainDirHealth}
+	var subDirs = this.subDirs // copy slice
+	for _, subDir := range subDirs {
+		result = append(result, subDir.health)
+	}
+	return result
+}
+
+// 定时检查已停用的目录
+func (this *FileStorage) initDirHealthTicker() {
+	var interval = fileDirProbeInterval
+	if Tea.IsTesting() {
+		interval = 1 * time.Second
+	}
+	this.dirHealthTicker = utils.NewTicker(interval)
+	goman.New(func() {
+		for this.dirHealthTicker.Next() {
+			this.probeDirs()
+		}
+	})
+}
+
+// 检查已停用的目录，检查通过后恢复使用
+func (this *FileStorage) probeDirs() {
+	for _, health := range this.allDirHealths() {
+		if health == nil || health.Status() == fileDirStatusHealthy {
+			continue
+		}
+		var before = time.Now()
+		err := this.probeDir(health.dir)
+		if err != nil || time.Since(before) >= fileDirSlowCost {
+			continue
+		}
+		if health.Readmit() {
+			remotelogs.Println("CACHE", "disk '"+health.dir+"' of policy '"+types.String(this.policy.Id)+"' recovered, start using it again")
+		}
+	}
+}
+
+// 尝试在目录中写入、读取和删除一个文件
+func (this *FileStorage) probeDir(dir string) error {
+	var policyDir = dir + "/p" + types.String(this.policy.Id)
+	err := this.fs.MkdirAll(policyDir, 0777)
+	if err != nil {
+		return err
+	}
+
+	var path = policyDir + "/" + fileDirProbeFile
+	writer, err := this.fs.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(fileDirProbeData)
+	if err == nil {
+		err = writer.Sync()
+	}
+	closeErr := writer.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	reader, err := this.fs.OpenFile(path, os.O_RDONLY, 0444)
+	if err != nil {
+		return err
+	}
+	var buf = make([]byte, len(fileDirProbeData))
+	_, err = io.ReadFull(reader, buf)
+	_ = reader.Close()
+	if err != nil {
+		return err
+	}
+
+	return this.fs.Remove(path)
+}
@@ -0,0 +1,261 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/iwind/TeaGo/Tea"
+	stringutil "github.com/iwind/TeaGo/utils/string"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 在某个目录中注入读写错误的文件系统
+type faultFileSystem struct {
+	osFileSystem
+
+	dir   string
+	isOn  int32
+	errno syscall.Errno // 注入的错误，默认为EIO
+}
+
+func (this *faultFileSystem) SetOn(isOn bool) {
+	if isOn {
+		atomic.StoreInt32(&this.isOn, 1)
+	} else {
+		atomic.StoreInt32(&this.isOn, 0)
+	}
+}
+
+func (this *faultFileSystem) fault(op string, name string) error {
+	if atomic.LoadInt32(&this.isOn) == 1 && strings.HasPrefix(name, this.dir) {
+		var errno = this.errno
+		if errno == 0 {
+			errno = syscall.EIO
+		}
+		return &os.PathError{Op: op, Path: name, Err: errno}
+	}
+	return nil
+}
+
+func (this *faultFileSystem) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	err := this.fault("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return this.osFileSystem.OpenFile(name, flag, perm)
+}
+
+func (this *faultFileSystem) Stat(name string) (os.FileInfo, error) {
+	err := this.fault("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return this.osFileSystem.Stat(name)
+}
+
+func (this *faultFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	err := this.fault("mkdir", path)
+	if err != nil {
+		return err
+	}
+	return this.osFileSystem.MkdirAll(path, perm)
+}
+
+func (this *faultFileSystem) Remove(name string) error {
+	err := this.fault("remove", name)
+	if err != nil {
+		return err
+	}
+	return this.osFileSystem.Remove(name)
+}
+
+func TestFileDirHealth(t *testing.T) {
+	var health = newFileDirHealth("/data")
+	for i := 0; i < fileDirMaxSlowOps-1; i++ {
+		health.Success(1 * time.Second)
+	}
+	health.Success(1 * time.Millisecond)
+	health.Success(1 * time.Second)
+	if !health.IsWritable() {
+		t.Fatal("slow operations should be consecutive")
+	}
+	for i := 0; i < fileDirMaxSlowOps; i++ {
+		health.Success(1 * time.Second)
+	}
+	if health.IsWritable() || !health.IsReadable() {
+		t.Fatal("slow disk should be degraded")
+	}
+
+	for i := 0; i < fileDirMaxErrors; i++ {
+		health.Fail()
+	}
+	if health.IsReadable() {
+		t.Fatal("disk with errors should be failed")
+	}
+
+	if !health.Readmit() || !health.IsWritable() || health.ReadmittedAt() == 0 {
+		t.Fatal("disk should be readmitted")
+	}
+}
+
+func TestFileStorage_DiskFailover(t *testing.T) {
+	var storage = NewFileStorage(&serverconfigs.HTTPCachePolicy{
+		Id:   11,
+		IsOn: true,
+		Options: map[string]interface{}{
+			"dir": Tea.Root + "/caches",
+		},
+	})
+	err := storage.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Stop()
+
+	var subDir = Tea.Root + "/caches-disk2"
+	storage.subDirs = []*FileDir{
+		{
+			Path:   subDir,
+			health: newFileDirHealth(subDir),
+		},
+	}
+	var fs = &faultFileSystem{dir: subDir}
+	storage.fs = fs
+
+	// 查找一个存放在第二块磁盘上的Key
+	var key string
+	for i := 0; i < 100; i++ {
+		key = "https://example.com/failover/" + strconv.Itoa(i)
+		if storage.dirIndex(stringutil.Md5(key), 1) == 1 {
+			break
+		}
+	}
+
+	var write = func(body string) {
+		writer, err := storage.openWriter(key, time.Now().Unix()+3600+int64(len(body)), 200, -1, -1, -1, false, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = writer.WriteHeader([]byte("Content-Type:text/plain\n"))
+		_, _ = writer.Write([]byte(body))
+		err = writer.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		storage.AddToList(&Item{
+			Type:       writer.ItemType(),
+			Key:        key,
+			ExpiredAt:  writer.ExpiredAt(),
+			HeaderSize: writer.HeaderSize(),
+			BodySize:   writer.BodySize(),
+		})
+		time.Sleep(1 * time.Second) // 等待索引写入
+	}
+	var read = func() (bodySize int64, err error) {
+		reader, err := storage.OpenReader(key, false, false)
+		if err != nil {
+			return 0, err
+		}
+		_ = reader.Close()
+		return reader.BodySize(), nil
+	}
+
+	write("hello")
+	hash, path, _ := storage.keyPath(key)
+	if !strings.HasPrefix(path, subDir) {
+		t.Fatal("key should be in '" + subDir + "'")
+	}
+
+	// 不是磁盘错误时不停用磁盘
+	fs.errno = syscall.EACCES
+	fs.SetOn(true)
+	for i := 0; i < fileDirMaxErrors*2; i++ {
+		_, _ = read()
+	}
+	if !storage.dirHealth(hash).IsReadable() {
+		t.Fatal("disk should not be failed by non-disk errors")
+	}
+	fs.SetOn(false)
+	fs.errno = 0
+
+	// 磁盘出错后停止读取，并清理索引
+	fs.SetOn(true)
+	for i := 0; i < fileDirMaxErrors; i++ {
+		_, err = read()
+		if err == nil || err == ErrNotFound {
+			t.Fatal("expect disk error")
+		}
+	}
+	_, err = read()
+	if err != ErrNotFound {
+		t.Fatal("expect not found on failed disk, but got:", err)
+	}
+	exists, _ := storage.list.Exist(hash)
+	if exists {
+		t.Fatal("index should be removed")
+	}
+
+	// 新内容写入到主目录
+	write("hello, world")
+	fallbackPath, _ := storage.fallbackPath(hash, storage.dirHealth(hash))
+	if !strings.HasPrefix(fallbackPath, Tea.Root+"/caches/") {
+		t.Fatal("new content should be written to main dir, but got:", fallbackPath)
+	}
+	bodySize, err := read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bodySize != 12 {
+		t.Fatal("expect new content, but got size:", bodySize)
+	}
+
+	// 恢复后仍然读取备用目录中的新内容
+	var before = time.Now().Add(-1 * time.Hour)
+	_ = os.Chtimes(path, before, before)
+	fs.SetOn(false)
+	storage.probeDirs()
+	if !storage.dirHealth(hash).IsWritable() {
+		t.Fatal("disk should be readmitted")
+	}
+	bodySize, err = read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bodySize != 12 {
+		t.Fatal("expect content in fallback dir, but got size:", bodySize)
+	}
+
+	// 备用目录中的文件不存在时，第二块磁盘上的旧内容不能再被读取
+	fallbackData, err := os.ReadFile(fallbackPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Remove(fallbackPath)
+	_, err = read()
+	if err != ErrNotFound {
+		t.Fatal("stale content should not be read, but got:", err)
+	}
+
+	// 再次写入到第二块磁盘后删除备用目录中的文件
+	err = os.WriteFile(fallbackPath, fallbackData, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("hello, world!")
+	if storage.fileExists(fallbackPath) {
+		t.Fatal("file in fallback dir should be removed")
+	}
+	bodySize, err = read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bodySize != 13 {
+		t.Fatal("expect new content, but got size:", bodySize)
+	}
+}
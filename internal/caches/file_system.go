@@ -0,0 +1,32 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+import "os"
+
+// 缓存文件使用的文件系统操作，测试时可以替换为注入故障的实现
+type fileSystem interface {
+	OpenFile(name string, flag int, perm os.FileMode) (*os.File, error)
+	Stat(name string) (os.FileInfo, error)
+	MkdirAll(path string, perm os.FileMode) error
+	Remove(name string) error
+}
+
+type osFileSystem struct {
+}
+
+func (this osFileSystem) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (this osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (this osFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (this osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
@@ -0,0 +1,12 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+// ItemInfo 缓存项所在位置等详细信息，用于查看缓存
+type ItemInfo struct {
+	*Item
+
+	Storage string `json:"storage"` // 存储方式：file、memory、segment
+	Path    string `json:"path"`    // 所在文件路径，内存缓存为空
+	Offset  int64  `json:"offset"`  // 在文件中的位置，只有段存储才有
+}
@@ -346,6 +346,38 @@ func (this *FileList) IncreaseHit(hash string) error {
 	return db.IncreaseHitAsync(hash)
 }
 
+// ListItems 列出缓存项
+func (this *FileList) ListItems(prefix string, serverId int64, callback func(hash string, item *Item) (goNext bool)) error {
+	for _, db := range this.dbList {
+		var lastId int64
+		for {
+			hashList, itemList, maxId, err := db.ListItems(lastId, prefix, serverId, 1000)
+			if err != nil {
+				return err
+			}
+			if len(hashList) == 0 {
+				break
+			}
+			for index, hash := range hashList {
+				if !callback(hash, itemList[index]) {
+					return nil
+				}
+			}
+			lastId = maxId
+		}
+	}
+	return nil
+}
+
+// Item 读取单个缓存项
+func (this *FileList) Item(hash string) (*Item, error) {
+	var db = this.GetDB(hash)
+	if !db.IsReady() {
+		return nil, nil
+	}
+	return db.ItemWithHash(hash)
+}
+
 // OnAdd 添加事件
 func (this *FileList) OnAdd(f func(item *Item)) {
 	this.onAdd = f
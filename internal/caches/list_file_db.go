@@ -3,6 +3,7 @@
 package caches
 
 import (
+	"database/sql"
 	"errors"
 	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
 	"github.com/TeaOSLab/EdgeNode/internal/goman"
@@ -375,6 +376,75 @@ func (this *FileListDB) ListHashes(lastId int64) (hashList []string, maxId int64
 	return
 }
 
+// ListItems 按ID顺序分页列出缓存项，用于查看缓存
+// prefix 不为空时只列出Key以此开头的缓存项，serverId 大于0时只列出此网站的缓存项
+func (this *FileListDB) ListItems(lastId int64, prefix string, serverId int64, size int) (hashList []string, itemList []*Item, maxId int64, err error) {
+	if !this.isReady {
+		return nil, nil, 0, nil
+	}
+
+	if size <= 0 {
+		size = 1000
+	}
+
+	var query = `WHERE "i"."id">?`
+	var args = []any{lastId}
+	if len(prefix) > 0 {
+		query += ` AND INSTR("i"."key", ?)=1`
+		args = append(args, prefix)
+	}
+	if serverId > 0 {
+		query += ` AND "i"."serverId"=?`
+		args = append(args, serverId)
+	}
+	query += ` ORDER BY "i"."id" ASC LIMIT ` + types.String(size)
+
+	rows, err := this.readDB.Query(this.selectItemsSQL()+" "+query, args...)
+	if err != nil {
+		return nil, nil, 0, this.WrapError(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var id int64
+		var hash string
+		var item = &Item{Type: ItemTypeFile}
+		err = rows.Scan(&id, &hash, &item.Key, &item.HeaderSize, &item.BodySize, &item.MetaSize, &item.ExpiredAt, &item.StaleAt, &item.Host, &item.ServerId, &item.Week1Hits, &item.Week2Hits)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		maxId = id
+		hashList = append(hashList, hash)
+		itemList = append(itemList, item)
+	}
+	return hashList, itemList, maxId, rows.Err()
+}
+
+// ItemWithHash 根据Hash读取缓存项，不存在时返回nil
+func (this *FileListDB) ItemWithHash(hash string) (*Item, error) {
+	if !this.isReady {
+		return nil, nil
+	}
+
+	var row = this.readDB.QueryRow(this.selectItemsSQL()+` WHERE "i"."hash"=? ORDER BY "i"."id" DESC LIMIT 1`, hash)
+	if row.Err() != nil {
+		return nil, this.WrapError(row.Err())
+	}
+
+	var id int64
+	var item = &Item{Type: ItemTypeFile}
+	err := row.Scan(&id, &hash, &item.Key, &item.HeaderSize, &item.BodySize, &item.MetaSize, &item.ExpiredAt, &item.StaleAt, &item.Host, &item.ServerId, &item.Week1Hits, &item.Week2Hits)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return item, nil
+}
+
 func (this *FileListDB) IncreaseHitAsync(hash string) error {
 	var week = timeutil.Format("YW")
 	this.writeBatch.Add(this.increaseHitSQL, hash, week, week, week, week)
@@ -568,6 +638,11 @@ func (this *FileListDB) WrapError(err error) error {
 	return errors.New(err.Error() + "(file: " + this.dbPath + ")")
 }
 
+// 查询缓存项及点击量的语句
+func (this *FileListDB) selectItemsSQL() string {
+	return `SELECT "i"."id", "i"."hash", "i"."key", "i"."headerSize", "i"."bodySize", "i"."metaSize", "i"."expiredAt", "i"."staleAt", IFNULL("i"."host", ''), IFNULL("i"."serverId", 0), IFNULL("h"."week1Hits", 0), IFNULL("h"."week2Hits", 0) FROM "` + this.itemsTableName + `" AS "i" LEFT JOIN "` + this.hitsTableName + `" AS "h" ON "h"."hash"="i"."hash"`
+}
+
 // 初始化
 func (this *FileListDB) initTables(times int) error {
 	{
@@ -53,4 +53,11 @@ type ListInterface interface {
 
 	// IncreaseHit 增加点击量
 	IncreaseHit(hash string) error
+
+	// ListItems 列出缓存项
+	// prefix 不为空时只列出Key以此开头的缓存项，serverId 大于0时只列出此网站的缓存项；callback 返回false时停止
+	ListItems(prefix string, serverId int64, callback func(hash string, item *Item) (goNext bool)) error
+
+	// Item 读取单个缓存项，不存在时返回nil
+	Item(hash string) (*Item, error)
 }
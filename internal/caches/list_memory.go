@@ -474,6 +474,43 @@ func (this *MemoryList) IncreaseHit(hash string) error {
 	return nil
 }
 
+// ListItems 列出缓存项
+// 遍历时持有读锁，callback 中不能再调用列表的其他方法
+func (this *MemoryList) ListItems(prefix string, serverId int64, callback func(hash string, item *Item) (goNext bool)) error {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	for _, itemMap := range this.itemMaps {
+		for hash, item := range itemMap {
+			if (len(prefix) > 0 && !strings.HasPrefix(item.Key, prefix)) || (serverId > 0 && item.ServerId != serverId) {
+				continue
+			}
+			var itemCopy = *item
+			if !callback(hash, &itemCopy) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Item 读取单个缓存项
+func (this *MemoryList) Item(hash string) (*Item, error) {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	itemMap, ok := this.itemMaps[this.prefix(hash)]
+	if !ok {
+		return nil, nil
+	}
+	item, ok := itemMap[hash]
+	if !ok {
+		return nil, nil
+	}
+	var itemCopy = *item
+	return &itemCopy, nil
+}
+
 func (this *MemoryList) print(t *testing.T) {
 	this.locker.Lock()
 	for _, itemMap := range this.itemMaps {
@@ -54,6 +54,53 @@ func TestMemoryList_Remove(t *testing.T) {
 	t.Log(list.Count())
 }
 
+func TestMemoryList_ListItems(t *testing.T) {
+	list := NewMemoryList().(*MemoryList)
+	_ = list.Init()
+	_ = list.Add("a", &Item{
+		Key:       "https://example.com/a",
+		ExpiredAt: time.Now().Unix() + 3600,
+		ServerId:  1,
+	})
+	_ = list.Add("b", &Item{
+		Key:       "https://example.com/b",
+		ExpiredAt: time.Now().Unix() + 3600,
+		ServerId:  2,
+	})
+	_ = list.Add("c", &Item{
+		Key:       "https://example.org/c",
+		ExpiredAt: time.Now().Unix() + 3600,
+		ServerId:  2,
+	})
+
+	var keys = []string{}
+	err := list.ListItems("https://example.com/", 2, func(hash string, item *Item) (goNext bool) {
+		keys = append(keys, item.Key)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "https://example.com/b" {
+		t.Fatal("unexpected keys:", keys)
+	}
+
+	item, err := list.Item("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item == nil || item.Key != "https://example.org/c" {
+		t.Fatal("expect item 'c'")
+	}
+	item, err = list.Item("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item != nil {
+		t.Fatal("'d' should not exist")
+	}
+}
+
 func TestMemoryList_Purge(t *testing.T) {
 	list := NewMemoryList().(*MemoryList)
 	_ = list.Init()
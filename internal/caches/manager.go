@@ -3,9 +3,11 @@ package caches
 import (
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/shared"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
 	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
 	"github.com/TeaOSLab/EdgeNode/internal/events"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/iwind/TeaGo/Tea"
 	"github.com/iwind/TeaGo/lists"
 	"github.com/iwind/TeaGo/types"
 	"strconv"
@@ -32,6 +34,8 @@ type Manager struct {
 	MainDiskDir       string
 	SubDiskDirs       []*serverconfigs.CacheDir
 	MaxMemoryCapacity *shared.SizeCapacity
+	SegmentConfig     *configs.CacheSegmentConfig  // 小文件段存储配置
+	SnapshotConfig    *configs.CacheSnapshotConfig // 内存缓存快照配置
 
 	policyMap  map[int64]*serverconfigs.HTTPCachePolicy // policyId => []*Policy
 	storageMap map[int64]StorageInterface               // policyId => *Storage
@@ -68,6 +72,12 @@ func (this *Manager) UpdatePolicies(newPolicies []*serverconfigs.HTTPCachePolicy
 			delete(this.policyMap, oldPolicy.Id)
 			storage, ok := this.storageMap[oldPolicy.Id]
 			if ok {
+				// 策略已删除，不需要再保存快照
+				var memoryStorage = findMemoryStorage(storage)
+				if memoryStorage != nil {
+					memoryStorage.discardSnapshot()
+				}
+
 				storage.Stop()
 				delete(this.storageMap, oldPolicy.Id)
 			}
@@ -165,9 +175,16 @@ func (this *Manager) FindStorageWithPolicy(policyId int64) StorageInterface {
 func (this *Manager) NewStorageWithPolicy(policy *serverconfigs.HTTPCachePolicy) StorageInterface {
 	switch policy.Type {
 	case serverconfigs.CachePolicyStorageFile:
+		if this.SegmentConfig != nil && this.SegmentConfig.MatchPolicy(policy.Id) {
+			return NewSegmentStorage(policy, this.SegmentConfig)
+		}
 		return NewFileStorage(policy)
 	case serverconfigs.CachePolicyStorageMemory:
-		return NewMemoryStorage(policy, nil)
+		var memoryStorage = NewMemoryStorage(policy, nil)
+		if this.SnapshotConfig != nil && this.SnapshotConfig.MatchPolicy(policy.Id) {
+			memoryStorage.EnableSnapshot(Tea.Root+"/data/cache-p"+types.String(policy.Id)+".memory.snapshot", true, this.SnapshotConfig)
+		}
+		return memoryStorage
 	}
 	return nil
 }
@@ -223,6 +240,27 @@ func (this *Manager) FindAllCachePaths() []string {
 	return result
 }
 
+// CloseSnapshots 保存所有内存缓存的快照并停止定时写入
+// 热升级时在启动新进程之前调用，以便新进程可以恢复到最新的快照
+func (this *Manager) CloseSnapshots() {
+	for _, storage := range this.FindAllStorages() {
+		var memoryStorage = findMemoryStorage(storage)
+		if memoryStorage != nil {
+			memoryStorage.closeSnapshot()
+		}
+	}
+}
+
+// ResumeSnapshots 重新启动被 CloseSnapshots() 停止的快照任务
+func (this *Manager) ResumeSnapshots() {
+	for _, storage := range this.FindAllStorages() {
+		var memoryStorage = findMemoryStorage(storage)
+		if memoryStorage != nil {
+			memoryStorage.resumeSnapshot()
+		}
+	}
+}
+
 // FindAllStorages 读取所有缓存存储
 func (this *Manager) FindAllStorages() []StorageInterface {
 	this.locker.Lock()
@@ -234,3 +272,16 @@ func (this *Manager) FindAllStorages() []StorageInterface {
 	}
 	return storages
 }
+
+// 查找存储使用的内存缓存
+func findMemoryStorage(storage StorageInterface) *MemoryStorage {
+	switch rawStorage := storage.(type) {
+	case *MemoryStorage:
+		return rawStorage
+	case *FileStorage:
+		return rawStorage.memoryStorage
+	case *SegmentStorage:
+		return rawStorage.memoryStorage
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+import (
+	"encoding/binary"
+	"errors"
+	rangeutils "github.com/TeaOSLab/EdgeNode/internal/utils/ranges"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/segments"
+	"github.com/iwind/TeaGo/types"
+	"io"
+	"os"
+)
+
+// SegmentReader 段存储读取器
+// 通过段文件和偏移量读取，不需要单独打开文件
+type SegmentReader struct {
+	entry *segments.Entry
+
+	status     int
+	headerSize int64
+	bodyOffset int64 // Body在值中的位置
+	bodySize   int64
+
+	readOffset int64 // Read() 读取到的Body位置
+}
+
+func NewSegmentReader(entry *segments.Entry) *SegmentReader {
+	return &SegmentReader{entry: entry}
+}
+
+func (this *SegmentReader) Init() error {
+	var meta = make([]byte, segmentValueMetaSize)
+	_, err := this.entry.ReadAt(meta, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if this.entry.Size() < segmentValueMetaSize {
+		return ErrNotFound
+	}
+
+	var status = types.Int(string(meta[:SizeStatus]))
+	if status < 100 || status > 999 {
+		return errors.New("invalid status")
+	}
+	this.status = status
+	this.headerSize = int64(binary.BigEndian.Uint32(meta[SizeStatus:]))
+	this.bodyOffset = segmentValueMetaSize + this.headerSize
+	this.bodySize = this.entry.Size() - this.bodyOffset
+	if this.bodySize < 0 {
+		return errors.New("invalid header size")
+	}
+	return nil
+}
+
+func (this *SegmentReader) TypeName() string {
+	return "segment"
+}
+
+func (this *SegmentReader) ExpiresAt() int64 {
+	return this.entry.ExpiresAt()
+}
+
+func (this *SegmentReader) Status() int {
+	return this.status
+}
+
+func (this *SegmentReader) LastModified() int64 {
+	return this.entry.ModifiedAt()
+}
+
+func (this *SegmentReader) HeaderSize() int64 {
+	return this.headerSize
+}
+
+func (this *SegmentReader) BodySize() int64 {
+	return this.bodySize
+}
+
+func (this *SegmentReader) ReadHeader(buf []byte, callback ReaderFunc) error {
+	return this.readRange(buf, segmentValueMetaSize, this.bodyOffset-1, callback)
+}
+
+func (this *SegmentReader) ReadBody(buf []byte, callback ReaderFunc) error {
+	if this.bodySize == 0 {
+		return nil
+	}
+	return this.readRange(buf, this.bodyOffset, this.entry.Size()-1, callback)
+}
+
+func (this *SegmentReader) Read(buf []byte) (n int, err error) {
+	if this.readOffset >= this.bodySize {
+		return 0, io.EOF
+	}
+	n, err = this.entry.ReadAt(buf, this.bodyOffset+this.readOffset)
+	this.readOffset += int64(n)
+	if err == io.EOF && this.readOffset < this.bodySize {
+		err = nil
+	}
+	return
+}
+
+func (this *SegmentReader) ReadBodyRange(buf []byte, start int64, end int64, callback ReaderFunc) error {
+	if start < 0 {
+		start = this.bodySize + end
+		end = this.bodySize - 1
+	} else if end < 0 || end > this.bodySize-1 {
+		end = this.bodySize - 1
+	}
+	if start < 0 || end < 0 || start > end {
+		return ErrInvalidRange
+	}
+	return this.readRange(buf, this.bodyOffset+start, this.bodyOffset+end, callback)
+}
+
+// ContainsRange 是否包含某些区间内容
+func (this *SegmentReader) ContainsRange(r rangeutils.Range) (r2 rangeutils.Range, ok bool) {
+	return r, true
+}
+
+// BodySection 内容所在的段文件及在文件中的位置和长度，可用于sendfile之类的零拷贝发送
+func (this *SegmentReader) BodySection() (fp *os.File, offset int64, size int64) {
+	return this.entry.File(), this.entry.Offset() + this.bodyOffset, this.bodySize
+}
+
+func (this *SegmentReader) Close() error {
+	return this.entry.Close()
+}
+
+// 读取值中 [start, end] 范围内的数据
+func (this *SegmentReader) readRange(buf []byte, start int64, end int64, callback ReaderFunc) error {
+	for start <= end {
+		var chunk = buf
+		if int64(len(chunk)) > end-start+1 {
+			chunk = chunk[:end-start+1]
+		}
+		n, err := this.entry.ReadAt(chunk, start)
+		if n > 0 {
+			start += int64(n)
+			goNext, e := callback(n)
+			if e != nil {
+				return e
+			}
+			if !goNext {
+				return nil
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/utils/segments"
+	"testing"
+	"time"
+)
+
+func TestSegmentReader(t *testing.T) {
+	store, err := segments.Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	var storage = &SegmentStorage{store: store}
+	var writer = NewSegmentWriter(storage, "a", time.Now().Unix()+3600, 200, 6, 10, -1)
+	_, _ = writer.WriteHeader([]byte("a:b\nc:"))
+	_, _ = writer.Write([]byte("0123456789"))
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := store.Get("a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reader = NewSegmentReader(entry)
+	defer func() {
+		_ = reader.Close()
+	}()
+	err = reader.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.Status() != 200 || reader.HeaderSize() != 6 || reader.BodySize() != 10 {
+		t.Fatal("unexpected meta")
+	}
+
+	var buf = make([]byte, 4)
+	var header = []byte{}
+	err = reader.ReadHeader(buf, func(n int) (goNext bool, err error) {
+		header = append(header, buf[:n]...)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "a:b\nc:" {
+		t.Fatal("unexpected header:", string(header))
+	}
+
+	var body = []byte{}
+	err = reader.ReadBodyRange(buf, 2, 7, func(n int) (goNext bool, err error) {
+		body = append(body, buf[:n]...)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "234567" {
+		t.Fatal("unexpected body range:", string(body))
+	}
+}
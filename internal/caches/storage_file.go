@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/shared"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
 	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
 	"github.com/TeaOSLab/EdgeNode/internal/events"
 	"github.com/TeaOSLab/EdgeNode/internal/goman"
@@ -16,6 +17,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/utils"
 	setutils "github.com/TeaOSLab/EdgeNode/internal/utils/sets"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/sizes"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/snapshots"
 	"github.com/TeaOSLab/EdgeNode/internal/zero"
 	"github.com/iwind/TeaGo/Tea"
 	"github.com/iwind/TeaGo/rands"
@@ -94,8 +96,12 @@ type FileStorage struct {
 
 	mainDir        string
 	mainDiskIsFull bool
+	mainDirHealth  *fileDirHealth
 
 	subDirs []*FileDir
+
+	fs              fileSystem
+	dirHealthTicker *utils.Ticker
 }
 
 func NewFileStorage(policy *serverconfigs.HTTPCachePolicy) *FileStorage {
@@ -104,6 +110,7 @@ func NewFileStorage(policy *serverconfigs.HTTPCachePolicy) *FileStorage {
 		hotMap:      map[string]*HotItem{},
 		lastHotSize: -1,
 		ignoreKeys:  setutils.NewFixedSet(FileStorageMaxIgnoreKeys),
+		fs:          osFileSystem{},
 	}
 }
 
@@ -165,6 +172,7 @@ func (this *FileStorage) UpdatePolicy(newPolicy *serverconfigs.HTTPCachePolicy)
 			Path:     subDir.Path,
 			Capacity: subDir.Capacity,
 			IsFull:   false,
+			health:   newFileDirHealth(subDir.Path),
 		})
 	}
 	this.checkDiskSpace()
@@ -230,6 +238,7 @@ func (this *FileStorage) Init() error {
 
 	this.options.Dir = filepath.Clean(this.options.Dir)
 	var dir = this.options.Dir
+	this.mainDirHealth = newFileDirHealth(dir)
 
 	var subDirs = []*FileDir{}
 	for _, subDir := range this.options.SubDirs {
@@ -237,6 +246,7 @@ func (this *FileStorage) Init() error {
 			Path:     subDir.Path,
 			Capacity: subDir.Capacity,
 			IsFull:   false,
+			health:   newFileDirHealth(subDir.Path),
 		})
 	}
 	this.subDirs = subDirs
@@ -319,6 +329,9 @@ func (this *FileStorage) Init() error {
 	// 检查磁盘空间
 	this.checkDiskSpace()
 
+	// 检查磁盘健康状态
+	this.initDirHealthTicker()
+
 	return nil
 }
 
@@ -348,6 +361,18 @@ func (this *FileStorage) openReader(key string, allowMemory bool, useStale bool,
 
 	hash, path, _ := this.keyPath(key)
 
+	// 磁盘停用期间新内容会写入到备用目录中，恢复使用后仍然从备用目录中读取这些内容
+	var dirHealth = this.dirHealth(hash)
+	fallbackPath, fallbackHealth := this.fallbackPath(hash, dirHealth)
+	if len(fallbackPath) > 0 && this.fileExists(fallbackPath) {
+		path = fallbackPath
+		dirHealth = fallbackHealth
+	} else if !dirHealth.IsReadable() {
+		// 磁盘出错时当作未命中处理，并清理索引
+		_ = this.list.Remove(hash)
+		return nil, ErrNotFound
+	}
+
 	// 检查文件记录是否已过期
 	if !useStale {
 		exists, err := this.list.Exist(hash)
@@ -369,13 +394,18 @@ func (this *FileStorage) openReader(key string, allowMemory bool, useStale bool,
 	var fp *os.File
 	var err error
 	if openFile == nil {
-		fp, err = os.OpenFile(path, os.O_RDONLY, 0444)
+		var before = time.Now()
+		fp, err = this.fs.OpenFile(path, os.O_RDONLY, 0444)
 		if err != nil {
 			if !os.IsNotExist(err) {
+				if isDiskError(err) {
+					this.reportDir(dirHealth, 0, err)
+				}
 				return nil, err
 			}
 			return nil, ErrNotFound
 		}
+		this.reportDir(dirHealth, time.Since(before), nil)
 	} else {
 		fp = openFile.fp
 	}
@@ -400,9 +430,18 @@ func (this *FileStorage) openReader(key string, allowMemory bool, useStale bool,
 	}
 	err = reader.Init()
 	if err != nil {
+		if isDiskError(err) {
+			this.reportDir(dirHealth, 0, err)
+		}
 		return nil, err
 	}
 
+	// 磁盘恢复使用之前写入的文件，可能在停用期间已经在备用目录中更新过
+	if !isPartial && this.isStaleDirFile(fp, hash, reader, dirHealth) {
+		_ = this.list.Remove(hash)
+		return nil, ErrNotFound
+	}
+
 	// 增加点击量
 	// 1/1000采样
 	if !isPartial && allowMemory && reader.BodySize() < FileToMemoryMaxSize {
@@ -496,6 +535,18 @@ func (this *FileStorage) openWriter(key string, expiredAt int64, status int, hea
 	var hash = stringutil.Md5(key)
 
 	dir, diskIsFull := this.subDir(hash)
+
+	// 磁盘停用时写入到其他可用的目录中
+	var dirHealth = this.dirHealth(hash)
+	var oldFallbackPath string // 磁盘恢复使用前写入到备用目录中的文件
+	if !dirHealth.IsWritable() {
+		dir, diskIsFull, dirHealth = this.fallbackDir(hash)
+		if len(dir) == 0 {
+			return nil, NewCapacityError("no available disk to write")
+		}
+	} else {
+		oldFallbackPath, _ = this.fallbackPath(hash, dirHealth)
+	}
 	if diskIsFull {
 		return nil, NewCapacityError("the disk is full")
 	}
@@ -511,7 +562,7 @@ func (this *FileStorage) openWriter(key string, expiredAt int64, status int, hea
 	}
 
 	// 查询当前已有缓存文件
-	stat, err := os.Stat(cachePath)
+	stat, err := this.fs.Stat(cachePath)
 
 	// 检查两次写入缓存的时间是否过于相近，分片内容不受此限制
 	if err == nil && !isPartial && time.Now().Sub(stat.ModTime()) <= 1*time.Second {
@@ -583,19 +634,28 @@ func (this *FileStorage) openWriter(key string, expiredAt int64, status int, hea
 		flags |= os.O_TRUNC
 	}
 	var before = time.Now()
-	writer, err := os.OpenFile(tmpPath, flags, 0666)
+	writer, err := this.fs.OpenFile(tmpPath, flags, 0666)
 	if err != nil {
 		// TODO 检查在各个系统中的稳定性
 		if os.IsNotExist(err) {
-			_ = os.MkdirAll(dir, 0777)
+			_ = this.fs.MkdirAll(dir, 0777)
 
 			// open file again
-			writer, err = os.OpenFile(tmpPath, flags, 0666)
+			writer, err = this.fs.OpenFile(tmpPath, flags, 0666)
 		}
 		if err != nil {
+			if isDiskError(err) {
+				this.reportDir(dirHealth, 0, err)
+			}
 			return nil, err
 		}
 	}
+	this.reportDir(dirHealth, time.Since(before), nil)
+
+	// 新内容写入到恢复使用的目录后，不再读取备用目录中的文件
+	if len(oldFallbackPath) > 0 {
+		_ = this.removeCacheFile(oldFallbackPath)
+	}
 	if !isFlushing {
 		if time.Since(before) >= maxOpenFilesSlowCost {
 			maxOpenFiles.Slow()
@@ -720,6 +780,13 @@ func (this *FileStorage) Delete(key string) error {
 	if err != nil {
 		return err
 	}
+
+	// 删除磁盘停用期间写入到备用目录中的文件
+	fallbackPath, _ := this.fallbackPath(hash, this.dirHealth(hash))
+	if len(fallbackPath) > 0 {
+		_ = this.removeCacheFile(fallbackPath)
+	}
+
 	err = this.removeCacheFile(path)
 	if err == nil || os.IsNotExist(err) {
 		return nil
@@ -906,6 +973,9 @@ func (this *FileStorage) Stop() {
 	if this.hotTicker != nil {
 		this.hotTicker.Stop()
 	}
+	if this.dirHealthTicker != nil {
+		this.dirHealthTicker.Stop()
+	}
 
 	_ = this.list.Close()
 
@@ -944,6 +1014,37 @@ func (this *FileStorage) CanSendfile() bool {
 	return this.options.EnableSendfile
 }
 
+// ListItems 列出缓存项
+// 只列出文件缓存，内存缓存中的内容最终也会写入文件
+func (this *FileStorage) ListItems(prefix string, serverId int64, callback func(item *Item) (goNext bool)) error {
+	return this.list.ListItems(prefix, serverId, func(hash string, item *Item) (goNext bool) {
+		return callback(item)
+	})
+}
+
+// ItemInfo 读取某个Key对应的缓存项信息
+// 文件中不存在时再从内存缓存中查找
+func (this *FileStorage) ItemInfo(key string) (*ItemInfo, error) {
+	hash, path, _ := this.keyPath(key)
+	item, err := this.list.Item(hash)
+	if err != nil {
+		return nil, err
+	}
+	if item != nil {
+		return &ItemInfo{
+			Item:    item,
+			Storage: "file",
+			Path:    path,
+		}, nil
+	}
+
+	var memoryStorage = this.memoryStorage
+	if memoryStorage != nil {
+		return memoryStorage.ItemInfo(key)
+	}
+	return nil, ErrNotFound
+}
+
 // 获取Key对应的文件路径
 func (this *FileStorage) keyPath(key string) (hash string, path string, diskIsFull bool) {
 	hash = stringutil.Md5(key)
@@ -1163,84 +1264,89 @@ func (this *FileStorage) hotLoop() {
 		var buf = utils.BytePool16k.Get()
 		defer utils.BytePool16k.Put(buf)
 		for _, item := range result[:size] {
-			reader, err := this.openReader(item.Key, false, false, false)
-			if err != nil {
-				continue
-			}
-			if reader == nil {
-				continue
-			}
+			this.transferToMemory(memoryStorage, item.Key, int64(item.Hits), buf)
+		}
+	}
+}
 
-			// 如果即将过期，则忽略
-			var nowUnixTime = time.Now().Unix()
-			if reader.ExpiresAt() <= nowUnixTime+600 {
-				continue
-			}
+// 将某个Key对应的文件缓存复制到内存缓存
+func (this *FileStorage) transferToMemory(memoryStorage *MemoryStorage, key string, hits int64, buf []byte) {
+	reader, err := this.openReader(key, false, false, false)
+	if err != nil {
+		return
+	}
+	if reader == nil {
+		return
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
 
-			// 计算合适的过期时间
-			var bestExpiresAt = nowUnixTime + HotItemLifeSeconds
-			var hotTimes = int64(item.Hits) / 1000
-			if hotTimes > 8 {
-				hotTimes = 8
-			}
-			bestExpiresAt += hotTimes * HotItemLifeSeconds
-			var expiresAt = reader.ExpiresAt()
-			if expiresAt <= 0 || expiresAt > bestExpiresAt {
-				expiresAt = bestExpiresAt
-			}
+	// 如果即将过期，则忽略
+	var nowUnixTime = time.Now().Unix()
+	if reader.ExpiresAt() <= nowUnixTime+600 {
+		return
+	}
 
-			writer, err := memoryStorage.openWriter(item.Key, expiresAt, reader.Status(), types.Int(reader.HeaderSize()), reader.BodySize(), -1, false)
-			if err != nil {
-				if !CanIgnoreErr(err) {
-					remotelogs.Error("CACHE", "transfer hot item failed: "+err.Error())
-				}
-				_ = reader.Close()
-				continue
-			}
-			if writer == nil {
-				_ = reader.Close()
-				continue
-			}
+	// 计算合适的过期时间
+	var bestExpiresAt = nowUnixTime + HotItemLifeSeconds
+	var hotTimes = hits / 1000
+	if hotTimes > 8 {
+		hotTimes = 8
+	}
+	bestExpiresAt += hotTimes * HotItemLifeSeconds
+	var expiresAt = reader.ExpiresAt()
+	if expiresAt <= 0 || expiresAt > bestExpiresAt {
+		expiresAt = bestExpiresAt
+	}
 
-			err = reader.ReadHeader(buf, func(n int) (goNext bool, err error) {
-				_, err = writer.WriteHeader(buf[:n])
-				return
-			})
-			if err != nil {
-				_ = reader.Close()
-				_ = writer.Discard()
-				continue
-			}
+	writer, err := memoryStorage.openWriter(key, expiresAt, reader.Status(), types.Int(reader.HeaderSize()), reader.BodySize(), -1, false)
+	if err != nil {
+		if !CanIgnoreErr(err) {
+			remotelogs.Error("CACHE", "transfer hot item failed: "+err.Error())
+		}
+		return
+	}
+	if writer == nil {
+		return
+	}
 
-			err = reader.ReadBody(buf, func(n int) (goNext bool, err error) {
-				goNext = true
-				if n > 0 {
-					_, err = writer.Write(buf[:n])
-					if err != nil {
-						goNext = false
-					}
-				}
-				return
-			})
+	err = reader.ReadHeader(buf, func(n int) (goNext bool, err error) {
+		_, err = writer.WriteHeader(buf[:n])
+		return
+	})
+	if err != nil {
+		_ = writer.Discard()
+		return
+	}
+
+	err = reader.ReadBody(buf, func(n int) (goNext bool, err error) {
+		goNext = true
+		if n > 0 {
+			_, err = writer.Write(buf[:n])
 			if err != nil {
-				_ = reader.Close()
-				_ = writer.Discard()
-				continue
+				goNext = false
 			}
-
-			memoryStorage.AddToList(&Item{
-				Type:       writer.ItemType(),
-				Key:        item.Key,
-				Host:       ParseHost(item.Key),
-				ExpiredAt:  expiresAt,
-				HeaderSize: writer.HeaderSize(),
-				BodySize:   writer.BodySize(),
-			})
-
-			_ = reader.Close()
-			_ = writer.Close()
 		}
+		return
+	})
+	if err != nil {
+		_ = writer.Discard()
+		return
 	}
+
+	memoryStorage.AddToList(&Item{
+		Type:       writer.ItemType(),
+		Key:        key,
+		Host:       ParseHost(key),
+		ExpiredAt:  expiresAt,
+		HeaderSize: writer.HeaderSize(),
+		BodySize:   writer.BodySize(),
+		Week2Hits:  hits,
+		Week:       currentWeek(),
+	})
+
+	_ = writer.Close()
 }
 
 func (this *FileStorage) diskCapacityBytes() int64 {
@@ -1376,15 +1482,63 @@ func (this *FileStorage) createMemoryStorage() error {
 		return err
 	}
 	var memoryStorage = NewMemoryStorage(memoryPolicy, this)
+
+	// 快照
+	var snapshotConfig = SharedManager.SnapshotConfig
+	var snapshotPath = ""
+	var snapshotWithContent = false
+	if snapshotConfig != nil && snapshotConfig.MatchPolicy(this.policy.Id) {
+		snapshotPath = this.options.Dir + "/p" + types.String(this.policy.Id) + "/.memory.snapshot"
+		snapshotWithContent = snapshotConfig.Mode == configs.CacheSnapshotModeContent
+		memoryStorage.EnableSnapshot(snapshotPath, snapshotWithContent, snapshotConfig)
+	}
+
 	err = memoryStorage.Init()
 	if err != nil {
 		return err
 	}
 	this.memoryStorage = memoryStorage
 
+	// 从快照的热点Key中恢复内存缓存
+	if len(snapshotPath) > 0 && !snapshotWithContent {
+		goman.New(func() {
+			this.restoreMemorySnapshot(memoryStorage, snapshotPath)
+		})
+	}
+
 	return nil
 }
 
+// 根据快照中的热点Key将文件缓存复制到内存缓存
+func (this *FileStorage) restoreMemorySnapshot(memoryStorage *MemoryStorage, snapshotPath string) {
+	var buf = utils.BytePool16k.Get()
+	defer utils.BytePool16k.Put(buf)
+
+	var countKeys = 0
+	var before = time.Now()
+	err := snapshots.Read(snapshotPath, func(record *snapshots.Record) (goNext bool) {
+		// 已经停止或者重建
+		if teaconst.IsQuiting || this.memoryStorage != memoryStorage {
+			return false
+		}
+		if record.ExpiresAt <= time.Now().Unix() {
+			return true
+		}
+		this.transferToMemory(memoryStorage, record.Key, record.Hits, buf)
+		countKeys++
+		return true
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		remotelogs.Warn("CACHE", "read memory snapshot of policy '"+types.String(this.policy.Id)+"' failed: "+err.Error())
+	}
+	if countKeys > 0 {
+		remotelogs.Println("CACHE", "restore "+strconv.Itoa(countKeys)+" hot keys of policy '"+types.String(this.policy.Id)+"' from memory snapshot, cost: "+strconv.FormatInt(time.Since(before).Milliseconds(), 10)+" ms")
+	}
+}
+
 func (this *FileStorage) initPurgeTicker() {
 	var autoPurgeInterval = this.policy.PersistenceAutoPurgeInterval
 	if autoPurgeInterval <= 0 {
@@ -1462,10 +1616,19 @@ func (this *FileStorage) subDir(hash string) (dirPath string, dirIsFull bool) {
 	}
 
 	var subDirs = this.subDirs // copy slice
-	var countSubDirs = len(subDirs)
-	if countSubDirs == 0 {
+	var dirIndex = this.dirIndex(hash, len(subDirs))
+	if dirIndex == 0 {
 		return this.options.Dir + suffix, this.mainDiskIsFull
 	}
+	var subDir = subDirs[dirIndex-1]
+	return subDir.Path + suffix, subDir.IsFull
+}
+
+// 获取Hash对应的目录序号，0表示主目录
+func (this *FileStorage) dirIndex(hash string, countSubDirs int) int {
+	if countSubDirs == 0 {
+		return 0
+	}
 
 	countSubDirs++ // add main dir
 
@@ -1474,12 +1637,91 @@ func (this *FileStorage) subDir(hash string) (dirPath string, dirIsFull bool) {
 		countSubDirs = 16
 	}
 
-	var dirIndex = this.charCode(hash[0]) % uint8(countSubDirs)
+	return int(this.charCode(hash[0]) % uint8(countSubDirs))
+}
+
+// 获取Hash对应目录的健康状态
+func (this *FileStorage) dirHealth(hash string) *fileDirHealth {
+	if len(hash) < 4 {
+		return this.mainDirHealth
+	}
+	var subDirs = this.subDirs // copy slice
+	var dirIndex = this.dirIndex(hash, len(subDirs))
 	if dirIndex == 0 {
-		return this.options.Dir + suffix, this.mainDiskIsFull
+		return this.mainDirHealth
 	}
-	var subDir = subDirs[dirIndex-1]
-	return subDir.Path + suffix, subDir.IsFull
+	return subDirs[dirIndex-1].health
+}
+
+// 获取Hash对应目录停用时使用的备用目录
+// 从除此目录之外可写入的目录中按Hash选择，以便在停用期间和恢复使用后读取时可以找到同一个目录；没有可用的目录时返回空
+func (this *FileStorage) fallbackDir(hash string) (dirPath string, dirIsFull bool, health *fileDirHealth) {
+	if len(hash) < 4 {
+		return "", false, nil
+	}
+	var suffix = "/p" + types.String(this.policy.Id) + "/" + hash[:2] + "/" + hash[2:4]
+	var ownHealth = this.dirHealth(hash)
+
+	var subDirs = this.subDirs // copy slice
+	if len(subDirs) > 15 {
+		subDirs = subDirs[:15]
+	}
+	var candidates = []*FileDir{}
+	if this.mainDirHealth.IsWritable() && this.mainDirHealth != ownHealth {
+		candidates = append(candidates, &FileDir{
+			Path:   this.options.Dir,
+			IsFull: this.mainDiskIsFull,
+			health: this.mainDirHealth,
+		})
+	}
+	for _, subDir := range subDirs {
+		if subDir.health.IsWritable() && subDir.health != ownHealth {
+			candidates = append(candidates, subDir)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false, nil
+	}
+
+	var candidate = candidates[int(this.charCode(hash[1]))%len(candidates)]
+	return candidate.Path + suffix, candidate.IsFull, candidate.health
+}
+
+// 获取Hash在备用目录中的缓存文件路径
+// 只有目录停用过时才返回，从未停用过时返回空
+func (this *FileStorage) fallbackPath(hash string, health *fileDirHealth) (path string, fallbackHealth *fileDirHealth) {
+	if health.IsWritable() && health.ReadmittedAt() <= 0 {
+		return "", nil
+	}
+	fallbackDir, _, fallbackHealth := this.fallbackDir(hash)
+	if len(fallbackDir) == 0 {
+		return "", nil
+	}
+	return fallbackDir + "/" + hash + ".cache", fallbackHealth
+}
+
+// 检查文件是否存在
+func (this *FileStorage) fileExists(path string) bool {
+	_, err := this.fs.Stat(path)
+	return err == nil
+}
+
+// 检查是否为磁盘恢复使用前写入的过时文件
+// 恢复使用之前写入的文件如果和索引中的过期时间不一致，说明在停用期间已经写入过新的内容
+func (this *FileStorage) isStaleDirFile(fp *os.File, hash string, reader Reader, health *fileDirHealth) bool {
+	var readmittedAt = health.ReadmittedAt()
+	if readmittedAt <= 0 {
+		return false
+	}
+	stat, err := fp.Stat()
+	if err != nil || stat.ModTime().Unix() >= readmittedAt {
+		return false
+	}
+	item, err := this.list.Item(hash)
+	if err != nil || item == nil {
+		return false
+	}
+	return item.ExpiredAt != reader.ExpiresAt()
 }
 
 func (this *FileStorage) charCode(r byte) uint8 {
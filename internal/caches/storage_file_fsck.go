@@ -0,0 +1,393 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FsckIssueOrphanFile      = "orphanFile"      // 索引中不存在的缓存文件
+	FsckIssueMissingFile     = "missingFile"     // 索引中存在但是缓存文件不存在
+	FsckIssueTruncatedBody   = "truncatedBody"   // 缓存文件尺寸小于元数据中的尺寸
+	FsckIssueCorruptedMeta   = "corruptedMeta"   // 元数据损坏
+	FsckIssueCorruptedHeader = "corruptedHeader" // Header损坏
+	FsckIssueMetaMismatch    = "metaMismatch"    // 元数据和索引中的尺寸不一致
+)
+
+const (
+	fsckMaxSamples       = 100
+	fsckMaxHeaderSize    = 1 << 20
+	fsckRecentSeconds    = 60   // 最近修改的文件可能正在写入，不做检查
+	fsckTmpExpireSeconds = 3600 // 超过此时间的临时文件认为是残留文件
+)
+
+var fsckDirNameReg = regexp.MustCompile(`^[0-9a-f]{2}$`)
+var fsckFileNameReg = regexp.MustCompile(`^([0-9a-f]{32})(@ranges)?\.cache(\.tmp)?$`)
+
+// FsckOptions 缓存一致性检查选项
+type FsckOptions struct {
+	Repair bool // 是否修复
+	Rate   int  // 每秒最多检查的文件数，0表示不限制
+}
+
+// FsckIssue 检查发现的问题
+type FsckIssue struct {
+	Type       string `json:"type"`
+	Hash       string `json:"hash"`
+	Key        string `json:"key"`
+	Path       string `json:"path"`
+	Message    string `json:"message"`
+	IsRepaired bool   `json:"isRepaired"`
+}
+
+// FsckReport 缓存一致性检查报告
+// 检查过程中可以随时读取
+type FsckReport struct {
+	checkedItems int64
+	checkedFiles int64
+	repaired     int64
+	issues       map[string]int64 // type => count
+	samples      []*FsckIssue
+	locker       sync.Mutex
+}
+
+func NewFsckReport() *FsckReport {
+	return &FsckReport{
+		issues: map[string]int64{},
+	}
+}
+
+func (this *FsckReport) addIssue(issue *FsckIssue) {
+	this.locker.Lock()
+	this.issues[issue.Type]++
+	if issue.IsRepaired {
+		this.repaired++
+	}
+	if len(this.samples) < fsckMaxSamples {
+		this.samples = append(this.samples, issue)
+	}
+	this.locker.Unlock()
+}
+
+func (this *FsckReport) increaseItems() {
+	this.locker.Lock()
+	this.checkedItems++
+	this.locker.Unlock()
+}
+
+func (this *FsckReport) increaseFiles() {
+	this.locker.Lock()
+	this.checkedFiles++
+	this.locker.Unlock()
+}
+
+// AsMap 检查结果
+func (this *FsckReport) AsMap() maps.Map {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var issues = maps.Map{}
+	var countIssues int64
+	for issueType, count := range this.issues {
+		issues[issueType] = count
+		countIssues += count
+	}
+	return maps.Map{
+		"checkedItems": this.checkedItems,
+		"checkedFiles": this.checkedFiles,
+		"countIssues":  countIssues,
+		"repaired":     this.repaired,
+		"issues":       issues,
+		"samples":      append([]*FsckIssue{}, this.samples...),
+	}
+}
+
+// Fsck 检查缓存索引和缓存文件是否一致
+// 先遍历索引检查对应的文件，再遍历缓存目录查找索引中不存在的文件；可以在节点运行时执行，最近修改的文件和正在写入的Key会被跳过
+func (this *FileStorage) Fsck(ctx context.Context, options *FsckOptions, report *FsckReport) error {
+	// 限速
+	var rateChan <-chan time.Time
+	if options.Rate > 0 {
+		var ticker = time.NewTicker(time.Second / time.Duration(options.Rate))
+		defer ticker.Stop()
+		rateChan = ticker.C
+	}
+	var wait = func() bool {
+		if rateChan != nil {
+			select {
+			case <-rateChan:
+			case <-ctx.Done():
+				return false
+			}
+		}
+		return ctx.Err() == nil
+	}
+
+	// 检查索引
+	err := this.list.ListItems("", 0, func(hash string, item *Item) (goNext bool) {
+		if !wait() {
+			return false
+		}
+		report.increaseItems()
+		this.fsckItem(hash, item, options.Repair, report)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// 检查缓存目录
+	var rootDirs = []string{this.options.Dir}
+	for _, subDir := range this.subDirs {
+		rootDirs = append(rootDirs, subDir.Path)
+	}
+	for _, rootDir := range rootDirs {
+		err = this.fsckDir(rootDir+"/p"+types.String(this.policy.Id), options.Repair, report, wait)
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// 检查索引中的某个缓存项
+func (this *FileStorage) fsckItem(hash string, item *Item, repair bool, report *FsckReport) {
+	// 正在写入
+	sharedWritingFileKeyLocker.Lock()
+	_, isWriting := sharedWritingFileKeyMap[item.Key]
+	sharedWritingFileKeyLocker.Unlock()
+	if isWriting {
+		return
+	}
+
+	// 磁盘停用期间内容可能在备用目录中
+	if !this.dirHealth(hash).IsWritable() {
+		return
+	}
+
+	path, _ := this.hashPath(hash)
+	if len(path) == 0 {
+		return
+	}
+	issueType, message := this.fsckFile(path, item)
+	if len(issueType) == 0 {
+		return
+	}
+
+	var issue = &FsckIssue{
+		Type:    issueType,
+		Hash:    hash,
+		Key:     item.Key,
+		Path:    path,
+		Message: message,
+	}
+	if repair {
+		var err error
+		if issueType != FsckIssueMissingFile {
+			err = this.removeCacheFile(path)
+		}
+		if err == nil {
+			err = this.list.Remove(hash)
+		}
+		if err == nil {
+			issue.IsRepaired = true
+		} else {
+			issue.Message += "; repair failed: " + err.Error()
+		}
+	}
+	report.addIssue(issue)
+}
+
+// 检查缓存文件是否和索引中的信息一致
+func (this *FileStorage) fsckFile(path string, item *Item) (issueType string, message string) {
+	fp, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return FsckIssueMissingFile, "cache file not found"
+		}
+		return "", ""
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	stat, err := fp.Stat()
+	if err != nil {
+		return "", ""
+	}
+	if time.Since(stat.ModTime()) < fsckRecentSeconds*time.Second {
+		return "", ""
+	}
+
+	var meta = make([]byte, SizeMeta)
+	_, err = io.ReadFull(fp, meta)
+	if err != nil {
+		return FsckIssueCorruptedMeta, "read meta failed: " + err.Error()
+	}
+	var status = types.Int(string(meta[OffsetStatus : OffsetStatus+SizeStatus]))
+	if status < 100 || status > 999 {
+		return FsckIssueCorruptedMeta, "invalid status '" + string(meta[OffsetStatus:OffsetStatus+SizeStatus]) + "'"
+	}
+	var urlLength = int64(binary.BigEndian.Uint32(meta[OffsetURLLength : OffsetURLLength+SizeURLLength]))
+	var headerSize = int64(binary.BigEndian.Uint32(meta[OffsetHeaderLength : OffsetHeaderLength+SizeHeaderLength]))
+	var bodySize = int64(binary.BigEndian.Uint64(meta[OffsetBodyLength : OffsetBodyLength+SizeBodyLength]))
+	if urlLength > fsckMaxHeaderSize || headerSize > fsckMaxHeaderSize {
+		return FsckIssueCorruptedMeta, "invalid url or header length"
+	}
+
+	// 区间缓存的内容是不连续的，只检查区间文件
+	if strings.HasSuffix(item.Key, SuffixPartial) {
+		_, err = os.Stat(partialRangesFilePath(path))
+		if err != nil && os.IsNotExist(err) {
+			return FsckIssueCorruptedMeta, "ranges file not found"
+		}
+	} else {
+		if headerSize != item.HeaderSize || bodySize != item.BodySize {
+			return FsckIssueMetaMismatch, "header size: " + types.String(headerSize) + ", body size: " + types.String(bodySize) + " in file, but header size: " + types.String(item.HeaderSize) + ", body size: " + types.String(item.BodySize) + " in index"
+		}
+		var expectedSize = int64(SizeMeta) + urlLength + headerSize + bodySize
+		if stat.Size() < expectedSize {
+			return FsckIssueTruncatedBody, "file size: " + types.String(stat.Size()) + ", expected: " + types.String(expectedSize)
+		}
+	}
+
+	// Header中每一行都应该是 Name:Value 格式
+	if headerSize > 0 {
+		var header = make([]byte, headerSize)
+		_, err = fp.ReadAt(header, int64(SizeMeta)+urlLength)
+		if err != nil {
+			return FsckIssueTruncatedBody, "read header failed: " + err.Error()
+		}
+		for _, row := range bytes.Split(header, []byte{'\n'}) {
+			if len(row) > 0 && bytes.IndexByte(row, ':') <= 0 {
+				return FsckIssueCorruptedHeader, "invalid header line"
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// 查找某个策略目录中索引里不存在的缓存文件
+func (this *FileStorage) fsckDir(dir string, repair bool, report *FsckReport, wait func() bool) error {
+	level1Dirs, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, level1Dir := range level1Dirs {
+		if !level1Dir.IsDir() || !fsckDirNameReg.MatchString(level1Dir.Name()) {
+			continue
+		}
+		level2Dirs, err := os.ReadDir(dir + "/" + level1Dir.Name())
+		if err != nil {
+			continue
+		}
+		for _, level2Dir := range level2Dirs {
+			if !level2Dir.IsDir() || !fsckDirNameReg.MatchString(level2Dir.Name()) {
+				continue
+			}
+			var fileDir = dir + "/" + level1Dir.Name() + "/" + level2Dir.Name()
+			files, err := os.ReadDir(fileDir)
+			if err != nil {
+				continue
+			}
+			for _, file := range files {
+				if file.IsDir() {
+					continue
+				}
+				if !wait() {
+					return nil
+				}
+				report.increaseFiles()
+				this.fsckOrphanFile(fileDir+"/"+file.Name(), file.Name(), repair, report)
+			}
+		}
+	}
+	return nil
+}
+
+// 检查某个缓存文件在索引中是否存在
+func (this *FileStorage) fsckOrphanFile(path string, name string, repair bool, report *FsckReport) {
+	var matches = fsckFileNameReg.FindStringSubmatch(name)
+	if len(matches) == 0 {
+		return
+	}
+	var hash = matches[1]
+	var isRanges = len(matches[2]) > 0
+	var isTmp = len(matches[3]) > 0
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	var age = time.Since(stat.ModTime())
+	if age < fsckRecentSeconds*time.Second {
+		return
+	}
+
+	var message string
+	var expectedPath, _ = this.hashPath(hash)
+	switch {
+	case isTmp:
+		if age < fsckTmpExpireSeconds*time.Second {
+			return
+		}
+		message = "temporary file left"
+	case isRanges:
+		_, err = os.Stat(strings.TrimSuffix(path, "@ranges.cache") + ".cache")
+		if err == nil || !os.IsNotExist(err) {
+			return
+		}
+		message = "cache file of ranges not found"
+	case path != expectedPath && this.dirHealth(hash).IsWritable():
+		// 目录配置有变化后，文件不在应该在的位置上，将无法被读取；磁盘停用期间写入备用目录的文件除外
+		message = "file should be in '" + expectedPath + "'"
+	default:
+		item, err := this.list.Item(hash)
+		if err != nil || item != nil {
+			return
+		}
+		message = "not found in index"
+	}
+
+	var issue = &FsckIssue{
+		Type:    FsckIssueOrphanFile,
+		Hash:    hash,
+		Path:    path,
+		Message: message,
+	}
+	if repair {
+		if isTmp || isRanges {
+			err = os.Remove(path)
+		} else {
+			err = this.removeCacheFile(path)
+		}
+		if err == nil || os.IsNotExist(err) {
+			issue.IsRepaired = true
+		} else {
+			issue.Message += "; repair failed: " + err.Error()
+		}
+	}
+	report.addIssue(issue)
+}
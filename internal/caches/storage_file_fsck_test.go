@@ -0,0 +1,94 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+import (
+	"context"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/iwind/TeaGo/Tea"
+	"github.com/iwind/TeaGo/logs"
+	stringutil "github.com/iwind/TeaGo/utils/string"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorage_Fsck(t *testing.T) {
+	var storage = NewFileStorage(&serverconfigs.HTTPCachePolicy{
+		Id:   10,
+		IsOn: true,
+		Options: map[string]interface{}{
+			"dir": Tea.Root + "/caches",
+		},
+	})
+	err := storage.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Stop()
+
+	var expiresAt = time.Now().Unix() + 3600
+	var before = time.Now().Add(-1 * time.Hour)
+	for _, key := range []string{"https://example.com/fsck/a", "https://example.com/fsck/b"} {
+		writer, err := storage.openWriter(key, expiresAt, 200, -1, -1, -1, false, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = writer.WriteHeader([]byte("Content-Type:text/plain\n"))
+		_, _ = writer.Write([]byte("hello"))
+		err = writer.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		storage.AddToList(&Item{
+			Type:       writer.ItemType(),
+			Key:        key,
+			ExpiredAt:  expiresAt,
+			HeaderSize: writer.HeaderSize(),
+			BodySize:   writer.BodySize(),
+		})
+	}
+
+	// 删除一个文件，并且构造一个索引中不存在的文件
+	_, pathA, _ := storage.keyPath("https://example.com/fsck/a")
+	err = os.Remove(pathA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pathOrphan, _ := storage.keyPath("https://example.com/fsck/orphan")
+	_ = os.MkdirAll(filepath.Dir(pathOrphan), 0777)
+	err = os.WriteFile(pathOrphan, []byte("orphan"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pathB, _ := storage.keyPath("https://example.com/fsck/b")
+	for _, path := range []string{pathB, pathOrphan} {
+		_ = os.Chtimes(path, before, before)
+	}
+
+	time.Sleep(1 * time.Second) // 等待索引写入
+
+	var report = NewFsckReport()
+	err = storage.Fsck(context.Background(), &FsckOptions{Repair: true}, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result = report.AsMap()
+	logs.PrintAsJSON(result, t)
+	if result.GetInt64("repaired") != 2 {
+		t.Fatal("expect 2 repaired issues")
+	}
+
+	_, err = os.Stat(pathOrphan)
+	if !os.IsNotExist(err) {
+		t.Fatal("orphan file should be removed")
+	}
+	item, err := storage.list.Item(stringutil.Md5("https://example.com/fsck/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item != nil {
+		t.Fatal("index row without file should be removed")
+	}
+}
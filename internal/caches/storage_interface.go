@@ -58,4 +58,11 @@ type StorageInterface interface {
 
 	// CanSendfile 是否支持Sendfile
 	CanSendfile() bool
+
+	// ListItems 列出缓存项
+	// prefix 不为空时只列出Key以此开头的缓存项，serverId 大于0时只列出此网站的缓存项；callback 返回false时停止
+	ListItems(prefix string, serverId int64, callback func(item *Item) (goNext bool)) error
+
+	// ItemInfo 读取某个Key对应的缓存项信息，不存在时返回 ErrNotFound
+	ItemInfo(key string) (*ItemInfo, error)
 }
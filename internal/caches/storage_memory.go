@@ -54,6 +54,9 @@ type MemoryStorage struct {
 	writingKeyMap map[string]zero.Zero // key => bool
 
 	ignoreKeys *setutils.FixedSet
+
+	snapshot       *memorySnapshot
+	snapshotLocker sync.Mutex
 }
 
 func NewMemoryStorage(policy *serverconfigs.HTTPCachePolicy, parentStorage StorageInterface) *MemoryStorage {
@@ -93,6 +96,9 @@ func (this *MemoryStorage) Init() error {
 
 	this.initPurgeTicker()
 
+	// 从快照中恢复
+	this.initSnapshot()
+
 	// 启动定时Flush memory to disk任务
 	if this.parentStorage != nil {
 		// TODO 应该根据磁盘性能决定线程数
@@ -256,11 +262,16 @@ func (this *MemoryStorage) CleanAll() error {
 	_ = this.list.Reset()
 	atomic.StoreInt64(&this.totalSize, 0)
 	this.locker.Unlock()
+
+	this.removeSnapshot()
 	return nil
 }
 
 // Purge 批量删除缓存
 func (this *MemoryStorage) Purge(keys []string, urlType string) error {
+	// 已删除的内容不能再从快照中恢复
+	defer this.removeSnapshot()
+
 	// 目录
 	if urlType == "dir" {
 		for _, key := range keys {
@@ -310,6 +321,9 @@ func (this *MemoryStorage) Purge(keys []string, urlType string) error {
 
 // Stop 停止缓存策略
 func (this *MemoryStorage) Stop() {
+	// 在清空之前保存快照
+	this.closeSnapshot()
+
 	this.locker.Lock()
 
 	this.valuesMap = map[uint64]*MemoryItem{}
@@ -392,6 +406,28 @@ func (this *MemoryStorage) CanSendfile() bool {
 	return false
 }
 
+// ListItems 列出缓存项
+func (this *MemoryStorage) ListItems(prefix string, serverId int64, callback func(item *Item) (goNext bool)) error {
+	return this.list.ListItems(prefix, serverId, func(hash string, item *Item) (goNext bool) {
+		return callback(item)
+	})
+}
+
+// ItemInfo 读取某个Key对应的缓存项信息
+func (this *MemoryStorage) ItemInfo(key string) (*ItemInfo, error) {
+	item, err := this.list.Item(types.String(this.hash(key)))
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, ErrNotFound
+	}
+	return &ItemInfo{
+		Item:    item,
+		Storage: "memory",
+	}, nil
+}
+
 // 计算Key Hash
 func (this *MemoryStorage) hash(key string) uint64 {
 	return xxhash.Sum64String(key)
@@ -0,0 +1,304 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/snapshots"
+	"github.com/iwind/TeaGo/types"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 内存缓存快照
+type memorySnapshot struct {
+	path        string
+	withContent bool // 是否保存内容，否则只保存Key和点击量
+	config      *configs.CacheSnapshotConfig
+
+	ticker   *utils.Ticker
+	isClosed bool
+}
+
+// EnableSnapshot 启用快照，需要在 Init() 之前调用
+// withContent 为false时只保存Key和点击量，由上级存储负责恢复内容
+func (this *MemoryStorage) EnableSnapshot(path string, withContent bool, config *configs.CacheSnapshotConfig) {
+	this.snapshot = &memorySnapshot{
+		path:        path,
+		withContent: withContent,
+		config:      config,
+	}
+}
+
+// 启动快照任务
+func (this *MemoryStorage) initSnapshot() {
+	var snapshot = this.snapshot
+	if snapshot == nil {
+		return
+	}
+
+	if snapshot.withContent {
+		this.loadSnapshot()
+	}
+
+	this.startSnapshot()
+}
+
+// 启动定时写入快照的任务
+func (this *MemoryStorage) startSnapshot() {
+	var snapshot = this.snapshot
+
+	this.snapshotLocker.Lock()
+	snapshot.isClosed = false
+	var ticker = utils.NewTicker(time.Duration(snapshot.config.Interval) * time.Second)
+	snapshot.ticker = ticker
+	this.snapshotLocker.Unlock()
+
+	goman.New(func() {
+		for ticker.Next() {
+			err := this.saveSnapshot(false)
+			if err != nil {
+				remotelogs.Error("CACHE", "save memory snapshot of policy '"+types.String(this.policy.Id)+"' failed: "+err.Error())
+			}
+		}
+	})
+
+	// 退出时保存
+	events.OnKey(events.EventQuit, this, func() {
+		this.closeSnapshot()
+	})
+}
+
+// 重新启动已经关闭的快照任务
+func (this *MemoryStorage) resumeSnapshot() {
+	var snapshot = this.snapshot
+	if snapshot == nil {
+		return
+	}
+
+	this.snapshotLocker.Lock()
+	var isClosed = snapshot.isClosed
+	this.snapshotLocker.Unlock()
+	if isClosed {
+		this.startSnapshot()
+	}
+}
+
+// 停止快照任务并保存最后一次快照
+func (this *MemoryStorage) closeSnapshot() {
+	var snapshot = this.snapshot
+	if snapshot == nil {
+		return
+	}
+
+	this.snapshotLocker.Lock()
+	if snapshot.isClosed {
+		this.snapshotLocker.Unlock()
+		return
+	}
+	snapshot.isClosed = true
+	var ticker = snapshot.ticker
+	this.snapshotLocker.Unlock()
+
+	events.Remove(this)
+	if ticker != nil {
+		ticker.Stop()
+	}
+
+	err := this.saveSnapshot(true)
+	if err != nil {
+		remotelogs.Error("CACHE", "save memory snapshot of policy '"+types.String(this.policy.Id)+"' failed: "+err.Error())
+	}
+}
+
+// 停止快照任务并删除快照，在删除缓存策略时调用
+func (this *MemoryStorage) discardSnapshot() {
+	var snapshot = this.snapshot
+	if snapshot == nil {
+		return
+	}
+
+	this.snapshotLocker.Lock()
+	snapshot.isClosed = true
+	var ticker = snapshot.ticker
+	this.snapshotLocker.Unlock()
+
+	events.Remove(this)
+	if ticker != nil {
+		ticker.Stop()
+	}
+
+	this.removeSnapshot()
+}
+
+// 写入快照
+// 按点击量从高到低写入，超出数量或尺寸限制的内容会被忽略
+func (this *MemoryStorage) saveSnapshot(isClosing bool) error {
+	var snapshot = this.snapshot
+	if snapshot == nil {
+		return nil
+	}
+
+	this.snapshotLocker.Lock()
+	defer this.snapshotLocker.Unlock()
+
+	// 已关闭后不再定时写入
+	if snapshot.isClosed && !isClosing {
+		return nil
+	}
+
+	type snapshotItem struct {
+		item  *Item
+		value *MemoryItem
+		hits  int64
+	}
+
+	var items = []*snapshotItem{}
+	err := this.list.ListItems("", 0, func(hash string, item *Item) (goNext bool) {
+		if !item.IsExpired() {
+			items = append(items, &snapshotItem{
+				item: item,
+				hits: item.Week1Hits + item.Week2Hits,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// 完成写入的内容在之后不会再修改，所以可以在锁之外写入文件
+	this.locker.RLock()
+	for _, entry := range items {
+		var value = this.valuesMap[this.hash(entry.item.Key)]
+		if value != nil && value.IsDone {
+			entry.value = value
+		}
+	}
+	this.locker.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].hits > items[j].hits
+	})
+
+	writer, err := snapshots.Create(snapshot.path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range items {
+		var value = entry.value
+		if value == nil || value.IsExpired() {
+			continue
+		}
+		var record = &snapshots.Record{
+			Key:        entry.item.Key,
+			ExpiresAt:  value.ExpiresAt,
+			ModifiedAt: value.ModifiedAt,
+			Hits:       entry.hits,
+			Status:     value.Status,
+		}
+		if snapshot.withContent {
+			if writer.Size()+int64(len(value.HeaderValue)+len(value.BodyValue)) > snapshot.config.MaxSize {
+				continue
+			}
+			record.Header = value.HeaderValue
+			record.Body = value.BodyValue
+		} else if writer.Count() >= snapshot.config.MaxKeys {
+			break
+		}
+		err = writer.Write(record)
+		if err != nil {
+			_ = writer.Discard()
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// 删除快照，在清除或者删除缓存之后调用，防止异常退出后恢复已经删除的内容
+// 正在写入的快照完成后才会删除，下一次定时任务会重新写入
+func (this *MemoryStorage) removeSnapshot() {
+	var snapshot = this.snapshot
+	if snapshot == nil {
+		return
+	}
+
+	this.snapshotLocker.Lock()
+	defer this.snapshotLocker.Unlock()
+
+	err := os.Remove(snapshot.path)
+	if err != nil && !os.IsNotExist(err) {
+		remotelogs.Error("CACHE", "remove memory snapshot of policy '"+types.String(this.policy.Id)+"' failed: "+err.Error())
+	}
+}
+
+// 从快照中恢复内容
+func (this *MemoryStorage) loadSnapshot() {
+	var snapshot = this.snapshot
+	var countLoaded = 0
+	var before = time.Now()
+	err := snapshots.Read(snapshot.path, func(record *snapshots.Record) (goNext bool) {
+		if record.ExpiresAt <= time.Now().Unix() {
+			return true
+		}
+		if !this.restoreItem(record) {
+			// 容量已满
+			return false
+		}
+		countLoaded++
+		return true
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		remotelogs.Warn("CACHE", "read memory snapshot of policy '"+types.String(this.policy.Id)+"' failed: "+err.Error())
+	}
+	if countLoaded > 0 {
+		remotelogs.Println("CACHE", "restore "+strconv.Itoa(countLoaded)+" items of policy '"+types.String(this.policy.Id)+"' from memory snapshot, cost: "+strconv.FormatInt(time.Since(before).Milliseconds(), 10)+" ms")
+	}
+}
+
+// 将快照中的一条记录放入内存
+func (this *MemoryStorage) restoreItem(record *snapshots.Record) bool {
+	var bodySize = int64(len(record.Body))
+	var capacityBytes = this.memoryCapacityBytes()
+	if capacityBytes > 0 && capacityBytes <= this.TotalMemorySize()+bodySize {
+		return false
+	}
+	if this.policy.MaxKeys > 0 {
+		totalKeys, err := this.list.Count()
+		if err != nil || totalKeys >= this.policy.MaxKeys {
+			return false
+		}
+	}
+
+	this.locker.Lock()
+	this.valuesMap[this.hash(record.Key)] = &MemoryItem{
+		ExpiresAt:   record.ExpiresAt,
+		HeaderValue: record.Header,
+		BodyValue:   record.Body,
+		Status:      record.Status,
+		IsDone:      true,
+		ModifiedAt:  record.ModifiedAt,
+	}
+	this.locker.Unlock()
+
+	this.AddToList(&Item{
+		Type:       ItemTypeMemory,
+		Key:        record.Key,
+		ExpiredAt:  record.ExpiresAt,
+		HeaderSize: int64(len(record.Header)),
+		BodySize:   bodySize,
+		Week2Hits:  record.Hits,
+		Week:       currentWeek(),
+	})
+	return true
+}
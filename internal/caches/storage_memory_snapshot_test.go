@@ -0,0 +1,123 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSnapshotStorage(t *testing.T, path string) *MemoryStorage {
+	var config = &configs.CacheSnapshotConfig{IsOn: true, Mode: configs.CacheSnapshotModeContent}
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var storage = NewMemoryStorage(&serverconfigs.HTTPCachePolicy{Id: 1, IsOn: true}, nil)
+	storage.EnableSnapshot(path, true, config)
+	err = storage.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+func writeTestSnapshotItem(t *testing.T, storage *MemoryStorage, key string) {
+	writer, err := storage.OpenWriter(key, time.Now().Unix()+3600, 200, -1, -1, -1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = writer.WriteHeader([]byte("Header"))
+	_, _ = writer.Write([]byte("Hello"))
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryStorage_CloseSnapshot(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "memory.snapshot")
+
+	var storage = newTestSnapshotStorage(t, path)
+	writeTestSnapshotItem(t, storage, "abc")
+
+	// 关闭时写入快照，之后Stop()不再重复写入
+	storage.closeSnapshot()
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestSnapshotItem(t, storage, "def")
+	storage.Stop()
+	stat2, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stat2.ModTime().Equal(stat.ModTime()) || stat2.Size() != stat.Size() {
+		t.Fatal("snapshot should not be written again after closed")
+	}
+
+	// 新的存储从快照中恢复
+	var newStorage = newTestSnapshotStorage(t, path)
+	defer newStorage.Stop()
+	reader, err := newStorage.OpenReader("abc", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = reader.Close()
+	_, err = newStorage.OpenReader("def", false, false)
+	if err != ErrNotFound {
+		t.Fatal("'def' should not be restored, got:", err)
+	}
+}
+
+func TestMemoryStorage_ResumeSnapshot(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "memory.snapshot")
+
+	var storage = newTestSnapshotStorage(t, path)
+	storage.closeSnapshot()
+	storage.resumeSnapshot()
+	writeTestSnapshotItem(t, storage, "abc")
+
+	// 恢复后Stop()重新写入快照
+	storage.Stop()
+
+	var newStorage = newTestSnapshotStorage(t, path)
+	defer newStorage.Stop()
+	reader, err := newStorage.OpenReader("abc", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = reader.Close()
+}
+
+func TestManager_RemovePolicy_DiscardSnapshot(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "memory.snapshot")
+
+	var storage = newTestSnapshotStorage(t, path)
+	writeTestSnapshotItem(t, storage, "abc")
+
+	var manager = NewManager()
+	manager.policyMap[1] = storage.Policy()
+	manager.storageMap[1] = storage
+
+	// 热升级前写入快照
+	manager.CloseSnapshots()
+	_, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.ResumeSnapshots()
+
+	// 删除策略时删除快照
+	manager.UpdatePolicies([]*serverconfigs.HTTPCachePolicy{})
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Fatal("snapshot should be removed with the policy, got:", err)
+	}
+}
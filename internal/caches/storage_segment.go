@@ -0,0 +1,347 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/trackers"
+	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/segments"
+	"github.com/iwind/TeaGo/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SegmentStorage 小文件段存储
+// 小对象追加写入到段文件中，大对象、区间缓存等仍然使用 FileStorage 的单独文件存储
+type SegmentStorage struct {
+	*FileStorage
+
+	config        *configs.CacheSegmentConfig
+	store         *segments.Store
+	compactTicker *utils.Ticker
+}
+
+func NewSegmentStorage(policy *serverconfigs.HTTPCachePolicy, config *configs.CacheSegmentConfig) *SegmentStorage {
+	return &SegmentStorage{
+		FileStorage: NewFileStorage(policy),
+		config:      config,
+	}
+}
+
+// Init 初始化
+func (this *SegmentStorage) Init() error {
+	err := this.FileStorage.Init()
+	if err != nil {
+		return err
+	}
+
+	var before = time.Now()
+	var dir = this.options.Dir + "/p" + types.String(this.policy.Id) + "/segments"
+	store, err := segments.Open(dir, &segments.Options{
+		SegmentSize: this.config.SegmentSize,
+	})
+	if err != nil {
+		return err
+	}
+	this.store = store
+
+	// 内存缓存刷入时也写入段存储
+	this.runMemoryStorageSafety(func(memoryStorage *MemoryStorage) {
+		memoryStorage.parentStorage = this
+	})
+
+	var stat = store.Stat()
+	remotelogs.Println("CACHE", "init segments of policy "+strconv.FormatInt(this.policy.Id, 10)+", cost: "+strconv.FormatInt(time.Since(before).Milliseconds(), 10)+" ms, count: "+strconv.Itoa(stat.Count)+", segments: "+strconv.Itoa(stat.Segments))
+
+	this.compactTicker = utils.NewTicker(time.Duration(this.config.CompactInterval) * time.Second)
+	goman.New(func() {
+		for this.compactTicker.Next() {
+			trackers.Run("SEGMENT_CACHE_STORAGE_COMPACT_LOOP", func() {
+				this.compactLoop()
+			})
+		}
+	})
+
+	return nil
+}
+
+// OpenReader 读取缓存
+func (this *SegmentStorage) OpenReader(key string, useStale bool, isPartial bool) (Reader, error) {
+	if !isPartial {
+		entry, err := this.store.Get(key, useStale)
+		if err == nil {
+			var reader = NewSegmentReader(entry)
+			err = reader.Init()
+			if err == nil {
+				return reader, nil
+			}
+			_ = reader.Close()
+			_ = this.store.Delete(key)
+		}
+	}
+	return this.FileStorage.OpenReader(key, useStale, isPartial)
+}
+
+// OpenWriter 打开缓存写入器等待写入
+func (this *SegmentStorage) OpenWriter(key string, expiresAt int64, status int, headerSize int, bodySize int64, maxSize int64, isPartial bool) (Writer, error) {
+	// 有内存缓存时，小对象先写入内存，刷入时再写入段存储
+	if !isPartial && this.memoryStorage == nil && this.canWriteSegment(headerSize, bodySize) {
+		return this.openSegmentWriter(key, expiresAt, status, headerSize, bodySize, maxSize)
+	}
+
+	// 写入文件或者内存后，删除段存储中的旧内容，防止读取时优先读到旧内容
+	writer, err := this.FileStorage.OpenWriter(key, expiresAt, status, headerSize, bodySize, maxSize, isPartial)
+	if err == nil && !isPartial {
+		_ = this.store.Delete(key)
+	}
+	return writer, err
+}
+
+// OpenFlushWriter 打开从其他媒介直接刷入的写入器
+func (this *SegmentStorage) OpenFlushWriter(key string, expiresAt int64, status int, headerSize int, bodySize int64) (Writer, error) {
+	if this.canWriteSegment(headerSize, bodySize) {
+		return this.openSegmentWriter(key, expiresAt, status, headerSize, bodySize, -1)
+	}
+
+	writer, err := this.FileStorage.OpenFlushWriter(key, expiresAt, status, headerSize, bodySize)
+	if err == nil {
+		_ = this.store.Delete(key)
+	}
+	return writer, err
+}
+
+// Delete 删除某个键值对应的缓存
+func (this *SegmentStorage) Delete(key string) error {
+	if teaconst.IsQuiting {
+		return nil
+	}
+
+	err := this.store.Delete(key)
+	if err != nil {
+		return err
+	}
+	return this.FileStorage.Delete(key)
+}
+
+// Stat 统计缓存
+func (this *SegmentStorage) Stat() (*Stat, error) {
+	stat, err := this.FileStorage.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var segmentStat = this.store.Stat()
+	stat.Count += segmentStat.Count
+	stat.ValueSize += segmentStat.TotalSize - segmentStat.GarbageSize
+	stat.Size += segmentStat.TotalSize
+	return stat, nil
+}
+
+// CleanAll 清除所有缓存
+func (this *SegmentStorage) CleanAll() error {
+	err := this.store.Reset()
+	if err != nil {
+		return err
+	}
+	return this.FileStorage.CleanAll()
+}
+
+// Purge 批量删除缓存
+func (this *SegmentStorage) Purge(keys []string, urlType string) error {
+	if teaconst.IsQuiting {
+		return nil
+	}
+
+	for _, key := range keys {
+		if urlType == "dir" {
+			var err error
+			var schemeIndex = strings.Index(key, "://")
+			if schemeIndex > 0 && strings.HasPrefix(key[schemeIndex+3:], "*.") {
+				err = this.purgeMatchPrefix(key[:schemeIndex+3], key[schemeIndex+4:])
+			} else {
+				_, err = this.store.DeletePrefix(key)
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			err := this.store.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return this.FileStorage.Purge(keys, urlType)
+}
+
+// Stop 停止缓存策略
+func (this *SegmentStorage) Stop() {
+	if this.compactTicker != nil {
+		this.compactTicker.Stop()
+	}
+	this.FileStorage.Stop()
+	if this.store != nil {
+		_ = this.store.Close()
+	}
+}
+
+// TotalDiskSize 消耗的磁盘尺寸
+func (this *SegmentStorage) TotalDiskSize() int64 {
+	return this.FileStorage.TotalDiskSize() + this.store.Stat().TotalSize
+}
+
+// ListItems 列出缓存项
+// 段存储中没有保存网站ID，按网站列出时只列出单独文件中的缓存项
+func (this *SegmentStorage) ListItems(prefix string, serverId int64, callback func(item *Item) (goNext bool)) error {
+	var goNext = true
+	err := this.FileStorage.ListItems(prefix, serverId, func(item *Item) bool {
+		goNext = callback(item)
+		return goNext
+	})
+	if err != nil || !goNext || serverId > 0 {
+		return err
+	}
+
+	this.store.Keys(func(key string) bool {
+		if len(prefix) > 0 && !strings.HasPrefix(key, prefix) {
+			return true
+		}
+		info, err := this.segmentItemInfo(key)
+		if err != nil {
+			return true
+		}
+		return callback(info.Item)
+	})
+	return nil
+}
+
+// ItemInfo 读取某个Key对应的缓存项信息
+func (this *SegmentStorage) ItemInfo(key string) (*ItemInfo, error) {
+	info, err := this.segmentItemInfo(key)
+	if err == nil {
+		return info, nil
+	}
+	return this.FileStorage.ItemInfo(key)
+}
+
+// CanUpdatePolicy 检查策略是否可以更新
+// 段存储依赖于启动时的配置，所以策略修改后需要重新启动
+func (this *SegmentStorage) CanUpdatePolicy(newPolicy *serverconfigs.HTTPCachePolicy) bool {
+	return false
+}
+
+// 检查对象是否可以写入段存储
+func (this *SegmentStorage) canWriteSegment(headerSize int, bodySize int64) bool {
+	return bodySize >= 0 && headerSize >= 0 && int64(headerSize)+bodySize <= this.config.MaxObjectSize
+}
+
+func (this *SegmentStorage) openSegmentWriter(key string, expiresAt int64, status int, headerSize int, bodySize int64, maxSize int64) (Writer, error) {
+	if teaconst.IsQuiting {
+		return nil, ErrWritingUnavailable
+	}
+	if this.ignoreKeys.Has(key) {
+		return nil, ErrEntityTooLarge
+	}
+	if this.policy.MaxKeys > 0 && int64(this.store.Stat().Count) > this.policy.MaxKeys {
+		return nil, NewCapacityError("write segment cache failed: too many keys in cache storage")
+	}
+	var capacityBytes = this.diskCapacityBytes()
+	if capacityBytes > 0 && capacityBytes <= this.TotalDiskSize() {
+		return nil, NewCapacityError("write segment cache failed: over disk size, capacity: " + strconv.FormatInt(capacityBytes, 10))
+	}
+	return NewSegmentWriter(this, key, expiresAt, status, headerSize, bodySize, maxSize), nil
+}
+
+// 写入段存储
+// 单独文件中的旧内容不需要删除，读取时优先使用段存储中的内容，旧文件过期后自动清理
+func (this *SegmentStorage) putSegmentValue(key string, expiresAt int64, value []byte) error {
+	return this.store.Put(key, expiresAt, value)
+}
+
+// 读取段存储中的缓存项信息
+func (this *SegmentStorage) segmentItemInfo(key string) (*ItemInfo, error) {
+	entry, err := this.store.Get(key, true)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var reader = NewSegmentReader(entry)
+	defer func() {
+		_ = reader.Close()
+	}()
+	err = reader.Init()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ItemInfo{
+		Item: &Item{
+			Type:       ItemTypeFile,
+			Key:        key,
+			ExpiredAt:  entry.ExpiresAt(),
+			HeaderSize: reader.HeaderSize(),
+			BodySize:   reader.BodySize(),
+			Host:       ParseHost(key),
+		},
+		Storage: "segment",
+		Path:    entry.File().Name(),
+		Offset:  entry.Offset(),
+	}, nil
+}
+
+// 删除 scheme://*.example.com/path 这种通配的前缀
+func (this *SegmentStorage) purgeMatchPrefix(scheme string, domainSuffix string) error {
+	var pathPrefix = ""
+	var slashIndex = strings.Index(domainSuffix, "/")
+	if slashIndex >= 0 {
+		pathPrefix = domainSuffix[slashIndex:]
+		domainSuffix = domainSuffix[:slashIndex]
+	}
+
+	var keys = []string{}
+	this.store.Keys(func(key string) bool {
+		if !strings.HasPrefix(key, scheme) {
+			return true
+		}
+		var host = key[len(scheme):]
+		var path = ""
+		var slashIndex = strings.Index(host, "/")
+		if slashIndex >= 0 {
+			path = host[slashIndex:]
+			host = host[:slashIndex]
+		}
+		if strings.HasSuffix(host, domainSuffix) && strings.HasPrefix(path, pathPrefix) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		err := this.store.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 清理过期数据并压缩段文件
+func (this *SegmentStorage) compactLoop() {
+	if teaconst.IsQuiting {
+		return
+	}
+
+	var purged = this.store.PurgeExpired(100000)
+	compacted, err := this.store.Compact(this.config.GarbageRatio)
+	if err != nil {
+		remotelogs.Error("CACHE", "compact segments of policy "+strconv.FormatInt(this.policy.Id, 10)+" failed: "+err.Error())
+		return
+	}
+	if compacted > 0 {
+		remotelogs.Println("CACHE", "compact segments of policy "+strconv.FormatInt(this.policy.Id, 10)+": purged "+strconv.Itoa(purged)+" expired items, compacted "+strconv.Itoa(compacted)+" segments")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"testing"
+	"time"
+)
+
+func newTestSegmentStorage(t *testing.T) *SegmentStorage {
+	var config = &configs.CacheSegmentConfig{IsOn: true, MaxObjectSize: 1024}
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var storage = NewSegmentStorage(&serverconfigs.HTTPCachePolicy{
+		Id:   1,
+		IsOn: true,
+		Options: map[string]interface{}{
+			"dir": t.TempDir(),
+		},
+	}, config)
+	err = storage.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.Stop)
+	return storage
+}
+
+func writeTestSegmentCache(t *testing.T, storage *SegmentStorage, key string, body string, bodySize int64, isPartial bool) {
+	writer, err := storage.OpenWriter(key, time.Now().Unix()+3600, 200, 0, bodySize, -1, isPartial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writer.Write([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestSegmentCache(t *testing.T, storage *SegmentStorage, key string) (typeName string, body string) {
+	reader, err := storage.OpenReader(key, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	var buf = make([]byte, 64)
+	var data = []byte{}
+	err = reader.ReadBody(buf, func(n int) (goNext bool, err error) {
+		data = append(data, buf[:n]...)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader.TypeName(), string(data)
+}
+
+func TestSegmentStorage_OpenWriter(t *testing.T) {
+	var storage = newTestSegmentStorage(t)
+
+	// 小对象写入段存储
+	writeTestSegmentCache(t, storage, "a", "segment", 7, false)
+	typeName, body := readTestSegmentCache(t, storage, "a")
+	if body != "segment" {
+		t.Fatal("unexpected body:", typeName, body)
+	}
+	_, err := storage.store.Get("a", false)
+	if err != nil {
+		t.Fatal("should be written to segments:", err)
+	}
+
+	// 长度未知的新内容写入单独文件后，不能再读到段存储中的旧内容
+	writeTestSegmentCache(t, storage, "a", "new content", -1, false)
+	_, err = storage.store.Get("a", false)
+	if err == nil {
+		t.Fatal("old segment entry should be deleted")
+	}
+	typeName, body = readTestSegmentCache(t, storage, "a")
+	if body != "new content" {
+		t.Fatal("unexpected body:", typeName, body)
+	}
+}
+
+func TestSegmentStorage_OpenWriter_Partial(t *testing.T) {
+	var storage = newTestSegmentStorage(t)
+
+	writeTestSegmentCache(t, storage, "a", "segment", 7, false)
+
+	// 区间缓存使用单独的Key，不影响完整内容
+	writer, err := storage.OpenWriter("a", time.Now().Unix()+3600, 206, 0, 100, -1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = writer.Discard()
+
+	_, body := readTestSegmentCache(t, storage, "a")
+	if body != "segment" {
+		t.Fatal("segment entry should be kept for partial writes:", body)
+	}
+}
+
+func TestSegmentStorage_MatchPolicy(t *testing.T) {
+	config, err := configs.ParseLocalConfig([]byte("cacheSegment:\n  isOn: true\n  policyIds: [ 2 ]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.CacheSegment.MatchPolicy(2) || config.CacheSegment.MatchPolicy(1) {
+		t.Fatal("unexpected policy match")
+	}
+
+	_, err = configs.ParseLocalConfig([]byte("cacheSegment:\n  maxObjectSize: 1048576\n  segmentSize: 1024\n"))
+	if err == nil {
+		t.Fatal("max object size larger than segment size should be rejected")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package caches
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// 段存储中的值结构：
+//
+//	[ status ] | [header length] | [header data] [body data]
+const segmentValueMetaSize = SizeStatus + SizeHeaderLength
+
+// SegmentWriter 段存储写入器
+// 内容先保存在内存中，关闭时一次性追加到段文件
+type SegmentWriter struct {
+	storage *SegmentStorage
+
+	key       string
+	expiredAt int64
+	status    int
+	maxSize   int64
+
+	header []byte
+	body   []byte
+
+	once sync.Once
+}
+
+func NewSegmentWriter(storage *SegmentStorage, key string, expiredAt int64, status int, headerSize int, bodySize int64, maxSize int64) *SegmentWriter {
+	var writer = &SegmentWriter{
+		storage:   storage,
+		key:       key,
+		expiredAt: expiredAt,
+		status:    status,
+		maxSize:   maxSize,
+	}
+	if headerSize > 0 {
+		writer.header = make([]byte, 0, headerSize)
+	}
+	if bodySize > 0 {
+		writer.body = make([]byte, 0, bodySize)
+	}
+	return writer
+}
+
+// WriteHeader 写入Header数据
+func (this *SegmentWriter) WriteHeader(data []byte) (n int, err error) {
+	this.header = append(this.header, data...)
+	return len(data), nil
+}
+
+// Write 写入Body数据
+func (this *SegmentWriter) Write(data []byte) (n int, err error) {
+	this.body = append(this.body, data...)
+
+	// 检查尺寸
+	if this.maxSize > 0 && int64(len(this.body)) > this.maxSize {
+		err = ErrEntityTooLarge
+		this.storage.IgnoreKey(this.key)
+		return len(data), err
+	}
+	return len(data), nil
+}
+
+// WriteAt 在指定位置写入数据
+func (this *SegmentWriter) WriteAt(offset int64, data []byte) error {
+	if offset < 0 || offset+int64(len(data)) > int64(len(this.body)) {
+		return errors.New("out of range")
+	}
+	copy(this.body[offset:], data)
+	return nil
+}
+
+// HeaderSize 写入的Header数据大小
+func (this *SegmentWriter) HeaderSize() int64 {
+	return int64(len(this.header))
+}
+
+// BodySize 写入的Body数据大小
+func (this *SegmentWriter) BodySize() int64 {
+	return int64(len(this.body))
+}
+
+// Close 关闭并写入段文件
+func (this *SegmentWriter) Close() error {
+	var err error
+	this.once.Do(func() {
+		var status = this.status
+		if status > 999 || status < 100 {
+			status = 200
+		}
+
+		var value = make([]byte, segmentValueMetaSize, segmentValueMetaSize+len(this.header)+len(this.body))
+		copy(value, strconv.Itoa(status))
+		binary.BigEndian.PutUint32(value[SizeStatus:], uint32(len(this.header)))
+		value = append(value, this.header...)
+		value = append(value, this.body...)
+
+		err = this.storage.putSegmentValue(this.key, this.expiredAt, value)
+	})
+	return err
+}
+
+// Discard 丢弃
+func (this *SegmentWriter) Discard() error {
+	this.once.Do(func() {
+		this.header = nil
+		this.body = nil
+	})
+	return nil
+}
+
+// Key Key
+func (this *SegmentWriter) Key() string {
+	return this.key
+}
+
+// ExpiredAt 过期时间
+func (this *SegmentWriter) ExpiredAt() int64 {
+	return this.expiredAt
+}
+
+// ItemType 内容类型
+func (this *SegmentWriter) ItemType() ItemType {
+	return ItemTypeFile
+}
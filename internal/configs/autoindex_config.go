@@ -0,0 +1,46 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+// AutoIndexConfig 静态文件目录列表配置
+type AutoIndexConfig struct {
+	IsOn        bool    `yaml:"isOn" json:"isOn"`               // 是否启用
+	ServerIds   []int64 `yaml:"serverIds" json:"serverIds"`     // 启用的网站ID，为空表示所有开启了静态分发的网站
+	ShowHidden  bool    `yaml:"showHidden" json:"showHidden"`   // 是否显示以 . 开头的文件
+	PageSize    int     `yaml:"pageSize" json:"pageSize"`       // 每页条目数
+	MaxPageSize int     `yaml:"maxPageSize" json:"maxPageSize"` // 客户端可以指定的最大每页条目数
+	FormatParam string  `yaml:"formatParam" json:"formatParam"` // 指定输出格式的URL参数名，比如 ?format=json
+}
+
+// Init 初始化并检查配置
+func (this *AutoIndexConfig) Init() error {
+	if this.PageSize <= 0 {
+		this.PageSize = 1000
+	}
+	if this.MaxPageSize <= 0 {
+		this.MaxPageSize = 10000
+	}
+	if this.MaxPageSize < this.PageSize {
+		this.MaxPageSize = this.PageSize
+	}
+	if len(this.FormatParam) == 0 {
+		this.FormatParam = "format"
+	}
+	return nil
+}
+
+// MatchServer 检查某个网站是否启用
+func (this *AutoIndexConfig) MatchServer(serverId int64) bool {
+	if !this.IsOn {
+		return false
+	}
+	if len(this.ServerIds) == 0 {
+		return true
+	}
+	for _, id := range this.ServerIds {
+		if id == serverId {
+			return true
+		}
+	}
+	return false
+}
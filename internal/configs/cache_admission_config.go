@@ -0,0 +1,66 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+)
+
+const (
+	CacheAdmissionModeNthHit  = "nth"
+	CacheAdmissionModeTinyLFU = "tinylfu"
+)
+
+// CacheAdmissionConfig 缓存写入准入配置
+// 启用后只有访问频率足够高的内容才写入缓存，避免爬虫、扫描等只访问一次的请求冲掉热点内容
+type CacheAdmissionConfig struct {
+	IsOn      bool    `yaml:"isOn" json:"isOn"`           // 是否启用
+	PolicyIds []int64 `yaml:"policyIds" json:"policyIds"` // 启用的缓存策略ID，为空表示所有缓存策略
+	Mode      string  `yaml:"mode" json:"mode"`           // 模式：nth、tinylfu
+	MinHits   int     `yaml:"minHits" json:"minHits"`     // nth 模式下写入需要达到的请求次数，范围为1-15
+	Window    int     `yaml:"window" json:"window"`       // nth 模式下统计请求次数的时间窗口（秒）
+	MaxMemory int64   `yaml:"maxMemory" json:"maxMemory"` // 每个缓存策略中频率统计最大占用内存（字节）
+	FullRatio float64 `yaml:"fullRatio" json:"fullRatio"` // tinylfu 模式下缓存用量达到容量的此比例时开始比较访问频率
+}
+
+// Init 初始化并检查配置
+func (this *CacheAdmissionConfig) Init() error {
+	switch this.Mode {
+	case "":
+		this.Mode = CacheAdmissionModeNthHit
+	case CacheAdmissionModeNthHit, CacheAdmissionModeTinyLFU:
+	default:
+		return errors.New("invalid mode '" + this.Mode + "'")
+	}
+	if this.MinHits <= 0 {
+		this.MinHits = 2
+	} else if this.MinHits > 15 {
+		return errors.New("'minHits' should not be greater than 15")
+	}
+	if this.Window <= 0 {
+		this.Window = 3600
+	}
+	if this.MaxMemory <= 0 {
+		this.MaxMemory = 4 << 20
+	}
+	if this.FullRatio <= 0 || this.FullRatio > 1 {
+		this.FullRatio = 0.9
+	}
+	return nil
+}
+
+// MatchPolicy 检查某个缓存策略是否启用
+func (this *CacheAdmissionConfig) MatchPolicy(policyId int64) bool {
+	if !this.IsOn {
+		return false
+	}
+	if len(this.PolicyIds) == 0 {
+		return true
+	}
+	for _, id := range this.PolicyIds {
+		if id == policyId {
+			return true
+		}
+	}
+	return false
+}
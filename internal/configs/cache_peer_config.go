@@ -0,0 +1,67 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+)
+
+const (
+	CachePeerSourceCluster = "cluster"
+	CachePeerSourceStatic  = "static"
+)
+
+// CachePeerConfig 同集群节点之间的缓存共享配置
+// 启用后集群中的节点按缓存Key组成一致性Hash环，缓存未命中时先从Key所属的节点读取，由所属节点统一回源
+type CachePeerConfig struct {
+	IsOn        bool     `yaml:"isOn" json:"isOn"`               // 是否启用
+	ServerIds   []int64  `yaml:"serverIds" json:"serverIds"`     // 启用的网站ID，为空表示所有网站
+	Source      string   `yaml:"source" json:"source"`           // 节点来源：cluster（从API节点同步的节点列表）、static（使用peers）
+	Peers       []string `yaml:"peers" json:"peers"`             // static 来源中的节点地址，格式为 IP 或者 IP:端口，不带端口时使用和当前请求相同的端口
+	Self        string   `yaml:"self" json:"self"`               // 当前节点在节点列表中的地址，为空时根据节点ID或者本机IP查找
+	Secret      string   `yaml:"secret" json:"secret"`           // 节点之间请求的签名密钥，同一个集群中的节点需要一致
+	Replicas    int      `yaml:"replicas" json:"replicas"`       // 每个节点在Hash环上的虚拟节点数量
+	FailTimeout int      `yaml:"failTimeout" json:"failTimeout"` // 节点请求失败后暂停使用的时间（秒）
+}
+
+// Init 初始化并检查配置
+func (this *CachePeerConfig) Init() error {
+	switch this.Source {
+	case "":
+		this.Source = CachePeerSourceCluster
+	case CachePeerSourceCluster, CachePeerSourceStatic:
+	default:
+		return errors.New("invalid source '" + this.Source + "'")
+	}
+	if this.IsOn {
+		if len(this.Secret) == 0 {
+			return errors.New("'secret' should not be empty")
+		}
+		if this.Source == CachePeerSourceStatic && len(this.Peers) == 0 {
+			return errors.New("'peers' should not be empty with static source")
+		}
+	}
+	if this.Replicas <= 0 {
+		this.Replicas = 160
+	}
+	if this.FailTimeout <= 0 {
+		this.FailTimeout = 30
+	}
+	return nil
+}
+
+// MatchServer 检查某个网站是否启用
+func (this *CachePeerConfig) MatchServer(serverId int64) bool {
+	if !this.IsOn {
+		return false
+	}
+	if len(this.ServerIds) == 0 {
+		return true
+	}
+	for _, id := range this.ServerIds {
+		if id == serverId {
+			return true
+		}
+	}
+	return false
+}
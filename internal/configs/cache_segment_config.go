@@ -0,0 +1,54 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+)
+
+// CacheSegmentConfig 小文件段存储配置
+// 启用后，文件缓存策略中的小对象追加写入到较大的段文件中，大对象仍然使用单独的文件存储
+type CacheSegmentConfig struct {
+	IsOn            bool    `yaml:"isOn" json:"isOn"`                       // 是否启用
+	PolicyIds       []int64 `yaml:"policyIds" json:"policyIds"`             // 启用的缓存策略ID，为空表示所有文件缓存策略
+	MaxObjectSize   int64   `yaml:"maxObjectSize" json:"maxObjectSize"`     // 写入段文件的最大对象尺寸（字节），包括Header和Body
+	SegmentSize     int64   `yaml:"segmentSize" json:"segmentSize"`         // 单个段文件最大尺寸（字节）
+	GarbageRatio    float64 `yaml:"garbageRatio" json:"garbageRatio"`       // 段文件中失效数据超过此比例时压缩
+	CompactInterval int     `yaml:"compactInterval" json:"compactInterval"` // 检查压缩和清理过期数据的间隔（秒）
+}
+
+// Init 初始化并检查配置
+func (this *CacheSegmentConfig) Init() error {
+	if this.MaxObjectSize <= 0 {
+		this.MaxObjectSize = 64 << 10
+	}
+	if this.SegmentSize <= 0 {
+		this.SegmentSize = 256 << 20
+	}
+	if this.MaxObjectSize > this.SegmentSize {
+		return errors.New("'maxObjectSize' should not be greater than 'segmentSize'")
+	}
+	if this.GarbageRatio <= 0 || this.GarbageRatio >= 1 {
+		this.GarbageRatio = 0.5
+	}
+	if this.CompactInterval <= 0 {
+		this.CompactInterval = 60
+	}
+	return nil
+}
+
+// MatchPolicy 检查某个缓存策略是否启用
+func (this *CacheSegmentConfig) MatchPolicy(policyId int64) bool {
+	if !this.IsOn {
+		return false
+	}
+	if len(this.PolicyIds) == 0 {
+		return true
+	}
+	for _, id := range this.PolicyIds {
+		if id == policyId {
+			return true
+		}
+	}
+	return false
+}
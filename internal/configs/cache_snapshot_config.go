@@ -0,0 +1,62 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+)
+
+const (
+	CacheSnapshotModeKeys    = "keys"
+	CacheSnapshotModeContent = "content"
+)
+
+// CacheSnapshotConfig 内存缓存快照配置
+// 启用后在退出时和定时将内存缓存写入快照文件，启动时再从快照中恢复，避免重启后大量请求回源
+type CacheSnapshotConfig struct {
+	IsOn      bool    `yaml:"isOn" json:"isOn"`           // 是否启用
+	PolicyIds []int64 `yaml:"policyIds" json:"policyIds"` // 启用的缓存策略ID，为空表示所有缓存策略
+	Mode      string  `yaml:"mode" json:"mode"`           // 文件缓存策略中内存缓存的快照模式：keys（只保存热点Key和点击量）、content（保存内容）；纯内存缓存策略总是保存内容
+	Interval  int     `yaml:"interval" json:"interval"`   // 定时写入快照的间隔（秒）
+	MaxKeys   int     `yaml:"maxKeys" json:"maxKeys"`     // keys 模式下最多保存的Key数量
+	MaxSize   int64   `yaml:"maxSize" json:"maxSize"`     // content 模式下快照文件的最大尺寸（字节）
+}
+
+// Init 初始化并检查配置
+func (this *CacheSnapshotConfig) Init() error {
+	switch this.Mode {
+	case "":
+		this.Mode = CacheSnapshotModeKeys
+	case CacheSnapshotModeKeys, CacheSnapshotModeContent:
+	default:
+		return errors.New("invalid mode '" + this.Mode + "'")
+	}
+	if this.Interval <= 0 {
+		this.Interval = 600
+	} else if this.Interval < 60 {
+		return errors.New("'interval' should not be less than 60")
+	}
+	if this.MaxKeys <= 0 {
+		this.MaxKeys = 100000
+	}
+	if this.MaxSize <= 0 {
+		this.MaxSize = 1 << 30
+	}
+	return nil
+}
+
+// MatchPolicy 检查某个缓存策略是否启用
+func (this *CacheSnapshotConfig) MatchPolicy(policyId int64) bool {
+	if !this.IsOn {
+		return false
+	}
+	if len(this.PolicyIds) == 0 {
+		return true
+	}
+	for _, id := range this.PolicyIds {
+		if id == policyId {
+			return true
+		}
+	}
+	return false
+}
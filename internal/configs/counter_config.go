@@ -0,0 +1,46 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+)
+
+const (
+	CounterBackendLocal = "local" // 本地计数
+	CounterBackendRedis = "redis" // 使用Redis在集群中共享计数
+)
+
+// CounterConfig CC等阈值计数器配置
+type CounterConfig struct {
+	Backend string `yaml:"backend" json:"backend"` // 计数后端：local, redis
+
+	Redis struct {
+		Addr            string `yaml:"addr" json:"addr"`                       // 地址，比如 127.0.0.1:6379
+		Password        string `yaml:"password" json:"password"`               // 密码
+		DB              int    `yaml:"db" json:"db"`                           // 数据库序号
+		Prefix          string `yaml:"prefix" json:"prefix"`                   // 键前缀
+		FlushIntervalMs int    `yaml:"flushIntervalMs" json:"flushIntervalMs"` // 批量同步间隔（毫秒）
+	} `yaml:"redis" json:"redis"`
+}
+
+// Init 初始化并检查配置
+func (this *CounterConfig) Init() error {
+	switch this.Backend {
+	case "", CounterBackendLocal:
+		this.Backend = CounterBackendLocal
+	case CounterBackendRedis:
+		if len(this.Redis.Addr) == 0 {
+			return errors.New("'redis.addr' should not be empty")
+		}
+		if len(this.Redis.Prefix) == 0 {
+			this.Redis.Prefix = "edge:counter:"
+		}
+		if this.Redis.FlushIntervalMs <= 0 {
+			this.Redis.FlushIntervalMs = 100
+		}
+	default:
+		return errors.New("unknown counter backend '" + this.Backend + "'")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+)
+
+// ImageTransformConfig 图片实时转换配置
+type ImageTransformConfig struct {
+	IsOn           bool    `yaml:"isOn" json:"isOn"`                     // 是否启用
+	Param          string  `yaml:"param" json:"param"`                   // URL参数名
+	Header         string  `yaml:"header" json:"header"`                 // 请求Header名
+	MaxWidth       int     `yaml:"maxWidth" json:"maxWidth"`             // 最大输出宽度
+	MaxHeight      int     `yaml:"maxHeight" json:"maxHeight"`           // 最大输出高度
+	MaxDPR         float64 `yaml:"maxDPR" json:"maxDPR"`                 // 最大设备像素比
+	MaxPixels      int64   `yaml:"maxPixels" json:"maxPixels"`           // 原图最大像素数
+	MaxSourceBytes int64   `yaml:"maxSourceBytes" json:"maxSourceBytes"` // 原图最大字节数
+	Quality        int     `yaml:"quality" json:"quality"`               // 默认质量
+	RequireCache   bool    `yaml:"requireCache" json:"requireCache"`     // 是否只在开启缓存时转换
+}
+
+// Init 初始化并检查配置
+func (this *ImageTransformConfig) Init() error {
+	if len(this.Param) == 0 {
+		this.Param = "x-image"
+	}
+	if len(this.Header) == 0 {
+		this.Header = "X-Edge-Image"
+	}
+	if this.MaxWidth <= 0 {
+		this.MaxWidth = 4096
+	}
+	if this.MaxHeight <= 0 {
+		this.MaxHeight = 4096
+	}
+	if this.MaxDPR <= 0 {
+		this.MaxDPR = 3
+	}
+	if this.MaxPixels <= 0 {
+		this.MaxPixels = 50_000_000
+	}
+	if this.MaxSourceBytes <= 0 {
+		this.MaxSourceBytes = 32 << 20
+	}
+	if this.Quality <= 0 {
+		this.Quality = 80
+	}
+	if this.Quality > 100 {
+		return errors.New("'quality' should be between 1 and 100")
+	}
+	return nil
+}
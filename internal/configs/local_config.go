@@ -0,0 +1,134 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+	"github.com/iwind/TeaGo/Tea"
+	"gopkg.in/yaml.v3"
+	"os"
+)
+
+// LocalConfigFile 节点本地配置文件名
+const LocalConfigFile = "local.yaml"
+
+// LocalConfig 节点本地配置
+// 包含不在API节点中配置的网站和策略选项，每一项功能对应其中的一段，没有设置的段使用默认值
+type LocalConfig struct {
+	Upgrade        *UpgradeConfig        `yaml:"upgrade" json:"upgrade"`               // 节点升级
+	Counter        *CounterConfig        `yaml:"counter" json:"counter"`               // CC等阈值计数器
+	Resolver       *ResolverConfig       `yaml:"resolver" json:"resolver"`             // 源站域名解析
+	Image          *ImageTransformConfig `yaml:"image" json:"image"`                   // 图片实时转换
+	TLSPassthrough *TLSPassthroughConfig `yaml:"tlsPassthrough" json:"tlsPassthrough"` // TLS透传
+	UDP            *UDPProxyConfig       `yaml:"udp" json:"udp"`                       // UDP代理
+	AutoIndex      *AutoIndexConfig      `yaml:"autoIndex" json:"autoIndex"`           // 静态文件目录列表
+	WebSocket      *WebSocketConfig      `yaml:"websocket" json:"websocket"`           // Websocket代理
+	Stream         *StreamConfig         `yaml:"stream" json:"stream"`                 // 流式响应
+	Slice          *SliceConfig          `yaml:"slice" json:"slice"`                   // 分片回源
+	CacheSegment   *CacheSegmentConfig   `yaml:"cacheSegment" json:"cacheSegment"`     // 小文件段存储
+	CacheAdmission *CacheAdmissionConfig `yaml:"cacheAdmission" json:"cacheAdmission"` // 缓存写入准入
+	CacheSnapshot  *CacheSnapshotConfig  `yaml:"cacheSnapshot" json:"cacheSnapshot"`   // 内存缓存快照
+	CachePeer      *CachePeerConfig      `yaml:"cachePeer" json:"cachePeer"`           // 节点之间的缓存共享
+}
+
+// LoadLocalConfig 加载节点本地配置
+// 配置文件不存在时返回默认配置
+func LoadLocalConfig() (*LocalConfig, error) {
+	data, err := os.ReadFile(Tea.ConfigFile(LocalConfigFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ParseLocalConfig(nil)
+		}
+		return nil, err
+	}
+	return ParseLocalConfig(data)
+}
+
+// ParseLocalConfig 从YAML中解析节点本地配置
+func ParseLocalConfig(data []byte) (*LocalConfig, error) {
+	var config = &LocalConfig{}
+	if len(data) > 0 {
+		err := yaml.Unmarshal(data, config)
+		if err != nil {
+			return nil, errors.New("decode '" + LocalConfigFile + "' failed: " + err.Error())
+		}
+	}
+
+	err := config.Init()
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Init 初始化并检查配置
+func (this *LocalConfig) Init() error {
+	if this.Upgrade == nil {
+		this.Upgrade = &UpgradeConfig{}
+	}
+	if this.Counter == nil {
+		this.Counter = &CounterConfig{}
+	}
+	if this.Resolver == nil {
+		this.Resolver = &ResolverConfig{}
+	}
+	if this.Image == nil {
+		this.Image = &ImageTransformConfig{}
+	}
+	if this.TLSPassthrough == nil {
+		this.TLSPassthrough = &TLSPassthroughConfig{}
+	}
+	if this.UDP == nil {
+		this.UDP = &UDPProxyConfig{}
+	}
+	if this.AutoIndex == nil {
+		this.AutoIndex = &AutoIndexConfig{}
+	}
+	if this.WebSocket == nil {
+		this.WebSocket = &WebSocketConfig{}
+	}
+	if this.Stream == nil {
+		this.Stream = &StreamConfig{}
+	}
+	if this.Slice == nil {
+		this.Slice = &SliceConfig{}
+	}
+	if this.CacheSegment == nil {
+		this.CacheSegment = &CacheSegmentConfig{}
+	}
+	if this.CacheAdmission == nil {
+		this.CacheAdmission = &CacheAdmissionConfig{}
+	}
+	if this.CacheSnapshot == nil {
+		this.CacheSnapshot = &CacheSnapshotConfig{}
+	}
+	if this.CachePeer == nil {
+		this.CachePeer = &CachePeerConfig{}
+	}
+
+	for _, section := range []struct {
+		name string
+		init func() error
+	}{
+		{"upgrade", this.Upgrade.Init},
+		{"counter", this.Counter.Init},
+		{"resolver", this.Resolver.Init},
+		{"image", this.Image.Init},
+		{"tlsPassthrough", this.TLSPassthrough.Init},
+		{"udp", this.UDP.Init},
+		{"autoIndex", this.AutoIndex.Init},
+		{"websocket", this.WebSocket.Init},
+		{"stream", this.Stream.Init},
+		{"slice", this.Slice.Init},
+		{"cacheSegment", this.CacheSegment.Init},
+		{"cacheAdmission", this.CacheAdmission.Init},
+		{"cacheSnapshot", this.CacheSnapshot.Init},
+		{"cachePeer", this.CachePeer.Init},
+	} {
+		err := section.init()
+		if err != nil {
+			return errors.New("invalid '" + section.name + "' config: " + err.Error())
+		}
+	}
+	return nil
+}
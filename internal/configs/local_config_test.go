@@ -0,0 +1,42 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs_test
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"os"
+	"testing"
+)
+
+func TestParseLocalConfig(t *testing.T) {
+	{
+		config, err := configs.ParseLocalConfig(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Counter == nil || config.Counter.Backend != configs.CounterBackendLocal {
+			t.Fatal("expect default values")
+		}
+	}
+
+	{
+		_, err := configs.ParseLocalConfig([]byte(`
+counter:
+  backend: redis
+`))
+		if err == nil {
+			t.Fatal("redis addr should be required")
+		}
+	}
+}
+
+func TestParseLocalConfig_Template(t *testing.T) {
+	data, err := os.ReadFile("../../build/configs/local.template.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = configs.ParseLocalConfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
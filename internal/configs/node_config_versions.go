@@ -0,0 +1,160 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"encoding/json"
+	"github.com/cespare/xxhash"
+	"sort"
+)
+
+// 不参与全局版本计算的字段
+var nodeConfigVersionIgnoredKeys = map[string]bool{
+	"version":              true,
+	"servers":              true,
+	"httpCachePolicies":    true,
+	"httpFirewallPolicies": true,
+}
+
+// NodeConfigVersions 节点配置中各个对象的版本
+// 版本使用对象JSON内容的哈希值表示，用于在同步配置时找出有变化的服务和策略
+type NodeConfigVersions struct {
+	Version          int64            // 节点配置版本
+	Global           uint64           // 除服务和策略之外的其他配置，包括数据映射
+	Servers          map[int64]uint64 // serverId => version
+	CachePolicies    map[int64]uint64 // policyId => version
+	FirewallPolicies map[int64]uint64 // policyId => version
+}
+
+// NodeConfigDelta 两个版本之间的差异
+type NodeConfigDelta struct {
+	IsFull bool // 是否需要全量更新
+
+	UpdatedServerIds []int64 // 新增或修改的服务
+	RemovedServerIds []int64 // 删除的服务
+
+	CachePoliciesChanged    bool
+	FirewallPoliciesChanged bool
+}
+
+// IsEmpty 是否没有任何变化
+func (this *NodeConfigDelta) IsEmpty() bool {
+	return !this.IsFull &&
+		len(this.UpdatedServerIds) == 0 &&
+		len(this.RemovedServerIds) == 0 &&
+		!this.CachePoliciesChanged &&
+		!this.FirewallPoliciesChanged
+}
+
+// ParseNodeConfigVersions 从节点配置JSON中分析各个对象的版本
+func ParseNodeConfigVersions(configJSON []byte) (*NodeConfigVersions, error) {
+	var rawMap = map[string]json.RawMessage{}
+	err := json.Unmarshal(configJSON, &rawMap)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions = &NodeConfigVersions{}
+
+	// 节点版本
+	versionJSON, ok := rawMap["version"]
+	if ok && len(versionJSON) > 0 {
+		_ = json.Unmarshal(versionJSON, &versions.Version)
+	}
+
+	// 全局配置
+	var keys = []string{}
+	for key := range rawMap {
+		if !nodeConfigVersionIgnoredKeys[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var digest = xxhash.New()
+	for _, key := range keys {
+		_, _ = digest.Write([]byte(key))
+		_, _ = digest.Write(rawMap[key])
+	}
+	versions.Global = digest.Sum64()
+
+	versions.Servers, err = parseObjectVersions(rawMap["servers"])
+	if err != nil {
+		return nil, err
+	}
+	versions.CachePolicies, err = parseObjectVersions(rawMap["httpCachePolicies"])
+	if err != nil {
+		return nil, err
+	}
+	versions.FirewallPolicies, err = parseObjectVersions(rawMap["httpFirewallPolicies"])
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// Diff 对比新的版本
+func (this *NodeConfigVersions) Diff(newVersions *NodeConfigVersions) *NodeConfigDelta {
+	var delta = &NodeConfigDelta{}
+	if newVersions == nil || this.Global != newVersions.Global {
+		delta.IsFull = true
+		return delta
+	}
+
+	for serverId, version := range newVersions.Servers {
+		oldVersion, ok := this.Servers[serverId]
+		if !ok || oldVersion != version {
+			delta.UpdatedServerIds = append(delta.UpdatedServerIds, serverId)
+		}
+	}
+	for serverId := range this.Servers {
+		_, ok := newVersions.Servers[serverId]
+		if !ok {
+			delta.RemovedServerIds = append(delta.RemovedServerIds, serverId)
+		}
+	}
+
+	delta.CachePoliciesChanged = !this.equalVersions(this.CachePolicies, newVersions.CachePolicies)
+	delta.FirewallPoliciesChanged = !this.equalVersions(this.FirewallPolicies, newVersions.FirewallPolicies)
+
+	return delta
+}
+
+func (this *NodeConfigVersions) equalVersions(m1 map[int64]uint64, m2 map[int64]uint64) bool {
+	if len(m1) != len(m2) {
+		return false
+	}
+	for id, version := range m1 {
+		version2, ok := m2[id]
+		if !ok || version != version2 {
+			return false
+		}
+	}
+	return true
+}
+
+// 分析一组对象的版本
+func parseObjectVersions(listJSON json.RawMessage) (map[int64]uint64, error) {
+	var result = map[int64]uint64{}
+	if len(listJSON) == 0 || string(listJSON) == "null" {
+		return result, nil
+	}
+
+	var items = []json.RawMessage{}
+	err := json.Unmarshal(listJSON, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, itemJSON := range items {
+		var item = struct {
+			Id int64 `json:"id"`
+		}{}
+		err = json.Unmarshal(itemJSON, &item)
+		if err != nil {
+			return nil, err
+		}
+		result[item.Id] = xxhash.Sum64(itemJSON)
+	}
+	return result, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs_test
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"testing"
+)
+
+func TestParseNodeConfigVersions(t *testing.T) {
+	versions, err := configs.ParseNodeConfigVersions([]byte(`{
+	"id": 1,
+	"version": 10,
+	"servers": [ { "id": 1, "name": "a" }, { "id": 2, "name": "b" } ],
+	"httpCachePolicies": [ { "id": 1 } ],
+	"httpFirewallPolicies": null
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.Version != 10 {
+		t.Fatal("unexpected version")
+	}
+	if len(versions.Servers) != 2 || len(versions.CachePolicies) != 1 || len(versions.FirewallPolicies) != 0 {
+		t.Fatalf("unexpected versions: %+v", versions)
+	}
+}
+
+func TestNodeConfigVersions_Diff(t *testing.T) {
+	oldVersions, err := configs.ParseNodeConfigVersions([]byte(`{
+	"id": 1,
+	"version": 10,
+	"servers": [ { "id": 1, "name": "a" }, { "id": 2, "name": "b" }, { "id": 3, "name": "c" } ],
+	"httpCachePolicies": [ { "id": 1 } ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// servers only
+	{
+		newVersions, err := configs.ParseNodeConfigVersions([]byte(`{
+	"id": 1,
+	"version": 11,
+	"servers": [ { "id": 1, "name": "a" }, { "id": 2, "name": "b2" }, { "id": 4, "name": "d" } ],
+	"httpCachePolicies": [ { "id": 1 } ]
+}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var delta = oldVersions.Diff(newVersions)
+		if delta.IsFull || delta.CachePoliciesChanged || delta.FirewallPoliciesChanged {
+			t.Fatalf("unexpected delta: %+v", delta)
+		}
+		if len(delta.UpdatedServerIds) != 2 || len(delta.RemovedServerIds) != 1 || delta.RemovedServerIds[0] != 3 {
+			t.Fatalf("unexpected delta: %+v", delta)
+		}
+	}
+
+	// policies
+	{
+		newVersions, err := configs.ParseNodeConfigVersions([]byte(`{
+	"id": 1,
+	"version": 11,
+	"servers": [ { "id": 1, "name": "a" }, { "id": 2, "name": "b" }, { "id": 3, "name": "c" } ],
+	"httpCachePolicies": [ { "id": 1, "isOn": true } ]
+}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var delta = oldVersions.Diff(newVersions)
+		if delta.IsFull || !delta.CachePoliciesChanged || len(delta.UpdatedServerIds) > 0 {
+			t.Fatalf("unexpected delta: %+v", delta)
+		}
+	}
+
+	// global
+	{
+		newVersions, err := configs.ParseNodeConfigVersions([]byte(`{
+	"id": 1,
+	"version": 11,
+	"maxCPU": 4,
+	"servers": [ { "id": 1, "name": "a" }, { "id": 2, "name": "b" }, { "id": 3, "name": "c" } ],
+	"httpCachePolicies": [ { "id": 1 } ]
+}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var delta = oldVersions.Diff(newVersions)
+		if !delta.IsFull {
+			t.Fatal("should be full")
+		}
+	}
+
+	// data map
+	{
+		newVersions, err := configs.ParseNodeConfigVersions([]byte(`{
+	"id": 1,
+	"version": 11,
+	"dataMap": { "map": { "a": "MTIz" } },
+	"servers": [ { "id": 1, "name": "a" }, { "id": 2, "name": "b" }, { "id": 3, "name": "c" } ],
+	"httpCachePolicies": [ { "id": 1 } ]
+}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !oldVersions.Diff(newVersions).IsFull {
+			t.Fatal("data map changes should be full")
+		}
+	}
+
+	// same
+	{
+		newVersions, err := configs.ParseNodeConfigVersions([]byte(`{
+	"version": 12,
+	"id": 1,
+	"servers": [ { "id": 1, "name": "a" }, { "id": 2, "name": "b" }, { "id": 3, "name": "c" } ],
+	"httpCachePolicies": [ { "id": 1 } ]
+}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !oldVersions.Diff(newVersions).IsEmpty() {
+			t.Fatal("should be empty")
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+	"net"
+)
+
+// ResolverConfig 源站域名解析配置
+type ResolverConfig struct {
+	Servers      []string `yaml:"servers" json:"servers"`           // 上游DNS服务器，比如 8.8.8.8:53，为空时使用 /etc/resolv.conf 中的服务器
+	TimeoutMs    int      `yaml:"timeoutMs" json:"timeoutMs"`       // 单次查询超时时间（毫秒）
+	MinTTL       int      `yaml:"minTTL" json:"minTTL"`             // 最短缓存时间（秒）
+	MaxTTL       int      `yaml:"maxTTL" json:"maxTTL"`             // 最长缓存时间（秒）
+	StaleTTL     int      `yaml:"staleTTL" json:"staleTTL"`         // 上游DNS失败时过期结果仍可使用的时间（秒）
+	PrefetchHits int      `yaml:"prefetchHits" json:"prefetchHits"` // 一个缓存周期内访问次数达到此值时在过期前提前刷新
+}
+
+// Init 初始化并检查配置
+func (this *ResolverConfig) Init() error {
+	for index, server := range this.Servers {
+		_, _, err := net.SplitHostPort(server)
+		if err != nil {
+			if net.ParseIP(server) == nil {
+				return errors.New("invalid resolver server '" + server + "'")
+			}
+			this.Servers[index] = net.JoinHostPort(server, "53")
+		}
+	}
+
+	if this.TimeoutMs <= 0 {
+		this.TimeoutMs = 2000
+	}
+	if this.MinTTL <= 0 {
+		this.MinTTL = 5
+	}
+	if this.MaxTTL <= 0 {
+		this.MaxTTL = 3600
+	}
+	if this.MaxTTL < this.MinTTL {
+		this.MaxTTL = this.MinTTL
+	}
+	if this.StaleTTL <= 0 {
+		this.StaleTTL = 3600
+	}
+	if this.PrefetchHits <= 0 {
+		this.PrefetchHits = 10
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	DefaultSliceSize int64 = 4 << 20
+	MinSliceSize     int64 = 64 << 10
+	MaxSliceSize     int64 = 64 << 20
+)
+
+// SliceConfig 分片回源配置
+// 启用后，大文件按固定尺寸的分片从源站读取，每个分片单独缓存
+type SliceConfig struct {
+	IsOn       bool     `yaml:"isOn" json:"isOn"`             // 是否启用
+	ServerIds  []int64  `yaml:"serverIds" json:"serverIds"`   // 启用的网站ID，为空表示所有网站
+	Extensions []string `yaml:"extensions" json:"extensions"` // 启用的文件扩展名，比如 .mp4，为空表示所有文件
+	SliceSize  int64    `yaml:"sliceSize" json:"sliceSize"`   // 分片尺寸（字节）
+}
+
+// Init 初始化并检查配置
+func (this *SliceConfig) Init() error {
+	if this.SliceSize <= 0 {
+		this.SliceSize = DefaultSliceSize
+	}
+	if this.SliceSize < MinSliceSize || this.SliceSize > MaxSliceSize {
+		return errors.New("'sliceSize' should be between 64KB and 64MB")
+	}
+
+	for index, ext := range this.Extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if len(ext) > 0 && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		this.Extensions[index] = ext
+	}
+	return nil
+}
+
+// MatchRequest 检查某个请求是否启用分片回源
+func (this *SliceConfig) MatchRequest(serverId int64, path string) bool {
+	if !this.IsOn {
+		return false
+	}
+
+	if len(this.ServerIds) > 0 {
+		var found = false
+		for _, id := range this.ServerIds {
+			if id == serverId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(this.Extensions) == 0 {
+		return true
+	}
+	var ext = strings.ToLower(filepath.Ext(path))
+	for _, allowedExt := range this.Extensions {
+		if ext == allowedExt {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/iwind/TeaGo/Tea"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 独立模式配置文件，按顺序查找
+var standaloneConfigFiles = []string{"standalone.yaml", "standalone.yml", "standalone.json"}
+
+// StandaloneConfig 独立运行模式配置
+// 在没有API节点的环境中（比如边缘站点或者CI），节点直接从此配置中读取服务、源站、缓存和WAF等配置
+type StandaloneConfig struct {
+	Node map[string]any `yaml:"node" json:"node"` // 节点配置，格式和API节点下发的NodeConfig一致
+
+	AccessLog struct {
+		File string `yaml:"file" json:"file"` // 访问日志文件，为空表示不记录
+	} `yaml:"accessLog" json:"accessLog"`
+}
+
+// FindStandaloneConfigFile 查找独立模式配置文件
+// 如果不存在则返回空
+func FindStandaloneConfigFile() string {
+	for _, filename := range standaloneConfigFiles {
+		var path = Tea.ConfigFile(filename)
+		stat, err := os.Stat(path)
+		if err == nil && !stat.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// LoadStandaloneConfig 从文件中加载独立模式配置
+// 根据文件扩展名决定使用YAML还是JSON解析
+func LoadStandaloneConfig(path string) (*StandaloneConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config = &StandaloneConfig{}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err = json.Unmarshal(data, config)
+	} else {
+		err = yaml.Unmarshal(data, config)
+	}
+	if err != nil {
+		return nil, errors.New("decode '" + filepath.Base(path) + "' failed: " + err.Error())
+	}
+
+	if len(config.Node) == 0 {
+		return nil, errors.New("'node' should not be empty in '" + filepath.Base(path) + "'")
+	}
+
+	return config, nil
+}
+
+// NodeJSON 将节点配置转换为JSON，以便于解析为NodeConfig
+func (this *StandaloneConfig) NodeJSON() ([]byte, error) {
+	return json.Marshal(this.Node)
+}
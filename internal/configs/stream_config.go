@@ -0,0 +1,76 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+	"strings"
+)
+
+// StreamConfig 流式响应配置
+type StreamConfig struct {
+	IdleTimeout int                 `yaml:"idleTimeout" json:"idleTimeout"` // 两次读取到源站数据之间的最长间隔（秒），流式响应使用此超时时间代替源站读取超时时间
+	Rules       []*StreamRuleConfig `yaml:"rules" json:"rules"`             // 总是作为流式响应处理的请求
+}
+
+// StreamRuleConfig 流式响应规则
+type StreamRuleConfig struct {
+	ServerIds    []int64  `yaml:"serverIds" json:"serverIds"`       // 网站ID，为空表示所有网站
+	PathPrefixes []string `yaml:"pathPrefixes" json:"pathPrefixes"` // 路径前缀，为空表示所有路径
+}
+
+// Init 初始化并检查配置
+func (this *StreamConfig) Init() error {
+	if this.IdleTimeout <= 0 {
+		this.IdleTimeout = 300
+	}
+
+	var rules = []*StreamRuleConfig{}
+	for _, rule := range this.Rules {
+		if rule == nil {
+			continue
+		}
+		if len(rule.ServerIds) == 0 && len(rule.PathPrefixes) == 0 {
+			return errors.New("stream rule should have at least one server id or path prefix")
+		}
+		rules = append(rules, rule)
+	}
+	this.Rules = rules
+	return nil
+}
+
+// MatchRequest 检查请求是否匹配某个规则
+func (this *StreamConfig) MatchRequest(serverId int64, path string) bool {
+	for _, rule := range this.Rules {
+		if rule.Match(serverId, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match 检查规则是否匹配
+func (this *StreamRuleConfig) Match(serverId int64, path string) bool {
+	if len(this.ServerIds) > 0 {
+		var found = false
+		for _, id := range this.ServerIds {
+			if id == serverId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(this.PathPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range this.PathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
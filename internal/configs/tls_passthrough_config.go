@@ -0,0 +1,109 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+	"github.com/iwind/TeaGo/types"
+	"strings"
+)
+
+// TLSPassthroughConfig TLS透传配置
+// 只有匹配规则的连接才会透传，其余连接仍然由节点使用网站证书终止TLS
+type TLSPassthroughConfig struct {
+	Rules []*TLSPassthroughRule `yaml:"rules" json:"rules"` // 透传规则，按顺序匹配
+}
+
+// TLSPassthroughRule TLS透传规则
+type TLSPassthroughRule struct {
+	ServerId    int64    `yaml:"serverId" json:"serverId"`       // 透传到此网站的源站，网站需要监听当前端口
+	ServerNames []string `yaml:"serverNames" json:"serverNames"` // 匹配的SNI，支持 *.example.com，为空表示匹配网站自身的域名
+	ALPN        []string `yaml:"alpn" json:"alpn"`               // 匹配的ALPN协议，客户端提供其中任一个即可，为空表示不限
+
+	serverNames []string
+}
+
+// Init 初始化并检查配置
+func (this *TLSPassthroughConfig) Init() error {
+	for index, rule := range this.Rules {
+		if rule == nil {
+			return errors.New("rule " + types.String(index) + ": should not be empty")
+		}
+		err := rule.Init()
+		if err != nil {
+			return errors.New("rule " + types.String(index) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Match 查找匹配的透传规则
+// matchedServerId 为根据SNI找到的网站ID，找不到时为0
+func (this *TLSPassthroughConfig) Match(serverName string, alpn []string, matchedServerId int64) *TLSPassthroughRule {
+	serverName = strings.ToLower(serverName)
+	for _, rule := range this.Rules {
+		if rule.Match(serverName, alpn, matchedServerId) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Init 初始化并检查规则
+func (this *TLSPassthroughRule) Init() error {
+	if this.ServerId <= 0 {
+		return errors.New("'serverId' should be greater than 0")
+	}
+
+	this.serverNames = nil
+	for _, serverName := range this.ServerNames {
+		serverName = strings.ToLower(strings.TrimSpace(serverName))
+		if len(serverName) == 0 {
+			continue
+		}
+		if strings.Contains(strings.TrimPrefix(serverName, "*."), "*") {
+			return errors.New("invalid server name '" + serverName + "', only '*.' prefix is supported")
+		}
+		this.serverNames = append(this.serverNames, serverName)
+	}
+	return nil
+}
+
+// Match 检查SNI和ALPN是否匹配
+func (this *TLSPassthroughRule) Match(serverName string, alpn []string, matchedServerId int64) bool {
+	if len(this.serverNames) == 0 {
+		if matchedServerId != this.ServerId {
+			return false
+		}
+	} else if !this.matchServerName(serverName) {
+		return false
+	}
+
+	if len(this.ALPN) == 0 {
+		return true
+	}
+	for _, protocol := range alpn {
+		for _, ruleProtocol := range this.ALPN {
+			if protocol == ruleProtocol {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (this *TLSPassthroughRule) matchServerName(serverName string) bool {
+	if len(serverName) == 0 {
+		return false
+	}
+	for _, ruleServerName := range this.serverNames {
+		if strings.HasPrefix(ruleServerName, "*.") {
+			if strings.HasSuffix(serverName, ruleServerName[1:]) && len(serverName) > len(ruleServerName)-1 {
+				return true
+			}
+		} else if ruleServerName == serverName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+	"github.com/iwind/TeaGo/types"
+)
+
+const (
+	UDPAffinityNone     = "none"     // 不保持会话
+	UDPAffinityClientIP = "clientIP" // 按客户端IP一致性Hash选择源站
+
+	UDPHealthCheckTypeDNS = "dns" // 发送DNS查询检查源站
+)
+
+// UDPProxyConfig UDP代理配置
+type UDPProxyConfig struct {
+	Default *UDPProxyPolicy           `yaml:"default" json:"default"` // 默认策略
+	Servers map[int64]*UDPProxyPolicy `yaml:"servers" json:"servers"` // 单个网站的策略：serverId => policy，未设置的选项使用默认策略
+}
+
+// UDPProxyPolicy UDP代理策略
+type UDPProxyPolicy struct {
+	Affinity        string                `yaml:"affinity" json:"affinity"`               // 会话保持方式：none、clientIP
+	SessionTimeout  int                   `yaml:"sessionTimeout" json:"sessionTimeout"`   // 会话空闲超时时间（秒）
+	MaxSessions     int                   `yaml:"maxSessions" json:"maxSessions"`         // 最大会话数，0表示不限制
+	ResponseTimeout int                   `yaml:"responseTimeout" json:"responseTimeout"` // 源站响应超时时间（秒），超时后认为源站失败，0表示不检查
+	HealthCheck     *UDPHealthCheckConfig `yaml:"healthCheck" json:"healthCheck"`         // 源站健康检查
+}
+
+// UDPHealthCheckConfig UDP源站健康检查配置
+type UDPHealthCheckConfig struct {
+	IsOn      bool   `yaml:"isOn" json:"isOn"`           // 是否启用
+	Type      string `yaml:"type" json:"type"`           // 检查方式，目前只支持dns
+	Interval  int    `yaml:"interval" json:"interval"`   // 检查间隔（秒）
+	TimeoutMs int    `yaml:"timeoutMs" json:"timeoutMs"` // 单次检查超时时间（毫秒）
+	MaxFails  int    `yaml:"maxFails" json:"maxFails"`   // 连续失败N次后认为源站不可用
+	Domain    string `yaml:"domain" json:"domain"`       // 查询的域名
+	QueryType string `yaml:"queryType" json:"queryType"` // 查询的记录类型，比如A、NS
+}
+
+// Init 初始化并检查配置
+func (this *UDPProxyConfig) Init() error {
+	if this.Default == nil {
+		this.Default = &UDPProxyPolicy{}
+	}
+	err := this.Default.Init(nil)
+	if err != nil {
+		return errors.New("default: " + err.Error())
+	}
+
+	for serverId, policy := range this.Servers {
+		if policy == nil {
+			delete(this.Servers, serverId)
+			continue
+		}
+		err = policy.Init(this.Default)
+		if err != nil {
+			return errors.New("server '" + types.String(serverId) + "': " + err.Error())
+		}
+	}
+	return nil
+}
+
+// PolicyForServer 获取某个网站的策略
+func (this *UDPProxyConfig) PolicyForServer(serverId int64) *UDPProxyPolicy {
+	policy, ok := this.Servers[serverId]
+	if ok {
+		return policy
+	}
+	return this.Default
+}
+
+// Init 初始化并检查策略
+// parent 不为空时，未设置的选项从 parent 中继承
+func (this *UDPProxyPolicy) Init(parent *UDPProxyPolicy) error {
+	if parent != nil {
+		if len(this.Affinity) == 0 {
+			this.Affinity = parent.Affinity
+		}
+		if this.SessionTimeout <= 0 {
+			this.SessionTimeout = parent.SessionTimeout
+		}
+		if this.MaxSessions <= 0 {
+			this.MaxSessions = parent.MaxSessions
+		}
+		if this.ResponseTimeout <= 0 {
+			this.ResponseTimeout = parent.ResponseTimeout
+		}
+		if this.HealthCheck == nil {
+			this.HealthCheck = parent.HealthCheck
+		}
+	}
+
+	switch this.Affinity {
+	case "":
+		this.Affinity = UDPAffinityNone
+	case UDPAffinityNone, UDPAffinityClientIP:
+	default:
+		return errors.New("invalid affinity '" + this.Affinity + "'")
+	}
+
+	if this.SessionTimeout <= 0 {
+		this.SessionTimeout = 30
+	}
+	if this.MaxSessions < 0 {
+		this.MaxSessions = 0
+	}
+	if this.ResponseTimeout < 0 {
+		this.ResponseTimeout = 0
+	}
+
+	if this.HealthCheck != nil {
+		err := this.HealthCheck.Init()
+		if err != nil {
+			return errors.New("healthCheck: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// AffinityIsOn 是否开启了会话保持
+func (this *UDPProxyPolicy) AffinityIsOn() bool {
+	return this.Affinity == UDPAffinityClientIP
+}
+
+// HealthCheckIsOn 是否开启了健康检查
+func (this *UDPProxyPolicy) HealthCheckIsOn() bool {
+	return this.HealthCheck != nil && this.HealthCheck.IsOn
+}
+
+// Init 初始化并检查配置
+func (this *UDPHealthCheckConfig) Init() error {
+	if len(this.Type) == 0 {
+		this.Type = UDPHealthCheckTypeDNS
+	}
+	if this.Type != UDPHealthCheckTypeDNS {
+		return errors.New("unsupported type '" + this.Type + "'")
+	}
+	if this.Interval <= 0 {
+		this.Interval = 10
+	}
+	if this.TimeoutMs <= 0 {
+		this.TimeoutMs = 2000
+	}
+	if this.MaxFails <= 0 {
+		this.MaxFails = 3
+	}
+	if len(this.Domain) == 0 {
+		this.Domain = "."
+	}
+	if len(this.QueryType) == 0 {
+		this.QueryType = "NS"
+	}
+	return nil
+}
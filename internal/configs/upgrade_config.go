@@ -0,0 +1,13 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+// UpgradeConfig 节点升级配置
+type UpgradeConfig struct {
+	AllowUnsigned bool `yaml:"allowUnsigned" json:"allowUnsigned"` // 没有内置签名公钥时是否允许安装未校验签名的升级包
+}
+
+// Init 初始化并检查配置
+func (this *UpgradeConfig) Init() error {
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package configs
+
+import (
+	"errors"
+	"github.com/iwind/TeaGo/types"
+)
+
+// WebSocketConfig Websocket代理配置
+type WebSocketConfig struct {
+	Default *WebSocketPolicy           `yaml:"default" json:"default"` // 默认策略
+	Servers map[int64]*WebSocketPolicy `yaml:"servers" json:"servers"` // 单个网站的策略：serverId => policy，未设置的选项使用默认策略
+}
+
+// WebSocketPolicy Websocket代理策略
+type WebSocketPolicy struct {
+	HandshakeTimeout     int   `yaml:"handshakeTimeout" json:"handshakeTimeout"`         // 和源站握手的超时时间（秒）
+	IdleTimeout          int   `yaml:"idleTimeout" json:"idleTimeout"`                   // 双向都没有数据时的超时时间（秒），0表示不限制
+	MaxMessageSize       int64 `yaml:"maxMessageSize" json:"maxMessageSize"`             // 客户端单个消息最大尺寸（字节），0表示不限制
+	MaxMessagesPerSecond int   `yaml:"maxMessagesPerSecond" json:"maxMessagesPerSecond"` // 客户端每秒最多发送的消息数，0表示不限制
+	PingInterval         int   `yaml:"pingInterval" json:"pingInterval"`                 // 边缘节点向客户端发送Ping的间隔（秒），0表示不发送
+}
+
+// Init 初始化并检查配置
+func (this *WebSocketConfig) Init() error {
+	if this.Default == nil {
+		this.Default = &WebSocketPolicy{}
+	}
+	err := this.Default.Init(nil)
+	if err != nil {
+		return errors.New("default: " + err.Error())
+	}
+
+	for serverId, policy := range this.Servers {
+		if policy == nil {
+			delete(this.Servers, serverId)
+			continue
+		}
+		err = policy.Init(this.Default)
+		if err != nil {
+			return errors.New("server '" + types.String(serverId) + "': " + err.Error())
+		}
+	}
+	return nil
+}
+
+// PolicyForServer 获取某个网站的策略
+func (this *WebSocketConfig) PolicyForServer(serverId int64) *WebSocketPolicy {
+	policy, ok := this.Servers[serverId]
+	if ok {
+		return policy
+	}
+	return this.Default
+}
+
+// Init 初始化并检查策略
+// parent 不为空时，未设置的选项从 parent 中继承
+func (this *WebSocketPolicy) Init(parent *WebSocketPolicy) error {
+	if parent != nil {
+		if this.HandshakeTimeout <= 0 {
+			this.HandshakeTimeout = parent.HandshakeTimeout
+		}
+		if this.IdleTimeout <= 0 {
+			this.IdleTimeout = parent.IdleTimeout
+		}
+		if this.MaxMessageSize <= 0 {
+			this.MaxMessageSize = parent.MaxMessageSize
+		}
+		if this.MaxMessagesPerSecond <= 0 {
+			this.MaxMessagesPerSecond = parent.MaxMessagesPerSecond
+		}
+		if this.PingInterval <= 0 {
+			this.PingInterval = parent.PingInterval
+		}
+	}
+
+	if this.HandshakeTimeout <= 0 {
+		this.HandshakeTimeout = 10
+	}
+	if this.IdleTimeout < 0 {
+		this.IdleTimeout = 0
+	}
+	if this.MaxMessageSize < 0 {
+		this.MaxMessageSize = 0
+	}
+	if this.MaxMessagesPerSecond < 0 {
+		this.MaxMessagesPerSecond = 0
+	}
+	if this.PingInterval < 0 {
+		this.PingInterval = 0
+	}
+	if this.IdleTimeout > 0 && this.PingInterval > 0 && this.PingInterval >= this.IdleTimeout {
+		return errors.New("'pingInterval' should be less than 'idleTimeout'")
+	}
+	return nil
+}
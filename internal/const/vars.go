@@ -22,8 +22,13 @@ var (
 
 	IsQuiting    = false // 是否正在退出
 	EnableDBStat = false // 是否开启本地数据库统计
+	IsStandalone = false // 是否为独立运行模式，此模式下不连接API节点
 
 	DiskIsFast = false // 是否为高速硬盘
+
+	// UpgradePublicKey 用于校验升级包签名的Ed25519公钥（Base64编码）
+	// 为空时拒绝升级，除非在 configs/local.yaml 中设置了 upgrade.allowUnsigned；可以在编译时通过 -ldflags "-X github.com/TeaOSLab/EdgeNode/internal/const.UpgradePublicKey=..." 设置
+	UpgradePublicKey = ""
 )
 
 // 检查是否为主程序
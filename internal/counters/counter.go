@@ -0,0 +1,44 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package counters
+
+import (
+	"sync/atomic"
+)
+
+var sharedCounter = &atomic.Value{}
+
+func init() {
+	sharedCounter.Store(&counterHolder{counter: NewLocalCounter()})
+}
+
+// CounterInterface CC等阈值计数器接口
+type CounterInterface interface {
+	// Increase 增加计数并返回当前的计数
+	// expiresAt 为计数过期时间戳，extend 表示是否使用新的过期时间延长已有的计数
+	Increase(key string, delta int64, expiresAt int64, extend bool) int64
+
+	// Close 关闭计数器
+	Close() error
+}
+
+// 用来在atomic.Value中存储不同类型的计数器
+type counterHolder struct {
+	counter CounterInterface
+}
+
+// SharedCounter 获取当前使用的计数器
+func SharedCounter() CounterInterface {
+	return sharedCounter.Load().(*counterHolder).counter
+}
+
+// SetSharedCounter 设置当前使用的计数器，并关闭之前的计数器
+func SetSharedCounter(counter CounterInterface) {
+	if counter == nil {
+		return
+	}
+	var oldHolder = sharedCounter.Swap(&counterHolder{counter: counter}).(*counterHolder)
+	if oldHolder.counter != counter {
+		_ = oldHolder.counter.Close()
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package counters
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/ttlcache"
+)
+
+// LocalCounter 进程内的计数器
+type LocalCounter struct {
+	cache *ttlcache.Cache
+}
+
+// NewLocalCounter 获取新对象
+func NewLocalCounter() *LocalCounter {
+	return &LocalCounter{
+		cache: ttlcache.NewCache(),
+	}
+}
+
+// Increase 增加计数
+func (this *LocalCounter) Increase(key string, delta int64, expiresAt int64, extend bool) int64 {
+	return this.cache.IncreaseInt64(key, delta, expiresAt, extend)
+}
+
+// Close 关闭计数器
+func (this *LocalCounter) Close() error {
+	this.cache.Destroy()
+	return nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package counters
+
+import (
+	"context"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 最多同时跟踪的键数量，超出后使用本地计数
+const maxRedisCounterKeys = 1_000_000
+
+type redisCounterItem struct {
+	pending   int64 // 尚未同步到Redis的增量
+	remote    int64 // 最近一次同步后Redis中的计数
+	expiresAt int64
+	extend    bool
+}
+
+// RedisCounter 使用Redis在集群中共享的计数器
+// 增量先在本地累积，然后批量同步到Redis，返回的计数为最近一次同步的结果加上本地尚未同步的增量；
+// Redis不可用时自动降级为本地计数，恢复后继续使用Redis
+type RedisCounter struct {
+	client *redis.Client
+	prefix string
+	local  *LocalCounter
+
+	itemMap map[string]*redisCounterItem
+	locker  sync.Mutex
+
+	isOnline int32
+	ticker   *time.Ticker
+	timeout  time.Duration
+}
+
+// NewRedisCounter 获取新对象
+func NewRedisCounter(config *configs.CounterConfig) *RedisCounter {
+	var flushInterval = time.Duration(config.Redis.FlushIntervalMs) * time.Millisecond
+	if flushInterval <= 0 {
+		flushInterval = 100 * time.Millisecond
+	}
+
+	var timeout = 1 * time.Second
+	var counter = &RedisCounter{
+		client: redis.NewClient(&redis.Options{
+			Addr:         config.Redis.Addr,
+			Password:     config.Redis.Password,
+			DB:           config.Redis.DB,
+			DialTimeout:  timeout,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+			MaxRetries:   -1,
+		}),
+		prefix:   config.Redis.Prefix,
+		local:    NewLocalCounter(),
+		itemMap:  map[string]*redisCounterItem{},
+		isOnline: 1,
+		ticker:   time.NewTicker(flushInterval),
+		timeout:  timeout,
+	}
+
+	goman.New(func() {
+		for range counter.ticker.C {
+			counter.flush()
+		}
+	})
+
+	return counter
+}
+
+// Increase 增加计数
+func (this *RedisCounter) Increase(key string, delta int64, expiresAt int64, extend bool) int64 {
+	if !this.IsOnline() {
+		return this.local.Increase(key, delta, expiresAt, extend)
+	}
+
+	var now = time.Now().Unix()
+	if expiresAt <= now {
+		return 0
+	}
+
+	this.locker.Lock()
+	item, ok := this.itemMap[key]
+	if !ok || item.expiresAt <= now {
+		if !ok && len(this.itemMap) >= maxRedisCounterKeys {
+			this.locker.Unlock()
+			return this.local.Increase(key, delta, expiresAt, extend)
+		}
+		item = &redisCounterItem{
+			expiresAt: expiresAt,
+		}
+		this.itemMap[key] = item
+	}
+	item.pending += delta
+	if extend {
+		item.expiresAt = expiresAt
+		item.extend = true
+	}
+	var result = item.remote + item.pending
+	this.locker.Unlock()
+
+	return result
+}
+
+// IsOnline 检查Redis是否可用
+func (this *RedisCounter) IsOnline() bool {
+	return atomic.LoadInt32(&this.isOnline) == 1
+}
+
+// Close 关闭计数器
+func (this *RedisCounter) Close() error {
+	this.ticker.Stop()
+	this.flush()
+	_ = this.local.Close()
+	return this.client.Close()
+}
+
+// 将本地累积的增量同步到Redis
+func (this *RedisCounter) flush() {
+	// 离线时检查是否恢复
+	if !this.IsOnline() {
+		ctx, cancel := context.WithTimeout(context.Background(), this.timeout)
+		err := this.client.Ping(ctx).Err()
+		cancel()
+		if err == nil {
+			atomic.StoreInt32(&this.isOnline, 1)
+			remotelogs.Println("COUNTER", "redis is online again")
+		}
+		return
+	}
+
+	type flushItem struct {
+		key       string
+		item      *redisCounterItem
+		delta     int64
+		expiresAt int64
+		extend    bool
+		cmd       *redis.IntCmd
+	}
+
+	var now = time.Now().Unix()
+	var flushItems = []*flushItem{}
+	this.locker.Lock()
+	for key, item := range this.itemMap {
+		if item.expiresAt <= now {
+			delete(this.itemMap, key)
+			continue
+		}
+		if item.pending == 0 {
+			continue
+		}
+		flushItems = append(flushItems, &flushItem{
+			key:       key,
+			item:      item,
+			delta:     item.pending,
+			expiresAt: item.expiresAt,
+			extend:    item.extend,
+		})
+		item.pending = 0
+	}
+	this.locker.Unlock()
+
+	if len(flushItems) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), this.timeout)
+	defer cancel()
+
+	_, err := this.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, flushItem := range flushItems {
+			var redisKey = this.prefix + flushItem.key
+			var expiresAt = time.Unix(flushItem.expiresAt, 0)
+
+			// 第一次创建时设置过期时间
+			pipe.SetArgs(ctx, redisKey, 0, redis.SetArgs{
+				Mode:     "NX",
+				ExpireAt: expiresAt,
+			})
+			flushItem.cmd = pipe.IncrBy(ctx, redisKey, flushItem.delta)
+			if flushItem.extend {
+				pipe.ExpireAt(ctx, redisKey, expiresAt)
+			}
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		this.markOffline(err)
+		return
+	}
+
+	this.locker.Lock()
+	for _, flushItem := range flushItems {
+		result, err := flushItem.cmd.Result()
+		if err != nil {
+			continue
+		}
+		if this.itemMap[flushItem.key] == flushItem.item {
+			flushItem.item.remote = result
+		}
+	}
+	this.locker.Unlock()
+}
+
+// 标记为离线，在恢复之前使用本地计数
+func (this *RedisCounter) markOffline(err error) {
+	if !atomic.CompareAndSwapInt32(&this.isOnline, 1, 0) {
+		return
+	}
+
+	this.locker.Lock()
+	this.itemMap = map[string]*redisCounterItem{}
+	this.locker.Unlock()
+
+	remotelogs.Warn("COUNTER", "redis is unreachable, fall back to local counter: "+err.Error())
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package counters
+
+import (
+	"bufio"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 用于测试的进程内Redis，只实现了计数器用到的命令
+type testRedisServer struct {
+	listener  net.Listener
+	valueMap  map[string]int64
+	expireMap map[string]int64
+	locker    sync.Mutex
+}
+
+func newTestRedisServer(t *testing.T) *testRedisServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server = &testRedisServer{
+		listener:  listener,
+		valueMap:  map[string]int64{},
+		expireMap: map[string]int64{},
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handle(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	return server
+}
+
+func (this *testRedisServer) Addr() string {
+	return this.listener.Addr().String()
+}
+
+func (this *testRedisServer) Value(key string) int64 {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.valueMap[key]
+}
+
+func (this *testRedisServer) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var reader = bufio.NewReader(conn)
+	for {
+		args, err := this.readCommand(reader)
+		if err != nil {
+			return
+		}
+		_, err = conn.Write([]byte(this.exec(args)))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (this *testRedisServer) readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	var args = []string{}
+	for i := 0; i < count; i++ {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		var buf = make([]byte, size+2)
+		_, err = io.ReadFull(reader, buf)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func (this *testRedisServer) exec(args []string) string {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	switch strings.ToLower(args[0]) {
+	case "ping":
+		return "+PONG\r\n"
+	case "set":
+		var key = args[1]
+		_, exists := this.valueMap[key]
+		var isNX = false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "nx":
+				isNX = true
+			case "exat":
+				if !exists || !isNX {
+					this.expireMap[key], _ = strconv.ParseInt(args[i+1], 10, 64)
+				}
+				i++
+			}
+		}
+		if isNX && exists {
+			return "$-1\r\n"
+		}
+		this.valueMap[key], _ = strconv.ParseInt(args[2], 10, 64)
+		return "+OK\r\n"
+	case "incrby":
+		delta, _ := strconv.ParseInt(args[2], 10, 64)
+		this.valueMap[args[1]] += delta
+		return ":" + strconv.FormatInt(this.valueMap[args[1]], 10) + "\r\n"
+	case "expireat":
+		this.expireMap[args[1]], _ = strconv.ParseInt(args[2], 10, 64)
+		return ":1\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func newTestRedisCounter(addr string) *RedisCounter {
+	var config = &configs.CounterConfig{Backend: configs.CounterBackendRedis}
+	config.Redis.Addr = addr
+	config.Redis.FlushIntervalMs = 3600 * 1000 // 在测试中手动同步
+	_ = config.Init()
+	return NewRedisCounter(config)
+}
+
+func TestRedisCounter_Increase(t *testing.T) {
+	var server = newTestRedisServer(t)
+
+	var counter1 = newTestRedisCounter(server.Addr())
+	var counter2 = newTestRedisCounter(server.Addr())
+	defer func() {
+		_ = counter1.Close()
+		_ = counter2.Close()
+	}()
+
+	var expiresAt = time.Now().Unix() + 60
+	for i := 0; i < 10; i++ {
+		counter1.Increase("a", 1, expiresAt, false)
+		counter2.Increase("a", 1, expiresAt, false)
+	}
+	counter1.flush()
+	counter2.flush()
+
+	if server.Value("edge:counter:a") != 20 {
+		t.Fatal("expect 20, got", server.Value("edge:counter:a"))
+	}
+
+	// 计数包含其他节点的增量
+	var value = counter2.Increase("a", 1, expiresAt, false)
+	if value != 21 {
+		t.Fatal("expect 21, got", value)
+	}
+}
+
+func TestRedisCounter_Offline(t *testing.T) {
+	// 获取一个没有监听的地址
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addr = listener.Addr().String()
+	_ = listener.Close()
+
+	var counter = newTestRedisCounter(addr)
+	defer func() {
+		_ = counter.Close()
+	}()
+
+	var expiresAt = time.Now().Unix() + 60
+	counter.Increase("a", 1, expiresAt, false)
+	counter.flush()
+	if counter.IsOnline() {
+		t.Fatal("should be offline")
+	}
+
+	// 使用本地计数
+	counter.Increase("a", 1, expiresAt, false)
+	var value = counter.Increase("a", 1, expiresAt, false)
+	if value != 2 {
+		t.Fatal("expect 2, got", value)
+	}
+}
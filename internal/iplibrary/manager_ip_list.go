@@ -67,6 +67,11 @@ func NewIPListManager() *IPListManager {
 func (this *IPListManager) Start() {
 	this.init()
 
+	// 独立运行模式下没有API节点可以同步
+	if teaconst.IsStandalone {
+		return
+	}
+
 	// 第一次读取
 	err := this.loop()
 	if err != nil {
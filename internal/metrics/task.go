@@ -352,7 +352,7 @@ func (this *Task) CleanExpired() error {
 
 // Upload 上传数据
 func (this *Task) Upload(pauseDuration time.Duration) error {
-	if this.isStopped {
+	if this.isStopped || teaconst.IsStandalone {
 		return nil
 	}
 
@@ -41,6 +41,11 @@ func NewValueQueue() *ValueQueue {
 
 // Start 启动队列
 func (this *ValueQueue) Start() {
+	// 独立运行模式下不上传
+	if teaconst.IsStandalone {
+		return
+	}
+
 	// 这里单次循环就行，因为Loop里已经使用了Range通道
 	err := this.Loop()
 	if err != nil {
@@ -2,14 +2,18 @@ package nodes
 
 import (
 	"bytes"
+	"encoding/json"
 	"github.com/TeaOSLab/EdgeCommon/pkg/rpc/pb"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
 	"github.com/TeaOSLab/EdgeNode/internal/goman"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
 	"github.com/TeaOSLab/EdgeNode/internal/rpc"
 	"github.com/TeaOSLab/EdgeNode/internal/utils"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"os"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 )
@@ -21,6 +25,10 @@ type HTTPAccessLogQueue struct {
 	queue chan *pb.HTTPAccessLog
 
 	rpcClient *rpc.RPCClient
+
+	localFile       *os.File // 本地访问日志文件，用于独立运行模式
+	localFilePath   string
+	localFileLocker sync.Mutex
 }
 
 // NewHTTPAccessLogQueue 获取新对象
@@ -97,6 +105,11 @@ Loop:
 		}
 	}
 
+	// 独立运行模式下只写入本地文件
+	if teaconst.IsStandalone {
+		return this.writeLocalFile(accessLogs)
+	}
+
 	// 发送到API
 	if this.rpcClient == nil {
 		client, err := rpc.SharedRPC()
@@ -138,6 +151,55 @@ Loop:
 	return nil
 }
 
+// SetLocalFile 设置本地访问日志文件
+// path 为空时表示关闭本地文件
+func (this *HTTPAccessLogQueue) SetLocalFile(path string) error {
+	this.localFileLocker.Lock()
+	defer this.localFileLocker.Unlock()
+
+	if path == this.localFilePath && (len(path) == 0 || this.localFile != nil) {
+		return nil
+	}
+
+	if this.localFile != nil {
+		_ = this.localFile.Close()
+		this.localFile = nil
+	}
+	this.localFilePath = path
+
+	if len(path) == 0 {
+		return nil
+	}
+
+	fp, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	this.localFile = fp
+	return nil
+}
+
+// 将访问日志以JSON Lines格式写入本地文件
+func (this *HTTPAccessLogQueue) writeLocalFile(accessLogs []*pb.HTTPAccessLog) error {
+	this.localFileLocker.Lock()
+	defer this.localFileLocker.Unlock()
+
+	if this.localFile == nil {
+		return nil
+	}
+
+	var buf = &bytes.Buffer{}
+	var encoder = json.NewEncoder(buf)
+	for _, accessLog := range accessLogs {
+		err := encoder.Encode(accessLog)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := this.localFile.Write(buf.Bytes())
+	return err
+}
+
 // ToValidUTF8 处理访问日志中的非UTF-8字节
 func (this *HTTPAccessLogQueue) ToValidUTF8(accessLog *pb.HTTPAccessLog) {
 	accessLog.RemoteAddr = utils.ToValidUTF8string(accessLog.RemoteAddr)
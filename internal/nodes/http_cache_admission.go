@@ -0,0 +1,131 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/caches"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/admission"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+	"sync"
+)
+
+var sharedCacheAdmission = newCacheAdmission()
+
+// 缓存写入准入控制
+// 每个缓存策略使用单独的过滤器，在第一次使用时创建
+type cacheAdmission struct {
+	config  *configs.CacheAdmissionConfig
+	filters map[int64]*admission.Filter // policyId => filter
+	locker  sync.RWMutex
+}
+
+func newCacheAdmission() *cacheAdmission {
+	return &cacheAdmission{
+		filters: map[int64]*admission.Filter{},
+	}
+}
+
+// UpdateConfig 修改配置
+// 修改后重新统计访问频率
+func (this *cacheAdmission) UpdateConfig(config *configs.CacheAdmissionConfig) {
+	this.locker.Lock()
+	this.config = config
+	this.filters = map[int64]*admission.Filter{}
+	this.locker.Unlock()
+}
+
+// Record 记录一次对缓存内容的访问
+func (this *cacheAdmission) Record(policyId int64, key string) {
+	var filter = this.filter(policyId)
+	if filter != nil {
+		filter.Record(key)
+	}
+}
+
+// Admit 检查是否允许写入缓存
+func (this *cacheAdmission) Admit(storage caches.StorageInterface, key string) bool {
+	var filter = this.filter(storage.Policy().Id)
+	if filter == nil {
+		return true
+	}
+	return filter.Admit(key, filter.Mode() == admission.ModeTinyLFU && this.storageIsFull(storage))
+}
+
+// Stats 各个缓存策略的准入统计
+func (this *cacheAdmission) Stats() map[string]maps.Map {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	var result = map[string]maps.Map{}
+	for policyId, filter := range this.filters {
+		var stat = filter.Stat()
+		result[types.String(policyId)] = maps.Map{
+			"mode":       filter.Mode(),
+			"admitted":   stat.Admitted,
+			"rejected":   stat.Rejected,
+			"memorySize": filter.MemorySize(),
+		}
+	}
+	return result
+}
+
+// 查找某个缓存策略的过滤器
+func (this *cacheAdmission) filter(policyId int64) *admission.Filter {
+	this.locker.RLock()
+	var config = this.config
+	filter, ok := this.filters[policyId]
+	this.locker.RUnlock()
+
+	if ok || config == nil || !config.MatchPolicy(policyId) {
+		return filter
+	}
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	filter, ok = this.filters[policyId]
+	if ok || this.config != config {
+		return filter
+	}
+	filter = admission.NewFilter(&admission.Options{
+		Mode:      config.Mode,
+		MinHits:   config.MinHits,
+		Window:    config.Window,
+		MaxMemory: config.MaxMemory,
+	})
+	this.filters[policyId] = filter
+	return filter
+}
+
+// 检查缓存存储用量是否已接近容量，此时写入新内容会淘汰已有内容
+// 没有设置容量时总是按已满处理
+func (this *cacheAdmission) storageIsFull(storage caches.StorageInterface) bool {
+	this.locker.RLock()
+	var config = this.config
+	this.locker.RUnlock()
+	if config == nil {
+		return false
+	}
+
+	var policy = storage.Policy()
+	var capacityBytes = policy.CapacityBytes()
+	var size int64
+	if policy.Type == serverconfigs.CachePolicyStorageMemory {
+		size = storage.TotalMemorySize()
+	} else {
+		size = storage.TotalDiskSize()
+		if caches.SharedManager.MaxDiskCapacity != nil {
+			var maxCapacityBytes = caches.SharedManager.MaxDiskCapacity.Bytes()
+			if maxCapacityBytes > 0 {
+				capacityBytes = maxCapacityBytes
+			}
+		}
+	}
+	if capacityBytes <= 0 {
+		return true
+	}
+	return float64(size) >= float64(capacityBytes)*config.FullRatio
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/shared"
+	"github.com/TeaOSLab/EdgeNode/internal/caches"
+	"testing"
+)
+
+func TestCacheAdmission_NthHit(t *testing.T) {
+	var manager = newCacheAdmission()
+	manager.UpdateConfig(parseTestLocalConfig(t, `
+cacheAdmission:
+  isOn: true
+  policyIds: [ 1 ]
+  minHits: 2
+`).CacheAdmission)
+
+	var storage = caches.NewMemoryStorage(&serverconfigs.HTTPCachePolicy{Id: 1, IsOn: true}, nil)
+	var otherStorage = caches.NewMemoryStorage(&serverconfigs.HTTPCachePolicy{Id: 2, IsOn: true}, nil)
+
+	manager.Record(1, "a")
+	if manager.Admit(storage, "a") {
+		t.Fatal("should be rejected at first hit")
+	}
+	manager.Record(1, "a")
+	if !manager.Admit(storage, "a") {
+		t.Fatal("should be admitted at second hit")
+	}
+
+	// 没有启用的缓存策略总是允许写入
+	if !manager.Admit(otherStorage, "b") {
+		t.Fatal("other policies should not be filtered")
+	}
+
+	var stats = manager.Stats()
+	if len(stats) != 1 || stats["1"].GetInt64("admitted") != 1 || stats["1"].GetInt64("rejected") != 1 {
+		t.Fatal("unexpected stats:", stats)
+	}
+
+	// 修改配置后重新统计
+	manager.UpdateConfig(parseTestLocalConfig(t, "").CacheAdmission)
+	if !manager.Admit(storage, "c") || len(manager.Stats()) != 0 {
+		t.Fatal("should not filter after disabled")
+	}
+}
+
+func TestCacheAdmission_StorageIsFull(t *testing.T) {
+	var manager = newCacheAdmission()
+	manager.UpdateConfig(parseTestLocalConfig(t, `
+cacheAdmission:
+  isOn: true
+  mode: tinylfu
+  fullRatio: 0.5
+`).CacheAdmission)
+
+	// 没有设置容量时按已满处理
+	var policy = &serverconfigs.HTTPCachePolicy{Id: 1, IsOn: true, Type: serverconfigs.CachePolicyStorageMemory}
+	err := policy.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var storage = caches.NewMemoryStorage(policy, nil)
+	if !manager.storageIsFull(storage) {
+		t.Fatal("storage without capacity should be treated as full")
+	}
+
+	policy = &serverconfigs.HTTPCachePolicy{
+		Id:       2,
+		IsOn:     true,
+		Type:     serverconfigs.CachePolicyStorageMemory,
+		Capacity: &shared.SizeCapacity{Count: 1, Unit: shared.SizeCapacityUnitGB},
+	}
+	err = policy.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage = caches.NewMemoryStorage(policy, nil)
+	if manager.storageIsFull(storage) {
+		t.Fatal("empty storage should not be full")
+	}
+
+	// 存储未满时总是允许写入
+	if !manager.Admit(storage, "a") {
+		t.Fatal("should be admitted when storage is not full")
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/TeaOSLab/EdgeCommon/pkg/nodeconfigs"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/fasttime"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/hashring"
+	"github.com/cespare/xxhash"
+	"github.com/iwind/TeaGo/lists"
+	"github.com/iwind/TeaGo/types"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	HTTPCachePeerNodeHeader      = "X-Edge-Peer-Node"      // 发起请求的节点地址
+	HTTPCachePeerTimestampHeader = "X-Edge-Peer-Timestamp" // 签名时间
+	HTTPCachePeerTokenHeader     = "X-Edge-Peer-Token"     // 签名
+	HTTPCachePeerClientHeader    = "X-Edge-Peer-Client"    // 原始客户端IP
+
+	httpCachePeerMaxTimeDiff = 60 // 签名有效期（秒）
+)
+
+var sharedHTTPCachePeerManager = NewHTTPCachePeerManager()
+
+// HTTPCachePeerManager 同集群节点之间的缓存共享
+// 节点按缓存Key组成一致性Hash环，缓存未命中时从Key所属的节点读取，所属节点读取失败时直接回源
+type HTTPCachePeerManager struct {
+	config *configs.CachePeerConfig
+
+	clusterNodes    []*nodeconfigs.ParentNodeConfig // 同集群节点，按ID排序
+	clusterNodeKeys []string                        // 用来对比节点列表是否有变化
+	clusterSelfId   int64                           // 当前节点ID
+
+	ring       *hashring.Ring
+	memberMap  map[int64]string // 节点ID => 节点
+	members    []string
+	self       string
+	hasMembers bool
+	peerIPs    map[string]bool                        // 节点IP
+	failedMap  map[string]int64                       // 节点 => 恢复使用的时间
+	originMap  map[string]*serverconfigs.OriginConfig // scheme://addr => origin
+
+	locker sync.RWMutex
+}
+
+func NewHTTPCachePeerManager() *HTTPCachePeerManager {
+	return &HTTPCachePeerManager{
+		config:    &configs.CachePeerConfig{},
+		ring:      hashring.NewRing(0),
+		memberMap: map[int64]string{},
+		peerIPs:   map[string]bool{},
+		failedMap: map[string]int64{},
+		originMap: map[string]*serverconfigs.OriginConfig{},
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *HTTPCachePeerManager) UpdateConfig(config *configs.CachePeerConfig) {
+	this.locker.Lock()
+	this.config = config
+	this.rebuild()
+	this.locker.Unlock()
+}
+
+// UpdateClusterNodes 修改从API节点同步的节点列表
+// nodesMap 为 集群ID => 节点列表，同一个节点只使用一次
+func (this *HTTPCachePeerManager) UpdateClusterNodes(nodesMap map[int64][]*nodeconfigs.ParentNodeConfig, selfNodeId int64) {
+	var nodes = []*nodeconfigs.ParentNodeConfig{}
+	var nodeIds = map[int64]bool{}
+	for _, clusterNodes := range nodesMap {
+		for _, node := range clusterNodes {
+			if node == nil || len(node.Addrs) == 0 || nodeIds[node.Id] {
+				continue
+			}
+			nodeIds[node.Id] = true
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Id < nodes[j].Id
+	})
+
+	var nodeKeys = []string{"self:" + types.String(selfNodeId)}
+	for _, node := range nodes {
+		nodeKeys = append(nodeKeys, types.String(node.Id)+":"+strings.Join(node.Addrs, ","))
+	}
+
+	this.locker.Lock()
+	if utils.EqualStrings(nodeKeys, this.clusterNodeKeys) {
+		this.locker.Unlock()
+		return
+	}
+	this.clusterNodes = nodes
+	this.clusterNodeKeys = nodeKeys
+	this.clusterSelfId = selfNodeId
+	if this.config.IsOn && this.config.Source == configs.CachePeerSourceCluster {
+		this.rebuild()
+	}
+	this.locker.Unlock()
+}
+
+// MatchServer 检查某个网站是否启用
+func (this *HTTPCachePeerManager) MatchServer(serverId int64) bool {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	return this.config.MatchServer(serverId) && this.hasMembers
+}
+
+// Owner 查找某个Key所属的节点
+// 如果所属节点是当前节点或者暂停使用，则返回false
+func (this *HTTPCachePeerManager) Owner(key string) (member string, peerId int64, ok bool) {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	id, ok := this.ring.Get(key, nil)
+	if !ok {
+		return "", 0, false
+	}
+	member = this.memberMap[id]
+	if member == this.self {
+		return "", 0, false
+	}
+	failedUntil, isFailed := this.failedMap[member]
+	if isFailed && failedUntil > fasttime.Now().Unix() {
+		return "", 0, false
+	}
+	return member, id, true
+}
+
+// Fail 标记某个节点请求失败
+func (this *HTTPCachePeerManager) Fail(member string) {
+	this.locker.Lock()
+	var failTimeout = this.config.FailTimeout
+	if failTimeout <= 0 {
+		failTimeout = 30
+	}
+	_, isFailed := this.failedMap[member]
+	this.failedMap[member] = fasttime.Now().Unix() + int64(failTimeout)
+	this.locker.Unlock()
+
+	if !isFailed {
+		remotelogs.Warn("HTTP_CACHE_PEER", "peer '"+member+"' failed, fallback to origin in "+strconv.Itoa(failTimeout)+" seconds")
+	}
+}
+
+// Origin 获取访问某个节点使用的源站
+// 节点地址中没有端口时使用和当前请求相同的端口
+func (this *HTTPCachePeerManager) Origin(member string, isHTTPS bool, defaultPort int) *serverconfigs.OriginConfig {
+	var host, port, err = net.SplitHostPort(member)
+	if err != nil {
+		host = member
+		port = types.String(defaultPort)
+	}
+	var protocol = serverconfigs.ProtocolHTTP
+	if isHTTPS {
+		protocol = serverconfigs.ProtocolHTTPS
+	}
+	var originKey = string(protocol) + "://" + net.JoinHostPort(host, port)
+
+	this.locker.RLock()
+	origin, ok := this.originMap[originKey]
+	this.locker.RUnlock()
+	if ok {
+		return origin
+	}
+
+	origin = &serverconfigs.OriginConfig{
+		IsOn: true,
+		Addr: &serverconfigs.NetworkAddressConfig{
+			Protocol:  protocol,
+			Host:      host,
+			PortRange: port,
+		},
+	}
+	err = origin.Init(nil)
+	if err != nil {
+		remotelogs.Error("HTTP_CACHE_PEER", "init origin for peer '"+member+"' failed: "+err.Error())
+		return nil
+	}
+
+	this.locker.Lock()
+	this.originMap[originKey] = origin
+	this.locker.Unlock()
+	return origin
+}
+
+// Sign 为发往其他节点的请求签名
+// requestURI 为最终发送到其他节点的路径和参数
+func (this *HTTPCachePeerManager) Sign(header http.Header, method string, host string, requestURI string, clientIP string) {
+	this.locker.RLock()
+	var self = this.self
+	var secret = this.config.Secret
+	this.locker.RUnlock()
+
+	var timestamp = types.String(fasttime.Now().Unix())
+	header.Set(HTTPCachePeerNodeHeader, self)
+	header.Set(HTTPCachePeerTimestampHeader, timestamp)
+	header.Set(HTTPCachePeerTokenHeader, this.sign(secret, self, timestamp, method, host, requestURI))
+	header.Set(HTTPCachePeerClientHeader, clientIP)
+}
+
+// Verify 检查请求是否来自其他节点
+func (this *HTTPCachePeerManager) Verify(node string, timestamp string, token string, method string, host string, requestURI string, remoteIP string) bool {
+	this.locker.RLock()
+	var secret = this.config.Secret
+	var isOn = this.config.IsOn
+	var isPeerIP = this.peerIPs[remoteIP]
+	this.locker.RUnlock()
+
+	if !isOn || !isPeerIP {
+		return false
+	}
+
+	var diff = fasttime.Now().Unix() - types.Int64(timestamp)
+	if diff > httpCachePeerMaxTimeDiff || diff < -httpCachePeerMaxTimeDiff {
+		return false
+	}
+	return hmac.Equal([]byte(this.sign(secret, node, timestamp, method, host, requestURI)), []byte(token))
+}
+
+func (this *HTTPCachePeerManager) sign(secret string, node string, timestamp string, method string, host string, requestURI string) string {
+	var h = hmac.New(sha256.New, []byte(secret))
+	_, _ = h.Write([]byte(node + "\n" + timestamp + "\n" + method + "\n" + host + "\n" + requestURI))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 节点ID，用于在Hash环中表示节点，以及在重试时排除失败的节点
+// 只和节点地址有关，所以各个节点上的Hash环是一致的
+func (this *HTTPCachePeerManager) peerId(member string) int64 {
+	var id = int64(xxhash.Sum64String(member) >> 1)
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
+
+// 节点地址中的主机部分
+func (this *HTTPCachePeerManager) memberHost(member string) string {
+	host, _, err := net.SplitHostPort(member)
+	if err != nil {
+		return member
+	}
+	return host
+}
+
+// 重建Hash环
+func (this *HTTPCachePeerManager) rebuild() {
+	var members []string
+	var peerIPs = map[string]bool{}
+	var self = this.config.Self
+	var selfIsRequired = true
+	if this.config.IsOn {
+		if this.config.Source == configs.CachePeerSourceStatic {
+			members = this.config.Peers
+		} else {
+			// 每个节点只使用第一个地址，其他地址只用来检查请求来源
+			for _, node := range this.clusterNodes {
+				var member = node.Addrs[0]
+				members = append(members, member)
+				if node.Id == this.clusterSelfId && len(this.config.Self) == 0 {
+					self = member
+				}
+				for _, addr := range node.Addrs {
+					peerIPs[this.memberHost(addr)] = true
+				}
+			}
+
+			// 当前节点不在节点列表中时（比如边缘节点使用上级节点列表），只从其他节点读取，不作为所属节点
+			selfIsRequired = false
+		}
+	}
+
+	for _, member := range members {
+		peerIPs[this.memberHost(member)] = true
+	}
+
+	// 查找当前节点
+	if len(self) == 0 {
+		var localIPs = map[string]bool{}
+		addrs, err := net.InterfaceAddrs()
+		if err == nil {
+			for _, addr := range addrs {
+				ipNet, ok := addr.(*net.IPNet)
+				if ok {
+					localIPs[ipNet.IP.String()] = true
+				}
+			}
+		}
+		for _, member := range members {
+			if localIPs[this.memberHost(member)] {
+				self = member
+				break
+			}
+		}
+	}
+
+	// 当前节点不在静态节点列表中时不启用
+	var hasSelf = len(self) > 0 && lists.ContainsString(members, self)
+	if !hasSelf {
+		if selfIsRequired {
+			if this.config.IsOn && len(members) > 0 {
+				remotelogs.Warn("HTTP_CACHE_PEER", "can not find current node in peers, peer caching is disabled")
+			}
+			self = ""
+			members = nil
+		} else if len(self) == 0 {
+			// 使用节点ID作为请求中的节点标识
+			self = "node-" + types.String(this.clusterSelfId)
+		}
+	}
+
+	var ring = hashring.NewRing(this.config.Replicas)
+	var memberMap = map[int64]string{}
+	var memberList = []string{}
+	for _, member := range members {
+		if len(member) == 0 {
+			continue
+		}
+		var id = this.peerId(member)
+		if _, ok := memberMap[id]; ok {
+			continue
+		}
+		memberMap[id] = member
+		memberList = append(memberList, member)
+		ring.Add(id)
+	}
+
+	this.ring = ring
+	this.memberMap = memberMap
+	this.members = memberList
+	this.self = self
+	this.hasMembers = len(memberList) > 0
+	this.peerIPs = peerIPs
+	this.failedMap = map[string]int64{}
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/nodeconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/iwind/TeaGo/types"
+	"net/http"
+	"testing"
+)
+
+func newTestHTTPCachePeerManager(t *testing.T, self string, peers []string) *HTTPCachePeerManager {
+	var config = &configs.CachePeerConfig{
+		IsOn:   true,
+		Source: configs.CachePeerSourceStatic,
+		Peers:  peers,
+		Self:   self,
+		Secret: "123456",
+	}
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manager = NewHTTPCachePeerManager()
+	manager.UpdateConfig(config)
+	return manager
+}
+
+func TestHTTPCachePeerManager_Owner(t *testing.T) {
+	// 在同一个进程中模拟3个节点
+	var peers = []string{"127.0.0.1:18081", "127.0.0.1:18082", "127.0.0.1:18083"}
+	var managers = []*HTTPCachePeerManager{}
+	for _, peer := range peers {
+		managers = append(managers, newTestHTTPCachePeerManager(t, peer, peers))
+	}
+
+	for i := 0; i < 100; i++ {
+		var key = "https://example.com/" + types.String(i)
+
+		// 所属节点自己不再访问其他节点，其他节点都访问所属节点
+		var countSelf = 0
+		var owners = map[string]bool{}
+		for _, manager := range managers {
+			member, _, ok := manager.Owner(key)
+			if !ok {
+				countSelf++
+				continue
+			}
+			owners[member] = true
+		}
+		if countSelf != 1 || len(owners) != 1 {
+			t.Fatal("owners of '"+key+"' are not consistent:", countSelf, owners)
+		}
+	}
+}
+
+func TestHTTPCachePeerManager_Fail(t *testing.T) {
+	var peers = []string{"127.0.0.1:18081", "127.0.0.1:18082"}
+	var manager = newTestHTTPCachePeerManager(t, peers[0], peers)
+
+	for i := 0; i < 100; i++ {
+		var key = "https://example.com/" + types.String(i)
+		member, _, ok := manager.Owner(key)
+		if !ok {
+			continue
+		}
+		manager.Fail(member)
+		_, _, ok = manager.Owner(key)
+		if ok {
+			t.Fatal("failed peer should not be used")
+		}
+		return
+	}
+	t.Fatal("no key belongs to other peer")
+}
+
+func TestHTTPCachePeerManager_Sign(t *testing.T) {
+	var peers = []string{"127.0.0.1:18081", "127.0.0.1:18082"}
+	var manager1 = newTestHTTPCachePeerManager(t, peers[0], peers)
+	var manager2 = newTestHTTPCachePeerManager(t, peers[1], peers)
+
+	var header = http.Header{}
+	manager1.Sign(header, http.MethodGet, "example.com", "/hello?name=world", "192.168.1.100")
+
+	var node = header.Get(HTTPCachePeerNodeHeader)
+	var timestamp = header.Get(HTTPCachePeerTimestampHeader)
+	var token = header.Get(HTTPCachePeerTokenHeader)
+	if !manager2.Verify(node, timestamp, token, http.MethodGet, "example.com", "/hello?name=world", "127.0.0.1") {
+		t.Fatal("verify should pass")
+	}
+	if manager2.Verify(node, timestamp, token, http.MethodGet, "example.org", "/hello?name=world", "127.0.0.1") {
+		t.Fatal("verify should fail with different host")
+	}
+	if manager2.Verify(node, timestamp, token, http.MethodPost, "example.com", "/hello?name=world", "127.0.0.1") {
+		t.Fatal("verify should fail with different method")
+	}
+	if manager2.Verify(node, timestamp, token, http.MethodGet, "example.com", "/admin", "127.0.0.1") {
+		t.Fatal("verify should fail with different request uri")
+	}
+	if manager2.Verify(node, timestamp, token, http.MethodGet, "example.com", "/hello?name=world", "10.0.0.1") {
+		t.Fatal("verify should fail with unknown ip")
+	}
+}
+
+func TestHTTPCachePeerManager_Config(t *testing.T) {
+	// 静态节点列表需要包含当前节点
+	var manager = newTestHTTPCachePeerManager(t, "127.0.0.1:18084", []string{"127.0.0.1:18081", "127.0.0.1:18082"})
+	if manager.MatchServer(1) {
+		t.Fatal("peer caching should be disabled without current node")
+	}
+
+	// 只对指定的网站启用
+	var config = &configs.CachePeerConfig{
+		IsOn:      true,
+		Source:    configs.CachePeerSourceStatic,
+		Peers:     []string{"127.0.0.1:18081", "127.0.0.1:18082", "127.0.0.1:18081"},
+		Self:      "127.0.0.1:18081",
+		Secret:    "123456",
+		ServerIds: []int64{5},
+	}
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager = NewHTTPCachePeerManager()
+	manager.UpdateConfig(config)
+	if !manager.MatchServer(5) || manager.MatchServer(1) {
+		t.Fatal("unexpected server match")
+	}
+	if len(manager.members) != 2 {
+		t.Fatal("duplicated peers should be ignored:", manager.members)
+	}
+
+	// 节点顺序不影响结果
+	var config2 = &configs.CachePeerConfig{
+		IsOn:   true,
+		Source: configs.CachePeerSourceStatic,
+		Peers:  []string{"127.0.0.1:18082", "127.0.0.1:18081"},
+		Self:   "127.0.0.1:18081",
+		Secret: "123456",
+	}
+	err = config2.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manager2 = NewHTTPCachePeerManager()
+	manager2.UpdateConfig(config2)
+	for i := 0; i < 100; i++ {
+		var key = "https://example.com/" + types.String(i)
+		member, _, _ := manager.Owner(key)
+		member2, _, _ := manager2.Owner(key)
+		if member != member2 {
+			t.Fatal("owners of '" + key + "' should be same")
+		}
+	}
+
+	// 没有密钥时不能启用
+	if (&configs.CachePeerConfig{IsOn: true}).Init() == nil {
+		t.Fatal("empty secret should be rejected")
+	}
+}
+
+func TestHTTPCachePeerManager_UpdateClusterNodes(t *testing.T) {
+	var config = &configs.CachePeerConfig{
+		IsOn:   true,
+		Source: configs.CachePeerSourceCluster,
+		Secret: "123456",
+	}
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nodesMap = map[int64][]*nodeconfigs.ParentNodeConfig{
+		1: {
+			{Id: 1, Addrs: []string{"192.168.1.1", "10.0.0.1"}},
+			{Id: 2, Addrs: []string{"192.168.1.2", "10.0.0.2"}},
+		},
+		2: {
+			{Id: 2, Addrs: []string{"192.168.1.2", "10.0.0.2"}},
+			{Id: 3, Addrs: []string{"192.168.1.3"}},
+		},
+	}
+
+	var manager = NewHTTPCachePeerManager()
+	manager.UpdateConfig(config)
+	manager.UpdateClusterNodes(nodesMap, 1)
+
+	// 每个节点只有一个成员
+	var members = manager.members
+	if len(members) != 3 || manager.self != "192.168.1.1" {
+		t.Fatal("unexpected members:", members, "self:", manager.self)
+	}
+	for i := 0; i < 100; i++ {
+		member, _, ok := manager.Owner("https://example.com/" + types.String(i))
+		if ok && (member == "192.168.1.1" || member == "10.0.0.1") {
+			t.Fatal("should not request self")
+		}
+	}
+
+	// 其他地址也可以发起请求
+	if !manager.peerIPs["10.0.0.2"] {
+		t.Fatal("all addresses of nodes should be allowed")
+	}
+
+	// 当前节点不在节点列表中时只从其他节点读取
+	manager.UpdateClusterNodes(nodesMap, 4)
+	if !manager.MatchServer(1) || manager.self != "node-4" {
+		t.Fatal("node outside of list should read from peers")
+	}
+}
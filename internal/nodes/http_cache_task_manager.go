@@ -86,6 +86,11 @@ func NewHTTPCacheTaskManager() *HTTPCacheTaskManager {
 }
 
 func (this *HTTPCacheTaskManager) Start() {
+	// 独立运行模式下没有API节点下发任务
+	if teaconst.IsStandalone {
+		return
+	}
+
 	// task queue
 	goman.New(func() {
 		rpcClient, _ := rpc.SharedRPC()
@@ -196,6 +201,12 @@ func (this *HTTPCacheTaskManager) processKey(key *pb.HTTPCacheTaskKey) error {
 					if err != nil {
 						return err
 					}
+
+					// 分片缓存
+					err = storage.Purge([]string{cacheKey + caches.SuffixSlice}, "dir")
+					if err != nil {
+						return err
+					}
 				}
 			case "prefix":
 				var prefixes = []string{key.Key}
@@ -231,18 +242,28 @@ func (this *HTTPCacheTaskManager) fetchKey(key *pb.HTTPCacheTaskKey) error {
 		fullKey = "https://" + fullKey
 	}
 
-	req, err := http.NewRequest(http.MethodGet, fullKey, nil)
+	_, err := this.fetchURL(context.Background(), fullKey, nil)
+	return err
+}
+
+// 通过本节点请求某个URL以生成缓存
+// header 中的值会覆盖默认的Header
+func (this *HTTPCacheTaskManager) fetchURL(ctx context.Context, fullURL string, header http.Header) (statusCode int, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
 	if err != nil {
-		return errors.New("invalid url: " + fullKey + ": " + err.Error())
+		return 0, errors.New("invalid url: " + fullURL + ": " + err.Error())
 	}
 
 	// TODO 可以在管理界面自定义Header
 	req.Header.Set("X-Edge-Cache-Action", "fetch")
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/85.0.4183.121 Safari/537.36") // TODO 可以定义
 	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	for k, v := range header {
+		req.Header[k] = v
+	}
 	resp, err := this.httpClient.Do(req)
 	if err != nil {
-		return errors.New("request failed: " + fullKey + ": " + err.Error())
+		return 0, errors.New("request failed: " + fullURL + ": " + err.Error())
 	}
 
 	defer func() {
@@ -254,8 +275,8 @@ func (this *HTTPCacheTaskManager) fetchKey(key *pb.HTTPCacheTaskKey) error {
 
 	// 处理502
 	if resp.StatusCode == http.StatusBadGateway {
-		return errors.New("read origin site timeout")
+		return resp.StatusCode, errors.New("read origin site timeout")
 	}
 
-	return nil
+	return resp.StatusCode, nil
 }
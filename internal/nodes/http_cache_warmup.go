@@ -0,0 +1,371 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"context"
+	"errors"
+	"github.com/TeaOSLab/EdgeNode/internal/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/sitemaps"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	httpCacheWarmupMaxConcurrency = 64
+	httpCacheWarmupMaxRate        = 10000
+	httpCacheWarmupMaxURLs        = 1000000
+	httpCacheWarmupMaxSitemaps    = 1000 // 站点地图索引中最多读取的子站点地图数量
+	httpCacheWarmupMaxFailures    = 100  // 保留的最近失败记录数量
+	httpCacheWarmupMaxJobs        = 10   // 保留的最近任务数量
+)
+
+const (
+	HTTPCacheWarmupStatusRunning   = "running"
+	HTTPCacheWarmupStatusDone      = "done"
+	HTTPCacheWarmupStatusCancelled = "cancelled"
+	HTTPCacheWarmupStatusFailed    = "failed"
+)
+
+var sharedHTTPCacheWarmupManager = NewHTTPCacheWarmupManager()
+
+// HTTPCacheWarmupOptions 预热选项
+type HTTPCacheWarmupOptions struct {
+	File        string      // URL列表或者站点地图文件路径
+	Sitemap     string      // 站点地图URL
+	Concurrency int         // 并发数
+	Rate        int         // 每秒最多请求数，0表示不限制
+	Header      http.Header // 附加的请求Header
+}
+
+// HTTPCacheWarmupManager 本地缓存预热任务管理
+// 预热请求通过本节点发出，和真实客户端请求一样经过缓存、WebP和压缩等处理
+type HTTPCacheWarmupManager struct {
+	jobs   []*HTTPCacheWarmupJob
+	maxId  int64
+	locker sync.Mutex
+}
+
+func NewHTTPCacheWarmupManager() *HTTPCacheWarmupManager {
+	return &HTTPCacheWarmupManager{}
+}
+
+// Start 启动一个预热任务
+func (this *HTTPCacheWarmupManager) Start(options *HTTPCacheWarmupOptions) (*HTTPCacheWarmupJob, error) {
+	if len(options.File) == 0 && len(options.Sitemap) == 0 {
+		return nil, errors.New("'file' or 'sitemap' should be specified")
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = 4
+	} else if options.Concurrency > httpCacheWarmupMaxConcurrency {
+		options.Concurrency = httpCacheWarmupMaxConcurrency
+	}
+	if options.Rate < 0 {
+		options.Rate = 0
+	} else if options.Rate > httpCacheWarmupMaxRate {
+		options.Rate = httpCacheWarmupMaxRate
+	}
+
+	this.locker.Lock()
+	this.maxId++
+	var job = newHTTPCacheWarmupJob(this.maxId, options)
+	this.jobs = append(this.jobs, job)
+	if len(this.jobs) > httpCacheWarmupMaxJobs {
+		// 只清除已经结束的任务
+		var jobs = []*HTTPCacheWarmupJob{}
+		var countRemoved = len(this.jobs) - httpCacheWarmupMaxJobs
+		for _, oldJob := range this.jobs {
+			if countRemoved > 0 && !oldJob.IsRunning() {
+				countRemoved--
+				continue
+			}
+			jobs = append(jobs, oldJob)
+		}
+		this.jobs = jobs
+	}
+	this.locker.Unlock()
+
+	goman.New(func() {
+		job.run()
+	})
+
+	return job, nil
+}
+
+// FindJob 查找任务
+func (this *HTTPCacheWarmupManager) FindJob(jobId int64) *HTTPCacheWarmupJob {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	for _, job := range this.jobs {
+		if job.id == jobId {
+			return job
+		}
+	}
+	return nil
+}
+
+// Cancel 取消任务
+func (this *HTTPCacheWarmupManager) Cancel(jobId int64) error {
+	var job = this.FindJob(jobId)
+	if job == nil {
+		return errors.New("can not find job with id '" + types.String(jobId) + "'")
+	}
+	job.cancel()
+	return nil
+}
+
+// AllJobs 所有保留的任务
+func (this *HTTPCacheWarmupManager) AllJobs() []*HTTPCacheWarmupJob {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return append([]*HTTPCacheWarmupJob{}, this.jobs...)
+}
+
+type httpCacheWarmupFailure struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// HTTPCacheWarmupJob 预热任务
+type HTTPCacheWarmupJob struct {
+	id      int64
+	options *HTTPCacheWarmupOptions
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	total     int64
+	succeeded int64
+	failed    int64
+
+	status     string
+	err        string
+	failures   []*httpCacheWarmupFailure
+	createdAt  int64
+	finishedAt int64
+	locker     sync.Mutex
+}
+
+func newHTTPCacheWarmupJob(id int64, options *HTTPCacheWarmupOptions) *HTTPCacheWarmupJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &HTTPCacheWarmupJob{
+		id:        id,
+		options:   options,
+		ctx:       ctx,
+		cancel:    cancel,
+		status:    HTTPCacheWarmupStatusRunning,
+		createdAt: time.Now().Unix(),
+	}
+}
+
+// Id 任务ID
+func (this *HTTPCacheWarmupJob) Id() int64 {
+	return this.id
+}
+
+// IsRunning 是否正在运行
+func (this *HTTPCacheWarmupJob) IsRunning() bool {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.status == HTTPCacheWarmupStatusRunning
+}
+
+// AsMap 任务进度和失败记录
+func (this *HTTPCacheWarmupJob) AsMap() maps.Map {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var source = this.options.File
+	if len(source) == 0 {
+		source = this.options.Sitemap
+	}
+
+	return maps.Map{
+		"id":          this.id,
+		"source":      source,
+		"status":      this.status,
+		"error":       this.err,
+		"concurrency": this.options.Concurrency,
+		"rate":        this.options.Rate,
+		"total":       atomic.LoadInt64(&this.total),
+		"succeeded":   atomic.LoadInt64(&this.succeeded),
+		"failed":      atomic.LoadInt64(&this.failed),
+		"failures":    append([]*httpCacheWarmupFailure{}, this.failures...),
+		"createdAt":   this.createdAt,
+		"finishedAt":  this.finishedAt,
+	}
+}
+
+func (this *HTTPCacheWarmupJob) run() {
+	defer this.cancel()
+
+	var urls, err = this.loadURLs()
+	if err != nil {
+		remotelogs.Error("HTTP_CACHE_WARMUP", "load urls of job '"+strconv.FormatInt(this.id, 10)+"' failed: "+err.Error())
+		this.finish(HTTPCacheWarmupStatusFailed, err.Error())
+		return
+	}
+	atomic.StoreInt64(&this.total, int64(len(urls)))
+
+	// 限速
+	var rateChan <-chan time.Time
+	if this.options.Rate > 0 {
+		var ticker = time.NewTicker(time.Second / time.Duration(this.options.Rate))
+		defer ticker.Stop()
+		rateChan = ticker.C
+	}
+
+	var urlChan = make(chan string)
+	var wg = &sync.WaitGroup{}
+	for i := 0; i < this.options.Concurrency; i++ {
+		wg.Add(1)
+		goman.New(func() {
+			defer wg.Done()
+			for u := range urlChan {
+				this.fetch(u)
+			}
+		})
+	}
+
+Loop:
+	for _, u := range urls {
+		if rateChan != nil {
+			select {
+			case <-rateChan:
+			case <-this.ctx.Done():
+				break Loop
+			}
+		}
+		select {
+		case urlChan <- u:
+		case <-this.ctx.Done():
+			break Loop
+		}
+	}
+	close(urlChan)
+	wg.Wait()
+
+	if this.ctx.Err() != nil {
+		this.finish(HTTPCacheWarmupStatusCancelled, "")
+	} else {
+		this.finish(HTTPCacheWarmupStatusDone, "")
+	}
+}
+
+func (this *HTTPCacheWarmupJob) fetch(u string) {
+	statusCode, err := SharedHTTPCacheTaskManager.fetchURL(this.ctx, u, this.options.Header)
+	if err == nil && statusCode >= 400 {
+		err = errors.New("response status: " + strconv.Itoa(statusCode))
+	}
+	if err != nil {
+		// 取消任务导致的错误不计入失败
+		if this.ctx.Err() != nil {
+			return
+		}
+		atomic.AddInt64(&this.failed, 1)
+		this.locker.Lock()
+		this.failures = append(this.failures, &httpCacheWarmupFailure{
+			URL:   u,
+			Error: err.Error(),
+		})
+		if len(this.failures) > httpCacheWarmupMaxFailures {
+			this.failures = this.failures[1:]
+		}
+		this.locker.Unlock()
+		return
+	}
+	atomic.AddInt64(&this.succeeded, 1)
+}
+
+func (this *HTTPCacheWarmupJob) finish(status string, errString string) {
+	this.locker.Lock()
+	this.status = status
+	this.err = errString
+	this.finishedAt = time.Now().Unix()
+	this.locker.Unlock()
+}
+
+// 从文件或者站点地图中读取URL
+// 站点地图索引只展开一层
+func (this *HTTPCacheWarmupJob) loadURLs() ([]string, error) {
+	type sitemapSource struct {
+		url     string
+		isChild bool
+	}
+
+	var urls = []string{}
+	var sources = []*sitemapSource{}
+
+	if len(this.options.File) > 0 {
+		fp, err := os.Open(this.options.File)
+		if err != nil {
+			return nil, err
+		}
+		sitemap, err := sitemaps.Parse(fp)
+		_ = fp.Close()
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, sitemap.URLs...)
+		for _, sitemapURL := range sitemap.Sitemaps {
+			sources = append(sources, &sitemapSource{url: sitemapURL, isChild: true})
+		}
+	}
+	if len(this.options.Sitemap) > 0 {
+		sources = append(sources, &sitemapSource{url: this.options.Sitemap})
+	}
+
+	for index := 0; index < len(sources) && index < httpCacheWarmupMaxSitemaps && len(urls) < httpCacheWarmupMaxURLs; index++ {
+		var source = sources[index]
+		sitemap, err := this.fetchSitemap(source.url)
+		if err != nil {
+			return nil, errors.New("read sitemap '" + source.url + "' failed: " + err.Error())
+		}
+		urls = append(urls, sitemap.URLs...)
+		if !source.isChild {
+			for _, sitemapURL := range sitemap.Sitemaps {
+				sources = append(sources, &sitemapSource{url: sitemapURL, isChild: true})
+			}
+		}
+	}
+
+	if len(urls) > httpCacheWarmupMaxURLs {
+		urls = urls[:httpCacheWarmupMaxURLs]
+	}
+	return urls, nil
+}
+
+// 通过本节点读取站点地图
+func (this *HTTPCacheWarmupJob) fetchSitemap(sitemapURL string) (*sitemaps.Sitemap, error) {
+	req, err := http.NewRequestWithContext(this.ctx, http.MethodGet, sitemapURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range this.options.Header {
+		// 由HTTP客户端自动处理压缩
+		if k == "Accept-Encoding" {
+			continue
+		}
+		req.Header[k] = v
+	}
+	resp, err := SharedHTTPCacheTaskManager.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, errors.New("response status: " + strconv.Itoa(resp.StatusCode))
+	}
+	return sitemaps.Parse(resp.Body)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
 	"github.com/TeaOSLab/EdgeNode/internal/goman"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/resolvers"
 	"github.com/pires/go-proxyproto"
 	"net"
 	"net/http"
@@ -131,17 +132,26 @@ func (this *HTTPClientPool) Client(req *HTTPRequest,
 	var transport = &HTTPClientTransport{
 		Transport: &http.Transport{
 			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				// 支持TOA的连接
-				conn, err := this.handleTOA(req, ctx, network, originAddr, connectionTimeout)
-				if conn != nil || err != nil {
-					return conn, err
+				// 使用节点的DNS缓存解析源站域名
+				dialAddrs, err := resolvers.SharedResolver().ResolveAddrs(originAddr)
+				if err != nil {
+					return nil, err
 				}
 
-				// 普通的连接
-				conn, err = (&net.Dialer{
-					Timeout:   connectionTimeout,
-					KeepAlive: 1 * time.Minute,
-				}).DialContext(ctx, network, originAddr)
+				// 某个IP连接失败时尝试下一个
+				conn, err := dialOriginAddrs(dialAddrs, func(dialAddr string) (net.Conn, error) {
+					// 支持TOA的连接
+					conn, err := this.handleTOA(req, ctx, network, dialAddr, connectionTimeout)
+					if conn != nil || err != nil {
+						return conn, err
+					}
+
+					// 普通的连接
+					return (&net.Dialer{
+						Timeout:   connectionTimeout,
+						KeepAlive: 1 * time.Minute,
+					}).DialContext(ctx, network, dialAddr)
+				})
 				if err != nil {
 					return nil, err
 				}
@@ -166,8 +176,10 @@ func (this *HTTPClientPool) Client(req *HTTPRequest,
 		},
 	}
 
+	// 客户端只限制等待响应Header的时间，读取响应内容的时间由每个请求单独限制，以便于转发流式响应
+	transport.ResponseHeaderTimeout = readTimeout
+
 	rawClient = &http.Client{
-		Timeout:   readTimeout,
 		Transport: transport,
 		CheckRedirect: func(targetReq *http.Request, via []*http.Request) error {
 			// 是否跟随
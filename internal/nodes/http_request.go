@@ -13,6 +13,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/metrics"
 	"github.com/TeaOSLab/EdgeNode/internal/stats"
 	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/images"
 	"github.com/iwind/TeaGo/lists"
 	"github.com/iwind/TeaGo/maps"
 	"github.com/iwind/TeaGo/types"
@@ -75,10 +76,15 @@ type HTTPRequest struct {
 	rewriteIsExternalURL bool                              // 重写目标是否为外部URL
 	remoteAddr           string                            // 计算后的RemoteAddr
 
-	cacheRef         *serverconfigs.HTTPCacheRef // 缓存设置
-	cacheKey         string                      // 缓存使用的Key
-	isCached         bool                        // 是否已经被缓存
-	cacheCanTryStale bool                        // 是否可以尝试使用Stale缓存
+	cacheRef          *serverconfigs.HTTPCacheRef // 缓存设置
+	cacheKey          string                      // 缓存使用的Key
+	isCached          bool                        // 是否已经被缓存
+	cacheCanTryStale  bool                        // 是否可以尝试使用Stale缓存
+	cacheStatIsOn     bool                        // 是否统计缓存命中率
+	cacheBypassReason string                      // 不使用缓存的原因
+
+	imageTransform        *images.TransformOptions // 图片转换选项
+	imageFormatFromAccept bool                     // 图片格式是否根据Accept选择
 
 	isAttack        bool   // 是否是攻击请求
 	requestBodyData []byte // 读取的Body内容
@@ -273,6 +279,12 @@ func (this *HTTPRequest) Do() {
 			}
 		}
 
+		// 图片转换
+		if this.doImageTransform() {
+			this.doEnd()
+			return
+		}
+
 		// Compression
 		if this.web.Compression != nil && this.web.Compression.IsOn && this.web.Compression.Level > 0 {
 			this.writer.SetCompression(this.web.Compression)
@@ -385,6 +397,9 @@ func (this *HTTPRequest) doEnd() {
 	// 记录日志
 	this.log()
 
+	// 配置健康检查
+	sharedNodeConfigGuard.AddRequest(this.writer.StatusCode())
+
 	// 流量统计
 	// TODO 增加是否开启开关
 	if this.ReqServer != nil && this.ReqServer.Id > 0 {
@@ -405,6 +420,11 @@ func (this *HTTPRequest) doEnd() {
 
 		stats.SharedTrafficStatManager.Add(this.ReqServer.UserId, this.ReqServer.Id, this.ReqHost, this.writer.SentBodyBytes()+this.writer.SentHeaderBytes(), cachedBytes, 1, countCached, countAttacks, attackBytes, this.ReqServer.ShouldCheckTrafficLimit(), this.ReqServer.PlanId())
 
+		// 缓存命中率
+		if this.cacheStatIsOn {
+			this.doCacheStat()
+		}
+
 		// 指标
 		if metrics.SharedManager.HasHTTPMetrics() {
 			this.doMetricsResponse()
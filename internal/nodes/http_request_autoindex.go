@@ -0,0 +1,123 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/autoindex"
+	"github.com/iwind/TeaGo/types"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 目录列表配置，从 configs/local.yaml 中加载
+var sharedAutoIndexConfig = &configs.AutoIndexConfig{}
+
+// 输出目录列表
+// 只在目录中没有首页文件时调用，如果返回true，则终止请求
+func (this *HTTPRequest) doAutoIndex(rootDir string, dir string) (isBreak bool) {
+	var config = sharedAutoIndexConfig
+	if config == nil || !config.MatchServer(this.ReqServer.Id) {
+		return
+	}
+
+	var method = this.Method()
+	if method != http.MethodGet && method != http.MethodHead {
+		return
+	}
+
+	// 不能列出根目录之外的目录
+	if !autoindex.IsSubPath(rootDir, dir) {
+		this.write404()
+		return true
+	}
+
+	// 不显示隐藏文件时，也不能直接访问隐藏目录
+	if !config.ShowHidden && autoindex.IsHiddenPath(rootDir, dir) {
+		this.write404()
+		return true
+	}
+
+	var requestPath = this.Path()
+	var query = this.requestQueryString()
+
+	// 目录以 / 结尾，保证列表中的相对链接正确
+	if !strings.HasSuffix(requestPath, "/") {
+		var location = requestPath + "/"
+		if len(query) > 0 {
+			location += "?" + query
+		}
+		this.processResponseHeaders(this.writer.Header(), http.StatusMovedPermanently)
+		httpRedirect(this.writer, this.RawReq, location, http.StatusMovedPermanently)
+		return true
+	}
+
+	values, _ := url.ParseQuery(query)
+	var pageSize = types.Int(values.Get("pageSize"))
+	if pageSize <= 0 {
+		pageSize = config.PageSize
+	} else if pageSize > config.MaxPageSize {
+		pageSize = config.MaxPageSize
+	}
+
+	listing, err := autoindex.ReadDir(dir, &autoindex.Options{
+		ShowHidden: config.ShowHidden,
+		Sort:       values.Get("sort"),
+		Order:      values.Get("order"),
+		Page:       types.Int(values.Get("page")),
+		PageSize:   pageSize,
+	})
+	if err != nil {
+		this.write50x(err, http.StatusInternalServerError, "Failed to read the directory", "读取目录失败", false)
+		return true
+	}
+	listing.Path = requestPath
+
+	// 目录内容随时会变化，不缓存
+	this.cacheRef = nil
+
+	var buf = &bytes.Buffer{}
+	var contentType string
+	if this.autoIndexWantsJSON(values.Get(config.FormatParam)) {
+		contentType = "application/json; charset=utf-8"
+		err = json.NewEncoder(buf).Encode(listing)
+	} else {
+		contentType = "text/html; charset=utf-8"
+		err = autoindex.WriteHTML(buf, listing)
+	}
+	if err != nil {
+		this.write50x(err, http.StatusInternalServerError, "Failed to render the directory", "输出目录列表失败", false)
+		return true
+	}
+
+	var respHeader = this.writer.Header()
+	respHeader.Set("Content-Type", contentType)
+	respHeader.Set("Content-Length", strconv.Itoa(buf.Len()))
+	httpAddVary(respHeader, "Accept")
+	this.processResponseHeaders(respHeader, http.StatusOK)
+	this.writer.WriteHeader(http.StatusOK)
+	if method != http.MethodHead {
+		_, _ = this.writer.Write(buf.Bytes())
+	}
+	this.writer.SetOk()
+
+	return true
+}
+
+// 检查是否输出JSON格式
+// 优先使用URL参数，其次根据 Accept 判断
+func (this *HTTPRequest) autoIndexWantsJSON(format string) bool {
+	switch strings.ToLower(format) {
+	case "json":
+		return true
+	case "html":
+		return false
+	}
+
+	var accept = this.RawReq.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
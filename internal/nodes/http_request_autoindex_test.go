@@ -0,0 +1,115 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"encoding/json"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/autoindex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setTestAutoIndexConfig(t *testing.T, data string) {
+	var oldConfig = sharedAutoIndexConfig
+	t.Cleanup(func() {
+		sharedAutoIndexConfig = oldConfig
+	})
+	sharedAutoIndexConfig = parseTestLocalConfig(t, data).AutoIndex
+}
+
+func newTestAutoIndexRequest(uri string, accept string) (*HTTPRequest, *httptest.ResponseRecorder) {
+	var rawReq = httptest.NewRequest(http.MethodGet, uri, nil)
+	if len(accept) > 0 {
+		rawReq.Header.Set("Accept", accept)
+	}
+	var recorder = httptest.NewRecorder()
+	var req = &HTTPRequest{
+		RawReq:    rawReq,
+		ReqServer: &serverconfigs.ServerConfig{Id: 1},
+		uri:       uri,
+		rawURI:    uri,
+		web:       &serverconfigs.HTTPWebConfig{},
+	}
+	req.writer = NewHTTPWriter(req, recorder)
+	return req, recorder
+}
+
+func TestHTTPRequest_DoAutoIndex(t *testing.T) {
+	var rootDir = t.TempDir()
+	var dir = filepath.Join(rootDir, "files")
+	err := os.MkdirAll(filepath.Join(dir, "sub"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", ".hidden"} {
+		err = os.WriteFile(filepath.Join(dir, name), []byte("hello"), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 未启用的网站
+	setTestAutoIndexConfig(t, "autoIndex:\n  isOn: true\n  serverIds: [ 2 ]\n")
+	{
+		req, _ := newTestAutoIndexRequest("/files/", "")
+		if req.doAutoIndex(rootDir, dir) {
+			t.Fatal("should not list directory for other servers")
+		}
+	}
+
+	setTestAutoIndexConfig(t, "autoIndex:\n  isOn: true\n")
+
+	// 补全结尾的 /
+	{
+		req, recorder := newTestAutoIndexRequest("/files?page=2", "")
+		if !req.doAutoIndex(rootDir, dir) {
+			t.Fatal("should redirect")
+		}
+		if recorder.Code != http.StatusMovedPermanently || recorder.Header().Get("Location") != "/files/?page=2" {
+			t.Fatal("unexpected redirect:", recorder.Code, recorder.Header().Get("Location"))
+		}
+	}
+
+	// JSON格式，保留已有的Vary
+	{
+		req, recorder := newTestAutoIndexRequest("/files/", "application/json")
+		recorder.Header().Set("Vary", "Accept-Encoding")
+		if !req.doAutoIndex(rootDir, dir) {
+			t.Fatal("should list directory")
+		}
+		if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+			t.Fatal("unexpected response:", recorder.Code, recorder.Header().Get("Content-Type"))
+		}
+		var vary = recorder.Header().Values("Vary")
+		if len(vary) != 2 || vary[0] != "Accept-Encoding" || vary[1] != "Accept" {
+			t.Fatal("unexpected vary:", vary)
+		}
+		if req.cacheRef != nil {
+			t.Fatal("directory listing should not be cached")
+		}
+
+		var listing = &autoindex.Listing{}
+		err = json.Unmarshal(recorder.Body.Bytes(), listing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if listing.Path != "/files/" || listing.Total != 2 || listing.Entries[0].Name != "sub" || listing.Entries[1].Name != "a.txt" {
+			t.Fatalf("unexpected listing: %+v", listing)
+		}
+	}
+
+	// HTML格式
+	{
+		req, recorder := newTestAutoIndexRequest("/files/?format=html", "application/json")
+		if !req.doAutoIndex(rootDir, dir) {
+			t.Fatal("should list directory")
+		}
+		if recorder.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Fatal("unexpected content type:", recorder.Header().Get("Content-Type"))
+		}
+	}
+}
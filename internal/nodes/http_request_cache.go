@@ -6,6 +6,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/caches"
 	"github.com/TeaOSLab/EdgeNode/internal/compressions"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/stats"
 	"github.com/TeaOSLab/EdgeNode/internal/utils"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/fasttime"
 	rangeutils "github.com/TeaOSLab/EdgeNode/internal/utils/ranges"
@@ -35,6 +36,7 @@ func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
 	if this.web.Cache == nil || !this.web.Cache.IsOn || (len(cachePolicy.CacheRefs) == 0 && len(this.web.Cache.CacheRefs) == 0) {
 		return
 	}
+	this.cacheStatIsOn = true
 
 	// 添加 X-Cache Header
 	var addStatusHeader = this.web.Cache.AddStatusHeader
@@ -56,6 +58,7 @@ func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
 		if (cacheRef.Conds != nil && cacheRef.Conds.HasRequestConds() && cacheRef.Conds.MatchRequest(this.Format)) ||
 			(cacheRef.SimpleCond != nil && cacheRef.SimpleCond.Match(this.Format)) {
 			if cacheRef.IsReverse {
+				this.cacheBypassReason = stats.CacheBypassNoCacheRef
 				return
 			}
 			this.cacheRef = cacheRef
@@ -72,6 +75,7 @@ func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
 			if (cacheRef.Conds != nil && cacheRef.Conds.HasRequestConds() && cacheRef.Conds.MatchRequest(this.Format)) ||
 				(cacheRef.SimpleCond != nil && cacheRef.SimpleCond.Match(this.Format)) {
 				if cacheRef.IsReverse {
+					this.cacheBypassReason = stats.CacheBypassNoCacheRef
 					return
 				}
 				this.cacheRef = cacheRef
@@ -82,6 +86,7 @@ func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
 	}
 
 	if this.cacheRef == nil {
+		this.cacheBypassReason = stats.CacheBypassNoCacheRef
 		return
 	}
 
@@ -94,6 +99,7 @@ func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
 	// 校验请求
 	if !this.cacheRef.MatchRequest(this.RawReq) {
 		this.cacheRef = nil
+		this.cacheBypassReason = stats.CacheBypassMethod
 		return
 	}
 
@@ -106,6 +112,7 @@ func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
 	if this.cacheRef.EnableRequestCachePragma {
 		if this.RawReq.Header.Get("Cache-Control") == "no-cache" || this.RawReq.Header.Get("Pragma") == "no-cache" {
 			this.cacheRef = nil
+			this.cacheBypassReason = stats.CacheBypassNoCacheHeader
 			return
 		}
 	}
@@ -119,6 +126,7 @@ func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
 	var key = this.Format(this.cacheRef.Key)
 	if len(key) == 0 {
 		this.cacheRef = nil
+		this.cacheBypassReason = stats.CacheBypassOther
 		return
 	}
 	var method = this.Method()
@@ -134,18 +142,24 @@ func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
 	storage := caches.SharedManager.FindStorageWithPolicy(cachePolicy.Id)
 	if storage == nil {
 		this.cacheRef = nil
+		this.cacheBypassReason = stats.CacheBypassOther
 		return
 	}
 	this.writer.cacheStorage = storage
 
 	// 如果正在预热，则不读取缓存，等待下一个步骤重新生成
-	if (strings.HasPrefix(this.RawReq.RemoteAddr, "127.") || strings.HasPrefix(this.RawReq.RemoteAddr, "[::1]")) && this.RawReq.Header.Get("X-Edge-Cache-Action") == "fetch" {
+	if this.isCacheFetchRequest() {
+		this.cacheStatIsOn = false
 		return
 	}
 
+	// 记录访问频率，用于缓存写入准入
+	sharedCacheAdmission.Record(cachePolicy.Id, key)
+
 	// 判断是否在Purge
 	if isPurging {
 		this.varMapping["cache.status"] = "PURGE"
+		this.cacheStatIsOn = false
 
 		var subKeys = []string{
 			key,
@@ -164,6 +178,10 @@ func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
 				remotelogs.ErrorServer("HTTP_REQUEST_CACHE", "purge failed: "+err.Error())
 			}
 		}
+		err := storage.Purge([]string{key + caches.SuffixSlice}, "dir")
+		if err != nil {
+			remotelogs.ErrorServer("HTTP_REQUEST_CACHE", "purge slices failed: "+err.Error())
+		}
 
 		// 通过API节点清除别节点上的的Key
 		SharedHTTPCacheTaskManager.PushTaskKeys([]string{key})
@@ -188,6 +206,7 @@ func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
 	var isHeadMethod = method == http.MethodHead
 	if !isPartialRequest &&
 		!isHeadMethod &&
+		this.imageTransform == nil &&
 		this.web.WebP != nil &&
 		this.web.WebP.IsOn &&
 		this.web.WebP.MatchRequest(filepath.Ext(this.Path()), this.Format) &&
@@ -195,6 +214,16 @@ func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
 		webPIsEnabled = true
 	}
 
+	// 检查转换后的图片
+	if this.imageTransform != nil && !isPartialRequest && !isHeadMethod {
+		var imageSuffix = caches.SuffixImage + this.imageTransform.Key()
+		reader, _ = storage.OpenReader(key+imageSuffix, useStale, false)
+		if reader != nil {
+			this.writer.cacheReaderSuffix = imageSuffix
+			tags = append(tags, "image")
+		}
+	}
+
 	// 检查WebP压缩缓存
 	if webPIsEnabled && !isPartialRequest && !isHeadMethod && reader == nil {
 		if this.web.Compression != nil && this.web.Compression.IsOn {
@@ -234,6 +263,17 @@ func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
 		}
 	}
 
+	// 检查静态文件的预压缩缓存
+	if !isPartialRequest && reader == nil && this.web.Root != nil && this.web.Root.IsOn {
+		for _, encoding := range this.acceptedPrecompressedEncodings() {
+			reader, _ = storage.OpenReader(key+caches.SuffixCompression+encoding, useStale, false)
+			if reader != nil {
+				tags = append(tags, encoding)
+				break
+			}
+		}
+	}
+
 	// 检查正常的文件
 	var isPartialCache = false
 	var partialRanges []rangeutils.Range
@@ -651,3 +691,104 @@ func (this *HTTPRequest) tryPartialReader(storage caches.StorageInterface, key s
 	isOk = true
 	return pReader, ranges
 }
+
+// 是否为本机发起的缓存预热请求
+func (this *HTTPRequest) isCacheFetchRequest() bool {
+	return (strings.HasPrefix(this.RawReq.RemoteAddr, "127.") || strings.HasPrefix(this.RawReq.RemoteAddr, "[::1]")) && this.RawReq.Header.Get("X-Edge-Cache-Action") == "fetch"
+}
+
+// 检查源站响应是否可以写入缓存，普通回源和分片回源共用
+// 不能缓存时返回统计用的原因和 X-Cache 中的说明；分片回源时缓存条件中的最大尺寸由每个分片单独限制
+func (this *HTTPRequest) checkResponseCacheable(header http.Header, statusCode int, contentSize int64, isSlice bool) (bypassReason string, bypassDetail string) {
+	var cacheRef = this.cacheRef
+	if cacheRef == nil {
+		return stats.CacheBypassNoCacheRef, "no cache ref"
+	}
+
+	// 尺寸
+	var maxSize = cacheRef.MaxSizeBytes()
+	if isSlice {
+		maxSize = 0
+	}
+	var cachePolicy = this.ReqServer.HTTPCachePolicy
+	if contentSize >= 0 && ((maxSize > 0 && contentSize > maxSize) ||
+		(cachePolicy != nil && cachePolicy.MaxSizeBytes() > 0 && contentSize > cachePolicy.MaxSizeBytes()) || (cacheRef.MinSizeBytes() > contentSize)) {
+		return stats.CacheBypassSize, "Content-Length"
+	}
+
+	// 检查状态
+	if !cacheRef.MatchStatus(statusCode) {
+		return stats.CacheBypassStatus, "Status: " + types.String(statusCode)
+	}
+
+	// Cache-Control
+	if len(cacheRef.SkipResponseCacheControlValues) > 0 {
+		var cacheControl = header.Get("Cache-Control")
+		if len(cacheControl) > 0 {
+			for _, value := range strings.Split(cacheControl, ",") {
+				if cacheRef.ContainsCacheControl(strings.TrimSpace(value)) {
+					return stats.CacheBypassNoCacheHeader, "Cache-Control: " + cacheControl
+				}
+			}
+		}
+	}
+
+	// Set-Cookie
+	if cacheRef.SkipResponseSetCookie && len(header.Get("Set-Cookie")) > 0 {
+		return stats.CacheBypassSetCookie, "Set-Cookie"
+	}
+
+	// 校验其他条件
+	if cacheRef.Conds != nil && cacheRef.Conds.HasResponseConds() && !cacheRef.Conds.MatchResponse(this.Format) {
+		return stats.CacheBypassOther, "ResponseConds"
+	}
+
+	return "", ""
+}
+
+// 计算缓存有效期，支持源站设置的max-age
+func (this *HTTPRequest) cacheLifeSeconds(header http.Header) int64 {
+	var life = this.cacheRef.LifeSeconds()
+	if life <= 0 {
+		life = 60
+	}
+
+	if this.web.Cache != nil && this.web.Cache.EnableCacheControlMaxAge {
+		var cacheControl = header.Get("Cache-Control")
+		var pieces = strings.Split(cacheControl, ";")
+		for _, piece := range pieces {
+			var eqIndex = strings.Index(piece, "=")
+			if eqIndex > 0 && piece[:eqIndex] == "max-age" {
+				var maxAge = types.Int64(piece[eqIndex+1:])
+				if maxAge > 0 {
+					life = maxAge
+				}
+			}
+		}
+	}
+	return life
+}
+
+// 统计缓存命中率
+func (this *HTTPRequest) doCacheStat() {
+	var result string
+	switch {
+	case this.isCached:
+		if this.varMapping["cache.status"] == "STALE" {
+			result = stats.CacheResultStale
+		} else {
+			result = stats.CacheResultHit
+		}
+	case len(this.cacheBypassReason) > 0:
+		result = stats.CacheResultBypass
+	default:
+		result = stats.CacheResultMiss
+	}
+	var contentType = this.writer.Header().Get("Content-Type")
+	stats.SharedCacheStatManager.Add(this.ReqServer.Id, contentType, result, this.cacheBypassReason)
+
+	// 在指标中可以使用 ${cache.result}、${cache.contentType}、${cache.bypassReason} 分组上传
+	this.varMapping["cache.result"] = result
+	this.varMapping["cache.contentType"] = stats.CacheContentTypeBucket(contentType)
+	this.varMapping["cache.bypassReason"] = this.cacheBypassReason
+}
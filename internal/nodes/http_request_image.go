@@ -0,0 +1,115 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/images"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// 图片转换配置，从 configs/local.yaml 中加载
+var sharedImageTransformConfig = &configs.ImageTransformConfig{}
+
+// 解析图片转换选项
+// 选项来自URL参数或者请求Header，解析后从URI中移除参数，使转换后的图片和原图使用同一个源站请求和缓存Key
+func (this *HTTPRequest) doImageTransform() (shouldStop bool) {
+	var config = sharedImageTransformConfig
+	if config == nil || !config.IsOn {
+		return
+	}
+
+	var optionsString = this.RawReq.Header.Get(config.Header)
+	if len(optionsString) > 0 {
+		// 不再传递给源站或者上游节点
+		this.RawReq.Header.Del(config.Header)
+	}
+
+	var questionMark = strings.Index(this.uri, "?")
+	if questionMark >= 0 {
+		value, query, found := httpRemoveQueryParam(this.uri[questionMark+1:], config.Param)
+		if found {
+			if len(value) > 0 {
+				optionsString = value
+			}
+			this.uri = httpJoinURI(this.uri[:questionMark], query)
+
+			var rawQuestionMark = strings.Index(this.rawURI, "?")
+			if rawQuestionMark >= 0 {
+				_, rawQuery, _ := httpRemoveQueryParam(this.rawURI[rawQuestionMark+1:], config.Param)
+				this.rawURI = httpJoinURI(this.rawURI[:rawQuestionMark], rawQuery)
+			}
+		}
+	}
+
+	if len(optionsString) == 0 {
+		return
+	}
+
+	var method = this.Method()
+	if method != http.MethodGet && method != http.MethodHead {
+		return
+	}
+
+	options, err := images.ParseTransformOptions(optionsString, &images.TransformLimits{
+		MaxWidth:  config.MaxWidth,
+		MaxHeight: config.MaxHeight,
+		MaxDPR:    config.MaxDPR,
+	})
+	if err != nil {
+		this.writeCode(http.StatusBadRequest, "Invalid image transform options: "+err.Error(), "图片转换参数错误："+err.Error())
+		return true
+	}
+	if options.Quality <= 0 {
+		options.Quality = config.Quality
+	}
+
+	// 未指定格式时，如果客户端支持WebP，则优先使用WebP
+	if len(options.Format) == 0 &&
+		this.web.WebP != nil &&
+		this.web.WebP.IsOn &&
+		this.web.WebP.MatchRequest(filepath.Ext(this.Path()), this.Format) &&
+		this.web.WebP.MatchAccept(this.RawReq.Header.Get("Accept")) {
+		options.Format = images.FormatWebP
+		this.imageFormatFromAccept = true
+	}
+
+	this.imageTransform = options
+	return
+}
+
+// 从查询字符串中删除某个参数，并保持其他参数的顺序和编码不变
+func httpRemoveQueryParam(rawQuery string, name string) (value string, newQuery string, found bool) {
+	var pieces = strings.Split(rawQuery, "&")
+	var result = make([]string, 0, len(pieces))
+	for _, piece := range pieces {
+		var key = piece
+		var pieceValue = ""
+		var eqIndex = strings.Index(piece, "=")
+		if eqIndex >= 0 {
+			key = piece[:eqIndex]
+			pieceValue = piece[eqIndex+1:]
+		}
+		unescapedKey, err := url.QueryUnescape(key)
+		if err == nil && unescapedKey == name {
+			found = true
+			unescapedValue, err := url.QueryUnescape(pieceValue)
+			if err == nil {
+				value = unescapedValue
+			}
+			continue
+		}
+		result = append(result, piece)
+	}
+	return value, strings.Join(result, "&"), found
+}
+
+func httpJoinURI(path string, rawQuery string) string {
+	if len(rawQuery) == 0 {
+		return path
+	}
+	return path + "?" + rawQuery
+}
@@ -0,0 +1,164 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"bytes"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/images"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPRemoveQueryParam(t *testing.T) {
+	for _, testCase := range []struct {
+		query    string
+		value    string
+		newQuery string
+		found    bool
+	}{
+		{"a=1&b=2", "", "a=1&b=2", false},
+		{"x-image=w%3D100%2Ch%3D50", "w=100,h=50", "", true},
+		{"b=2&x-image=w=100,h=50&a=1", "w=100,h=50", "b=2&a=1", true},
+		{"x-image&a=%E4%B8%AD", "", "a=%E4%B8%AD", true},
+	} {
+		value, newQuery, found := httpRemoveQueryParam(testCase.query, "x-image")
+		if value != testCase.value || newQuery != testCase.newQuery || found != testCase.found {
+			t.Fatal(testCase.query, "unexpected result:", value, newQuery, found)
+		}
+	}
+}
+
+func setTestImageTransformConfig(t *testing.T) {
+	var oldConfig = sharedImageTransformConfig
+	t.Cleanup(func() {
+		sharedImageTransformConfig = oldConfig
+	})
+
+	var config = &configs.ImageTransformConfig{IsOn: true}
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedImageTransformConfig = config
+}
+
+func TestHTTPRequest_DoImageTransform(t *testing.T) {
+	setTestImageTransformConfig(t)
+
+	// URL参数
+	{
+		var uri = "/a.png?b=2&x-image=width%3D200%2Cformat%3Dwebp&a=1"
+		var req = &HTTPRequest{
+			RawReq: httptest.NewRequest(http.MethodGet, uri, nil),
+			uri:    uri,
+			rawURI: uri,
+			web:    &serverconfigs.HTTPWebConfig{},
+		}
+		if req.doImageTransform() {
+			t.Fatal("should not stop")
+		}
+		if req.imageTransform == nil || req.imageTransform.Width != 200 || req.imageTransform.Format != images.FormatWebP {
+			t.Fatalf("unexpected options: %+v", req.imageTransform)
+		}
+
+		// 转换后的图片和原图使用相同的源站请求和缓存Key
+		if req.uri != "/a.png?b=2&a=1" || req.rawURI != "/a.png?b=2&a=1" {
+			t.Fatal("param should be removed from uri:", req.uri, req.rawURI)
+		}
+	}
+
+	// 请求Header
+	{
+		var rawReq = httptest.NewRequest(http.MethodGet, "/a.png", nil)
+		rawReq.Header.Set("X-Edge-Image", "w=100,q=60")
+		var req = &HTTPRequest{
+			RawReq: rawReq,
+			uri:    "/a.png",
+			rawURI: "/a.png",
+			web:    &serverconfigs.HTTPWebConfig{},
+		}
+		req.doImageTransform()
+		if req.imageTransform == nil || req.imageTransform.Width != 100 || req.imageTransform.Quality != 60 {
+			t.Fatalf("unexpected options: %+v", req.imageTransform)
+		}
+		if len(rawReq.Header.Get("X-Edge-Image")) > 0 {
+			t.Fatal("header should not be sent to origin")
+		}
+	}
+
+	// 只转换GET和HEAD请求
+	{
+		var uri = "/a.png?x-image=w%3D100"
+		var req = &HTTPRequest{
+			RawReq: httptest.NewRequest(http.MethodPost, uri, nil),
+			uri:    uri,
+			rawURI: uri,
+			web:    &serverconfigs.HTTPWebConfig{},
+		}
+		req.doImageTransform()
+		if req.imageTransform != nil {
+			t.Fatal("should not transform POST requests")
+		}
+	}
+}
+
+func TestHTTPWriter_TransformImage(t *testing.T) {
+	setTestImageTransformConfig(t)
+
+	var sourceImage = image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			sourceImage.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+	var sourceBuffer = &bytes.Buffer{}
+	err := png.Encode(sourceBuffer, sourceImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, err := images.ParseTransformOptions("width=20", &images.TransformLimits{MaxWidth: 100, MaxHeight: 100, MaxDPR: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	{
+		var writer = &HTTPWriter{
+			req:         &HTTPRequest{imageTransform: options},
+			rawReader:   io.NopCloser(bytes.NewReader(sourceBuffer.Bytes())),
+			imageFormat: images.FormatPNG,
+		}
+		writer.transformImage()
+		if !writer.imageIsTransformed {
+			t.Fatal("image should be transformed")
+		}
+		resultImage, _, err := image.DecodeConfig(bytes.NewReader(writer.imageData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resultImage.Width != 20 || resultImage.Height != 10 {
+			t.Fatal("unexpected size:", resultImage.Width, resultImage.Height)
+		}
+	}
+
+	// 无法解码时保留原图，以便原样输出
+	{
+		var data = []byte("not an image")
+		var writer = &HTTPWriter{
+			req:         &HTTPRequest{imageTransform: options},
+			rawReader:   io.NopCloser(bytes.NewReader(data)),
+			imageFormat: images.FormatPNG,
+		}
+		writer.transformImage()
+		if writer.imageIsTransformed || !bytes.Equal(writer.imageData, data) {
+			t.Fatal("original data should be kept")
+		}
+	}
+}
@@ -6,16 +6,80 @@ package nodes
 
 import (
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/iwind/TeaGo/lists"
+	"net"
 )
 
 const (
 	LNExpiresHeader = "X-Edge-Ln-Expires"
 )
 
+// 检查是否为同集群其他节点发来的缓存请求
 func (this *HTTPRequest) checkLnRequest() bool {
-	return false
+	var peerNode = this.RawReq.Header.Get(HTTPCachePeerNodeHeader)
+	if len(peerNode) == 0 {
+		return false
+	}
+
+	var header = this.RawReq.Header
+	var timestamp = header.Get(HTTPCachePeerTimestampHeader)
+	var token = header.Get(HTTPCachePeerTokenHeader)
+	var clientIP = header.Get(HTTPCachePeerClientHeader)
+
+	// 不再传递给源站
+	this.deletePeerHeaders()
+
+	remoteIP, _, err := net.SplitHostPort(this.RawReq.RemoteAddr)
+	if err != nil {
+		remoteIP = this.RawReq.RemoteAddr
+	}
+	if !sharedHTTPCachePeerManager.Verify(peerNode, timestamp, token, this.RawReq.Method, this.ReqHost, this.RawReq.RequestURI, remoteIP) {
+		return false
+	}
+
+	if net.ParseIP(clientIP) != nil {
+		this.lnRemoteAddr = clientIP
+	}
+	return true
 }
 
+// 查找缓存Key所属的节点
+// 其他节点发来的请求直接回源，避免在节点之间循环请求；所属节点失败后在重试时回源
 func (this *HTTPRequest) getLnOrigin(excludingNodeIds []int64) (originConfig *serverconfigs.OriginConfig, lnNodeId int64, hasMultipleNodes bool) {
-	return nil, 0, false
+	// 清除上一次请求节点时的签名
+	this.deletePeerHeaders()
+
+	if this.isLnRequest || len(this.cacheKey) == 0 || !sharedHTTPCachePeerManager.MatchServer(this.ReqServer.Id) {
+		return nil, 0, false
+	}
+
+	member, peerId, ok := sharedHTTPCachePeerManager.Owner(this.cacheKey)
+	if !ok {
+		return nil, 0, false
+	}
+	if lists.ContainsInt64(excludingNodeIds, peerId) {
+		sharedHTTPCachePeerManager.Fail(member)
+		return nil, 0, false
+	}
+
+	originConfig = sharedHTTPCachePeerManager.Origin(member, this.IsHTTPS, this.requestServerPort())
+	if originConfig == nil {
+		return nil, 0, false
+	}
+
+	// 返回多个节点，以便在失败时重试
+	return originConfig, peerId, true
+}
+
+// 为发往其他节点的请求签名，需要在请求URL确定之后调用
+func (this *HTTPRequest) signLnRequest() {
+	sharedHTTPCachePeerManager.Sign(this.RawReq.Header, this.RawReq.Method, this.ReqHost, this.RawReq.URL.RequestURI(), this.requestRemoteAddr(true))
+}
+
+func (this *HTTPRequest) deletePeerHeaders() {
+	var header = this.RawReq.Header
+	header.Del(HTTPCachePeerNodeHeader)
+	header.Del(HTTPCachePeerTimestampHeader)
+	header.Del(HTTPCachePeerTokenHeader)
+	header.Del(HTTPCachePeerClientHeader)
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/shared"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/resolvers"
 	"github.com/TeaOSLab/EdgeNode/internal/utils"
 	"github.com/iwind/TeaGo/types"
 	"io"
@@ -176,6 +177,17 @@ func (this *HTTPRequest) doOriginRequest(failedOriginIds []int64, failedLnNodeId
 		}
 		originAddr = originAddr[:originHostIndex+1] + types.String(this.requestServerPort())
 	}
+
+	// 使用SRV名称定义的源站，从SRV记录中选取目标主机
+	if resolvers.IsSRVName(origin.Addr.Host) {
+		srvAddr, srvErr := resolvers.SharedResolver().ResolveSRVAddr(originAddr)
+		if srvErr != nil {
+			remotelogs.ErrorServer("HTTP_REQUEST_REVERSE_PROXY", this.URL()+": Resolve origin SRV '"+originAddr+"' failed: "+srvErr.Error())
+			this.write50x(srvErr, http.StatusBadGateway, "Failed to resolve origin site address", "解析源站地址失败", true)
+			return
+		}
+		originAddr = srvAddr
+	}
 	this.originAddr = originAddr
 
 	// RequestHost
@@ -234,6 +246,11 @@ func (this *HTTPRequest) doOriginRequest(failedOriginIds []int64, failedLnNodeId
 	this.setForwardHeaders(this.RawReq.Header)
 	this.processRequestHeaders(this.RawReq.Header)
 
+	// 其他节点
+	if lnNodeId > 0 {
+		this.signLnRequest()
+	}
+
 	// 调用回调
 	this.onRequest()
 	if this.writer.isFinished {
@@ -254,8 +271,15 @@ func (this *HTTPRequest) doOriginRequest(failedOriginIds []int64, failedLnNodeId
 		return
 	}
 
+	// 分片回源
+	if this.canSliceFetch() && this.doSliceFetch(client) {
+		return
+	}
+
 	// 开始请求
-	resp, err := client.Do(this.RawReq)
+	originReq, stopReadTimeout, cancelOriginReq := httpWithReadTimeout(this.RawReq, origin.ReadTimeoutDuration())
+	defer cancelOriginReq()
+	resp, err := client.Do(originReq)
 	if err != nil {
 		// 客户端取消请求，则不提示
 		httpErr, ok := err.(*url.Error)
@@ -407,21 +431,35 @@ func (this *HTTPRequest) doOriginRequest(failedOriginIds []int64, failedLnNodeId
 		}
 	}
 
+	// 流式响应
+	var isStreaming = this.checkStreamResponse(resp)
+	if isStreaming {
+		stopReadTimeout()
+		this.prepareStreamResponse(resp)
+		sharedStreamCounter.Add(this.ReqServer.Id, 1)
+		defer sharedStreamCounter.Add(this.ReqServer.Id, -1)
+	}
+
 	// 响应Header
 	this.writer.AddHeaders(resp.Header)
 	this.processResponseHeaders(this.writer.Header(), resp.StatusCode)
 
 	// 是否需要刷新
-	var shouldAutoFlush = this.reverseProxy.AutoFlush || this.RawReq.Header.Get("Accept") == "text/event-stream"
+	var shouldAutoFlush = this.reverseProxy.AutoFlush || isStreaming
 
 	// 准备
-	var delayHeaders = this.writer.Prepare(resp, resp.ContentLength, resp.StatusCode, true)
+	var delayHeaders = this.writer.Prepare(resp, resp.ContentLength, resp.StatusCode, !isStreaming)
 
 	// 设置响应代码
 	if !delayHeaders {
 		this.writer.WriteHeader(resp.StatusCode)
 	}
 
+	// 流式响应立即发送Header
+	if isStreaming {
+		this.writer.Flush()
+	}
+
 	// 是否有内容
 	if resp.ContentLength == 0 && len(resp.TransferEncoding) == 0 {
 		// 即使内容为0，也需要读取一次，以便于触发相关事件
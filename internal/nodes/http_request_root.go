@@ -2,10 +2,12 @@ package nodes
 
 import (
 	"fmt"
+	"github.com/TeaOSLab/EdgeNode/internal/caches"
 	rangeutils "github.com/TeaOSLab/EdgeNode/internal/utils/ranges"
 	"github.com/TeaOSLab/EdgeNode/internal/zero"
 	"github.com/cespare/xxhash"
 	"github.com/iwind/TeaGo/Tea"
+	"github.com/iwind/TeaGo/lists"
 	"github.com/iwind/TeaGo/logs"
 	"github.com/iwind/TeaGo/types"
 	"io"
@@ -15,6 +17,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -39,6 +42,16 @@ var textMimeMap = map[string]zero.Zero{
 	"text/sgml":                           {},
 }
 
+// 预压缩文件，按照服务端优先级排列
+var precompressedFiles = []struct {
+	encoding string
+	ext      string
+}{
+	{encoding: "br", ext: ".br"},
+	{encoding: "zstd", ext: ".zst"},
+	{encoding: "gzip", ext: ".gz"},
+}
+
 // 调用本地静态资源
 // 如果返回true，则终止请求
 func (this *HTTPRequest) doRoot() (isBreak bool) {
@@ -126,6 +139,11 @@ func (this *HTTPRequest) doRoot() (isBreak bool) {
 		if len(indexFile) > 0 {
 			filePath += Tea.DS + indexFile
 		} else {
+			// 目录列表
+			if this.doAutoIndex(rootDir, filePath) {
+				return true
+			}
+
 			if this.web.Root.IsBreak {
 				this.write404()
 				return true
@@ -193,6 +211,32 @@ func (this *HTTPRequest) doRoot() (isBreak bool) {
 		}
 	}
 
+	// 预压缩文件
+	var method = this.Method()
+	if method == http.MethodGet || method == http.MethodHead {
+		encoding, encodedFilePath, encodedStat, hasPrecompressedFile := this.findPrecompressedFile(filePath, stat)
+		if hasPrecompressedFile {
+			// 同一个URL根据Accept-Encoding输出不同的内容，包括没有使用预压缩文件的响应
+			httpAddVary(respHeader, "Accept-Encoding")
+		}
+		if len(encoding) > 0 {
+			filename += strings.TrimPrefix(encodedFilePath, filePath) // 不同编码使用不同的ETag
+			filePath = encodedFilePath
+			stat = encodedStat
+			this.filePath = filePath
+
+			respHeader.Set("Content-Encoding", encoding)
+
+			// 已经压缩，不再重复压缩
+			this.writer.SetCompression(nil)
+
+			// 不同编码使用不同的缓存Key，和压缩缓存保持一致
+			if this.cacheRef != nil && len(this.cacheKey) > 0 {
+				this.cacheKey += caches.SuffixCompression + encoding
+			}
+		}
+	}
+
 	// length
 	var fileSize = stat.Size()
 
@@ -406,6 +450,90 @@ func (this *HTTPRequest) doRoot() (isBreak bool) {
 	return true
 }
 
+// 查找客户端可以接受的预压缩文件，比如 app.js.br、app.js.gz
+// 预压缩文件比原文件旧时认为已经过期，不再使用
+// hasPrecompressedFile 表示是否有可用的预压缩文件，包括客户端不接受的编码
+func (this *HTTPRequest) findPrecompressedFile(filePath string, stat os.FileInfo) (encoding string, encodedFilePath string, encodedStat os.FileInfo, hasPrecompressedFile bool) {
+	if !stat.Mode().IsRegular() {
+		return
+	}
+
+	var acceptedEncodings = this.acceptedPrecompressedEncodings()
+	for _, acceptedEncoding := range acceptedEncodings {
+		var path = filePath + precompressedFileExt(acceptedEncoding)
+		fileStat, ok := this.statPrecompressedFile(path, stat)
+		if ok {
+			return acceptedEncoding, path, fileStat, true
+		}
+	}
+
+	// 检查客户端不接受的编码
+	for _, file := range precompressedFiles {
+		if lists.ContainsString(acceptedEncodings, file.encoding) {
+			continue
+		}
+		_, ok := this.statPrecompressedFile(filePath+file.ext, stat)
+		if ok {
+			hasPrecompressedFile = true
+			return
+		}
+	}
+	return
+}
+
+// 客户端可以接受的预压缩文件编码
+// 客户端优先级高的在前，相同时按照服务端的优先级
+func (this *HTTPRequest) acceptedPrecompressedEncodings() []string {
+	var acceptEncodings = this.RawReq.Header.Get("Accept-Encoding")
+	if len(acceptEncodings) == 0 {
+		return nil
+	}
+
+	type candidate struct {
+		encoding string
+		quality  float64
+	}
+	var candidates = []candidate{}
+	for _, file := range precompressedFiles {
+		var quality = httpAcceptEncodingQuality(acceptEncodings, file.encoding)
+		if quality > 0 {
+			candidates = append(candidates, candidate{
+				encoding: file.encoding,
+				quality:  quality,
+			})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+
+	var result = []string{}
+	for _, c := range candidates {
+		result = append(result, c.encoding)
+	}
+	return result
+}
+
+// 检查预压缩文件是否可用
+func (this *HTTPRequest) statPrecompressedFile(path string, stat os.FileInfo) (os.FileInfo, bool) {
+	fileStat, err := os.Stat(path)
+	if err != nil || !fileStat.Mode().IsRegular() || fileStat.ModTime().Before(stat.ModTime()) {
+		return nil, false
+	}
+	return fileStat, true
+}
+
+// 获取预压缩文件的扩展名
+func precompressedFileExt(encoding string) string {
+	for _, file := range precompressedFiles {
+		if file.encoding == encoding {
+			return file.ext
+		}
+	}
+	return ""
+}
+
 // 查找首页文件
 func (this *HTTPRequest) findIndexFile(dir string) (filename string, stat os.FileInfo) {
 	if this.web.Root == nil || !this.web.Root.IsOn {
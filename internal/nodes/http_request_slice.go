@@ -0,0 +1,418 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"bytes"
+	"errors"
+	"github.com/TeaOSLab/EdgeNode/internal/caches"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/fasttime"
+	rangeutils "github.com/TeaOSLab/EdgeNode/internal/utils/ranges"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/readers"
+	"github.com/iwind/TeaGo/types"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var sharedSliceConfig = &configs.SliceConfig{}
+
+var errSliceUnsupported = errors.New("origin does not support range requests")
+var errSliceMismatch = errors.New("slice validators mismatch")
+
+// 分片的校验信息，同一个文件的所有分片必须一致
+type httpSliceMeta struct {
+	start        int64
+	total        int64
+	etag         string
+	lastModified string
+	header       http.Header
+}
+
+func newHTTPSliceMeta(header http.Header) (*httpSliceMeta, bool) {
+	start, total := httpRequestParseContentRangeHeader(header.Get("Content-Range"))
+	if start < 0 || total <= 0 {
+		return nil, false
+	}
+	return &httpSliceMeta{
+		start:        start,
+		total:        total,
+		etag:         header.Get("ETag"),
+		lastModified: header.Get("Last-Modified"),
+		header:       header,
+	}, true
+}
+
+// Match 检查是否为同一个文件的分片
+func (this *httpSliceMeta) Match(other *httpSliceMeta) bool {
+	if this.total != other.total {
+		return false
+	}
+	if len(this.etag) > 0 || len(other.etag) > 0 {
+		return this.etag == other.etag
+	}
+	return this.lastModified == other.lastModified
+}
+
+// 单个分片，内容来自缓存或者源站
+type httpSlice struct {
+	index  int64
+	r      rangeutils.Range // 分片在文件中的范围
+	meta   *httpSliceMeta
+	reader caches.Reader
+	resp   *http.Response
+}
+
+func (this *httpSlice) IsCached() bool {
+	return this.reader != nil
+}
+
+func (this *httpSlice) Close() {
+	if this.reader != nil {
+		_ = this.reader.Close()
+	}
+	if this.resp != nil {
+		_ = this.resp.Body.Close()
+	}
+}
+
+// 检查是否可以分片回源
+func (this *HTTPRequest) canSliceFetch() bool {
+	if sharedSliceConfig == nil || !sharedSliceConfig.MatchRequest(this.ReqServer.Id, this.Path()) {
+		return false
+	}
+	if this.RawReq.Method != http.MethodGet || this.cacheRef == nil || this.writer.cacheStorage == nil || len(this.cacheKey) == 0 {
+		return false
+	}
+
+	// 只支持单个区间，且不支持 bytes=-N 这种需要先知道文件长度的区间
+	var rangeHeader = this.RawReq.Header.Get("Range")
+	if len(rangeHeader) > 0 {
+		ranges, ok := httpRequestParseRangeHeader(rangeHeader)
+		if !ok || len(ranges) != 1 || ranges[0].Start() < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 分片回源
+// 按固定尺寸的分片从缓存或者源站读取内容，组合成客户端请求的区间；返回 false 时使用普通的方式回源
+func (this *HTTPRequest) doSliceFetch(client *http.Client) (handled bool) {
+	var sliceSize = sharedSliceConfig.SliceSize
+	var storage = this.writer.cacheStorage
+
+	var clientRange = rangeutils.NewRange(0, -1)
+	var isRangeRequest = false
+	var rangeHeader = this.RawReq.Header.Get("Range")
+	if len(rangeHeader) > 0 {
+		ranges, _ := httpRequestParseRangeHeader(rangeHeader)
+		clientRange = ranges[0]
+		isRangeRequest = true
+	}
+
+	// 读取第一个分片，得到文件长度和校验信息
+	firstSlice, err := this.openSlice(client, storage, clientRange.Start()/sliceSize, sliceSize, nil)
+	if err != nil {
+		if err != errSliceUnsupported && !this.canIgnore(err) {
+			remotelogs.WarnServer("HTTP_REQUEST_SLICE", this.URL()+": fetch first slice failed: "+err.Error())
+		}
+		return false
+	}
+	var meta = firstSlice.meta
+
+	clientRange, ok := clientRange.Convert(meta.total)
+	if !ok {
+		firstSlice.Close()
+		this.writer.Header().Set("Content-Range", "bytes */"+types.String(meta.total))
+		this.writer.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+
+	// 响应Header
+	var header = http.Header{}
+	for k, v := range meta.header {
+		if k == "Content-Range" || k == "Content-Length" || k == "Set-Cookie" {
+			continue
+		}
+		header[k] = v
+	}
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Content-Length", strconv.FormatInt(clientRange.Length(), 10))
+	var status = http.StatusOK
+	if isRangeRequest {
+		status = http.StatusPartialContent
+		header.Set("Content-Range", clientRange.ComposeContentRangeHeader(types.String(meta.total)))
+	}
+	this.originStatus = int32(status)
+	this.writer.AddHeaders(header)
+	this.processResponseHeaders(this.writer.Header(), status)
+	this.writer.Prepare(nil, clientRange.Length(), status, false)
+	this.writer.WriteHeader(status)
+
+	var isAllCached = firstSlice.IsCached()
+	defer func() {
+		if isAllCached {
+			this.varMapping["cache.status"] = "HIT"
+		} else {
+			this.varMapping["cache.status"] = "MISS"
+		}
+	}()
+
+	// 输出分片内容
+	var buf = utils.BytePool32k.Get()
+	defer utils.BytePool32k.Put(buf)
+
+	var firstIndex, lastIndex = clientRange.SliceIndexes(sliceSize)
+	var slice = firstSlice
+	for index := firstIndex; index <= lastIndex; index++ {
+		if slice == nil {
+			slice, err = this.openSlice(client, storage, index, sliceSize, meta)
+			if err != nil {
+				if err == errSliceMismatch {
+					// 源站文件已经改变，清除所有分片，下次请求时重新读取
+					remotelogs.WarnServer("HTTP_REQUEST_SLICE", this.URL()+": slice "+types.String(index)+" does not match the first slice, purge all slices")
+					_ = storage.Purge([]string{this.cacheKey + caches.SuffixSlice}, "dir")
+				} else if !this.canIgnore(err) {
+					remotelogs.WarnServer("HTTP_REQUEST_SLICE", this.URL()+": fetch slice "+types.String(index)+" failed: "+err.Error())
+				}
+				this.addError(err)
+				return true
+			}
+			if !slice.IsCached() {
+				isAllCached = false
+			}
+		}
+
+		r, _ := slice.r.Intersect(clientRange)
+		err = this.writeSlice(storage, slice, r, buf)
+		slice.Close()
+		slice = nil
+		if err != nil {
+			if !this.canIgnore(err) {
+				remotelogs.WarnServer("HTTP_REQUEST_SLICE", this.URL()+": write slice "+types.String(index)+" failed: "+err.Error())
+				this.addError(err)
+			}
+			return true
+		}
+	}
+
+	this.writer.SetOk()
+	return true
+}
+
+// 打开某个分片
+// 优先从缓存中读取，缓存中没有或者和 meta 不一致时从源站读取
+func (this *HTTPRequest) openSlice(client *http.Client, storage caches.StorageInterface, index int64, sliceSize int64, meta *httpSliceMeta) (*httpSlice, error) {
+	var sliceKey = this.cacheKey + caches.SuffixSlice + types.String(index)
+	var expectedRange = rangeutils.SliceRange(index, sliceSize, 0)
+	if meta != nil {
+		expectedRange = rangeutils.SliceRange(index, sliceSize, meta.total)
+	}
+
+	// 从缓存中读取
+	reader, err := storage.OpenReader(sliceKey, false, false)
+	if err == nil {
+		cachedMeta, ok := this.readSliceMeta(reader)
+		if ok &&
+			reader.Status() == http.StatusPartialContent &&
+			cachedMeta.start == expectedRange.Start() &&
+			(meta == nil || meta.Match(cachedMeta)) &&
+			reader.BodySize() == rangeutils.SliceRange(index, sliceSize, cachedMeta.total).Length() {
+			return &httpSlice{
+				index:  index,
+				r:      rangeutils.SliceRange(index, sliceSize, cachedMeta.total),
+				meta:   cachedMeta,
+				reader: reader,
+			}, nil
+		}
+		_ = reader.Close()
+		_ = storage.Delete(sliceKey)
+	}
+
+	// 从源站读取
+	var req = this.RawReq.Clone(this.RawReq.Context())
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(expectedRange.Start(), 10)+"-"+strconv.FormatInt(expectedRange.End(), 10))
+	for _, name := range []string{"If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		req.Header.Del(name)
+	}
+	if meta != nil && len(meta.etag) > 0 && !strings.HasPrefix(meta.etag, "W/") {
+		req.Header.Set("If-Match", meta.etag)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		_ = resp.Body.Close()
+		return nil, errSliceMismatch
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		_ = resp.Body.Close()
+		return nil, errSliceUnsupported
+	}
+
+	originMeta, ok := newHTTPSliceMeta(resp.Header)
+	if !ok || originMeta.start != expectedRange.Start() {
+		_ = resp.Body.Close()
+		return nil, errSliceUnsupported
+	}
+	if meta != nil && !meta.Match(originMeta) {
+		_ = resp.Body.Close()
+		return nil, errSliceMismatch
+	}
+
+	// 源站客户端不限制读取响应内容的总时间，这里使用读取超时作为两次读取之间的最长间隔
+	if this.origin != nil && this.origin.ReadTimeoutDuration() > 0 {
+		resp.Body = readers.NewIdleTimeoutReaderCloser(resp.Body, this.origin.ReadTimeoutDuration())
+	}
+
+	return &httpSlice{
+		index: index,
+		r:     rangeutils.SliceRange(index, sliceSize, originMeta.total),
+		meta:  originMeta,
+		resp:  resp,
+	}, nil
+}
+
+// 读取缓存中分片的Header
+func (this *HTTPRequest) readSliceMeta(reader caches.Reader) (*httpSliceMeta, bool) {
+	var header = http.Header{}
+	var headerData = []byte{}
+	var headerBuf = make([]byte, 1024)
+	err := reader.ReadHeader(headerBuf, func(n int) (goNext bool, err error) {
+		headerData = append(headerData, headerBuf[:n]...)
+		for {
+			var nIndex = bytes.IndexByte(headerData, '\n')
+			if nIndex < 0 {
+				break
+			}
+			var row = headerData[:nIndex]
+			var colonIndex = bytes.IndexByte(row, ':')
+			if colonIndex <= 0 {
+				return false, errors.New("invalid header '" + string(row) + "'")
+			}
+			header.Add(string(row[:colonIndex]), string(row[colonIndex+1:]))
+			headerData = headerData[nIndex+1:]
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, false
+	}
+	return newHTTPSliceMeta(header)
+}
+
+// 输出分片中 r 范围内的内容，从源站读取的分片同时写入缓存
+func (this *HTTPRequest) writeSlice(storage caches.StorageInterface, slice *httpSlice, r rangeutils.Range, buf []byte) error {
+	var offset = slice.r.Start()
+
+	if slice.IsCached() {
+		var writeErr error
+		err := slice.reader.ReadBodyRange(buf, r.Start()-offset, r.End()-offset, func(n int) (goNext bool, err error) {
+			_, writeErr = this.writer.Write(buf[:n])
+			return writeErr == nil, nil
+		})
+		if err != nil {
+			return err
+		}
+		return writeErr
+	}
+
+	var cacheWriter = this.openSliceWriter(storage, slice)
+	var pos = offset
+	var clientErr error
+	for {
+		n, err := slice.resp.Body.Read(buf)
+		if n > 0 {
+			if cacheWriter != nil {
+				_, writeErr := cacheWriter.Write(buf[:n])
+				if writeErr != nil {
+					_ = cacheWriter.Discard()
+					cacheWriter = nil
+				}
+			}
+
+			// 只输出客户端请求的部分
+			var chunk = rangeutils.NewRange(pos, pos+int64(n)-1)
+			pos += int64(n)
+			part, ok := chunk.Intersect(r)
+			if ok && clientErr == nil {
+				_, clientErr = this.writer.Write(buf[part.Start()-chunk.Start() : part.End()-chunk.Start()+1])
+			}
+
+			// 客户端已经断开，且不需要缓存时提前结束
+			if clientErr != nil && cacheWriter == nil {
+				return clientErr
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				if cacheWriter != nil {
+					_ = cacheWriter.Discard()
+				}
+				return err
+			}
+			break
+		}
+	}
+
+	if cacheWriter != nil {
+		if pos-offset == slice.r.Length() {
+			_ = cacheWriter.Close()
+		} else {
+			_ = cacheWriter.Discard()
+		}
+	}
+	if pos-offset != slice.r.Length() {
+		return io.ErrUnexpectedEOF
+	}
+	return clientErr
+}
+
+// 打开分片的缓存写入器
+func (this *HTTPRequest) openSliceWriter(storage caches.StorageInterface, slice *httpSlice) caches.Writer {
+	// 和普通回源使用同样的缓存条件，尺寸使用整个文件的长度，状态码使用完整响应的200
+	bypassReason, _ := this.checkResponseCacheable(slice.resp.Header, http.StatusOK, slice.meta.total, true)
+	if len(bypassReason) > 0 {
+		return nil
+	}
+
+	// 分片按整个文件的访问频率准入
+	if !this.isCacheFetchRequest() && !sharedCacheAdmission.Admit(storage, this.cacheKey) {
+		return nil
+	}
+
+	var life = this.cacheLifeSeconds(slice.resp.Header)
+
+	var headerBuf = utils.SharedBufferPool.Get()
+	defer utils.SharedBufferPool.Put(headerBuf)
+	for k, v := range slice.resp.Header {
+		if k == "Set-Cookie" {
+			continue
+		}
+		for _, v1 := range v {
+			headerBuf.WriteString(k + ":" + v1 + "\n")
+		}
+	}
+
+	var sliceKey = this.cacheKey + caches.SuffixSlice + types.String(slice.index)
+	cacheWriter, err := storage.OpenWriter(sliceKey, fasttime.Now().Unix()+life, http.StatusPartialContent, headerBuf.Len(), slice.r.Length(), this.cacheRef.MaxSizeBytes(), false)
+	if err != nil {
+		if !caches.CanIgnoreErr(err) {
+			remotelogs.Error("HTTP_REQUEST_SLICE", "write slice cache failed: "+err.Error())
+		}
+		return nil
+	}
+	_, err = cacheWriter.WriteHeader(headerBuf.Bytes())
+	if err != nil {
+		_ = cacheWriter.Discard()
+		return nil
+	}
+	return cacheWriter
+}
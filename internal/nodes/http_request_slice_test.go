@@ -0,0 +1,129 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/shared"
+	"github.com/TeaOSLab/EdgeNode/internal/stats"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPSliceMeta_Match(t *testing.T) {
+	var newMeta = func(contentRange string, etag string, lastModified string) *httpSliceMeta {
+		var header = http.Header{}
+		header.Set("Content-Range", contentRange)
+		header.Set("ETag", etag)
+		header.Set("Last-Modified", lastModified)
+		meta, ok := newHTTPSliceMeta(header)
+		if !ok {
+			t.Fatal("invalid Content-Range: " + contentRange)
+		}
+		return meta
+	}
+
+	var meta = newMeta("bytes 0-99/1000", `"abc"`, "")
+	if meta.start != 0 || meta.total != 1000 {
+		t.Fatal("unexpected meta:", meta.start, meta.total)
+	}
+	if !meta.Match(newMeta("bytes 100-199/1000", `"abc"`, "")) {
+		t.Fatal("slices of same file should match")
+	}
+	if meta.Match(newMeta("bytes 100-199/1000", `"def"`, "")) || meta.Match(newMeta("bytes 100-199/2000", `"abc"`, "")) {
+		t.Fatal("slices of different files should not match")
+	}
+	if !newMeta("bytes 0-99/1000", "", "Mon, 2 Jan 2023 15:04:05 GMT").Match(newMeta("bytes 100-199/1000", "", "Mon, 2 Jan 2023 15:04:05 GMT")) {
+		t.Fatal("should match with Last-Modified")
+	}
+
+	_, ok := newHTTPSliceMeta(http.Header{})
+	if ok {
+		t.Fatal("should fail without Content-Range")
+	}
+}
+
+func TestHTTPRequest_CheckResponseCacheable(t *testing.T) {
+	var cacheRef = &serverconfigs.HTTPCacheRef{
+		IsOn:                           true,
+		Status:                         []int{200},
+		MinSize:                        &shared.SizeCapacity{Count: 1, Unit: shared.SizeCapacityUnitMB},
+		MaxSize:                        &shared.SizeCapacity{Count: 2, Unit: shared.SizeCapacityUnitMB},
+		SkipResponseCacheControlValues: []string{"private", "no-store"},
+		SkipResponseSetCookie:          true,
+	}
+	err := cacheRef.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cachePolicy = &serverconfigs.HTTPCachePolicy{
+		IsOn:    true,
+		MaxSize: &shared.SizeCapacity{Count: 8, Unit: shared.SizeCapacityUnitMB},
+	}
+	err = cachePolicy.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req = &HTTPRequest{
+		ReqServer: &serverconfigs.ServerConfig{HTTPCachePolicy: cachePolicy},
+		web:       &serverconfigs.HTTPWebConfig{},
+		cacheRef:  cacheRef,
+	}
+
+	const mb = 1 << 20
+	for _, testCase := range []struct {
+		header       map[string]string
+		statusCode   int
+		contentSize  int64
+		isSlice      bool
+		bypassReason string
+	}{
+		{nil, 200, mb + 1, false, ""},
+		{nil, 200, 100, false, stats.CacheBypassSize},
+		{nil, 200, 100, true, stats.CacheBypassSize},
+		{nil, 200, 4 * mb, false, stats.CacheBypassSize},
+		{nil, 200, 4 * mb, true, ""}, // 分片时缓存条件中的最大尺寸只限制单个分片
+		{nil, 200, 16 * mb, true, stats.CacheBypassSize},
+		{nil, 206, mb + 1, false, stats.CacheBypassStatus},
+		{map[string]string{"Cache-Control": "public, no-store"}, 200, mb + 1, true, stats.CacheBypassNoCacheHeader},
+		{map[string]string{"Cache-Control": "private"}, 200, mb + 1, false, stats.CacheBypassNoCacheHeader},
+		{map[string]string{"Cache-Control": "max-age=60"}, 200, mb + 1, true, ""},
+		{map[string]string{"Set-Cookie": "a=b"}, 200, mb + 1, true, stats.CacheBypassSetCookie},
+	} {
+		var header = http.Header{}
+		for k, v := range testCase.header {
+			header.Set(k, v)
+		}
+		bypassReason, _ := req.checkResponseCacheable(header, testCase.statusCode, testCase.contentSize, testCase.isSlice)
+		if bypassReason != testCase.bypassReason {
+			t.Fatal(testCase.header, testCase.statusCode, testCase.contentSize, testCase.isSlice, "expect '"+testCase.bypassReason+"', got '"+bypassReason+"'")
+		}
+	}
+}
+
+func TestHTTPRequest_CacheLifeSeconds(t *testing.T) {
+	var cacheRef = &serverconfigs.HTTPCacheRef{IsOn: true}
+	err := cacheRef.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req = &HTTPRequest{
+		web:      &serverconfigs.HTTPWebConfig{},
+		cacheRef: cacheRef,
+	}
+	var header = http.Header{}
+	header.Set("Cache-Control", "max-age=120")
+	if req.cacheLifeSeconds(header) != 60 {
+		t.Fatal("should ignore max-age by default")
+	}
+
+	req.web.Cache = &serverconfigs.HTTPCacheConfig{EnableCacheControlMaxAge: true}
+	if req.cacheLifeSeconds(header) != 120 {
+		t.Fatal("should use max-age from origin")
+	}
+	if req.cacheLifeSeconds(http.Header{}) != 60 {
+		t.Fatal("should use default life")
+	}
+}
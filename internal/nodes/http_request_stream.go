@@ -0,0 +1,57 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"context"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/readers"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var sharedStreamConfig = &configs.StreamConfig{IdleTimeout: 300}
+
+// 当前正在转发的流式响应数
+var sharedStreamCounter = newServerCounter()
+
+// 源站响应是否为流式响应
+func (this *HTTPRequest) checkStreamResponse(resp *http.Response) bool {
+	if sharedStreamConfig != nil && sharedStreamConfig.MatchRequest(this.ReqServer.Id, this.RawReq.URL.Path) {
+		return true
+	}
+	var contentType = resp.Header.Get("Content-Type")
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), "text/event-stream") {
+		return true
+	}
+	return strings.EqualFold(resp.Header.Get("X-Accel-Buffering"), "no")
+}
+
+// 准备转发流式响应
+// 不压缩、不缓存，使用空闲超时代替读取超时
+func (this *HTTPRequest) prepareStreamResponse(resp *http.Response) {
+	resp.Header.Del("X-Accel-Buffering")
+	this.writer.SetCompression(nil)
+	this.cacheRef = nil
+
+	var idleTimeout = 300 * time.Second
+	if sharedStreamConfig != nil && sharedStreamConfig.IdleTimeout > 0 {
+		idleTimeout = time.Duration(sharedStreamConfig.IdleTimeout) * time.Second
+	}
+	resp.Body = readers.NewIdleTimeoutReaderCloser(resp.Body, idleTimeout)
+}
+
+// 限制源站请求的读取时间，包括读取响应内容的时间
+// 和 http.Client.Timeout 不同，检测到流式响应后可以调用 stopTimeout() 取消限制，之后只使用空闲超时
+func httpWithReadTimeout(req *http.Request, timeout time.Duration) (newReq *http.Request, stopTimeout func(), cancel context.CancelFunc) {
+	ctx, cancel := context.WithCancel(req.Context())
+	if timeout <= 0 {
+		return req.WithContext(ctx), func() {}, cancel
+	}
+
+	var timer = time.AfterFunc(timeout, cancel)
+	return req.WithContext(ctx), func() {
+		timer.Stop()
+	}, cancel
+}
@@ -0,0 +1,113 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPRequest_CheckStreamResponse(t *testing.T) {
+	var oldConfig = sharedStreamConfig
+	defer func() {
+		sharedStreamConfig = oldConfig
+	}()
+	sharedStreamConfig = parseTestLocalConfig(t, `
+stream:
+  rules:
+    - serverIds: [ 2 ]
+      pathPrefixes: [ "/events" ]
+`).Stream
+
+	for _, testCase := range []struct {
+		serverId    int64
+		path        string
+		contentType string
+		buffering   string
+		result      bool
+	}{
+		{1, "/", "text/html", "", false},
+		{1, "/", "text/event-stream; charset=utf-8", "", true},
+		{1, "/", "application/json", "no", true},
+		{2, "/events/1", "application/json", "", true},
+		{3, "/events/1", "application/json", "", false},
+	} {
+		var req = &HTTPRequest{
+			RawReq:    httptest.NewRequest(http.MethodGet, testCase.path, nil),
+			ReqServer: &serverconfigs.ServerConfig{Id: testCase.serverId},
+		}
+		var resp = &http.Response{Header: http.Header{}}
+		resp.Header.Set("Content-Type", testCase.contentType)
+		if len(testCase.buffering) > 0 {
+			resp.Header.Set("X-Accel-Buffering", testCase.buffering)
+		}
+		if req.checkStreamResponse(resp) != testCase.result {
+			t.Fatal(testCase.serverId, testCase.path, testCase.contentType, "expect", testCase.result)
+		}
+	}
+}
+
+func TestHTTPWithReadTimeout(t *testing.T) {
+	// 先发送Header，隔一段时间再发送内容
+	var server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Set("Content-Type", "text/event-stream")
+		writer.WriteHeader(http.StatusOK)
+		writer.(http.Flusher).Flush()
+
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-req.Context().Done():
+			return
+		}
+		_, _ = writer.Write([]byte("data: hello\n\n"))
+	}))
+	defer server.Close()
+
+	var client = &http.Client{
+		Transport: &http.Transport{
+			ResponseHeaderTimeout: 200 * time.Millisecond,
+		},
+	}
+
+	// 普通响应在读取超时后中断
+	{
+		var rawReq = httptest.NewRequest(http.MethodGet, server.URL, nil)
+		rawReq.RequestURI = ""
+		req, _, cancel := httpWithReadTimeout(rawReq, 200*time.Millisecond)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		cancel()
+		if err == nil {
+			t.Fatal("body reading should be interrupted")
+		}
+	}
+
+	// 取消限制后可以读取完整的流式响应
+	{
+		var rawReq = httptest.NewRequest(http.MethodGet, server.URL, nil)
+		rawReq.RequestURI = ""
+		req, stopTimeout, cancel := httpWithReadTimeout(rawReq, 200*time.Millisecond)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stopTimeout()
+		data, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "data: hello\n\n" {
+			t.Fatal("unexpected data:", string(data))
+		}
+	}
+}
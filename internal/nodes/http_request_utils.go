@@ -213,6 +213,51 @@ func httpAcceptEncoding(acceptEncodings string, encoding string) bool {
 	return false
 }
 
+// 获取客户端对某个编码的接受程度（q值），返回0表示不接受
+func httpAcceptEncodingQuality(acceptEncodings string, encoding string) float64 {
+	var wildcardQuality float64 = -1
+	for _, piece := range strings.Split(acceptEncodings, ",") {
+		var name = piece
+		var quality float64 = 1
+		var semicolonIndex = strings.Index(piece, ";")
+		if semicolonIndex >= 0 {
+			name = piece[:semicolonIndex]
+			var param = strings.TrimSpace(piece[semicolonIndex+1:])
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					q = 0
+				}
+				quality = q
+			}
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == encoding {
+			return quality
+		}
+		if name == "*" {
+			wildcardQuality = quality
+		}
+	}
+	if wildcardQuality > 0 {
+		return wildcardQuality
+	}
+	return 0
+}
+
+// 在Vary中添加一个Header名称，已经存在时不重复添加
+func httpAddVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, piece := range strings.Split(value, ",") {
+			piece = strings.TrimSpace(piece)
+			if piece == "*" || strings.EqualFold(piece, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
+
 // 跳转到某个URL
 func httpRedirect(writer http.ResponseWriter, req *http.Request, url string, code int) {
 	if len(writer.Header().Get("Content-Type")) == 0 {
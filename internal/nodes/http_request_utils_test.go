@@ -4,6 +4,7 @@ import (
 	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
 	"github.com/TeaOSLab/EdgeNode/internal/zero"
 	"github.com/iwind/TeaGo/assert"
+	"net/http"
 	"runtime"
 	"sync"
 	"testing"
@@ -98,6 +99,29 @@ func BenchmarkHTTPRequest_httpRequestParseContentRangeHeader(b *testing.B) {
 	}
 }
 
+func TestHTTPRequest_httpAcceptEncodingQuality(t *testing.T) {
+	var a = assert.NewAssertion(t)
+	a.IsTrue(httpAcceptEncodingQuality("gzip, deflate, br", "br") == 1)
+	a.IsTrue(httpAcceptEncodingQuality("gzip;q=0.8, br;q=0.5", "gzip") == 0.8)
+	a.IsTrue(httpAcceptEncodingQuality("gzip, br;q=0", "br") == 0)
+	a.IsTrue(httpAcceptEncodingQuality("gzip", "zstd") == 0)
+	a.IsTrue(httpAcceptEncodingQuality("*;q=0.1, gzip", "zstd") == 0.1)
+	a.IsTrue(httpAcceptEncodingQuality("", "gzip") == 0)
+}
+
+func TestHTTPRequest_httpAddVary(t *testing.T) {
+	var a = assert.NewAssertion(t)
+	var header = http.Header{}
+	httpAddVary(header, "Accept")
+	httpAddVary(header, "accept")
+	a.IsTrue(header.Get("Vary") == "Accept")
+
+	header.Set("Vary", "Origin, Accept-Encoding")
+	httpAddVary(header, "Accept-Encoding")
+	httpAddVary(header, "Accept")
+	a.IsTrue(len(header.Values("Vary")) == 2)
+}
+
 func TestHTTPRequest_httpRequestNextId(t *testing.T) {
 	teaconst.NodeId = 123
 	teaconst.NodeIdString = "123"
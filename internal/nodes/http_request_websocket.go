@@ -2,38 +2,17 @@ package nodes
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
-	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"time"
 )
 
-// WebsocketResponseReader Websocket响应Reader
-type WebsocketResponseReader struct {
-	rawReader io.Reader
-	buf       []byte
-}
-
-func NewWebsocketResponseReader(rawReader io.Reader) *WebsocketResponseReader {
-	return &WebsocketResponseReader{
-		rawReader: rawReader,
-	}
-}
-
-func (this *WebsocketResponseReader) Read(p []byte) (n int, err error) {
-	n, err = this.rawReader.Read(p)
-	if n > 0 {
-		if len(this.buf) == 0 {
-			this.buf = make([]byte, n)
-			copy(this.buf, p[:n])
-		} else {
-			this.buf = append(this.buf, p[:n]...)
-		}
-	}
-	return
-}
+// Websocket代理策略，从 configs/local.yaml 中加载
+var sharedWebSocketConfig = &configs.WebSocketConfig{}
 
 // 处理Websocket请求
 func (this *HTTPRequest) doWebsocket(requestHost string, isLastRetry bool) (shouldRetry bool) {
@@ -46,8 +25,6 @@ func (this *HTTPRequest) doWebsocket(requestHost string, isLastRetry bool) (shou
 		return
 	}
 
-	// TODO 实现handshakeTimeout
-
 	// 校验来源
 	var requestOrigin = this.RawReq.Header.Get("Origin")
 	if len(requestOrigin) > 0 {
@@ -70,6 +47,16 @@ func (this *HTTPRequest) doWebsocket(requestHost string, isLastRetry bool) (shou
 		this.RawReq.Header.Set("Origin", newRequestOrigin)
 	}
 
+	// 超时时间和消息限制
+	var policy *configs.WebSocketPolicy
+	if sharedWebSocketConfig != nil {
+		policy = sharedWebSocketConfig.PolicyForServer(this.ReqServer.Id)
+	}
+	if policy == nil {
+		policy = &configs.WebSocketPolicy{}
+		_ = policy.Init(nil)
+	}
+
 	// 获取当前连接
 	var requestConn = this.RawReq.Context().Value(HTTPConnContextKey)
 	if requestConn == nil {
@@ -77,7 +64,7 @@ func (this *HTTPRequest) doWebsocket(requestHost string, isLastRetry bool) (shou
 	}
 
 	// 连接源站
-	// TODO 增加N次错误重试，重试的时候需要尝试不同的源站
+	// 连接或者握手失败时，由上层换一个源站重试
 	originConn, _, err := OriginConnect(this.origin, this.requestServerPort(), this.RawReq.RemoteAddr, requestHost)
 	if err != nil {
 		if isLastRetry {
@@ -93,19 +80,39 @@ func (this *HTTPRequest) doWebsocket(requestHost string, isLastRetry bool) (shou
 		return
 	}
 
-	if !this.origin.IsOk {
-		SharedOriginStateManager.Success(this.origin, func() {
+	defer func() {
+		_ = originConn.Close()
+	}()
+
+	// 和源站握手
+	resp, originReader, err := this.websocketHandshake(originConn, time.Duration(policy.HandshakeTimeout)*time.Second)
+	if err != nil {
+		if isLastRetry {
+			this.write50x(err, http.StatusBadGateway, "Failed to handshake with origin site", "源站握手失败", false)
+		}
+
+		SharedOriginStateManager.Fail(this.origin, requestHost, this.reverseProxy, func() {
 			this.reverseProxy.ResetScheduling()
 		})
-	}
 
+		shouldRetry = true
+		return
+	}
 	defer func() {
-		_ = originConn.Close()
+		if resp.Body != nil {
+			_ = resp.Body.Close()
+		}
 	}()
 
-	err = this.RawReq.Write(originConn)
-	if err != nil {
-		this.write50x(err, http.StatusBadGateway, "Failed to write request to origin site", "源站请求初始化失败", false)
+	if !this.origin.IsOk {
+		SharedOriginStateManager.Success(this.origin, func() {
+			this.reverseProxy.ResetScheduling()
+		})
+	}
+
+	// 源站暂时不可用时换一个源站重试
+	if !isLastRetry && (resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout) {
+		shouldRetry = true
 		return
 	}
 
@@ -114,7 +121,7 @@ func (this *HTTPRequest) doWebsocket(requestHost string, isLastRetry bool) (shou
 		requestClientConn.SetIsPersistent(true)
 	}
 
-	clientConn, _, err := this.writer.Hijack()
+	clientConn, clientBuf, err := this.writer.Hijack()
 	if err != nil || clientConn == nil {
 		this.write50x(err, http.StatusInternalServerError, "Failed to get origin site connection", "获取源站连接失败", false)
 		return
@@ -123,78 +130,56 @@ func (this *HTTPRequest) doWebsocket(requestHost string, isLastRetry bool) (shou
 		_ = clientConn.Close()
 	}()
 
-	go func() {
-		// 读取第一个响应
-		var respReader = NewWebsocketResponseReader(originConn)
-		resp, err := http.ReadResponse(bufio.NewReader(respReader), this.RawReq)
-		if err != nil || resp == nil {
-			if resp != nil && resp.Body != nil {
-				_ = resp.Body.Close()
-			}
+	this.processResponseHeaders(resp.Header, resp.StatusCode)
+	this.writer.statusCode = resp.StatusCode
 
-			_ = clientConn.Close()
-			_ = originConn.Close()
-			return
-		}
+	// 将响应写回客户端
+	err = resp.Write(clientConn)
+	if err != nil {
+		return
+	}
 
-		this.processResponseHeaders(resp.Header, resp.StatusCode)
-		this.writer.statusCode = resp.StatusCode
+	// 源站拒绝升级协议
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return
+	}
 
-		// 将响应写回客户端
-		err = resp.Write(clientConn)
-		if err != nil {
-			if resp.Body != nil {
-				_ = resp.Body.Close()
-			}
+	// 客户端在握手完成前已经发送的数据
+	var clientReader io.Reader = clientConn
+	if clientBuf != nil && clientBuf.Reader.Buffered() > 0 {
+		clientReader = io.MultiReader(clientBuf.Reader, clientConn)
+	}
 
-			_ = clientConn.Close()
-			_ = originConn.Close()
-			return
-		}
+	sharedWebsocketCounter.Add(this.ReqServer.Id, 1)
+	defer sharedWebsocketCounter.Add(this.ReqServer.Id, -1)
 
-		// 剩余已经从源站读取的内容
-		var headerBytes = respReader.buf
-		var headerIndex = bytes.Index(headerBytes, []byte{'\r', '\n', '\r', '\n'}) // CRLF
-		if headerIndex > 0 {
-			var leftBytes = headerBytes[headerIndex+4:]
-			if len(leftBytes) > 0 {
-				_, err = clientConn.Write(leftBytes)
-				if err != nil {
-					if resp.Body != nil {
-						_ = resp.Body.Close()
-					}
-
-					_ = clientConn.Close()
-					_ = originConn.Close()
-					return
-				}
-			}
-		}
+	var session = newWebsocketSession(clientConn, clientReader, originConn, originReader, policy)
+	session.onSent = func(n int64) {
+		this.writer.sentBodyBytes += n
+	}
+	session.Run()
 
-		if resp.Body != nil {
-			_ = resp.Body.Close()
-		}
+	return
+}
 
-		// 复制剩余的数据
-		var buf = utils.BytePool4k.Get()
-		defer utils.BytePool4k.Put(buf)
-		for {
-			n, err := originConn.Read(buf)
-			if n > 0 {
-				this.writer.sentBodyBytes += int64(n)
-				_, err = clientConn.Write(buf[:n])
-				if err != nil {
-					break
-				}
-			}
-			if err != nil {
-				break
-			}
-		}
-		_ = clientConn.Close()
-		_ = originConn.Close()
-	}()
-	_, _ = io.Copy(originConn, clientConn)
+// 向源站发送握手请求并读取响应
+func (this *HTTPRequest) websocketHandshake(originConn net.Conn, timeout time.Duration) (resp *http.Response, originReader *bufio.Reader, err error) {
+	if timeout > 0 {
+		_ = originConn.SetDeadline(time.Now().Add(timeout))
+		defer func() {
+			_ = originConn.SetDeadline(time.Time{})
+		}()
+	}
 
-	return
+	err = this.RawReq.Write(originConn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	originReader = bufio.NewReader(originConn)
+	resp, err = http.ReadResponse(originReader, this.RawReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, originReader, nil
 }
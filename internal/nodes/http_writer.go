@@ -12,8 +12,10 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/compressions"
 	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/stats"
 	"github.com/TeaOSLab/EdgeNode/internal/utils"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/fasttime"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/images"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/readers"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/writers"
 	_ "github.com/biessek/golang-ico"
@@ -79,6 +81,12 @@ type HTTPWriter struct {
 	webpIsEncoding        bool
 	webpOriginContentType string
 
+	// Image
+	imageIsTransforming bool
+	imageFormat         string
+	imageData           []byte // 转换后的图片，或者无法转换时已经读取的原图
+	imageIsTransformed  bool
+
 	// Compression
 	compressionConfig      *serverconfigs.HTTPCompressionConfig
 	compressionCacheWriter caches.Writer
@@ -140,7 +148,11 @@ func (this *HTTPWriter) Prepare(resp *http.Response, size int64, status int, ena
 			this.PrepareCache(resp, size)
 		}
 		if !this.isPartial {
-			this.PrepareWebP(resp, size)
+			if this.req.imageTransform != nil {
+				this.PrepareImage(resp, size)
+			} else {
+				this.PrepareWebP(resp, size)
+			}
 		}
 		this.PrepareCompression(resp, size)
 	}
@@ -178,6 +190,7 @@ func (this *HTTPWriter) PrepareCache(resp *http.Response, size int64) {
 	if this.isPartial {
 		if !cacheRef.AllowPartialContent {
 			this.req.varMapping["cache.status"] = "BYPASS"
+			this.req.cacheBypassReason = stats.CacheBypassOther
 			if addStatusHeader {
 				this.Header().Set("X-Cache", "BYPASS, not supported partial content")
 			}
@@ -185,6 +198,7 @@ func (this *HTTPWriter) PrepareCache(resp *http.Response, size int64) {
 		}
 		if this.cacheStorage.Policy().Type != serverconfigs.CachePolicyStorageFile {
 			this.req.varMapping["cache.status"] = "BYPASS"
+			this.req.cacheBypassReason = stats.CacheBypassOther
 			if addStatusHeader {
 				this.Header().Set("X-Cache", "BYPASS, not supported partial content in memory storage")
 			}
@@ -195,6 +209,7 @@ func (this *HTTPWriter) PrepareCache(resp *http.Response, size int64) {
 	// 如果允许 ChunkedEncoding，就无需尺寸的判断，因为此时的 size 为 -1
 	if !cacheRef.AllowChunkedEncoding && size < 0 {
 		this.req.varMapping["cache.status"] = "BYPASS"
+		this.req.cacheBypassReason = stats.CacheBypassSize
 		if addStatusHeader {
 			this.Header().Set("X-Cache", "BYPASS, ChunkedEncoding")
 		}
@@ -210,65 +225,35 @@ func (this *HTTPWriter) PrepareCache(resp *http.Response, size int64) {
 			contentSize = totalSize
 		}
 	}
-	if contentSize >= 0 && ((cacheRef.MaxSizeBytes() > 0 && contentSize > cacheRef.MaxSizeBytes()) ||
-		(cachePolicy.MaxSizeBytes() > 0 && contentSize > cachePolicy.MaxSizeBytes()) || (cacheRef.MinSizeBytes() > contentSize)) {
-		this.req.varMapping["cache.status"] = "BYPASS"
-		if addStatusHeader {
-			this.Header().Set("X-Cache", "BYPASS, Content-Length")
-		}
-		return
-	}
-
-	// 检查状态
-	if !cacheRef.MatchStatus(this.StatusCode()) {
-		this.req.varMapping["cache.status"] = "BYPASS"
-		if addStatusHeader {
-			this.Header().Set("X-Cache", "BYPASS, Status: "+types.String(this.StatusCode()))
-		}
-		return
-	}
-
-	// Cache-Control
-	if len(cacheRef.SkipResponseCacheControlValues) > 0 {
-		var cacheControl = this.GetHeader("Cache-Control")
-		if len(cacheControl) > 0 {
-			values := strings.Split(cacheControl, ",")
-			for _, value := range values {
-				if cacheRef.ContainsCacheControl(strings.TrimSpace(value)) {
-					this.req.varMapping["cache.status"] = "BYPASS"
-					if addStatusHeader {
-						this.Header().Set("X-Cache", "BYPASS, Cache-Control: "+cacheControl)
-					}
-					return
-				}
-			}
-		}
-	}
 
-	// Set-Cookie
-	if cacheRef.SkipResponseSetCookie && len(this.GetHeader("Set-Cookie")) > 0 {
+	// 检查尺寸、状态码和响应Header等条件
+	bypassReason, bypassDetail := this.req.checkResponseCacheable(this.Header(), this.StatusCode(), contentSize, false)
+	if len(bypassReason) > 0 {
 		this.req.varMapping["cache.status"] = "BYPASS"
+		this.req.cacheBypassReason = bypassReason
 		if addStatusHeader {
-			this.Header().Set("X-Cache", "BYPASS, Set-Cookie")
+			this.Header().Set("X-Cache", "BYPASS, "+bypassDetail)
 		}
 		return
 	}
 
-	// 校验其他条件
-	if cacheRef.Conds != nil && cacheRef.Conds.HasResponseConds() && !cacheRef.Conds.MatchResponse(this.req.Format) {
+	// 打开缓存写入
+	var storage = caches.SharedManager.FindStorageWithPolicy(cachePolicy.Id)
+	if storage == nil {
 		this.req.varMapping["cache.status"] = "BYPASS"
+		this.req.cacheBypassReason = stats.CacheBypassOther
 		if addStatusHeader {
-			this.Header().Set("X-Cache", "BYPASS, ResponseConds")
+			this.Header().Set("X-Cache", "BYPASS, Storage")
 		}
 		return
 	}
 
-	// 打开缓存写入
-	var storage = caches.SharedManager.FindStorageWithPolicy(cachePolicy.Id)
-	if storage == nil {
+	// 准入检查，预热请求总是写入
+	if !this.req.isCacheFetchRequest() && !sharedCacheAdmission.Admit(storage, this.req.cacheKey) {
 		this.req.varMapping["cache.status"] = "BYPASS"
+		this.req.cacheBypassReason = stats.CacheBypassAdmission
 		if addStatusHeader {
-			this.Header().Set("X-Cache", "BYPASS, Storage")
+			this.Header().Set("X-Cache", "BYPASS, Admission")
 		}
 		return
 	}
@@ -279,28 +264,7 @@ func (this *HTTPWriter) PrepareCache(resp *http.Response, size int64) {
 	}
 
 	this.cacheStorage = storage
-	var life = cacheRef.LifeSeconds()
-
-	if life <= 0 {
-		life = 60
-	}
-
-	// 支持源站设置的max-age
-	if this.req.web.Cache != nil && this.req.web.Cache.EnableCacheControlMaxAge {
-		var cacheControl = this.GetHeader("Cache-Control")
-		var pieces = strings.Split(cacheControl, ";")
-		for _, piece := range pieces {
-			var eqIndex = strings.Index(piece, "=")
-			if eqIndex > 0 && piece[:eqIndex] == "max-age" {
-				var maxAge = types.Int64(piece[eqIndex+1:])
-				if maxAge > 0 {
-					life = maxAge
-				}
-			}
-		}
-	}
-
-	var expiresAt = fasttime.Now().Unix() + life
+	var expiresAt = fasttime.Now().Unix() + this.req.cacheLifeSeconds(this.Header())
 
 	if this.req.isLnRequest {
 		// 返回上级节点过期时间
@@ -581,6 +545,86 @@ func (this *HTTPWriter) PrepareWebP(resp *http.Response, size int64) {
 	}
 }
 
+// PrepareImage 准备图片转换
+func (this *HTTPWriter) PrepareImage(resp *http.Response, size int64) {
+	var options = this.req.imageTransform
+	if resp == nil || options == nil {
+		return
+	}
+
+	var config = sharedImageTransformConfig
+	if !config.IsOn {
+		return
+	}
+
+	// 已经是转换后的缓存
+	if strings.HasPrefix(this.cacheReaderSuffix, caches.SuffixImage) {
+		return
+	}
+
+	if this.req.Method() == http.MethodHead || this.StatusCode() != http.StatusOK {
+		return
+	}
+
+	// 只有在开启了缓存之后，才会转换，防止占用的系统资源过高
+	if config.RequireCache && this.req.cacheRef == nil {
+		return
+	}
+
+	if resp.ContentLength == 0 || resp.ContentLength > config.MaxSourceBytes {
+		return
+	}
+
+	var format = images.FormatWithContentType(this.GetHeader("Content-Type"))
+	if len(format) == 0 {
+		return
+	}
+	if len(options.Format) > 0 {
+		format = options.Format
+	}
+
+	// 检查内存
+	if atomic.LoadInt64(&webpTotalBufferSize) >= webpMaxBufferSize {
+		return
+	}
+
+	var contentEncoding = this.GetHeader("Content-Encoding")
+	switch contentEncoding {
+	case "gzip", "deflate", "br", "zstd":
+		reader, err := compressions.NewReader(resp.Body, contentEncoding)
+		if err != nil {
+			return
+		}
+		this.Header().Del("Content-Encoding")
+		this.Header().Del("Content-Length")
+		this.rawReader = reader
+	case "": // 空
+	default:
+		return
+	}
+
+	this.imageFormat = format
+	this.imageIsTransforming = true
+	resp.Body = io.NopCloser(&bytes.Buffer{})
+	this.delayRead = true
+
+	// 在发送响应头之前完成转换，转换失败时保留原图的响应头
+	this.transformImage()
+	if !this.imageIsTransformed {
+		if len(contentEncoding) > 0 {
+			this.Header().Del("ETag")
+		}
+		return
+	}
+
+	this.Header().Del("Content-Length")
+	this.Header().Del("ETag")
+	this.Header().Set("Content-Type", images.ContentType(format))
+	if this.req.imageFormatFromAccept {
+		httpAddVary(this.Header(), "Accept")
+	}
+}
+
 // PrepareCompression 准备压缩
 func (this *HTTPWriter) PrepareCompression(resp *http.Response, size int64) {
 	var method = this.req.Method()
@@ -666,7 +710,8 @@ func (this *HTTPWriter) PrepareCompression(resp *http.Response, size int64) {
 		this.cacheStorage != nil &&
 		cacheRef != nil &&
 		(this.cacheReader != nil || (this.cacheStorage.Policy().SyncCompressionCache && this.cacheWriter != nil)) &&
-		!this.webpIsEncoding {
+		!this.webpIsEncoding &&
+		!this.imageIsTransforming {
 		var cacheKey = ""
 		var expiredAt int64 = 0
 
@@ -792,7 +837,7 @@ func (this *HTTPWriter) AddHeaders(header http.Header) {
 
 // Write 写入数据
 func (this *HTTPWriter) Write(data []byte) (n int, err error) {
-	if this.webpIsEncoding {
+	if this.webpIsEncoding || this.imageIsTransforming {
 		return
 	}
 	n, err = this.writer.Write(data)
@@ -934,6 +979,7 @@ func (this *HTTPWriter) SetOk() {
 // Close 关闭
 func (this *HTTPWriter) Close() {
 	this.finishWebP()
+	this.finishImage()
 	this.finishRequest()
 	this.finishCache()
 	this.finishCompression()
@@ -1138,6 +1184,137 @@ func (this *HTTPWriter) finishWebP() {
 	}
 }
 
+// 结束图片转换
+func (this *HTTPWriter) finishImage() {
+	if !this.imageIsTransforming {
+		return
+	}
+
+	_, err := this.writer.Write(this.imageData)
+	if err != nil {
+		return
+	}
+
+	// 无法转换时输出剩余的原图
+	if !this.imageIsTransformed {
+		_, _ = io.Copy(this.writer, this.rawReader)
+		return
+	}
+
+	// 写入缓存
+	if this.cacheStorage != nil && (this.cacheReader != nil || this.cacheWriter != nil) {
+		var cacheKey string
+		var expiredAt int64
+		if this.cacheReader != nil {
+			cacheKey = this.req.cacheKey
+			expiredAt = this.cacheReader.ExpiresAt()
+		} else {
+			cacheKey = this.cacheWriter.Key()
+			expiredAt = this.cacheWriter.ExpiredAt()
+		}
+		this.writeImageCache(cacheKey+caches.SuffixImage+this.req.imageTransform.Key(), expiredAt, this.imageData)
+	}
+}
+
+// 读取并转换图片
+// 转换成功时 imageData 为转换后的图片，否则为已经读取的原图
+func (this *HTTPWriter) transformImage() {
+	var config = sharedImageTransformConfig
+	var options = this.req.imageTransform
+
+	// 读取原图
+	// 需要读取完整的内容，以便原图可以正常写入缓存
+	data, err := io.ReadAll(io.LimitReader(this.rawReader, config.MaxSourceBytes+1))
+	this.imageData = data
+	if err != nil {
+		return
+	}
+
+	var totalBytes = int64(len(data))
+	atomic.AddInt64(&webpTotalBufferSize, totalBytes)
+	defer func() {
+		atomic.AddInt64(&webpTotalBufferSize, -totalBytes)
+	}()
+
+	if int64(len(data)) > config.MaxSourceBytes {
+		return
+	}
+
+	// 检查原图尺寸，防止解码过大的图片
+	imageConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || int64(imageConfig.Width)*int64(imageConfig.Height) > config.MaxPixels {
+		return
+	}
+
+	var pixelBytes = int64(imageConfig.Width) * int64(imageConfig.Height) * 4
+	atomic.AddInt64(&webpTotalBufferSize, pixelBytes)
+	defer func() {
+		atomic.AddInt64(&webpTotalBufferSize, -pixelBytes)
+	}()
+
+	imageData, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+
+	var resultBuffer = &bytes.Buffer{}
+	err = images.Encode(resultBuffer, images.Transform(imageData, options), this.imageFormat, options.Quality)
+	if err != nil {
+		remotelogs.Error("HTTP_WRITER", "'"+this.req.URL()+"' transform image failed: "+err.Error())
+		return
+	}
+
+	this.imageData = resultBuffer.Bytes()
+	this.imageIsTransformed = true
+}
+
+// 写入转换后的图片缓存
+func (this *HTTPWriter) writeImageCache(cacheKey string, expiredAt int64, data []byte) {
+	cacheWriter, err := this.cacheStorage.OpenWriter(cacheKey, expiredAt, this.StatusCode(), -1, -1, -1, false)
+	if err != nil {
+		return
+	}
+
+	for k, v := range this.Header() {
+		if k == "Set-Cookie" {
+			continue
+		}
+
+		// 这里是原始的数据，不需要内容编码
+		if k == "Content-Encoding" || k == "Transfer-Encoding" {
+			continue
+		}
+		for _, v1 := range v {
+			_, err = cacheWriter.WriteHeader([]byte(k + ":" + v1 + "\n"))
+			if err != nil {
+				_ = cacheWriter.Discard()
+				return
+			}
+		}
+	}
+
+	_, err = cacheWriter.Write(data)
+	if err == nil {
+		err = cacheWriter.Close()
+	}
+	if err != nil {
+		remotelogs.Error("HTTP_WRITER", "write image cache failed: "+err.Error())
+		_ = cacheWriter.Discard()
+		return
+	}
+
+	this.cacheStorage.AddToList(&caches.Item{
+		Type:       cacheWriter.ItemType(),
+		Key:        cacheWriter.Key(),
+		ExpiredAt:  cacheWriter.ExpiredAt(),
+		StaleAt:    cacheWriter.ExpiredAt() + int64(this.calculateStaleLife()),
+		HeaderSize: cacheWriter.HeaderSize(),
+		BodySize:   cacheWriter.BodySize(),
+		Host:       this.req.ReqHost,
+		ServerId:   this.req.ReqServer.Id,
+	})
+}
+
 // 结束缓存相关处理
 func (this *HTTPWriter) finishCache() {
 	// 缓存
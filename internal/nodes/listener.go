@@ -18,6 +18,10 @@ type Listener struct {
 	group    *serverconfigs.ServerAddressGroup
 	listener ListenerInterface // 监听器
 
+	rawTCPListener net.Listener // 原始的TCP/Unix监听器，用于在热升级时传递给新进程
+	rawUDPIPv4Conn *net.UDPConn
+	rawUDPIPv6Conn *net.UDPConn
+
 	locker sync.RWMutex
 }
 
@@ -62,6 +66,7 @@ func (this *Listener) listenTCP() error {
 	if err != nil {
 		return err
 	}
+	this.rawTCPListener = tcpListener
 	var netListener = NewClientListener(tcpListener, protocol.IsHTTPFamily() || protocol.IsHTTPSFamily())
 	events.OnKey(events.EventQuit, this, func() {
 		remotelogs.Println("LISTENER", "quit "+this.group.FullAddr())
@@ -134,6 +139,7 @@ func (this *Listener) listenUDP() error {
 		// ipv4
 		ipv4Listener, err := this.createUDPIPv4Listener()
 		if err == nil {
+			this.rawUDPIPv4Conn = ipv4Listener
 			ipv4PacketListener = ipv4.NewPacketConn(ipv4Listener)
 		} else {
 			remotelogs.Error("LISTENER", "create udp ipv4 listener '"+addr+"': "+err.Error())
@@ -142,6 +148,7 @@ func (this *Listener) listenUDP() error {
 		// ipv6
 		ipv6Listener, err := this.createUDPIPv6Listener()
 		if err == nil {
+			this.rawUDPIPv6Conn = ipv6Listener
 			ipv6PacketListener = ipv6.NewPacketConn(ipv6Listener)
 		} else {
 			remotelogs.Error("LISTENER", "create udp ipv6 listener '"+addr+"': "+err.Error())
@@ -149,6 +156,7 @@ func (this *Listener) listenUDP() error {
 	} else if strings.Contains(host, ":") { // ipv6
 		ipv6Listener, err := this.createUDPIPv6Listener()
 		if err == nil {
+			this.rawUDPIPv6Conn = ipv6Listener
 			ipv6PacketListener = ipv6.NewPacketConn(ipv6Listener)
 		} else {
 			remotelogs.Error("LISTENER", "create udp ipv6 listener '"+addr+"': "+err.Error())
@@ -156,29 +164,27 @@ func (this *Listener) listenUDP() error {
 	} else { // ipv4
 		ipv4Listener, err := this.createUDPIPv4Listener()
 		if err == nil {
+			this.rawUDPIPv4Conn = ipv4Listener
 			ipv4PacketListener = ipv4.NewPacketConn(ipv4Listener)
 		} else {
 			remotelogs.Error("LISTENER", "create udp ipv4 listener '"+addr+"': "+err.Error())
 		}
 	}
 
+	var udpListener = &UDPListener{
+		BaseListener: BaseListener{Group: this.group},
+		IPv4Listener: ipv4PacketListener,
+		IPv6Listener: ipv6PacketListener,
+	}
+
 	events.OnKey(events.EventQuit, this, func() {
 		remotelogs.Println("LISTENER", "quit "+this.group.FullAddr())
 
-		if ipv4PacketListener != nil {
-			_ = ipv4PacketListener.Close()
-		}
-
-		if ipv6PacketListener != nil {
-			_ = ipv6PacketListener.Close()
-		}
+		// 不再接收新的数据包，已有的会话在超时后由进程退出时关闭
+		udpListener.Drain()
 	})
 
-	this.listener = &UDPListener{
-		BaseListener: BaseListener{Group: this.group},
-		IPv4Listener: ipv4PacketListener,
-		IPv6Listener: ipv6PacketListener,
-	}
+	this.listener = udpListener
 
 	goman.New(func() {
 		err := this.listener.Serve()
@@ -199,8 +205,65 @@ func (this *Listener) Close() error {
 	return this.listener.Close()
 }
 
+// Shutdown 优雅关闭
+func (this *Listener) Shutdown(ctx context.Context) error {
+	events.Remove(this)
+
+	if this.listener == nil {
+		return nil
+	}
+	return this.listener.Shutdown(ctx)
+}
+
+// CountActiveConnections 获取当前活跃连接数
+func (this *Listener) CountActiveConnections() int {
+	if this.listener == nil {
+		return 0
+	}
+	return this.listener.CountActiveConnections()
+}
+
+// Files 导出监听的文件描述符，用于在热升级时传递给新进程
+func (this *Listener) Files() []*ListenerFile {
+	var result = []*ListenerFile{}
+	var fullAddr = this.FullAddr()
+
+	var addFile = func(key string, rawListener any) {
+		file, err := exportListenerFile(key, rawListener)
+		if err != nil {
+			remotelogs.Error("LISTENER", "export '"+key+"' failed: "+err.Error())
+			return
+		}
+		if file != nil {
+			result = append(result, file)
+		}
+	}
+
+	if this.rawTCPListener != nil {
+		// 关闭时不删除sock文件，因为新进程还在使用
+		unixListener, ok := this.rawTCPListener.(*net.UnixListener)
+		if ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+		addFile(fullAddr, this.rawTCPListener)
+	}
+	if this.rawUDPIPv4Conn != nil {
+		addFile(fullAddr+"#udp4", this.rawUDPIPv4Conn)
+	}
+	if this.rawUDPIPv6Conn != nil {
+		addFile(fullAddr+"#udp6", this.rawUDPIPv6Conn)
+	}
+	return result
+}
+
 // 创建TCP监听器
 func (this *Listener) createTCPListener() (net.Listener, error) {
+	// 从上一个进程继承
+	inheritedListener, ok := sharedListenerInheritance.Listener(this.FullAddr())
+	if ok {
+		return inheritedListener, nil
+	}
+
 	var listenConfig = net.ListenConfig{
 		Control:   nil,
 		KeepAlive: 0,
@@ -218,6 +281,12 @@ func (this *Listener) createTCPListener() (net.Listener, error) {
 
 // 创建UDP IPv4监听器
 func (this *Listener) createUDPIPv4Listener() (*net.UDPConn, error) {
+	// 从上一个进程继承
+	inheritedConn, ok := sharedListenerInheritance.UDPConn(this.FullAddr() + "#udp4")
+	if ok {
+		return inheritedConn, nil
+	}
+
 	addr, err := net.ResolveUDPAddr("udp", this.group.Addr())
 	if err != nil {
 		return nil, err
@@ -227,6 +296,12 @@ func (this *Listener) createUDPIPv4Listener() (*net.UDPConn, error) {
 
 // 创建UDP监听器
 func (this *Listener) createUDPIPv6Listener() (*net.UDPConn, error) {
+	// 从上一个进程继承
+	inheritedConn, ok := sharedListenerInheritance.UDPConn(this.FullAddr() + "#udp6")
+	if ok {
+		return inheritedConn, nil
+	}
+
 	addr, err := net.ResolveUDPAddr("udp", this.group.Addr())
 	if err != nil {
 		return nil, err
@@ -1,6 +1,7 @@
 package nodes
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"github.com/TeaOSLab/EdgeCommon/pkg/configutils"
@@ -9,6 +10,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
 	"github.com/iwind/TeaGo/types"
 	"net"
+	"time"
 )
 
 type BaseListener struct {
@@ -31,6 +33,23 @@ func (this *BaseListener) CountActiveConnections() int {
 	return types.Int(this.countActiveConnections)
 }
 
+// 等待活跃连接数降为0，ctx 结束时返回错误
+func (this *BaseListener) waitForConnections(ctx context.Context, countFunc func() int) error {
+	var ticker = time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if countFunc() <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
 // 构造TLS配置
 func (this *BaseListener) buildTLSConfig() *tls.Config {
 	return &tls.Config{
@@ -3,6 +3,7 @@
 package nodes
 
 import (
+	"context"
 	"github.com/TeaOSLab/EdgeCommon/pkg/nodeconfigs"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
 	"github.com/iwind/TeaGo/types"
@@ -34,3 +35,25 @@ func TestBaseListener_FindServer(t *testing.T) {
 
 	t.Log(listener.findNamedServerMatched("855555.hello.com"))
 }
+
+func TestBaseListener_WaitForConnections(t *testing.T) {
+	var listener = &BaseListener{}
+
+	var count = 3
+	err := listener.waitForConnections(context.Background(), func() int {
+		count--
+		return count
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = listener.waitForConnections(ctx, func() int {
+		return 1
+	})
+	if err == nil {
+		t.Fatal("should be timeout")
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"crypto/tls"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
 	"github.com/iwind/TeaGo/Tea"
+	"github.com/iwind/TeaGo/types"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -33,6 +35,9 @@ type HTTPListener struct {
 	isHTTP     bool
 	isHTTPS    bool
 	httpServer *http.Server
+
+	hijackedConns  map[net.Conn]bool // 被WebSocket等接管的连接
+	hijackedLocker sync.Mutex
 }
 
 func (this *HTTPListener) Serve() error {
@@ -51,6 +56,15 @@ func (this *HTTPListener) Serve() error {
 				atomic.AddInt64(&this.countActiveConnections, 1)
 			case http.StateClosed:
 				atomic.AddInt64(&this.countActiveConnections, -1)
+			case http.StateHijacked:
+				// 被接管的连接不会再有 StateClosed 状态，需要单独跟踪
+				atomic.AddInt64(&this.countActiveConnections, -1)
+				this.hijackedLocker.Lock()
+				if this.hijackedConns == nil {
+					this.hijackedConns = map[net.Conn]bool{}
+				}
+				this.hijackedConns[conn] = true
+				this.hijackedLocker.Unlock()
 			}
 		},
 		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
@@ -98,6 +112,30 @@ func (this *HTTPListener) Close() error {
 	return this.Listener.Close()
 }
 
+// Shutdown 优雅关闭
+// 已有连接的响应中会加入 Connection: close，HTTP/2 连接会收到 GOAWAY，然后等待处理中的请求和 WebSocket 连接结束
+func (this *HTTPListener) Shutdown(ctx context.Context) error {
+	if this.httpServer == nil {
+		return this.Listener.Close()
+	}
+
+	this.httpServer.SetKeepAlivesEnabled(false)
+	err := this.httpServer.Shutdown(ctx)
+	if err == nil {
+		err = this.waitForConnections(ctx, this.countHijackedConns)
+	}
+	if err != nil {
+		_ = this.httpServer.Close()
+		this.closeHijackedConns()
+	}
+	return err
+}
+
+// CountActiveConnections 获取当前活跃连接数，包括被接管的连接
+func (this *HTTPListener) CountActiveConnections() int {
+	return types.Int(atomic.LoadInt64(&this.countActiveConnections)) + this.countHijackedConns()
+}
+
 func (this *HTTPListener) Reload(group *serverconfigs.ServerAddressGroup) {
 	this.Group = group
 
@@ -245,3 +283,38 @@ func (this *HTTPListener) emptyServer() *serverconfigs.ServerConfig {
 
 	return server
 }
+
+// 计算仍然活跃的被接管的连接数，同时清除已关闭的连接
+func (this *HTTPListener) countHijackedConns() int {
+	this.hijackedLocker.Lock()
+	defer this.hijackedLocker.Unlock()
+
+	for conn := range this.hijackedConns {
+		if this.isClosedConn(conn) {
+			delete(this.hijackedConns, conn)
+		}
+	}
+	return len(this.hijackedConns)
+}
+
+// 关闭所有被接管的连接
+func (this *HTTPListener) closeHijackedConns() {
+	this.hijackedLocker.Lock()
+	var conns = this.hijackedConns
+	this.hijackedConns = nil
+	this.hijackedLocker.Unlock()
+
+	for conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+// 检查连接是否已关闭，无法判断的连接视为已关闭
+func (this *HTTPListener) isClosedConn(conn net.Conn) bool {
+	tlsConn, ok := conn.(*tls.Conn)
+	if ok {
+		conn = tlsConn.NetConn()
+	}
+	clientConn, ok := conn.(ClientConnInterface)
+	return !ok || clientConn.IsClosed()
+}
@@ -0,0 +1,178 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"encoding/json"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/iwind/TeaGo/types"
+	"net"
+	"os"
+	"sync"
+)
+
+const (
+	envInheritedListeners = "EdgeInheritedListeners" // 从上一个进程继承的监听器：[{"key":"...","fd":N}, ...]
+	envUpgradeReadyFD     = "EdgeUpgradeReadyFD"     // 新进程启动完成后通知上一个进程的管道
+)
+
+var sharedListenerInheritance = NewListenerInheritance()
+
+// InheritedListenerFile 继承的监听器文件描述
+type InheritedListenerFile struct {
+	Key string `json:"key"`
+	FD  int    `json:"fd"`
+}
+
+// ListenerFile 可以传递给新进程的监听器文件
+type ListenerFile struct {
+	Key  string
+	File *os.File
+}
+
+// 可以导出文件描述符的监听器
+type listenerFiler interface {
+	File() (*os.File, error)
+}
+
+// ListenerInheritance 进程热升级时继承的监听器
+// 老进程通过 ExtraFiles 将监听的文件描述符传递给新进程，新进程优先使用这些文件描述符而不是重新监听端口，
+// 从而在升级过程中端口不会关闭
+type ListenerInheritance struct {
+	fileMap map[string]*os.File // key => file
+	readyFD int
+	locker  sync.Mutex
+}
+
+// NewListenerInheritance 从环境变量中读取继承的监听器
+func NewListenerInheritance() *ListenerInheritance {
+	var inheritance = &ListenerInheritance{
+		fileMap: map[string]*os.File{},
+		readyFD: -1,
+	}
+
+	var listenersJSON = os.Getenv(envInheritedListeners)
+	if len(listenersJSON) > 0 {
+		var files = []*InheritedListenerFile{}
+		err := json.Unmarshal([]byte(listenersJSON), &files)
+		if err != nil {
+			remotelogs.Error("LISTENER_INHERITANCE", "decode inherited listeners failed: "+err.Error())
+		} else {
+			for _, file := range files {
+				if file.FD > 2 && len(file.Key) > 0 {
+					inheritance.fileMap[file.Key] = os.NewFile(uintptr(file.FD), file.Key)
+				}
+			}
+		}
+	}
+
+	var readyFDString = os.Getenv(envUpgradeReadyFD)
+	if len(readyFDString) > 0 {
+		inheritance.readyFD = types.Int(readyFDString)
+	}
+
+	// 防止被再下一个进程继承
+	_ = os.Unsetenv(envInheritedListeners)
+	_ = os.Unsetenv(envUpgradeReadyFD)
+
+	return inheritance
+}
+
+// IsInherited 是否从上一个进程继承
+func (this *ListenerInheritance) IsInherited() bool {
+	return this.readyFD > 2
+}
+
+// Listener 获取继承的TCP/Unix监听器
+func (this *ListenerInheritance) Listener(key string) (net.Listener, bool) {
+	var file = this.take(key)
+	if file == nil {
+		return nil, false
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		remotelogs.Error("LISTENER_INHERITANCE", "restore listener '"+key+"' failed: "+err.Error())
+		return nil, false
+	}
+	return listener, true
+}
+
+// UDPConn 获取继承的UDP连接
+func (this *ListenerInheritance) UDPConn(key string) (*net.UDPConn, bool) {
+	var file = this.take(key)
+	if file == nil {
+		return nil, false
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	conn, err := net.FilePacketConn(file)
+	if err != nil {
+		remotelogs.Error("LISTENER_INHERITANCE", "restore udp listener '"+key+"' failed: "+err.Error())
+		return nil, false
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		_ = conn.Close()
+		return nil, false
+	}
+	return udpConn, true
+}
+
+// NotifyReady 通知上一个进程当前进程已经启动完成
+// 同时关闭没有用到的继承文件
+func (this *ListenerInheritance) NotifyReady() {
+	this.locker.Lock()
+	for key, file := range this.fileMap {
+		remotelogs.Println("LISTENER_INHERITANCE", "close unused listener '"+key+"'")
+		_ = file.Close()
+	}
+	this.fileMap = map[string]*os.File{}
+	var readyFD = this.readyFD
+	this.readyFD = -1
+	this.locker.Unlock()
+
+	if readyFD <= 2 {
+		return
+	}
+
+	var readyFile = os.NewFile(uintptr(readyFD), "ready")
+	_, err := readyFile.Write([]byte("ok"))
+	if err != nil {
+		remotelogs.Error("LISTENER_INHERITANCE", "notify parent process failed: "+err.Error())
+	}
+	_ = readyFile.Close()
+}
+
+func (this *ListenerInheritance) take(key string) *os.File {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	file, ok := this.fileMap[key]
+	if ok {
+		delete(this.fileMap, key)
+		return file
+	}
+	return nil
+}
+
+// 导出文件描述符
+func exportListenerFile(key string, listener any) (*ListenerFile, error) {
+	filer, ok := listener.(listenerFiler)
+	if !ok {
+		return nil, nil
+	}
+	file, err := filer.File()
+	if err != nil {
+		return nil, err
+	}
+	return &ListenerFile{
+		Key:  key,
+		File: file,
+	}, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestListenerInheritance_Listener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	file, err := exportListenerFile("tcp://"+listener.Addr().String(), listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file == nil {
+		t.Fatal("should export file")
+	}
+
+	inheritedJSON, err := json.Marshal([]*InheritedListenerFile{
+		{
+			Key: file.Key,
+			FD:  int(file.File.Fd()),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envInheritedListeners, string(inheritedJSON))
+
+	var inheritance = NewListenerInheritance()
+	if inheritance.IsInherited() {
+		t.Fatal("should not be inherited without ready fd")
+	}
+
+	newListener, ok := inheritance.Listener(file.Key)
+	if !ok {
+		t.Fatal("should restore listener")
+	}
+	defer func() {
+		_ = newListener.Close()
+	}()
+	if newListener.Addr().String() != listener.Addr().String() {
+		t.Fatal("expect '" + listener.Addr().String() + "', got '" + newListener.Addr().String() + "'")
+	}
+
+	_, ok = inheritance.Listener(file.Key)
+	if ok {
+		t.Fatal("listener should be taken only once")
+	}
+}
@@ -1,6 +1,9 @@
 package nodes
 
-import "github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+import (
+	"context"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+)
 
 // ListenerInterface 各协议监听器的接口
 type ListenerInterface interface {
@@ -13,6 +16,9 @@ type ListenerInterface interface {
 	// Close 关闭
 	Close() error
 
+	// Shutdown 优雅关闭：停止接收新的连接，等待已有的连接处理完成，ctx 结束时强制关闭
+	Shutdown(ctx context.Context) error
+
 	// Reload 重载配置
 	Reload(serverGroup *serverconfigs.ServerAddressGroup)
 
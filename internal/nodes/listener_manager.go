@@ -1,6 +1,7 @@
 package nodes
 
 import (
+	"context"
 	"errors"
 	"github.com/TeaOSLab/EdgeCommon/pkg/nodeconfigs"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
@@ -26,6 +27,9 @@ import (
 
 var sharedListenerManager *ListenerManager
 
+// DefaultListenerDrainTimeout 优雅关闭时默认等待已有连接处理完成的时间
+const DefaultListenerDrainTimeout = 60 * time.Second
+
 func init() {
 	if !teaconst.IsMain {
 		return
@@ -43,6 +47,9 @@ type ListenerManager struct {
 	retryListenerMap map[string]*Listener // 需要重试的监听器 addr => Listener
 	ticker           *time.Ticker
 
+	drainingListenerMap map[*Listener]int64 // 正在优雅关闭的监听器 => 截止时间
+	isShuttingDown      bool
+
 	firewalld         *firewalls.Firewalld
 	lastPortStrings   string
 	lastTCPPortRanges [][2]int
@@ -52,10 +59,11 @@ type ListenerManager struct {
 // NewListenerManager 获取新对象
 func NewListenerManager() *ListenerManager {
 	var manager = &ListenerManager{
-		listenersMap:     map[string]*Listener{},
-		retryListenerMap: map[string]*Listener{},
-		ticker:           time.NewTicker(1 * time.Minute),
-		firewalld:        firewalls.NewFirewalld(),
+		listenersMap:        map[string]*Listener{},
+		retryListenerMap:    map[string]*Listener{},
+		ticker:              time.NewTicker(1 * time.Minute),
+		firewalld:           firewalls.NewFirewalld(),
+		drainingListenerMap: map[*Listener]int64{},
 	}
 
 	// 提升测试效率
@@ -77,6 +85,11 @@ func (this *ListenerManager) Start(node *nodeconfigs.NodeConfig) error {
 	this.locker.Lock()
 	defer this.locker.Unlock()
 
+	// 正在退出
+	if this.isShuttingDown {
+		return nil
+	}
+
 	// 重置数据
 	this.retryListenerMap = map[string]*Listener{}
 
@@ -107,7 +120,7 @@ func (this *ListenerManager) Start(node *nodeconfigs.NodeConfig) error {
 		addr := listener.FullAddr()
 		if !lists.ContainsString(groupAddrs, addr) {
 			remotelogs.Println("LISTENER_MANAGER", "close '"+addr+"'")
-			_ = listener.Close()
+			this.drain(listener, DefaultListenerDrainTimeout, nil)
 
 			delete(this.listenersMap, listenerKey)
 		}
@@ -169,11 +182,104 @@ func (this *ListenerManager) TotalActiveConnections() int {
 
 	total := 0
 	for _, listener := range this.listenersMap {
-		total += listener.listener.CountActiveConnections()
+		total += listener.CountActiveConnections()
+	}
+	for listener := range this.drainingListenerMap {
+		total += listener.CountActiveConnections()
 	}
 	return total
 }
 
+// Shutdown 优雅关闭所有的监听器，并在 timeout 内等待已有连接处理完成
+func (this *ListenerManager) Shutdown(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultListenerDrainTimeout
+	}
+
+	var wg = &sync.WaitGroup{}
+
+	this.locker.Lock()
+	this.isShuttingDown = true
+	this.retryListenerMap = map[string]*Listener{}
+	for addr, listener := range this.listenersMap {
+		wg.Add(1)
+		this.drain(listener, timeout, wg)
+		delete(this.listenersMap, addr)
+	}
+	this.locker.Unlock()
+
+	wg.Wait()
+}
+
+// DrainStatus 获取正在优雅关闭的监听器状态
+func (this *ListenerManager) DrainStatus() maps.Map {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var listenerMaps = []maps.Map{}
+	var total = 0
+	var currentTime = time.Now().Unix()
+	for listener, deadline := range this.drainingListenerMap {
+		var count = listener.CountActiveConnections()
+		total += count
+		listenerMaps = append(listenerMaps, maps.Map{
+			"addr":              listener.FullAddr(),
+			"activeConnections": count,
+			"remainingSeconds":  deadline - currentTime,
+		})
+	}
+
+	return maps.Map{
+		"isDraining":        len(this.drainingListenerMap) > 0,
+		"isShuttingDown":    this.isShuttingDown,
+		"activeConnections": total,
+		"listeners":         listenerMaps,
+	}
+}
+
+// 在后台优雅关闭监听器；调用者需要持有 this.locker
+func (this *ListenerManager) drain(listener *Listener, timeout time.Duration, wg *sync.WaitGroup) {
+	this.drainingListenerMap[listener] = time.Now().Add(timeout).Unix()
+
+	goman.New(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		err := listener.Shutdown(ctx)
+		if err != nil {
+			remotelogs.Warn("LISTENER_MANAGER", "shutdown '"+listener.FullAddr()+"': "+err.Error()+", "+types.String(listener.CountActiveConnections())+" connection(s) closed forcibly")
+		}
+
+		this.locker.Lock()
+		delete(this.drainingListenerMap, listener)
+		this.locker.Unlock()
+
+		if wg != nil {
+			wg.Done()
+		}
+	})
+}
+
+// CountFailedListeners 获取监听失败的端口数量
+func (this *ListenerManager) CountFailedListeners() int {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	return len(this.retryListenerMap)
+}
+
+// Files 导出所有正在监听的文件描述符，用于在热升级时传递给新进程
+func (this *ListenerManager) Files() []*ListenerFile {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var result = []*ListenerFile{}
+	for _, listener := range this.listenersMap {
+		result = append(result, listener.Files()...)
+	}
+	return result
+}
+
 // 返回更加友好格式的地址
 func (this *ListenerManager) prettyAddress(addr string) string {
 	u, err := url.Parse(addr)
@@ -191,6 +297,10 @@ func (this *ListenerManager) retryListeners() {
 	this.locker.Lock()
 	defer this.locker.Unlock()
 
+	if this.isShuttingDown {
+		return
+	}
+
 	for addr, listener := range this.retryListenerMap {
 		err := listener.Listen()
 		if err == nil {
@@ -1,34 +1,46 @@
 package nodes
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/shared"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
 	"github.com/TeaOSLab/EdgeNode/internal/goman"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
 	"github.com/TeaOSLab/EdgeNode/internal/stats"
 	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	connutils "github.com/TeaOSLab/EdgeNode/internal/utils/conns"
 	"github.com/iwind/TeaGo/types"
 	"github.com/pires/go-proxyproto"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// 读取TLS ClientHello的超时时间
+const tlsClientHelloTimeout = 10 * time.Second
+
+// TLS透传配置，从 configs/local.yaml 中加载
+var sharedTLSPassthroughConfig = &configs.TLSPassthroughConfig{}
+
 type TCPListener struct {
 	BaseListener
 
 	Listener net.Listener
 
 	port int
+
+	connMap    map[net.Conn]bool // 正在处理的连接
+	connLocker sync.Mutex
 }
 
 func (this *TCPListener) Serve() error {
+	// TLS连接在handleConn()中根据ClientHello决定是终止TLS还是透传
 	var listener = this.Listener
-	if this.Group.IsTLS() {
-		listener = tls.NewListener(listener, this.buildTLSConfig())
-	}
 
 	// 获取分组端口
 	var groupAddr = this.Group.Addr()
@@ -45,12 +57,14 @@ func (this *TCPListener) Serve() error {
 		}
 
 		atomic.AddInt64(&this.countActiveConnections, 1)
+		this.addConn(conn)
 
 		go func(conn net.Conn) {
 			err = this.handleConn(conn)
 			if err != nil {
 				remotelogs.Error("TCP_LISTENER", err.Error())
 			}
+			this.removeConn(conn)
 			atomic.AddInt64(&this.countActiveConnections, -1)
 		}(conn)
 	}
@@ -63,48 +77,68 @@ func (this *TCPListener) Reload(group *serverconfigs.ServerAddressGroup) {
 	this.Reset()
 }
 
-func (this *TCPListener) handleConn(conn net.Conn) error {
-	var server = this.Group.FirstServer()
-	if server == nil {
-		return errors.New("no server available")
+func (this *TCPListener) handleConn(rawConn net.Conn) error {
+	var conn = rawConn
+	var server *serverconfigs.ServerConfig
+	var serverName = ""
+	var isPassthrough = false
+
+	if this.Group.IsTLS() {
+		// 读取ClientHello，根据SNI和ALPN选择服务
+		hello, peekedConn, err := connutils.PeekClientHello(rawConn, tlsClientHelloTimeout)
+		if err != nil {
+			// 不是TLS连接或者读取超时
+			_ = rawConn.Close()
+			remotelogs.Debug("TCP_LISTENER", "read tls client hello from '"+rawConn.RemoteAddr().String()+"' failed: "+err.Error())
+			return nil
+		}
+		serverName = hello.ServerName
+
+		var passthroughServerId int64
+		server, passthroughServerId = this.findTLSServer(serverName, hello.ALPN)
+		if passthroughServerId > 0 {
+			if server == nil {
+				_ = rawConn.Close()
+				return errors.New("tls passthrough server '" + types.String(passthroughServerId) + "' not found on '" + this.Group.Addr() + "'")
+			}
+			isPassthrough = true
+			conn = peekedConn
+		} else {
+			if server == nil {
+				_ = rawConn.Close()
+				return errors.New("no server found for tls server name '" + serverName + "' (alpn: " + strings.Join(hello.ALPN, ", ") + ")")
+			}
+			conn = tls.Server(peekedConn, this.buildTLSConfig())
+		}
+	} else {
+		server = this.Group.FirstServer()
+		if server == nil {
+			return errors.New("no server available")
+		}
 	}
 	if server.ReverseProxy == nil {
+		_ = rawConn.Close()
 		return errors.New("no ReverseProxy configured for the server")
 	}
 
 	// 绑定连接和服务
-	clientConn, ok := conn.(ClientConnInterface)
+	clientConn, ok := rawConn.(ClientConnInterface)
 	if ok {
 		var goNext = clientConn.SetServerId(server.Id)
 		if !goNext {
 			return nil
 		}
 		clientConn.SetUserId(server.UserId)
-	} else {
-		tlsConn, ok := conn.(*tls.Conn)
-		if ok {
-			var internalConn = tlsConn.NetConn()
-			if internalConn != nil {
-				clientConn, ok = internalConn.(ClientConnInterface)
-				if ok {
-					var goNext = clientConn.SetServerId(server.Id)
-					if !goNext {
-						return nil
-					}
-					clientConn.SetUserId(server.UserId)
-				}
-			}
-		}
 	}
 
 	// 是否已达到流量限制
-	if this.reachedTrafficLimit() || (server.UserId > 0 && !SharedUserManager.CheckUserServersIsEnabled(server.UserId)) {
+	if this.reachedTrafficLimit(server) || (server.UserId > 0 && !SharedUserManager.CheckUserServersIsEnabled(server.UserId)) {
 		// 关闭连接
-		tcpConn, ok := conn.(LingerConn)
+		tcpConn, ok := rawConn.(LingerConn)
 		if ok {
 			_ = tcpConn.SetLinger(0)
 		}
-		_ = conn.Close()
+		_ = rawConn.Close()
 
 		// TODO 使用系统防火墙drop当前端口的数据包一段时间（1分钟）
 		// 不能使用阻止IP的方法，因为边缘节点只上有可能还有别的代理服务
@@ -113,24 +147,9 @@ func (this *TCPListener) handleConn(conn net.Conn) error {
 	}
 
 	// 记录域名排行
-	tlsConn, ok := conn.(*tls.Conn)
-	var recordStat = false
-	var serverName = ""
-	if ok {
-		serverName = tlsConn.ConnectionState().ServerName
-		if len(serverName) > 0 {
-			// 统计
-			stats.SharedTrafficStatManager.Add(server.UserId, server.Id, serverName, 0, 0, 1, 0, 0, 0, server.ShouldCheckTrafficLimit(), server.PlanId())
-			recordStat = true
-		}
-	}
-
-	// 统计
-	if !recordStat {
-		stats.SharedTrafficStatManager.Add(server.UserId, server.Id, "", 0, 0, 1, 0, 0, 0, server.ShouldCheckTrafficLimit(), server.PlanId())
-	}
+	stats.SharedTrafficStatManager.Add(server.UserId, server.Id, serverName, 0, 0, 1, 0, 0, 0, server.ShouldCheckTrafficLimit(), server.PlanId())
 
-	originConn, err := this.connectOrigin(server.Id, serverName, server.ReverseProxy, conn.RemoteAddr().String())
+	originConn, err := this.connectOrigin(server.Id, serverName, server.ReverseProxy, conn.RemoteAddr().String(), isPassthrough)
 	if err != nil {
 		_ = conn.Close()
 		return err
@@ -199,7 +218,7 @@ func (this *TCPListener) handleConn(conn net.Conn) error {
 	}()
 	for {
 		// 是否已达到流量限制
-		if this.reachedTrafficLimit() {
+		if this.reachedTrafficLimit(server) {
 			closer()
 			return nil
 		}
@@ -226,8 +245,42 @@ func (this *TCPListener) Close() error {
 	return this.Listener.Close()
 }
 
+// Shutdown 优雅关闭：不再接收新的连接，等待已有的连接转发完成
+func (this *TCPListener) Shutdown(ctx context.Context) error {
+	_ = this.Listener.Close()
+
+	err := this.waitForConnections(ctx, this.CountActiveConnections)
+	if err != nil {
+		this.connLocker.Lock()
+		var conns = this.connMap
+		this.connMap = nil
+		this.connLocker.Unlock()
+
+		for conn := range conns {
+			_ = conn.Close()
+		}
+	}
+	return err
+}
+
+func (this *TCPListener) addConn(conn net.Conn) {
+	this.connLocker.Lock()
+	if this.connMap == nil {
+		this.connMap = map[net.Conn]bool{}
+	}
+	this.connMap[conn] = true
+	this.connLocker.Unlock()
+}
+
+func (this *TCPListener) removeConn(conn net.Conn) {
+	this.connLocker.Lock()
+	delete(this.connMap, conn)
+	this.connLocker.Unlock()
+}
+
 // 连接源站
-func (this *TCPListener) connectOrigin(serverId int64, requestHost string, reverseProxy *serverconfigs.ReverseProxyConfig, remoteAddr string) (conn net.Conn, err error) {
+// isPassthrough 为true时，只建立TCP连接，将客户端的TLS数据原样转发给源站
+func (this *TCPListener) connectOrigin(serverId int64, requestHost string, reverseProxy *serverconfigs.ReverseProxyConfig, remoteAddr string, isPassthrough bool) (conn net.Conn, err error) {
 	if reverseProxy == nil {
 		return nil, errors.New("no reverse proxy config")
 	}
@@ -259,7 +312,11 @@ func (this *TCPListener) connectOrigin(serverId int64, requestHost string, rever
 			requestHost = reverseProxy.RequestHost
 		}
 
-		conn, addr, err = OriginConnect(origin, this.port, remoteAddr, requestHost)
+		if isPassthrough {
+			conn, addr, err = OriginConnectRaw(origin, this.port, remoteAddr)
+		} else {
+			conn, addr, err = OriginConnect(origin, this.port, remoteAddr, requestHost)
+		}
 		if err != nil {
 			failedOriginIds = append(failedOriginIds, origin.Id)
 
@@ -287,9 +344,41 @@ func (this *TCPListener) connectOrigin(serverId int64, requestHost string, rever
 	return
 }
 
+// 根据ID查找当前端口上的服务
+// 根据SNI和ALPN查找服务
+// 匹配透传规则时返回规则中的网站ID，此时只能使用规则中的网站；其余连接没有匹配的域名时和以前一样使用第一个服务
+func (this *TCPListener) findTLSServer(serverName string, alpn []string) (server *serverconfigs.ServerConfig, passthroughServerId int64) {
+	if len(serverName) > 0 {
+		server, _ = this.findNamedServer(serverName)
+	}
+
+	// 只有匹配透传规则的连接才透传TLS数据，由源站使用自己的证书完成握手
+	var matchedServerId int64
+	if server != nil {
+		matchedServerId = server.Id
+	}
+	var passthroughRule = sharedTLSPassthroughConfig.Match(serverName, alpn, matchedServerId)
+	if passthroughRule != nil {
+		return this.findServerWithId(passthroughRule.ServerId), passthroughRule.ServerId
+	}
+
+	if server == nil {
+		server = this.Group.FirstServer()
+	}
+	return server, 0
+}
+
+func (this *TCPListener) findServerWithId(serverId int64) *serverconfigs.ServerConfig {
+	for _, server := range this.Group.Servers() {
+		if server.Id == serverId {
+			return server
+		}
+	}
+	return nil
+}
+
 // 检查是否已经达到流量限制
-func (this *TCPListener) reachedTrafficLimit() bool {
-	var server = this.Group.FirstServer()
+func (this *TCPListener) reachedTrafficLimit(server *serverconfigs.ServerConfig) bool {
 	if server == nil {
 		return true
 	}
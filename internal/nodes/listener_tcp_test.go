@@ -0,0 +1,82 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/nodeconfigs"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"testing"
+)
+
+func TestTCPListener_FindTLSServer(t *testing.T) {
+	var oldNodeConfig = sharedNodeConfig
+	var oldPassthroughConfig = sharedTLSPassthroughConfig
+	defer func() {
+		sharedNodeConfig = oldNodeConfig
+		sharedTLSPassthroughConfig = oldPassthroughConfig
+	}()
+
+	// 严格匹配域名
+	var globalServerConfig = serverconfigs.DefaultGlobalServerConfig()
+	globalServerConfig.HTTPAll.MatchDomainStrictly = true
+	sharedNodeConfig = &nodeconfigs.NodeConfig{GlobalServerConfig: globalServerConfig}
+
+	sharedTLSPassthroughConfig = parseTestLocalConfig(t, `
+tlsPassthrough:
+  rules:
+    - serverId: 2
+      alpn: [ "acme-tls/1" ]
+    - serverId: 3
+      serverNames: [ "*.example.org" ]
+`).TLSPassthrough
+
+	var listener = &TCPListener{}
+	listener.Group = serverconfigs.NewServerAddressGroup("tls://:443")
+	for _, server := range []*serverconfigs.ServerConfig{
+		{Id: 1, IsOn: true, ServerNames: []*serverconfigs.ServerNameConfig{{Name: "a.example.com"}}},
+		{Id: 2, IsOn: true, ServerNames: []*serverconfigs.ServerNameConfig{{Name: "b.example.com"}}},
+	} {
+		err := server.Init(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener.Group.Add(server)
+	}
+
+	for _, testCase := range []struct {
+		serverName          string
+		alpn                []string
+		expectServerId      int64
+		expectPassthroughId int64
+	}{
+		{"a.example.com", []string{"h2"}, 1, 0},
+		{"b.example.com", []string{"h2"}, 2, 0},
+		{"b.example.com", []string{"acme-tls/1"}, 2, 2},
+		{"a.example.com", []string{"acme-tls/1"}, 1, 0},
+		{"", nil, 1, 0},
+		{"unknown.example.com", nil, 1, 0}, // 没有匹配的域名时使用第一个服务
+		{"a.example.org", nil, 0, 3},       // 透传的网站不在当前端口上
+	} {
+		server, passthroughServerId := listener.findTLSServer(testCase.serverName, testCase.alpn)
+		var serverId int64
+		if server != nil {
+			serverId = server.Id
+		}
+		if serverId != testCase.expectServerId || passthroughServerId != testCase.expectPassthroughId {
+			t.Fatal(testCase.serverName, testCase.alpn, "unexpected result:", serverId, passthroughServerId)
+		}
+	}
+}
+
+func TestTCPListener_InvalidTLSPassthroughRules(t *testing.T) {
+	for _, data := range []string{
+		"tlsPassthrough:\n  rules:\n    - serverNames: [ \"a.example.com\" ]\n",
+		"tlsPassthrough:\n  rules:\n    - serverId: 1\n      serverNames: [ \"a.*.com\" ]\n",
+	} {
+		_, err := configs.ParseLocalConfig([]byte(data))
+		if err == nil {
+			t.Fatal("expect error:", data)
+		}
+	}
+}
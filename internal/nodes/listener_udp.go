@@ -1,8 +1,10 @@
 package nodes
 
 import (
+	"context"
 	"errors"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
 	"github.com/TeaOSLab/EdgeNode/internal/goman"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
 	"github.com/TeaOSLab/EdgeNode/internal/stats"
@@ -14,6 +16,7 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,6 +24,9 @@ const (
 	UDPConnLifeSeconds = 30
 )
 
+// UDP代理策略，从 configs/local.yaml 中加载
+var sharedUDPProxyConfig = &configs.UDPProxyConfig{}
+
 type UDPPacketListener interface {
 	ReadFrom(b []byte) (n int, cm any, src net.Addr, err error)
 	WriteTo(b []byte, cm any, dst net.Addr) (n int, err error)
@@ -78,10 +84,18 @@ type UDPListener struct {
 	connTicker *utils.Ticker
 
 	reverseProxy *serverconfigs.ReverseProxyConfig
+	policy       *configs.UDPProxyPolicy
+	affinity     *udpAffinity
+	policyLocker sync.RWMutex // 保护reverseProxy、policy和affinity，Reload()时会替换
+
+	healthCheck     *configs.UDPHealthCheckConfig
+	healthCheckDone chan struct{} // 用来停止当前的健康检查
 
-	port int
+	port     int
+	lastGCAt int64
 
-	isClosed bool
+	isClosed   bool
+	isDraining bool
 }
 
 func (this *UDPListener) Serve() error {
@@ -93,6 +107,7 @@ func (this *UDPListener) Serve() error {
 		return nil
 	}
 	var serverId = server.Id
+	this.applyPolicy(server)
 
 	var wg = &sync.WaitGroup{}
 	wg.Add(2) // 2 = ipv4 + ipv6
@@ -151,13 +166,12 @@ func (this *UDPListener) servePacketListener(listener UDPPacketListener) error {
 	if firstServer == nil {
 		return errors.New("no server available")
 	}
-	this.reverseProxy = firstServer.ReverseProxy
-	if this.reverseProxy == nil {
+	if firstServer.ReverseProxy == nil {
 		return errors.New("no ReverseProxy configured for the server '" + firstServer.Name + "'")
 	}
 
 	this.connMap = map[string]*UDPConn{}
-	this.connTicker = utils.NewTicker(1 * time.Minute)
+	this.connTicker = utils.NewTicker(10 * time.Second)
 	goman.New(func() {
 		for this.connTicker.Next() {
 			this.gcConns()
@@ -177,22 +191,49 @@ func (this *UDPListener) servePacketListener(listener UDPPacketListener) error {
 
 		n, cm, clientAddr, err := listener.ReadFrom(buffer)
 		if err != nil {
-			if this.isClosed {
+			if this.isClosed || this.isDraining {
 				return nil
 			}
 			return err
 		}
 
 		if n > 0 {
+			reverseProxy, policy, _ := this.currentPolicy()
 			this.connLocker.Lock()
 			conn, ok := this.connMap[clientAddr.String()]
+			var countConns = len(this.connMap)
 			this.connLocker.Unlock()
+
+			// 源站长时间没有响应时，换一个源站重新建立会话
+			var excludedOriginIds []int64
+			if ok && conn.IsTimeout() {
+				this.failOrigin(firstServer.Id, conn)
+				excludedOriginIds = append(excludedOriginIds, conn.OriginId())
+			}
 			if ok && !conn.IsOk() {
 				_ = conn.Close()
+				this.connLocker.Lock()
+				delete(this.connMap, clientAddr.String())
+				this.connLocker.Unlock()
 				ok = false
 			}
 			if !ok {
-				originConn, err := this.connectOrigin(firstServer.Id, this.reverseProxy, listener.LocalAddr(), clientAddr)
+				// 检查会话数限制
+				if policy.MaxSessions > 0 && countConns >= policy.MaxSessions {
+					var now = time.Now().Unix()
+					if this.lastGCAt < now {
+						this.lastGCAt = now
+						this.gcConns()
+					}
+					this.connLocker.Lock()
+					countConns = len(this.connMap)
+					this.connLocker.Unlock()
+					if countConns >= policy.MaxSessions {
+						continue
+					}
+				}
+
+				originConn, origin, err := this.connectOrigin(firstServer.Id, reverseProxy, listener.LocalAddr(), clientAddr, excludedOriginIds)
 				if err != nil {
 					remotelogs.Error("UDP_LISTENER", "unable to connect to origin server: "+err.Error())
 					continue
@@ -202,6 +243,8 @@ func (this *UDPListener) servePacketListener(listener UDPPacketListener) error {
 					continue
 				}
 				conn = NewUDPConn(firstServer, clientAddr, listener, cm, originConn.(*net.UDPConn))
+				conn.SetOrigin(origin, reverseProxy)
+				conn.SetPolicy(policy)
 				this.connLocker.Lock()
 				this.connMap[clientAddr.String()] = conn
 				this.connLocker.Unlock()
@@ -214,6 +257,10 @@ func (this *UDPListener) servePacketListener(listener UDPPacketListener) error {
 func (this *UDPListener) Close() error {
 	this.isClosed = true
 
+	this.policyLocker.Lock()
+	this.stopHealthCheck()
+	this.policyLocker.Unlock()
+
 	if this.connTicker != nil {
 		this.connTicker.Stop()
 	}
@@ -247,6 +294,42 @@ func (this *UDPListener) Close() error {
 	return nil
 }
 
+// Drain 停止接收新的数据包，但保留已有的会话直到超时
+// 用于热升级时将端口交给新进程，同时当前进程仍然可以向客户端回写源站的响应
+func (this *UDPListener) Drain() {
+	this.isDraining = true
+
+	var deadline = time.Now()
+	if this.IPv4Listener != nil {
+		_ = this.IPv4Listener.SetReadDeadline(deadline)
+	}
+	if this.IPv6Listener != nil {
+		_ = this.IPv6Listener.SetReadDeadline(deadline)
+	}
+}
+
+// Shutdown 优雅关闭：不再接收新的数据包，等待已有的会话超时后关闭
+func (this *UDPListener) Shutdown(ctx context.Context) error {
+	this.Drain()
+	var err = this.waitForConnections(ctx, this.CountActiveConnections)
+	_ = this.Close()
+	return err
+}
+
+// CountActiveConnections 获取当前活跃的会话数
+func (this *UDPListener) CountActiveConnections() int {
+	this.connLocker.Lock()
+	defer this.connLocker.Unlock()
+
+	var count = 0
+	for _, conn := range this.connMap {
+		if conn.IsOk() {
+			count++
+		}
+	}
+	return count
+}
+
 func (this *UDPListener) Reload(group *serverconfigs.ServerAddressGroup) {
 	this.Group = group
 	this.Reset()
@@ -256,22 +339,80 @@ func (this *UDPListener) Reload(group *serverconfigs.ServerAddressGroup) {
 	if firstServer == nil {
 		return
 	}
-	this.reverseProxy = firstServer.ReverseProxy
+	this.applyPolicy(firstServer)
+}
+
+// 应用网站对应的UDP代理策略
+func (this *UDPListener) applyPolicy(server *serverconfigs.ServerConfig) {
+	var config = sharedUDPProxyConfig
+	var policy *configs.UDPProxyPolicy
+	if config != nil {
+		policy = config.PolicyForServer(server.Id)
+	}
+	if policy == nil {
+		policy = &configs.UDPProxyPolicy{}
+		_ = policy.Init(nil)
+	}
+
+	var affinity *udpAffinity
+	if policy.AffinityIsOn() && server.ReverseProxy != nil {
+		affinity = newUDPAffinity(server.ReverseProxy)
+	}
+
+	this.policyLocker.Lock()
+	defer this.policyLocker.Unlock()
+
+	this.reverseProxy = server.ReverseProxy
+	this.policy = policy
+	this.affinity = affinity
+
+	// 源站健康检查，配置变化时重新启动
+	if !policy.HealthCheckIsOn() || this.isClosed {
+		this.stopHealthCheck()
+		return
+	}
+	if this.healthCheckDone != nil && *this.healthCheck == *policy.HealthCheck {
+		return
+	}
+	this.stopHealthCheck()
+
+	var serverId = server.Id
+	var healthCheck = policy.HealthCheck
+	var done = make(chan struct{})
+	this.healthCheck = healthCheck
+	this.healthCheckDone = done
+	goman.New(func() {
+		this.startHealthCheck(serverId, healthCheck, done)
+	})
 }
 
-func (this *UDPListener) connectOrigin(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig, localAddr net.Addr, remoteAddr net.Addr) (conn net.Conn, err error) {
+// 获取当前使用的反向代理、策略和会话保持设置
+func (this *UDPListener) currentPolicy() (reverseProxy *serverconfigs.ReverseProxyConfig, policy *configs.UDPProxyPolicy, affinity *udpAffinity) {
+	this.policyLocker.RLock()
+	defer this.policyLocker.RUnlock()
+	return this.reverseProxy, this.policy, this.affinity
+}
+
+func (this *UDPListener) connectOrigin(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig, localAddr net.Addr, remoteAddr net.Addr, excludedOriginIds []int64) (conn net.Conn, origin *serverconfigs.OriginConfig, err error) {
 	if reverseProxy == nil {
-		return nil, errors.New("no reverse proxy config")
+		return nil, nil, errors.New("no reverse proxy config")
 	}
 
 	var retries = 3
 	var addr string
 
-	var failedOriginIds []int64
+	var failedOriginIds = excludedOriginIds
 
 	for i := 0; i < retries; i++ {
-		var origin *serverconfigs.OriginConfig
-		if len(failedOriginIds) > 0 {
+		origin = nil
+
+		// 按客户端IP选择源站
+		_, _, affinity := this.currentPolicy()
+		if affinity != nil {
+			origin = affinity.Pick(remoteAddr, failedOriginIds)
+		}
+
+		if origin == nil && len(failedOriginIds) > 0 {
 			origin = reverseProxy.AnyOrigin(nil, failedOriginIds)
 		}
 		if origin == nil {
@@ -318,7 +459,7 @@ func (this *UDPListener) connectOrigin(serverId int64, reverseProxy *serverconfi
 				_, err = header.WriteTo(conn)
 				if err != nil {
 					_ = conn.Close()
-					return nil, err
+					return nil, nil, err
 				}
 			}
 
@@ -329,21 +470,60 @@ func (this *UDPListener) connectOrigin(serverId int64, reverseProxy *serverconfi
 	if err == nil {
 		err = errors.New("server '" + types.String(serverId) + "': no available origin server can be used")
 	}
-	return
+	return nil, nil, err
+}
+
+// 源站在指定时间内没有响应，记录源站失败
+func (this *UDPListener) failOrigin(serverId int64, conn *UDPConn) {
+	var origin = conn.origin
+	var reverseProxy = conn.reverseProxy
+	if origin == nil || reverseProxy == nil {
+		return
+	}
+
+	// 数据包循环和gcConns()都可能调用，只报告一次
+	if !atomic.CompareAndSwapInt32(&conn.isFailReported, 0, 1) {
+		return
+	}
+	atomic.StoreInt32(&conn.isOk, 0)
+
+	var addr = ""
+	if origin.Addr != nil {
+		addr = origin.Addr.PickAddress()
+	}
+	remotelogs.ServerError(serverId, "UDP_LISTENER", "origin server '"+addr+"' did not respond in "+types.String(conn.responseTimeout)+" seconds", "", nil)
+
+	SharedOriginStateManager.Fail(origin, "", reverseProxy, func() {
+		reverseProxy.ResetScheduling()
+	})
 }
 
 // 回收连接
 func (this *UDPListener) gcConns() {
+	var serverId int64
+	var server = this.Group.FirstServer()
+	if server != nil {
+		serverId = server.Id
+	}
+
 	this.connLocker.Lock()
 	var closingConns = []*UDPConn{}
+	var timeoutConns = []*UDPConn{}
 	for addr, conn := range this.connMap {
-		if !conn.IsOk() {
+		if conn.IsTimeout() {
+			timeoutConns = append(timeoutConns, conn)
+		}
+		if !conn.IsOk() || conn.IsTimeout() {
 			closingConns = append(closingConns, conn)
 			delete(this.connMap, addr)
 		}
 	}
 	this.connLocker.Unlock()
 
+	for _, conn := range timeoutConns {
+		this.failOrigin(serverId, conn)
+	}
+
 	for _, conn := range closingConns {
 		_ = conn.Close()
 	}
@@ -355,8 +535,16 @@ type UDPConn struct {
 	proxyListener UDPPacketListener
 	serverConn    net.Conn
 	activatedAt   int64
-	isOk          bool
+	isOk          int32 // 1表示正常，使用atomic读写
 	isClosed      bool
+
+	origin       *serverconfigs.OriginConfig
+	reverseProxy *serverconfigs.ReverseProxyConfig
+
+	lifeSeconds     int64
+	responseTimeout int64
+	waitingSince    int64 // 开始等待源站响应的时间，收到响应后重置为0，使用atomic读写
+	isFailReported  int32 // 是否已经报告源站失败，使用atomic读写
 }
 
 func NewUDPConn(server *serverconfigs.ServerConfig, addr net.Addr, proxyListener UDPPacketListener, cm any, serverConn *net.UDPConn) *UDPConn {
@@ -365,7 +553,8 @@ func NewUDPConn(server *serverconfigs.ServerConfig, addr net.Addr, proxyListener
 		proxyListener: proxyListener,
 		serverConn:    serverConn,
 		activatedAt:   time.Now().Unix(),
-		isOk:          true,
+		isOk:          1,
+		lifeSeconds:   UDPConnLifeSeconds,
 	}
 
 	// 统计
@@ -391,10 +580,22 @@ func NewUDPConn(server *serverconfigs.ServerConfig, addr net.Addr, proxyListener
 			n, err := serverConn.Read(buffer)
 			if n > 0 {
 				conn.activatedAt = time.Now().Unix()
+				atomic.StoreInt64(&conn.waitingSince, 0)
+
+				// 源站已恢复响应
+				var origin = conn.origin
+				if origin != nil && !origin.IsOk {
+					var reverseProxy = conn.reverseProxy
+					SharedOriginStateManager.Success(origin, func() {
+						if reverseProxy != nil {
+							reverseProxy.ResetScheduling()
+						}
+					})
+				}
 
 				_, writingErr := proxyListener.WriteTo(buffer[:n], cm, addr)
 				if writingErr != nil {
-					conn.isOk = false
+					atomic.StoreInt32(&conn.isOk, 0)
 					break
 				}
 
@@ -408,7 +609,7 @@ func NewUDPConn(server *serverconfigs.ServerConfig, addr net.Addr, proxyListener
 				}
 			}
 			if err != nil {
-				conn.isOk = false
+				atomic.StoreInt32(&conn.isOk, 0)
 				break
 			}
 		}
@@ -416,17 +617,43 @@ func NewUDPConn(server *serverconfigs.ServerConfig, addr net.Addr, proxyListener
 	return conn
 }
 
+// SetOrigin 设置会话对应的源站
+func (this *UDPConn) SetOrigin(origin *serverconfigs.OriginConfig, reverseProxy *serverconfigs.ReverseProxyConfig) {
+	this.origin = origin
+	this.reverseProxy = reverseProxy
+}
+
+// OriginId 会话对应的源站ID
+func (this *UDPConn) OriginId() int64 {
+	if this.origin == nil {
+		return 0
+	}
+	return this.origin.Id
+}
+
+// SetPolicy 设置会话超时时间等选项
+func (this *UDPConn) SetPolicy(policy *configs.UDPProxyPolicy) {
+	if policy == nil {
+		return
+	}
+	if policy.SessionTimeout > 0 {
+		this.lifeSeconds = int64(policy.SessionTimeout)
+	}
+	this.responseTimeout = int64(policy.ResponseTimeout)
+}
+
 func (this *UDPConn) Write(b []byte) (n int, err error) {
 	this.activatedAt = time.Now().Unix()
+	atomic.CompareAndSwapInt64(&this.waitingSince, 0, this.activatedAt)
 	n, err = this.serverConn.Write(b)
 	if err != nil {
-		this.isOk = false
+		atomic.StoreInt32(&this.isOk, 0)
 	}
 	return
 }
 
 func (this *UDPConn) Close() error {
-	this.isOk = false
+	atomic.StoreInt32(&this.isOk, 0)
 	if this.isClosed {
 		return nil
 	}
@@ -435,8 +662,17 @@ func (this *UDPConn) Close() error {
 }
 
 func (this *UDPConn) IsOk() bool {
-	if !this.isOk {
+	if atomic.LoadInt32(&this.isOk) != 1 {
+		return false
+	}
+	return time.Now().Unix()-this.activatedAt < this.lifeSeconds // 如果超过 N 秒没有活动我们认为是超时
+}
+
+// IsTimeout 检查源站是否在指定时间内没有响应
+func (this *UDPConn) IsTimeout() bool {
+	if this.responseTimeout <= 0 || this.isClosed {
 		return false
 	}
-	return time.Now().Unix()-this.activatedAt < UDPConnLifeSeconds // 如果超过 N 秒没有活动我们认为是超时
+	var waitingSince = atomic.LoadInt64(&this.waitingSince)
+	return waitingSince > 0 && time.Now().Unix()-waitingSince >= this.responseTimeout
 }
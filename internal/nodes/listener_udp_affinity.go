@@ -0,0 +1,65 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/hashring"
+	"net"
+)
+
+// 按客户端IP选择UDP源站
+// 使用一致性Hash，同一个客户端的会话过期后仍然会连接到同一个源站，某个源站不可用时只有这个源站上的客户端会被迁移
+type udpAffinity struct {
+	primaryRing *hashring.Ring
+	backupRing  *hashring.Ring
+	originMap   map[int64]*serverconfigs.OriginConfig
+}
+
+func newUDPAffinity(reverseProxy *serverconfigs.ReverseProxyConfig) *udpAffinity {
+	var affinity = &udpAffinity{
+		primaryRing: hashring.NewRing(0),
+		backupRing:  hashring.NewRing(0),
+		originMap:   map[int64]*serverconfigs.OriginConfig{},
+	}
+	for _, origin := range reverseProxy.PrimaryOrigins {
+		if origin.IsOn {
+			affinity.primaryRing.Add(origin.Id)
+			affinity.originMap[origin.Id] = origin
+		}
+	}
+	for _, origin := range reverseProxy.BackupOrigins {
+		if origin.IsOn {
+			affinity.backupRing.Add(origin.Id)
+			affinity.originMap[origin.Id] = origin
+		}
+	}
+	return affinity
+}
+
+// Pick 选择客户端对应的源站，主源站都不可用时使用备用源站
+func (this *udpAffinity) Pick(clientAddr net.Addr, excludedOriginIds []int64) *serverconfigs.OriginConfig {
+	var clientIP = clientAddr.String()
+	host, _, err := net.SplitHostPort(clientIP)
+	if err == nil {
+		clientIP = host
+	}
+
+	var filter = func(originId int64) bool {
+		for _, excludedId := range excludedOriginIds {
+			if excludedId == originId {
+				return false
+			}
+		}
+		var origin = this.originMap[originId]
+		return origin != nil && origin.IsOn && origin.IsOk
+	}
+
+	for _, ring := range []*hashring.Ring{this.primaryRing, this.backupRing} {
+		originId, ok := ring.Get(clientIP, filter)
+		if ok {
+			return this.originMap[originId]
+		}
+	}
+	return nil
+}
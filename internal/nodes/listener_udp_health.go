@@ -0,0 +1,117 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/configutils"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/resolvers"
+	"github.com/iwind/TeaGo/types"
+	"sync"
+	"time"
+)
+
+// 定时检查UDP源站是否可用
+// UDP无法通过连接是否成功判断源站状态，所以对DNS源站直接发送DNS查询
+// 关闭 done 后停止检查
+func (this *UDPListener) startHealthCheck(serverId int64, healthCheck *configs.UDPHealthCheckConfig, done <-chan struct{}) {
+	var ticker = time.NewTicker(time.Duration(healthCheck.Interval) * time.Second)
+	defer ticker.Stop()
+
+	var failsMap = map[int64]int{} // originId => 连续失败次数
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			this.checkOrigins(serverId, healthCheck, failsMap)
+		}
+	}
+}
+
+// 停止当前的健康检查，调用者需要持有 policyLocker
+func (this *UDPListener) stopHealthCheck() {
+	if this.healthCheckDone != nil {
+		close(this.healthCheckDone)
+		this.healthCheckDone = nil
+		this.healthCheck = nil
+	}
+}
+
+// 检查所有源站
+func (this *UDPListener) checkOrigins(serverId int64, healthCheck *configs.UDPHealthCheckConfig, failsMap map[int64]int) {
+	reverseProxy, _, _ := this.currentPolicy()
+	if reverseProxy == nil {
+		return
+	}
+
+	var origins = []*serverconfigs.OriginConfig{}
+	for _, origin := range append(append([]*serverconfigs.OriginConfig{}, reverseProxy.PrimaryOrigins...), reverseProxy.BackupOrigins...) {
+		if origin.IsOn && origin.Addr != nil {
+			origins = append(origins, origin)
+		}
+	}
+
+	var timeout = time.Duration(healthCheck.TimeoutMs) * time.Millisecond
+	var locker = sync.Mutex{}
+	var wg = &sync.WaitGroup{}
+	wg.Add(len(origins))
+	for _, origin := range origins {
+		go func(origin *serverconfigs.OriginConfig) {
+			defer wg.Done()
+
+			var originAddr = origin.Addr.PickAddress()
+			if origin.FollowPort && this.port > 0 {
+				originAddr = configutils.QuoteIP(origin.Addr.Host) + ":" + types.String(this.port)
+			}
+			originAddr, dialAddrs, err := resolveOriginAddr(originAddr)
+			if err == nil {
+				// 任一地址可用即认为源站可用
+				for _, dialAddr := range dialAddrs {
+					err = resolvers.ProbeDNS(dialAddr, healthCheck.Domain, healthCheck.QueryType, timeout)
+					if err == nil {
+						break
+					}
+				}
+			}
+
+			locker.Lock()
+			defer locker.Unlock()
+
+			if err != nil {
+				failsMap[origin.Id]++
+				if failsMap[origin.Id] >= healthCheck.MaxFails && origin.IsOk {
+					remotelogs.ServerError(serverId, "UDP_LISTENER", "origin server '"+originAddr+"' health check failed: "+err.Error(), "", nil)
+					origin.IsOk = false
+					reverseProxy.ResetScheduling()
+				}
+				return
+			}
+
+			delete(failsMap, origin.Id)
+			if !origin.IsOk {
+				remotelogs.Println("UDP_LISTENER", "origin server '"+originAddr+"' is available again")
+				SharedOriginStateManager.Success(origin, func() {
+					reverseProxy.ResetScheduling()
+				})
+			}
+		}(origin)
+	}
+	wg.Wait()
+
+	// 清除已经删除的源站
+	for originId := range failsMap {
+		var found = false
+		for _, origin := range origins {
+			if origin.Id == originId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			delete(failsMap, originId)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"sync"
+	"testing"
+)
+
+func TestUDPListener_ApplyPolicy(t *testing.T) {
+	var oldConfig = sharedUDPProxyConfig
+	defer func() {
+		sharedUDPProxyConfig = oldConfig
+	}()
+
+	var server = &serverconfigs.ServerConfig{
+		Id:           100,
+		ReverseProxy: &serverconfigs.ReverseProxyConfig{},
+	}
+	var listener = &UDPListener{}
+
+	sharedUDPProxyConfig = parseTestLocalConfig(t, `
+udp:
+  default:
+    affinity: clientIP
+    sessionTimeout: 60
+  servers:
+    100:
+      maxSessions: 1000
+      healthCheck:
+        isOn: true
+        interval: 30
+`).UDP
+	listener.applyPolicy(server)
+
+	reverseProxy, policy, affinity := listener.currentPolicy()
+	if reverseProxy != server.ReverseProxy || policy.MaxSessions != 1000 || policy.SessionTimeout != 60 || affinity == nil {
+		t.Fatalf("unexpected policy: %+v", policy)
+	}
+	var done = listener.healthCheckDone
+	if done == nil {
+		t.Fatal("health check should be started")
+	}
+
+	// 健康检查配置没有变化时不重启
+	sharedUDPProxyConfig = parseTestLocalConfig(t, `
+udp:
+  servers:
+    100:
+      maxSessions: 2000
+      healthCheck:
+        isOn: true
+        interval: 30
+`).UDP
+	listener.applyPolicy(server)
+	if listener.healthCheckDone != done {
+		t.Fatal("health check should not be restarted")
+	}
+	_, policy, affinity = listener.currentPolicy()
+	if policy.MaxSessions != 2000 || affinity != nil {
+		t.Fatalf("unexpected policy: %+v", policy)
+	}
+
+	// 修改检查间隔
+	sharedUDPProxyConfig = parseTestLocalConfig(t, `
+udp:
+  servers:
+    100:
+      healthCheck:
+        isOn: true
+        interval: 5
+`).UDP
+	listener.applyPolicy(server)
+	assertUDPHealthCheckStopped(t, done)
+	if listener.healthCheckDone == nil || listener.healthCheck.Interval != 5 {
+		t.Fatal("health check should be restarted with new interval")
+	}
+	done = listener.healthCheckDone
+
+	// 关闭健康检查
+	sharedUDPProxyConfig = parseTestLocalConfig(t, "").UDP
+	listener.applyPolicy(server)
+	assertUDPHealthCheckStopped(t, done)
+	if listener.healthCheckDone != nil {
+		t.Fatal("health check should be stopped")
+	}
+}
+
+func TestUDPListener_ApplyPolicy_Concurrent(t *testing.T) {
+	var server = &serverconfigs.ServerConfig{
+		Id:           1,
+		ReverseProxy: &serverconfigs.ReverseProxyConfig{},
+	}
+	var listener = &UDPListener{}
+	listener.applyPolicy(server)
+
+	// 使用 -race 运行时检查Reload()和读取策略之间的数据竞争
+	var wg = &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			listener.applyPolicy(server)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			reverseProxy, policy, _ := listener.currentPolicy()
+			if reverseProxy == nil || policy == nil {
+				t.Error("policy should not be nil")
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func assertUDPHealthCheckStopped(t *testing.T, done chan struct{}) {
+	select {
+	case <-done:
+	default:
+		t.Fatal("previous health check should be stopped")
+	}
+}
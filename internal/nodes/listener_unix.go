@@ -1,6 +1,7 @@
 package nodes
 
 import (
+	"context"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
 	"net"
 )
@@ -22,6 +23,10 @@ func (this *UnixListener) Close() error {
 	return nil
 }
 
+func (this *UnixListener) Shutdown(ctx context.Context) error {
+	return this.Close()
+}
+
 func (this *UnixListener) Reload(group *serverconfigs.ServerAddressGroup) {
 	this.Group = group
 	this.Reset()
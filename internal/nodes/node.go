@@ -14,6 +14,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/configs"
 	"github.com/TeaOSLab/EdgeNode/internal/conns"
 	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/counters"
 	"github.com/TeaOSLab/EdgeNode/internal/events"
 	"github.com/TeaOSLab/EdgeNode/internal/firewalls"
 	"github.com/TeaOSLab/EdgeNode/internal/goman"
@@ -78,6 +79,15 @@ type Node struct {
 
 	lastTaskVersion          int64
 	lastUpdatingServerListId int64
+
+	lastConfigVersion int64                       // 上次同步的节点配置版本
+	configVersions    *configs.NodeConfigVersions // 上次同步的节点配置中各个对象的版本
+	lastConfigJSON    []byte                      // 首次加载的配置，在端口启动后检查
+
+	standaloneConfigFile string // 独立运行模式配置文件
+
+	localConfig       *configs.LocalConfig // 节点本地配置
+	localConfigLocker sync.Mutex
 }
 
 func NewNode() *Node {
@@ -85,6 +95,7 @@ func NewNode() *Node {
 		sock:              gosock.NewTmpSock(teaconst.ProcessName),
 		oldMaxThreads:     -1,
 		oldMaxCPU:         -1,
+		lastConfigVersion: -1,
 		updatingServerMap: map[int64]*serverconfigs.ServerConfig{},
 	}
 	return nodeInstance
@@ -92,6 +103,15 @@ func NewNode() *Node {
 
 // Test 检查配置
 func (this *Node) Test() error {
+	// 独立运行模式下检查本地配置文件
+	if this.checkStandalone() {
+		err := this.testStandaloneConfig()
+		if err != nil {
+			return errors.New("test standalone config failed: " + err.Error())
+		}
+		return nil
+	}
+
 	// 检查是否能连接API
 	rpcClient, err := rpc.SharedRPC()
 	if err != nil {
@@ -121,6 +141,11 @@ func (this *Node) Start() {
 	// 处理异常
 	this.handlePanic()
 
+	// 检查是否为独立运行模式
+	if this.checkStandalone() {
+		remotelogs.Println("NODE", "start in standalone mode")
+	}
+
 	// 监听signal
 	this.listenSignals()
 
@@ -131,6 +156,9 @@ func (this *Node) Start() {
 		return
 	}
 
+	// 检查升级后是否需要回滚
+	sharedUpgradeManager.CheckStart()
+
 	// 启动IP库
 	remotelogs.Println("NODE", "initializing ip library ...")
 	err = iplib.InitDefault()
@@ -144,6 +172,15 @@ func (this *Node) Start() {
 	// 检查硬盘类型
 	this.checkDisk()
 
+	// 加载节点本地配置
+	err = this.loadLocalConfig()
+	if err != nil {
+		remotelogs.Error("NODE", "load local config failed: "+err.Error())
+	}
+	events.OnKey(events.EventTerminated, "counter", func() {
+		_ = counters.SharedCounter().Close()
+	})
+
 	// 启动事件
 	events.Notify(events.EventStart)
 
@@ -176,18 +213,26 @@ func (this *Node) Start() {
 		}
 	}
 
-	// 启动同步计时器
-	this.startSyncTimer()
+	if teaconst.IsStandalone {
+		// 监听配置文件变化
+		err = this.watchStandaloneConfig()
+		if err != nil {
+			remotelogs.Error("NODE", "watch standalone config failed: "+err.Error())
+		}
+	} else {
+		// 启动同步计时器
+		this.startSyncTimer()
 
-	// 更新IP库
-	goman.New(func() {
-		iplib.NewUpdater(NewIPLibraryUpdater(), 10*time.Minute).Start()
-	})
+		// 更新IP库
+		goman.New(func() {
+			iplib.NewUpdater(NewIPLibraryUpdater(), 10*time.Minute).Start()
+		})
 
-	// 监控节点运行状态
-	goman.New(func() {
-		NewNodeStatusExecutor().Listen()
-	})
+		// 监控节点运行状态
+		goman.New(func() {
+			NewNodeStatusExecutor().Listen()
+		})
+	}
 
 	// 读取配置
 	nodeConfig, err := nodeconfigs.SharedNodeConfig()
@@ -217,9 +262,11 @@ func (this *Node) Start() {
 	_ = utils.SetRLimit(1024 * 1024)
 
 	// 连接API
-	goman.New(func() {
-		NewAPIStream().Start()
-	})
+	if !teaconst.IsStandalone {
+		goman.New(func() {
+			NewAPIStream().Start()
+		})
+	}
 
 	// 统计
 	goman.New(func() {
@@ -236,6 +283,18 @@ func (this *Node) Start() {
 		return
 	}
 
+	// 通知上一个进程端口已经接管
+	sharedListenerInheritance.NotifyReady()
+
+	// 检查升级后的端口监听
+	sharedUpgradeManager.WatchProbation()
+
+	// 检查首次加载的配置
+	if len(this.lastConfigJSON) > 0 {
+		this.watchConfigHealth(nodeConfig.Version, this.lastConfigJSON, 0)
+		this.lastConfigJSON = nil
+	}
+
 	// hold住进程
 	select {}
 }
@@ -315,6 +374,11 @@ func (this *Node) syncConfig(taskVersion int64) error {
 	this.locker.Lock()
 	defer this.locker.Unlock()
 
+	// 独立运行模式
+	if teaconst.IsStandalone {
+		return this.syncStandaloneConfig()
+	}
+
 	// 检查api.yaml是否存在
 	apiConfigFile := Tea.ConfigFile("api.yaml")
 	_, err := os.Stat(apiConfigFile)
@@ -338,9 +402,9 @@ func (this *Node) syncConfig(taskVersion int64) error {
 	}
 
 	// 获取同步任务
-	// TODO 这里考虑只同步版本号有变更的
+	// 只有版本号有变更时才会返回新的配置
 	configResp, err := rpcClient.NodeRPC.FindCurrentNodeConfig(rpcClient.Context(), &pb.FindCurrentNodeConfigRequest{
-		Version:         -1, // 更新所有版本
+		Version:         this.lastConfigVersion,
 		Compress:        true,
 		NodeTaskVersion: taskVersion,
 		UseDataMap:      true,
@@ -388,10 +452,32 @@ func (this *Node) syncConfig(taskVersion int64) error {
 		}
 	}
 
-	// 写入到文件中
-	err = nodeConfig.Save()
+	// 分析有变化的服务和策略，尽可能只更新有变化的部分
+	newConfigVersions, err := configs.ParseNodeConfigVersions(configJSON)
 	if err != nil {
-		return err
+		remotelogs.Warn("NODE", "parse config versions failed: "+err.Error())
+		newConfigVersions = nil
+	}
+	if this.isLoaded && this.configVersions != nil && newConfigVersions != nil {
+		var delta = this.configVersions.Diff(newConfigVersions)
+		if !delta.IsFull {
+			var failedListeners = sharedListenerManager.CountFailedListeners()
+			err = this.applyConfigDelta(nodeConfig, delta)
+			if err == nil {
+				// 应用成功后再写入到文件中
+				err = nodeConfig.Save()
+				if err != nil {
+					remotelogs.Error("NODE", "save config failed: "+err.Error())
+				}
+				this.configVersions = newConfigVersions
+				this.lastConfigVersion = nodeConfig.Version
+				if !delta.IsEmpty() {
+					this.watchConfigHealth(nodeConfig.Version, configJSON, failedListeners)
+				}
+				return nil
+			}
+			remotelogs.Warn("NODE", "apply config changes failed, fallback to full reload: "+err.Error())
+		}
 	}
 
 	err, serverErrors := nodeConfig.Init(nil)
@@ -404,6 +490,12 @@ func (this *Node) syncConfig(taskVersion int64) error {
 		}
 	}
 
+	// 初始化成功后再写入到文件中
+	err = nodeConfig.Save()
+	if err != nil {
+		return err
+	}
+
 	// 刷新配置
 	if this.isLoaded {
 		remotelogs.Println("NODE", "reloading node config ...")
@@ -416,11 +508,18 @@ func (this *Node) syncConfig(taskVersion int64) error {
 	// 发送事件
 	events.Notify(events.EventReload)
 
+	this.configVersions = newConfigVersions
+	this.lastConfigVersion = nodeConfig.Version
+
 	if this.isLoaded {
-		return sharedListenerManager.Start(nodeConfig)
+		var failedListeners = sharedListenerManager.CountFailedListeners()
+		err = sharedListenerManager.Start(nodeConfig)
+		this.watchConfigHealth(nodeConfig.Version, configJSON, failedListeners)
+		return err
 	}
 
 	this.isLoaded = true
+	this.lastConfigJSON = configJSON
 
 	// 整体更新不需要再更新单个服务
 	this.updatingServerMap = map[int64]*serverconfigs.ServerConfig{}
@@ -631,22 +730,16 @@ func (this *Node) listenSock() error {
 				time.Sleep(100 * time.Millisecond)
 				utils.Exit()
 			case "quit":
+				var drainTimeout = time.Duration(maps.NewMap(cmd.Params).GetInt("timeout")) * time.Second
 				_ = cmd.ReplyOk()
 				_ = this.sock.Close()
 
 				events.Notify(events.EventQuit)
-				events.Notify(events.EventTerminated)
 
-				// 监控连接数，如果连接数为0，则退出进程
+				// 等待已有连接处理完成后退出进程
 				goman.New(func() {
-					for {
-						countActiveConnections := sharedListenerManager.TotalActiveConnections()
-						if countActiveConnections <= 0 {
-							utils.Exit()
-							return
-						}
-						time.Sleep(1 * time.Second)
-					}
+					sharedListenerManager.Shutdown(drainTimeout)
+					utils.Exit()
 				})
 			case "trackers":
 				_ = cmd.Reply(&gosock.Command{
@@ -734,8 +827,11 @@ func (this *Node) listenSock() error {
 
 				_ = cmd.Reply(&gosock.Command{
 					Params: map[string]interface{}{
-						"conns": connMaps,
-						"total": len(connMaps),
+						"conns":      connMaps,
+						"total":      len(connMaps),
+						"drain":      sharedListenerManager.DrainStatus(),
+						"websockets": sharedWebsocketCounter.Counts(),
+						"streams":    sharedStreamCounter.Counts(),
 					},
 				})
 			case "dropIP":
@@ -790,7 +886,64 @@ func (this *Node) listenSock() error {
 				debug.FreeOSMemory()
 				_ = cmd.ReplyOk()
 			case "reload":
-				err := this.syncConfig(0)
+				err := this.loadLocalConfig()
+				if err != nil {
+					_ = cmd.Reply(&gosock.Command{
+						Params: map[string]interface{}{
+							"error": "load local config failed: " + err.Error(),
+						},
+					})
+					break
+				}
+
+				// 手动重载时需要全量更新
+				this.resetConfigVersions()
+				err = this.syncConfig(0)
+				if err != nil {
+					_ = cmd.Reply(&gosock.Command{
+						Params: map[string]interface{}{
+							"error": err.Error(),
+						},
+					})
+				} else {
+					_ = cmd.ReplyOk()
+				}
+			case "reload.binary":
+				var drainTimeout = time.Duration(maps.NewMap(cmd.Params).GetInt("timeout")) * time.Second
+				err := sharedUpgradeManager.HandOff(drainTimeout)
+				if err != nil {
+					_ = cmd.Reply(&gosock.Command{
+						Params: map[string]interface{}{
+							"error": err.Error(),
+						},
+					})
+				} else {
+					_ = cmd.ReplyOk()
+				}
+			case "config.history":
+				this.locker.Lock()
+				var lastConfigVersion = this.lastConfigVersion
+				this.locker.Unlock()
+				_ = cmd.Reply(&gosock.Command{
+					Params: map[string]interface{}{
+						"version":   lastConfigVersion,
+						"histories": sharedNodeConfigHistory.List(),
+					},
+				})
+			case "config.rollback":
+				var version = maps.NewMap(cmd.Params).GetInt64("version")
+				if version <= 0 {
+					_ = cmd.Reply(&gosock.Command{
+						Params: map[string]interface{}{
+							"error": "invalid version",
+						},
+					})
+					break
+				}
+				this.locker.Lock()
+				var lastConfigVersion = this.lastConfigVersion
+				this.locker.Unlock()
+				err := this.rollbackConfig(version, lastConfigVersion)
 				if err != nil {
 					_ = cmd.Reply(&gosock.Command{
 						Params: map[string]interface{}{
@@ -817,6 +970,58 @@ func (this *Node) listenSock() error {
 				_ = cmd.Reply(&gosock.Command{Params: maps.Map{
 					"stats": m,
 				}})
+			case "cache.ls", "cache.inspect", "cache.export", "cache.warmup", "cache.warmup.status", "cache.warmup.cancel", "cache.fsck", "cache.fsck.status", "cache.fsck.cancel":
+				var result maps.Map
+				var err error
+				switch cmd.Code {
+				case "cache.ls":
+					result, err = this.listCacheItems(maps.NewMap(cmd.Params))
+				case "cache.inspect":
+					result, err = this.inspectCacheItem(maps.NewMap(cmd.Params))
+				case "cache.export":
+					result, err = this.exportCacheItem(maps.NewMap(cmd.Params))
+				case "cache.warmup":
+					result, err = this.startCacheWarmup(maps.NewMap(cmd.Params))
+				case "cache.warmup.status":
+					result, err = this.findCacheWarmupStatus(maps.NewMap(cmd.Params))
+				case "cache.warmup.cancel":
+					result, err = this.cancelCacheWarmup(maps.NewMap(cmd.Params))
+				case "cache.fsck":
+					result, err = this.startCacheFsck(maps.NewMap(cmd.Params))
+				case "cache.fsck.status":
+					result, err = this.findCacheFsckStatus(maps.NewMap(cmd.Params))
+				case "cache.fsck.cancel":
+					result, err = this.cancelCacheFsck(maps.NewMap(cmd.Params))
+				}
+				if err != nil {
+					_ = cmd.Reply(&gosock.Command{
+						Params: map[string]interface{}{
+							"error": err.Error(),
+						},
+					})
+				} else {
+					_ = cmd.Reply(&gosock.Command{Params: result})
+				}
+			case "cache.admission":
+				_ = cmd.Reply(&gosock.Command{Params: maps.Map{
+					"stats": sharedCacheAdmission.Stats(),
+				}})
+			case "cache.stat":
+				var params = maps.NewMap(cmd.Params)
+				if params.GetBool("reset") {
+					stats.SharedCacheStatManager.Reset()
+					_ = cmd.ReplyOk()
+				} else {
+					cacheStats, since := stats.SharedCacheStatManager.Stats(params.GetInt64("serverId"))
+					var statMaps = []maps.Map{}
+					for _, cacheStat := range cacheStats {
+						statMaps = append(statMaps, cacheStat.AsMap())
+					}
+					_ = cmd.Reply(&gosock.Command{Params: maps.Map{
+						"stats": statMaps,
+						"since": since,
+					}})
+				}
 			}
 		})
 
@@ -839,6 +1044,9 @@ func (this *Node) onReload(config *nodeconfigs.NodeConfig, reloadAll bool) {
 	nodeconfigs.ResetNodeConfig(config)
 	sharedNodeConfig = config
 
+	// 缓存共享节点
+	sharedHTTPCachePeerManager.UpdateClusterNodes(config.ParentNodes, config.Id)
+
 	if reloadAll {
 		// 缓存策略
 		var subDirs = config.CacheDiskSubDirs
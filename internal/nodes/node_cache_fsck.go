@@ -0,0 +1,157 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"context"
+	"errors"
+	"github.com/TeaOSLab/EdgeNode/internal/caches"
+	"github.com/TeaOSLab/EdgeNode/internal/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+	"sync"
+	"time"
+)
+
+const cacheFsckDefaultRate = 1000 // 默认每秒检查的文件数
+
+type cacheFsckStorage interface {
+	Fsck(ctx context.Context, options *caches.FsckOptions, report *caches.FsckReport) error
+}
+
+// 缓存一致性检查任务
+// 同时只能运行一个任务，只保留最近一次任务的结果
+type cacheFsckJob struct {
+	id        int64
+	policyIds []int64
+	options   *caches.FsckOptions
+	report    *caches.FsckReport
+
+	cancel context.CancelFunc
+
+	isRunning  bool
+	err        string
+	createdAt  int64
+	finishedAt int64
+}
+
+var lastCacheFsckJob *cacheFsckJob
+var cacheFsckLocker = &sync.Mutex{}
+
+// 启动缓存一致性检查
+// 参数：policyId（为0表示所有文件缓存策略）、repair、rate（每秒最多检查的文件数，-1表示不限制）
+func (this *Node) startCacheFsck(params maps.Map) (maps.Map, error) {
+	storages, err := this.findCacheStorages(params.GetInt64("policyId"))
+	if err != nil {
+		return nil, err
+	}
+	var fsckStorages = []caches.StorageInterface{}
+	var policyIds = []int64{}
+	for _, storage := range storages {
+		_, ok := storage.(cacheFsckStorage)
+		if ok {
+			fsckStorages = append(fsckStorages, storage)
+			policyIds = append(policyIds, storage.Policy().Id)
+		}
+	}
+	if len(fsckStorages) == 0 {
+		return nil, errors.New("no file cache policy to check")
+	}
+
+	var rate = params.GetInt("rate")
+	if rate == 0 {
+		rate = cacheFsckDefaultRate
+	} else if rate < 0 {
+		rate = 0
+	}
+
+	cacheFsckLocker.Lock()
+	if lastCacheFsckJob != nil && lastCacheFsckJob.isRunning {
+		cacheFsckLocker.Unlock()
+		return nil, errors.New("job '" + types.String(lastCacheFsckJob.id) + "' is running")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var jobId int64 = 1
+	if lastCacheFsckJob != nil {
+		jobId = lastCacheFsckJob.id + 1
+	}
+	var job = &cacheFsckJob{
+		id:        jobId,
+		policyIds: policyIds,
+		options: &caches.FsckOptions{
+			Repair: params.GetBool("repair"),
+			Rate:   rate,
+		},
+		report:    caches.NewFsckReport(),
+		cancel:    cancel,
+		isRunning: true,
+		createdAt: time.Now().Unix(),
+	}
+	lastCacheFsckJob = job
+	cacheFsckLocker.Unlock()
+
+	goman.New(func() {
+		defer cancel()
+
+		var errString string
+		for _, storage := range fsckStorages {
+			err := storage.(cacheFsckStorage).Fsck(ctx, job.options, job.report)
+			if err != nil {
+				if ctx.Err() != nil {
+					errString = "cancelled"
+				} else {
+					errString = "check policy '" + types.String(storage.Policy().Id) + "' failed: " + err.Error()
+					remotelogs.Error("CACHE_FSCK", errString)
+				}
+				break
+			}
+		}
+
+		cacheFsckLocker.Lock()
+		job.isRunning = false
+		job.err = errString
+		job.finishedAt = time.Now().Unix()
+		cacheFsckLocker.Unlock()
+	})
+
+	return maps.Map{
+		"id":        job.id,
+		"policyIds": policyIds,
+	}, nil
+}
+
+// 查看最近一次缓存一致性检查的结果
+func (this *Node) findCacheFsckStatus(params maps.Map) (maps.Map, error) {
+	cacheFsckLocker.Lock()
+	defer cacheFsckLocker.Unlock()
+
+	var job = lastCacheFsckJob
+	if job == nil {
+		return nil, errors.New("no job found")
+	}
+	var result = job.report.AsMap()
+	result["id"] = job.id
+	result["policyIds"] = job.policyIds
+	result["repair"] = job.options.Repair
+	result["rate"] = job.options.Rate
+	result["isRunning"] = job.isRunning
+	result["error"] = job.err
+	result["createdAt"] = job.createdAt
+	result["finishedAt"] = job.finishedAt
+	return maps.Map{
+		"job": result,
+	}, nil
+}
+
+// 取消正在运行的缓存一致性检查
+func (this *Node) cancelCacheFsck(params maps.Map) (maps.Map, error) {
+	cacheFsckLocker.Lock()
+	var job = lastCacheFsckJob
+	cacheFsckLocker.Unlock()
+	if job == nil {
+		return nil, errors.New("no job found")
+	}
+	job.cancel()
+	return maps.Map{}, nil
+}
@@ -0,0 +1,306 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"bytes"
+	"errors"
+	"github.com/TeaOSLab/EdgeNode/internal/caches"
+	"github.com/TeaOSLab/EdgeNode/internal/compressions"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/fasttime"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	cacheListDefaultLimit = 100
+	cacheListMaxLimit     = 10000
+)
+
+// 列出缓存项
+// 参数：policyId（为0表示所有缓存策略）、prefix、serverId、limit
+func (this *Node) listCacheItems(params maps.Map) (maps.Map, error) {
+	var storages, err = this.findCacheStorages(params.GetInt64("policyId"))
+	if err != nil {
+		return nil, err
+	}
+
+	var prefix = params.GetString("prefix")
+	var serverId = params.GetInt64("serverId")
+	var limit = params.GetInt("limit")
+	if limit <= 0 {
+		limit = cacheListDefaultLimit
+	} else if limit > cacheListMaxLimit {
+		limit = cacheListMaxLimit
+	}
+
+	var itemMaps = []maps.Map{}
+	var hasMore = false
+	for _, storage := range storages {
+		var policyId = storage.Policy().Id
+		err = storage.ListItems(prefix, serverId, func(item *caches.Item) (goNext bool) {
+			if len(itemMaps) >= limit {
+				hasMore = true
+				return false
+			}
+			itemMaps = append(itemMaps, maps.Map{
+				"policyId":  policyId,
+				"key":       item.Key,
+				"size":      item.Size(),
+				"expiredAt": item.ExpiredAt,
+				"isExpired": item.IsExpired(),
+				"hits":      item.Week1Hits + item.Week2Hits,
+				"serverId":  item.ServerId,
+			})
+			return true
+		})
+		if err != nil {
+			return nil, errors.New("list items of policy '" + storage.Policy().Name + "' failed: " + err.Error())
+		}
+		if hasMore {
+			break
+		}
+	}
+
+	return maps.Map{
+		"items":   itemMaps,
+		"count":   len(itemMaps),
+		"hasMore": hasMore,
+	}, nil
+}
+
+// 查看某个Key对应的缓存详情
+// 参数：key、policyId（为0表示所有缓存策略）
+func (this *Node) inspectCacheItem(params maps.Map) (maps.Map, error) {
+	var key = params.GetString("key")
+	if len(key) == 0 {
+		return nil, errors.New("'key' should not be empty")
+	}
+	storages, err := this.findCacheStorages(params.GetInt64("policyId"))
+	if err != nil {
+		return nil, err
+	}
+
+	var resultMaps = []maps.Map{}
+	for _, storage := range storages {
+		var resultMap = maps.Map{
+			"policyId": storage.Policy().Id,
+			"key":      key,
+		}
+
+		info, err := storage.ItemInfo(key)
+		if err == nil {
+			for k, v := range this.cacheItemInfoMap(info) {
+				resultMap[k] = v
+			}
+
+			reader, err := storage.OpenReader(key, true, false)
+			if err == nil {
+				resultMap["status"] = reader.Status()
+				resultMap["lastModified"] = reader.LastModified()
+				header, headerErr := this.readCacheHeader(reader)
+				if headerErr == nil {
+					resultMap["headers"] = header
+				}
+				_ = reader.Close()
+			}
+		} else if err != caches.ErrNotFound {
+			return nil, err
+		}
+
+		// 各种变体
+		var variantMaps = []maps.Map{}
+		for _, variantKey := range this.cacheVariantKeys(key) {
+			variantInfo, err := storage.ItemInfo(variantKey)
+			if err != nil {
+				continue
+			}
+			var variantMap = this.cacheItemInfoMap(variantInfo)
+			variantMap["key"] = variantKey
+			variantMaps = append(variantMaps, variantMap)
+		}
+		for _, variantPrefix := range this.cacheVariantPrefixes(key) {
+			// ListItems() 在回调中持有列表的锁，所以先收集Key，结束后再读取详细信息
+			var variantKeys = []string{}
+			err = storage.ListItems(variantPrefix, 0, func(item *caches.Item) (goNext bool) {
+				if len(variantMaps)+len(variantKeys) >= cacheListDefaultLimit {
+					return false
+				}
+				variantKeys = append(variantKeys, item.Key)
+				return true
+			})
+			if err != nil {
+				return nil, errors.New("list variants of policy '" + storage.Policy().Name + "' failed: " + err.Error())
+			}
+
+			for _, variantKey := range variantKeys {
+				variantInfo, err := storage.ItemInfo(variantKey)
+				if err != nil {
+					continue
+				}
+				var variantMap = this.cacheItemInfoMap(variantInfo)
+				variantMap["key"] = variantKey
+				variantMaps = append(variantMaps, variantMap)
+			}
+		}
+
+		if info == nil && len(variantMaps) == 0 {
+			continue
+		}
+		resultMap["isCached"] = info != nil
+		resultMap["variants"] = variantMaps
+		resultMaps = append(resultMaps, resultMap)
+	}
+
+	return maps.Map{
+		"items": resultMaps,
+	}, nil
+}
+
+// 导出某个Key对应的缓存内容到文件
+// 参数：key、file、policyId（为0表示所有缓存策略）；只导出第一个找到的缓存内容的Body
+func (this *Node) exportCacheItem(params maps.Map) (maps.Map, error) {
+	var key = params.GetString("key")
+	if len(key) == 0 {
+		return nil, errors.New("'key' should not be empty")
+	}
+	var file = params.GetString("file")
+	if len(file) == 0 {
+		return nil, errors.New("'file' should not be empty")
+	}
+	storages, err := this.findCacheStorages(params.GetInt64("policyId"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, storage := range storages {
+		reader, err := storage.OpenReader(key, true, false)
+		if err != nil {
+			if err == caches.ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+
+		resultMap, err := this.writeCacheBody(reader, file)
+		_ = reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		resultMap["policyId"] = storage.Policy().Id
+		return resultMap, nil
+	}
+	return nil, errors.New("cache not found")
+}
+
+// 查找缓存策略对应的存储
+func (this *Node) findCacheStorages(policyId int64) ([]caches.StorageInterface, error) {
+	if policyId > 0 {
+		var storage = caches.SharedManager.FindStorageWithPolicy(policyId)
+		if storage == nil {
+			return nil, errors.New("can not find cache policy with id '" + types.String(policyId) + "'")
+		}
+		return []caches.StorageInterface{storage}, nil
+	}
+	return caches.SharedManager.FindAllStorages(), nil
+}
+
+func (this *Node) cacheItemInfoMap(info *caches.ItemInfo) maps.Map {
+	return maps.Map{
+		"storage":    info.Storage,
+		"path":       info.Path,
+		"offset":     info.Offset,
+		"headerSize": info.HeaderSize,
+		"bodySize":   info.BodySize,
+		"expiredAt":  info.ExpiredAt,
+		"staleAt":    info.StaleAt,
+		"isExpired":  info.IsExpired(),
+		"isStale":    info.IsExpired() && info.StaleAt > fasttime.Now().Unix(),
+		"hits":       info.Week1Hits + info.Week2Hits,
+		"serverId":   info.ServerId,
+	}
+}
+
+// 某个Key可能存在的变体
+func (this *Node) cacheVariantKeys(key string) []string {
+	var keys = []string{
+		key + caches.SuffixMethod + http.MethodHead,
+		key + caches.SuffixWebP,
+		key + caches.SuffixPartial,
+	}
+	for _, encoding := range compressions.AllEncodings() {
+		keys = append(keys, key+caches.SuffixCompression+encoding)
+		keys = append(keys, key+caches.SuffixWebP+caches.SuffixCompression+encoding)
+	}
+	return keys
+}
+
+// 某个Key可能存在的变体前缀，比如转换后的图片和分片
+func (this *Node) cacheVariantPrefixes(key string) []string {
+	return []string{
+		key + caches.SuffixImage,
+		key + caches.SuffixSlice,
+	}
+}
+
+// 读取缓存中的Header
+func (this *Node) readCacheHeader(reader caches.Reader) (http.Header, error) {
+	var header = http.Header{}
+	var buf = make([]byte, 4096)
+	var headerData = []byte{}
+	err := reader.ReadHeader(buf, func(n int) (goNext bool, err error) {
+		headerData = append(headerData, buf[:n]...)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range bytes.Split(headerData, []byte{'\n'}) {
+		var colonIndex = bytes.IndexByte(row, ':')
+		if colonIndex <= 0 {
+			continue
+		}
+		header.Add(string(row[:colonIndex]), strings.TrimSpace(string(row[colonIndex+1:])))
+	}
+	return header, nil
+}
+
+// 将缓存Body写入文件
+func (this *Node) writeCacheBody(reader caches.Reader, file string) (maps.Map, error) {
+	// 不覆盖已有的文件
+	fp, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, errors.New("file '" + file + "' already exists")
+		}
+		return nil, err
+	}
+
+	var buf = make([]byte, 32*1024)
+	var written int64
+	err = reader.ReadBody(buf, func(n int) (goNext bool, err error) {
+		_, err = fp.Write(buf[:n])
+		if err != nil {
+			return false, err
+		}
+		written += int64(n)
+		return true, nil
+	})
+	var closeErr = fp.Close()
+	if err != nil {
+		_ = os.Remove(file)
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+
+	return maps.Map{
+		"file":   file,
+		"size":   written,
+		"status": reader.Status(),
+	}, nil
+}
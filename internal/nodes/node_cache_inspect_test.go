@@ -0,0 +1,70 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/shared"
+	"github.com/TeaOSLab/EdgeNode/internal/caches"
+	"github.com/iwind/TeaGo/maps"
+	"testing"
+	"time"
+)
+
+func TestNode_InspectCacheItem(t *testing.T) {
+	caches.SharedManager.UpdatePolicies([]*serverconfigs.HTTPCachePolicy{
+		{
+			Id:       1000,
+			IsOn:     true,
+			Name:     "test",
+			Type:     serverconfigs.CachePolicyStorageMemory,
+			Capacity: &shared.SizeCapacity{Count: 1, Unit: shared.SizeCapacityUnitGB},
+		},
+	})
+	defer caches.SharedManager.UpdatePolicies([]*serverconfigs.HTTPCachePolicy{})
+
+	var storage = caches.SharedManager.FindStorageWithPolicy(1000)
+	if storage == nil {
+		t.Fatal("storage should not be nil")
+	}
+	var key = "https://example.com/a.mp4"
+	for _, itemKey := range []string{key, key + caches.SuffixSlice + "0", key + caches.SuffixSlice + "1"} {
+		writer, err := storage.OpenWriter(itemKey, time.Now().Unix()+3600, 200, -1, -1, -1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = writer.Write([]byte("hello"))
+		err = writer.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 列出变体时不能在ListItems()的回调中读取详细信息，否则会死锁
+	var resultChan = make(chan maps.Map, 1)
+	var errChan = make(chan error, 1)
+	go func() {
+		result, err := (&Node{}).inspectCacheItem(maps.Map{"key": key, "policyId": 1000})
+		if err != nil {
+			errChan <- err
+			return
+		}
+		resultChan <- result
+	}()
+
+	select {
+	case err := <-errChan:
+		t.Fatal(err)
+	case result := <-resultChan:
+		var items = result["items"].([]maps.Map)
+		if len(items) != 1 || items[0]["isCached"] != true {
+			t.Fatal("unexpected items:", items)
+		}
+		var variants = items[0]["variants"].([]maps.Map)
+		if len(variants) != 2 {
+			t.Fatal("unexpected variants:", variants)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("inspect cache item timeout")
+	}
+}
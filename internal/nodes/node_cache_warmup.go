@@ -0,0 +1,72 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"errors"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+	"net/http"
+	"strings"
+)
+
+// 启动缓存预热任务
+// 参数：file、sitemap、concurrency、rate、headers（"Name: Value"格式的列表）
+func (this *Node) startCacheWarmup(params maps.Map) (maps.Map, error) {
+	var header = http.Header{}
+	for _, h := range params.GetSlice("headers") {
+		var headerString = types.String(h)
+		var colonIndex = strings.Index(headerString, ":")
+		if colonIndex <= 0 {
+			return nil, errors.New("invalid header '" + headerString + "'")
+		}
+		header.Add(strings.TrimSpace(headerString[:colonIndex]), strings.TrimSpace(headerString[colonIndex+1:]))
+	}
+
+	job, err := sharedHTTPCacheWarmupManager.Start(&HTTPCacheWarmupOptions{
+		File:        params.GetString("file"),
+		Sitemap:     params.GetString("sitemap"),
+		Concurrency: params.GetInt("concurrency"),
+		Rate:        params.GetInt("rate"),
+		Header:      header,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return maps.Map{
+		"id": job.Id(),
+	}, nil
+}
+
+// 查看缓存预热任务进度
+// 参数：id（为0表示所有任务）
+func (this *Node) findCacheWarmupStatus(params maps.Map) (maps.Map, error) {
+	var jobId = params.GetInt64("id")
+	if jobId > 0 {
+		var job = sharedHTTPCacheWarmupManager.FindJob(jobId)
+		if job == nil {
+			return nil, errors.New("can not find job with id '" + types.String(jobId) + "'")
+		}
+		return maps.Map{
+			"jobs": []maps.Map{job.AsMap()},
+		}, nil
+	}
+
+	var jobMaps = []maps.Map{}
+	for _, job := range sharedHTTPCacheWarmupManager.AllJobs() {
+		jobMaps = append(jobMaps, job.AsMap())
+	}
+	return maps.Map{
+		"jobs": jobMaps,
+	}, nil
+}
+
+// 取消缓存预热任务
+// 参数：id
+func (this *Node) cancelCacheWarmup(params maps.Map) (maps.Map, error) {
+	var err = sharedHTTPCacheWarmupManager.Cancel(params.GetInt64("id"))
+	if err != nil {
+		return nil, err
+	}
+	return maps.Map{}, nil
+}
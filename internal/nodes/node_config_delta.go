@@ -0,0 +1,97 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"errors"
+	"github.com/TeaOSLab/EdgeCommon/pkg/nodeconfigs"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+)
+
+// 增量应用节点配置的变化
+// 只替换有变化的服务和策略，而不重新加载整个节点配置；调用者需要持有 this.locker
+func (this *Node) applyConfigDelta(nodeConfig *nodeconfigs.NodeConfig, delta *configs.NodeConfigDelta) error {
+	if delta.IsFull {
+		return errors.New("full reload is required")
+	}
+	if sharedNodeConfig == nil {
+		return errors.New("no loaded node config")
+	}
+	if delta.IsEmpty() {
+		sharedNodeConfig.Version = nodeConfig.Version
+		return nil
+	}
+
+	newNodeConfig, err := nodeconfigs.CloneNodeConfig(sharedNodeConfig)
+	if err != nil {
+		return errors.New("clone node config failed: " + err.Error())
+	}
+	newNodeConfig.Version = nodeConfig.Version
+	newNodeConfig.DataMap = nodeConfig.DataMap
+
+	var serverMap = map[int64]*serverconfigs.ServerConfig{}
+	for _, server := range nodeConfig.Servers {
+		if server != nil {
+			serverMap[server.Id] = server
+		}
+	}
+	for _, serverId := range delta.UpdatedServerIds {
+		server, ok := serverMap[serverId]
+		if !ok {
+			return errors.New("can not find server '" + types.String(serverId) + "'")
+		}
+		newNodeConfig.AddServer(server)
+	}
+	for _, serverId := range delta.RemovedServerIds {
+		newNodeConfig.RemoveServer(serverId)
+	}
+
+	if delta.CachePoliciesChanged {
+		newNodeConfig.HTTPCachePolicies = nodeConfig.HTTPCachePolicies
+	}
+	if delta.FirewallPoliciesChanged {
+		newNodeConfig.HTTPFirewallPolicies = nodeConfig.HTTPFirewallPolicies
+	}
+
+	// 检查结果是否和API节点的配置一致
+	if len(newNodeConfig.Servers) != len(serverMap) {
+		return errors.New("servers mismatch, expected: " + types.String(len(serverMap)) + ", actual: " + types.String(len(newNodeConfig.Servers)))
+	}
+
+	err, serverErrors := newNodeConfig.Init(nil)
+	if err != nil {
+		return err
+	}
+	for _, serverErr := range serverErrors {
+		remotelogs.ServerError(serverErr.Id, "NODE", serverErr.Message, nodeconfigs.NodeLogTypeServerConfigInitFailed, maps.Map{})
+	}
+
+	remotelogs.Println("NODE", "applying config changes: updated servers: "+types.String(len(delta.UpdatedServerIds))+
+		", removed servers: "+types.String(len(delta.RemovedServerIds))+
+		", cache policies changed: "+types.String(delta.CachePoliciesChanged)+
+		", firewall policies changed: "+types.String(delta.FirewallPoliciesChanged))
+
+	// 缓存策略只有在变化时才需要重新加载
+	this.onReload(newNodeConfig, delta.CachePoliciesChanged)
+
+	// 发送事件
+	events.Notify(events.EventReload)
+
+	// 单个服务的更新已经包含在内
+	this.updatingServerMap = map[int64]*serverconfigs.ServerConfig{}
+
+	return sharedListenerManager.Start(newNodeConfig)
+}
+
+// 重置配置版本，下次同步时会全量更新
+func (this *Node) resetConfigVersions() {
+	this.locker.Lock()
+	this.lastConfigVersion = -1
+	this.configVersions = nil
+	this.locker.Unlock()
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/TeaOSLab/EdgeCommon/pkg/nodeconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+	"sync/atomic"
+	"time"
+)
+
+var sharedNodeConfigGuard = NewNodeConfigGuard()
+
+// NodeConfigGuard 节点配置加载后的健康检查
+// 在宽限期内检查端口监听和请求错误率，如果不健康则自动回滚到上一个正常的配置
+type NodeConfigGuard struct {
+	GracePeriod      time.Duration // 宽限期
+	MinRequests      int64         // 计算错误率需要的最少请求数
+	MaxErrorRate     float64       // 最大错误率
+	MaxErrorRateRise float64       // 相对于加载前的错误率最多上升多少
+
+	countRequests int64
+	countErrors   int64
+
+	watchId int64 // 每次加载配置时增加，用来放弃过期的检查
+}
+
+// NewNodeConfigGuard 获取新对象
+func NewNodeConfigGuard() *NodeConfigGuard {
+	return &NodeConfigGuard{
+		GracePeriod:      30 * time.Second,
+		MinRequests:      100,
+		MaxErrorRate:     0.5,
+		MaxErrorRateRise: 0.2,
+	}
+}
+
+// AddRequest 记录请求结果
+func (this *NodeConfigGuard) AddRequest(statusCode int) {
+	atomic.AddInt64(&this.countRequests, 1)
+	if statusCode >= 500 {
+		atomic.AddInt64(&this.countErrors, 1)
+	}
+}
+
+// 重置计数器并返回之前的错误率
+func (this *NodeConfigGuard) resetCounters() (countRequests int64, errorRate float64) {
+	countRequests = atomic.SwapInt64(&this.countRequests, 0)
+	var countErrors = atomic.SwapInt64(&this.countErrors, 0)
+	if countRequests > 0 {
+		errorRate = float64(countErrors) / float64(countRequests)
+	}
+	return
+}
+
+// CheckErrorRate 检查错误率是否正常
+func (this *NodeConfigGuard) CheckErrorRate(countRequests int64, errorRate float64, baseErrorRate float64) error {
+	if countRequests < this.MinRequests {
+		return nil
+	}
+	if errorRate >= this.MaxErrorRate && errorRate-baseErrorRate >= this.MaxErrorRateRise {
+		return errors.New("error rate rose from " + types.String(int(baseErrorRate*100)) + "% to " + types.String(int(errorRate*100)) + "%")
+	}
+	return nil
+}
+
+// 在宽限期内检查新加载的配置，通过后保存为最近的正常配置，否则自动回滚
+func (this *Node) watchConfigHealth(version int64, configJSON []byte, failedListenersBefore int) {
+	if len(configJSON) == 0 {
+		return
+	}
+
+	var guard = sharedNodeConfigGuard
+	var watchId = atomic.AddInt64(&guard.watchId, 1)
+	_, baseErrorRate := guard.resetCounters()
+
+	goman.New(func() {
+		time.Sleep(guard.GracePeriod)
+
+		// 已经有新的配置加载
+		if atomic.LoadInt64(&guard.watchId) != watchId {
+			return
+		}
+
+		var healthErr error
+		var failedListeners = sharedListenerManager.CountFailedListeners()
+		if failedListeners > failedListenersBefore {
+			healthErr = errors.New(types.String(failedListeners-failedListenersBefore) + " listener(s) failed to bind")
+		} else {
+			countRequests, errorRate := guard.resetCounters()
+			healthErr = guard.CheckErrorRate(countRequests, errorRate, baseErrorRate)
+		}
+
+		if healthErr == nil {
+			err := sharedNodeConfigHistory.Add(version, configJSON)
+			if err != nil {
+				remotelogs.Error("NODE", "save config history failed: "+err.Error())
+			}
+			return
+		}
+
+		remotelogs.Error("NODE", "config version '"+types.String(version)+"' is unhealthy: "+healthErr.Error()+", rolling back ...")
+		err := this.rollbackConfig(0, version)
+		if err != nil {
+			remotelogs.Error("NODE", "rollback config failed: "+err.Error())
+		}
+	})
+}
+
+// 回滚到某个历史配置
+// version 为0时表示回滚到除 badVersion 以外最近的一个正常配置
+func (this *Node) rollbackConfig(version int64, badVersion int64) error {
+	var configJSON []byte
+	var err error
+	if version > 0 {
+		configJSON, err = sharedNodeConfigHistory.Read(version)
+	} else {
+		version, configJSON, err = sharedNodeConfigHistory.Latest(badVersion)
+	}
+	if err != nil {
+		return errors.New("read config history failed: " + err.Error())
+	}
+
+	var nodeConfig = &nodeconfigs.NodeConfig{}
+	err = json.Unmarshal(configJSON, nodeConfig)
+	if err != nil {
+		return errors.New("decode config failed: " + err.Error())
+	}
+
+	err, serverErrors := nodeConfig.Init(nil)
+	if err != nil {
+		return errors.New("init config failed: " + err.Error())
+	}
+	for _, serverErr := range serverErrors {
+		remotelogs.ServerError(serverErr.Id, "NODE", serverErr.Message, nodeconfigs.NodeLogTypeServerConfigInitFailed, maps.Map{})
+	}
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	// 放弃正在进行的检查
+	atomic.AddInt64(&sharedNodeConfigGuard.watchId, 1)
+
+	err = nodeConfig.Save()
+	if err != nil {
+		return err
+	}
+
+	remotelogs.Println("NODE", "rollback to config version '"+types.String(version)+"'")
+	this.onReload(nodeConfig, true)
+	events.Notify(events.EventReload)
+
+	// 保持API节点的版本号，以免再次下发同样有问题的配置
+	configVersions, err := configs.ParseNodeConfigVersions(configJSON)
+	if err == nil {
+		this.configVersions = configVersions
+	}
+	if badVersion > 0 {
+		this.lastConfigVersion = badVersion
+	}
+
+	return sharedListenerManager.Start(nodeConfig)
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"errors"
+	"github.com/iwind/TeaGo/Tea"
+	"github.com/iwind/TeaGo/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认保留的历史配置数量
+const defaultMaxNodeConfigHistories = 5
+
+var sharedNodeConfigHistory = NewNodeConfigHistory(Tea.Root+"/data/node_configs", defaultMaxNodeConfigHistories)
+
+// NodeConfigHistoryItem 历史配置
+type NodeConfigHistoryItem struct {
+	Version   int64  `json:"version"`
+	CreatedAt int64  `json:"createdAt"`
+	Size      int64  `json:"size"`
+	Path      string `json:"path"`
+}
+
+// NodeConfigHistory 最近验证通过的节点配置
+// 每个配置保存为一个文件：node-VERSION.json，文件的修改时间即为保存时间
+type NodeConfigHistory struct {
+	dir    string
+	max    int
+	locker sync.Mutex
+}
+
+// NewNodeConfigHistory 获取新对象
+func NewNodeConfigHistory(dir string, max int) *NodeConfigHistory {
+	if max <= 0 {
+		max = defaultMaxNodeConfigHistories
+	}
+	return &NodeConfigHistory{
+		dir: dir,
+		max: max,
+	}
+}
+
+// Add 保存一个验证通过的配置
+func (this *NodeConfigHistory) Add(version int64, configJSON []byte) error {
+	if len(configJSON) == 0 {
+		return errors.New("config should not be empty")
+	}
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	_, err := os.Stat(this.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		err = os.MkdirAll(this.dir, 0777)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 先写入临时文件，防止写入中断导致文件不完整
+	var path = this.path(version)
+	var tmpPath = path + ".tmp"
+	err = os.WriteFile(tmpPath, configJSON, 0666)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	// 更新时间，以便于同样版本重新保存时排在最前面
+	var now = time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	// 删除多余的
+	var items = this.list()
+	if len(items) > this.max {
+		for _, item := range items[this.max:] {
+			_ = os.Remove(item.Path)
+		}
+	}
+
+	return nil
+}
+
+// List 列出所有历史配置，最新的排在最前面
+func (this *NodeConfigHistory) List() []*NodeConfigHistoryItem {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.list()
+}
+
+// Read 读取某个版本的配置
+func (this *NodeConfigHistory) Read(version int64) ([]byte, error) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return os.ReadFile(this.path(version))
+}
+
+// Latest 读取最近的一个配置
+// excludeVersion 表示需要排除的版本，通常是当前正在使用的有问题的版本
+func (this *NodeConfigHistory) Latest(excludeVersion int64) (version int64, configJSON []byte, err error) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	for _, item := range this.list() {
+		if item.Version == excludeVersion {
+			continue
+		}
+		data, err := os.ReadFile(item.Path)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		return item.Version, data, nil
+	}
+	return 0, nil, os.ErrNotExist
+}
+
+func (this *NodeConfigHistory) list() []*NodeConfigHistoryItem {
+	var result = []*NodeConfigHistoryItem{}
+	files, err := os.ReadDir(this.dir)
+	if err != nil {
+		return result
+	}
+	for _, file := range files {
+		var name = file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, "node-") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		result = append(result, &NodeConfigHistoryItem{
+			Version:   types.Int64(strings.TrimSuffix(strings.TrimPrefix(name, "node-"), ".json")),
+			CreatedAt: info.ModTime().Unix(),
+			Size:      info.Size(),
+			Path:      filepath.Join(this.dir, name),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt == result[j].CreatedAt {
+			return result[i].Version > result[j].Version
+		}
+		return result[i].CreatedAt > result[j].CreatedAt
+	})
+	return result
+}
+
+func (this *NodeConfigHistory) path(version int64) string {
+	return filepath.Join(this.dir, "node-"+types.String(version)+".json")
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"testing"
+)
+
+func TestNodeConfigHistory_Add(t *testing.T) {
+	var history = NewNodeConfigHistory(t.TempDir(), 3)
+	for version := int64(1); version <= 5; version++ {
+		err := history.Add(version, []byte(`{"version":`+string(rune('0'+version))+`}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var items = history.List()
+	if len(items) != 3 {
+		t.Fatal("expect 3 items, got", len(items))
+	}
+	for _, item := range items {
+		t.Logf("%+v", item)
+	}
+
+	data, err := history.Read(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(string(data))
+
+	_, err = history.Read(1)
+	if err == nil {
+		t.Fatal("version 1 should be removed")
+	}
+}
+
+func TestNodeConfigHistory_Latest(t *testing.T) {
+	var history = NewNodeConfigHistory(t.TempDir(), 5)
+
+	_, _, err := history.Latest(0)
+	if err == nil {
+		t.Fatal("should be empty")
+	}
+
+	_ = history.Add(1, []byte(`{"version":1}`))
+	_ = history.Add(2, []byte(`{"version":2}`))
+
+	version, _, err := history.Latest(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatal("expect version 1, got", version)
+	}
+}
+
+func TestNodeConfigGuard_CheckErrorRate(t *testing.T) {
+	var guard = NewNodeConfigGuard()
+	if guard.CheckErrorRate(10, 1, 0) != nil {
+		t.Fatal("too few requests should be ignored")
+	}
+	if guard.CheckErrorRate(1000, 0.6, 0.55) != nil {
+		t.Fatal("error rate did not rise enough")
+	}
+	if guard.CheckErrorRate(1000, 0.8, 0.1) == nil {
+		t.Fatal("error rate should be unhealthy")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/caches"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/counters"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/resolvers"
+	"reflect"
+	"strings"
+)
+
+// 加载节点本地配置 configs/local.yaml
+// 在启动和执行 edge-node reload 时调用，只重新应用有变化的部分；
+// 小文件段存储和内存缓存快照的修改在缓存策略重新创建后生效，需要在加载缓存策略之前调用
+func (this *Node) loadLocalConfig() error {
+	config, err := configs.LoadLocalConfig()
+	if err != nil {
+		return err
+	}
+	this.applyLocalConfig(config)
+	return nil
+}
+
+// 应用节点本地配置
+func (this *Node) applyLocalConfig(config *configs.LocalConfig) {
+	this.localConfigLocker.Lock()
+	defer this.localConfigLocker.Unlock()
+
+	var oldConfig = this.localConfig
+	this.localConfig = config
+	var isChanged = func(section func(config *configs.LocalConfig) interface{}) bool {
+		return oldConfig == nil || !reflect.DeepEqual(section(oldConfig), section(config))
+	}
+
+	// 节点升级
+	sharedUpgradeConfig = config.Upgrade
+
+	// 计数器
+	if isChanged(func(config *configs.LocalConfig) interface{} { return config.Counter }) {
+		if config.Counter.Backend == configs.CounterBackendRedis {
+			remotelogs.Println("NODE", "use redis counter '"+config.Counter.Redis.Addr+"'")
+			counters.SetSharedCounter(counters.NewRedisCounter(config.Counter))
+		} else if oldConfig != nil {
+			counters.SetSharedCounter(counters.NewLocalCounter())
+		}
+	}
+
+	// 源站域名解析
+	if isChanged(func(config *configs.LocalConfig) interface{} { return config.Resolver }) {
+		var resolver = resolvers.NewResolver(config.Resolver)
+		if len(resolver.Servers()) == 0 {
+			remotelogs.Warn("NODE", "no dns servers found for resolver, use system resolver instead")
+		} else if len(config.Resolver.Servers) > 0 {
+			remotelogs.Println("NODE", "use dns servers '"+strings.Join(resolver.Servers(), ", ")+"' for origins")
+		}
+		resolvers.SetSharedResolver(resolver)
+	}
+
+	// 图片转换
+	if config.Image.IsOn && isChanged(func(config *configs.LocalConfig) interface{} { return config.Image }) {
+		remotelogs.Println("NODE", "image transform is enabled with param '"+config.Image.Param+"' and header '"+config.Image.Header+"'")
+	}
+	sharedImageTransformConfig = config.Image
+
+	// 网站和策略选项
+	sharedTLSPassthroughConfig = config.TLSPassthrough
+	sharedUDPProxyConfig = config.UDP
+	sharedAutoIndexConfig = config.AutoIndex
+	sharedWebSocketConfig = config.WebSocket
+	sharedStreamConfig = config.Stream
+	sharedSliceConfig = config.Slice
+
+	// 缓存
+	caches.SharedManager.SegmentConfig = config.CacheSegment
+	caches.SharedManager.SnapshotConfig = config.CacheSnapshot
+	if isChanged(func(config *configs.LocalConfig) interface{} { return config.CacheAdmission }) {
+		sharedCacheAdmission.UpdateConfig(config.CacheAdmission)
+	}
+	if isChanged(func(config *configs.LocalConfig) interface{} { return config.CachePeer }) {
+		sharedHTTPCachePeerManager.UpdateConfig(config.CachePeer)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/counters"
+	"testing"
+)
+
+func parseTestLocalConfig(t *testing.T, data string) *configs.LocalConfig {
+	config, err := configs.ParseLocalConfig([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestNode_ApplyLocalConfig_Counter(t *testing.T) {
+	defer counters.SetSharedCounter(counters.NewLocalCounter())
+
+	var node = &Node{}
+	var redisConfig = `
+counter:
+  backend: redis
+  redis:
+    addr: "127.0.0.1:1"
+`
+	node.applyLocalConfig(parseTestLocalConfig(t, redisConfig))
+	var counter = counters.SharedCounter()
+	_, ok := counter.(*counters.RedisCounter)
+	if !ok {
+		t.Fatal("expect redis counter")
+	}
+
+	// 没有变化时继续使用原来的计数器
+	node.applyLocalConfig(parseTestLocalConfig(t, redisConfig))
+	if counters.SharedCounter() != counter {
+		t.Fatal("counter should not be recreated")
+	}
+
+	// 切换回本地计数
+	node.applyLocalConfig(parseTestLocalConfig(t, ""))
+	_, ok = counters.SharedCounter().(*counters.LocalCounter)
+	if !ok {
+		t.Fatal("expect local counter")
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/TeaOSLab/EdgeCommon/pkg/nodeconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/fsnotify/fsnotify"
+	"github.com/iwind/TeaGo/Tea"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+	"path/filepath"
+	"time"
+)
+
+// 检查是否为独立运行模式
+// 当 configs/standalone.yaml（或 .yml、.json）存在时，节点不再连接API节点，而是直接从此文件中读取配置
+func (this *Node) checkStandalone() bool {
+	this.standaloneConfigFile = configs.FindStandaloneConfigFile()
+	teaconst.IsStandalone = len(this.standaloneConfigFile) > 0
+	return teaconst.IsStandalone
+}
+
+// 从独立模式配置文件中读取并初始化节点配置
+func (this *Node) loadStandaloneConfig() (*configs.StandaloneConfig, *nodeconfigs.NodeConfig, []*nodeconfigs.ServerError, error) {
+	if len(this.standaloneConfigFile) == 0 {
+		return nil, nil, nil, errors.New("can not find standalone config file")
+	}
+
+	standaloneConfig, err := configs.LoadStandaloneConfig(this.standaloneConfigFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	nodeJSON, err := standaloneConfig.NodeJSON()
+	if err != nil {
+		return nil, nil, nil, errors.New("encode node config failed: " + err.Error())
+	}
+
+	var nodeConfig = &nodeconfigs.NodeConfig{}
+	err = json.Unmarshal(nodeJSON, nodeConfig)
+	if err != nil {
+		return nil, nil, nil, errors.New("decode node config failed: " + err.Error())
+	}
+
+	err, serverErrors := nodeConfig.Init(nil)
+	if err != nil {
+		return nil, nil, nil, errors.New("init node config failed: " + err.Error())
+	}
+
+	return standaloneConfig, nodeConfig, serverErrors, nil
+}
+
+// 检查独立模式配置文件
+func (this *Node) testStandaloneConfig() error {
+	_, _, serverErrors, err := this.loadStandaloneConfig()
+	if err != nil {
+		return err
+	}
+	if len(serverErrors) > 0 {
+		var serverErr = serverErrors[0]
+		return errors.New("init server '" + types.String(serverErr.Id) + "' failed: " + serverErr.Message)
+	}
+	return nil
+}
+
+// 从独立模式配置文件中同步配置
+// 调用者需要持有 this.locker
+func (this *Node) syncStandaloneConfig() error {
+	standaloneConfig, nodeConfig, serverErrors, err := this.loadStandaloneConfig()
+	if err != nil {
+		return err
+	}
+	for _, serverErr := range serverErrors {
+		remotelogs.ServerError(serverErr.Id, "NODE", serverErr.Message, nodeconfigs.NodeLogTypeServerConfigInitFailed, maps.Map{})
+	}
+
+	teaconst.NodeId = nodeConfig.Id
+	teaconst.NodeIdString = types.String(teaconst.NodeId)
+	nodeConfigUpdatedAt = time.Now().Unix()
+
+	// 访问日志
+	var accessLogFile = standaloneConfig.AccessLog.File
+	if len(accessLogFile) > 0 && !filepath.IsAbs(accessLogFile) {
+		accessLogFile = Tea.Root + "/" + accessLogFile
+	}
+	err = sharedHTTPAccessLogQueue.SetLocalFile(accessLogFile)
+	if err != nil {
+		remotelogs.Error("NODE", "open access log file failed: "+err.Error())
+	}
+
+	if this.isLoaded {
+		remotelogs.Println("NODE", "reloading standalone config ...")
+	} else {
+		remotelogs.Println("NODE", "loading standalone config from '"+this.standaloneConfigFile+"' ...")
+	}
+
+	this.onReload(nodeConfig, true)
+
+	// 发送事件
+	events.Notify(events.EventReload)
+
+	if this.isLoaded {
+		return sharedListenerManager.Start(nodeConfig)
+	}
+
+	this.isLoaded = true
+	return nil
+}
+
+// 监听独立模式配置文件变化
+func (this *Node) watchStandaloneConfig() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// 监听目录而不是文件，以便于支持编辑器通过重命名方式保存文件
+	err = watcher.Add(filepath.Dir(this.standaloneConfigFile))
+	if err != nil {
+		_ = watcher.Close()
+		return err
+	}
+
+	events.OnKey(events.EventQuit, watcher, func() {
+		remotelogs.Println("NODE", "quit standalone config watcher")
+		_ = watcher.Close()
+	})
+
+	var filename = filepath.Base(this.standaloneConfigFile)
+	var changedNotify = make(chan bool, 1)
+
+	goman.New(func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Base(event.Name) != filename || event.Op&fsnotify.Chmod == fsnotify.Chmod {
+					continue
+				}
+				select {
+				case changedNotify <- true:
+				default:
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				remotelogs.Error("NODE", "watch standalone config failed: "+err.Error())
+			}
+		}
+	})
+
+	goman.New(func() {
+		for range changedNotify {
+			// 等待文件写入完成，同时合并短时间内的多次变化
+			time.Sleep(1 * time.Second)
+			select {
+			case <-changedNotify:
+			default:
+			}
+
+			err := this.syncConfig(0)
+			if err != nil {
+				remotelogs.Error("NODE", "reload standalone config failed: "+err.Error())
+			}
+		}
+	})
+
+	return nil
+}
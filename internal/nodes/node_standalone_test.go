@@ -0,0 +1,126 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/TeaOSLab/EdgeCommon/pkg/rpc/pb"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNode_LoadStandaloneConfig(t *testing.T) {
+	var dir = t.TempDir()
+
+	{
+		var path = filepath.Join(dir, "standalone.yaml")
+		err := os.WriteFile(path, []byte(`node:
+  id: 1
+  servers:
+    - id: 1
+      isOn: true
+      name: example
+accessLog:
+  file: logs/access.log
+`), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var node = &Node{standaloneConfigFile: path}
+		standaloneConfig, nodeConfig, _, err := node.loadStandaloneConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodeConfig.Id != 1 || len(nodeConfig.Servers) != 1 {
+			t.Fatal("unexpected node config")
+		}
+		if standaloneConfig.AccessLog.File != "logs/access.log" {
+			t.Fatal("unexpected access log file: " + standaloneConfig.AccessLog.File)
+		}
+	}
+
+	{
+		var path = filepath.Join(dir, "standalone.json")
+		err := os.WriteFile(path, []byte(`{"node":{"id":2,"servers":[]}}`), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var node = &Node{standaloneConfigFile: path}
+		_, nodeConfig, _, err := node.loadStandaloneConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodeConfig.Id != 2 {
+			t.Fatal("unexpected node id")
+		}
+	}
+
+	// 没有节点配置
+	{
+		var path = filepath.Join(dir, "empty.yaml")
+		err := os.WriteFile(path, []byte("accessLog:\n  file: access.log\n"), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var node = &Node{standaloneConfigFile: path}
+		err = node.testStandaloneConfig()
+		if err == nil {
+			t.Fatal("should fail with empty node")
+		}
+		t.Log("expected error:", err)
+	}
+
+	// 没有配置文件
+	{
+		var node = &Node{}
+		_, _, _, err := node.loadStandaloneConfig()
+		if err == nil {
+			t.Fatal("should fail without config file")
+		}
+	}
+}
+
+func TestHTTPAccessLogQueue_LocalFile(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "access.log")
+
+	var queue = &HTTPAccessLogQueue{}
+	err := queue.SetLocalFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, requestPath := range []string{"/a", "/b"} {
+		err = queue.writeLocalFile([]*pb.HTTPAccessLog{
+			{
+				ServerId:    1,
+				RequestPath: requestPath,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 关闭后不再写入
+	err = queue.SetLocalFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = queue.writeLocalFile([]*pb.HTTPAccessLog{{ServerId: 1, RequestPath: "/c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"/a"`) || !strings.Contains(lines[1], `"/b"`) {
+		t.Fatal("unexpected access log file:", string(data))
+	}
+}
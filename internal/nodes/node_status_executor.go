@@ -26,6 +26,13 @@ import (
 	"time"
 )
 
+// 附带升级状态的节点状态
+type nodeStatusWithUpgrade struct {
+	*nodeconfigs.NodeStatus
+
+	Upgrade *UpgradeState `json:"upgrade,omitempty"`
+}
+
 type NodeStatusExecutor struct {
 	isFirstTime     bool
 	lastUpdatedTime time.Time
@@ -132,7 +139,10 @@ func (this *NodeStatusExecutor) update() {
 	status.Timestamp = status.UpdatedAt
 
 	//  发送数据
-	jsonData, err := json.Marshal(status)
+	jsonData, err := json.Marshal(&nodeStatusWithUpgrade{
+		NodeStatus: status,
+		Upgrade:    loadUpgradeState(),
+	})
 	if err != nil {
 		remotelogs.Error("NODE_STATUS", "serial NodeStatus fail: "+err.Error())
 		return
@@ -158,6 +158,7 @@ func (this *Node) execNodeLevelChangedTask(rpcClient *rpc.RPCClient) error {
 
 	if sharedNodeConfig != nil {
 		sharedNodeConfig.ParentNodes = parentNodes
+		sharedHTTPCachePeerManager.UpdateClusterNodes(parentNodes, sharedNodeConfig.Id)
 	}
 
 	return nil
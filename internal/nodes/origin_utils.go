@@ -6,6 +6,7 @@ import (
 	"github.com/TeaOSLab/EdgeCommon/pkg/configutils"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/resolvers"
 	"github.com/iwind/TeaGo/types"
 	"net"
 	"strconv"
@@ -16,6 +17,19 @@ func OriginConnect(origin *serverconfigs.OriginConfig, serverPort int, remoteAdd
 	if origin.Addr == nil {
 		return nil, "", errors.New("origin server address should not be empty")
 	}
+	return originConnect(origin, origin.Addr.Protocol, serverPort, remoteAddr, tlsHost)
+}
+
+// OriginConnectRaw 使用TCP连接源站，不管源站协议是否为TLS，用于TLS透传
+func OriginConnectRaw(origin *serverconfigs.OriginConfig, serverPort int, remoteAddr string) (originConn net.Conn, originAddr string, err error) {
+	if origin.Addr == nil {
+		return nil, "", errors.New("origin server address should not be empty")
+	}
+	return originConnect(origin, serverconfigs.ProtocolTCP, serverPort, remoteAddr, "")
+}
+
+// 使用指定的协议连接源站
+func originConnect(origin *serverconfigs.OriginConfig, protocol serverconfigs.Protocol, serverPort int, remoteAddr string, tlsHost string) (originConn net.Conn, originAddr string, err error) {
 
 	// 支持TOA的连接
 	// 这个条件很重要，如果没有传递remoteAddr，表示不使用TOA
@@ -42,12 +56,20 @@ func OriginConnect(origin *serverconfigs.OriginConfig, serverPort int, remoteAdd
 						originAddr = configutils.QuoteIP(origin.Addr.Host) + ":" + types.String(serverPort)
 					}
 
+					var dialAddrs []string
+					originAddr, dialAddrs, err = resolveOriginAddr(originAddr)
+					if err != nil {
+						return nil, originAddr, err
+					}
+
 					var conn net.Conn
-					switch origin.Addr.Protocol {
+					switch protocol {
 					case "", serverconfigs.ProtocolTCP, serverconfigs.ProtocolHTTP:
 						// TODO 支持TCP4/TCP6
 						// TODO 支持指定特定网卡
-						conn, err = dialer.Dial("tcp", originAddr)
+						conn, err = dialOriginAddrs(dialAddrs, func(dialAddr string) (net.Conn, error) {
+							return dialer.Dial("tcp", dialAddr)
+						})
 					case serverconfigs.ProtocolTLS, serverconfigs.ProtocolHTTPS:
 						// TODO 支持TCP4/TCP6
 						// TODO 支持指定特定网卡
@@ -68,8 +90,13 @@ func OriginConnect(origin *serverconfigs.OriginConfig, serverPort int, remoteAdd
 						if len(tlsHost) > 0 {
 							tlsConfig.ServerName = tlsHost
 						}
+						if len(tlsConfig.ServerName) == 0 {
+							tlsConfig.ServerName = originTLSServerName(originAddr)
+						}
 
-						conn, err = tls.DialWithDialer(&dialer, "tcp", originAddr, tlsConfig)
+						conn, err = dialOriginAddrs(dialAddrs, func(dialAddr string) (net.Conn, error) {
+							return tls.DialWithDialer(&dialer, "tcp", dialAddr, tlsConfig)
+						})
 					}
 
 					// TODO 需要在合适的时机删除TOA记录
@@ -88,11 +115,20 @@ func OriginConnect(origin *serverconfigs.OriginConfig, serverPort int, remoteAdd
 		originAddr = configutils.QuoteIP(origin.Addr.Host) + ":" + types.String(serverPort)
 	}
 
-	switch origin.Addr.Protocol {
+	// 使用节点的DNS缓存解析源站域名
+	var dialAddrs []string
+	originAddr, dialAddrs, err = resolveOriginAddr(originAddr)
+	if err != nil {
+		return nil, originAddr, err
+	}
+
+	switch protocol {
 	case "", serverconfigs.ProtocolTCP, serverconfigs.ProtocolHTTP:
 		// TODO 支持TCP4/TCP6
 		// TODO 支持指定特定网卡
-		originConn, err = net.DialTimeout("tcp", originAddr, origin.ConnTimeoutDuration())
+		originConn, err = dialOriginAddrs(dialAddrs, func(dialAddr string) (net.Conn, error) {
+			return net.DialTimeout("tcp", dialAddr, origin.ConnTimeoutDuration())
+		})
 		return originConn, originAddr, err
 	case serverconfigs.ProtocolTLS, serverconfigs.ProtocolHTTPS:
 		// TODO 支持TCP4/TCP6
@@ -114,11 +150,17 @@ func OriginConnect(origin *serverconfigs.OriginConfig, serverPort int, remoteAdd
 		if len(tlsHost) > 0 {
 			tlsConfig.ServerName = tlsHost
 		}
+		if len(tlsConfig.ServerName) == 0 {
+			tlsConfig.ServerName = originTLSServerName(originAddr)
+		}
 
-		originConn, err = tls.Dial("tcp", originAddr, tlsConfig)
+		originConn, err = dialOriginAddrs(dialAddrs, func(dialAddr string) (net.Conn, error) {
+			return tls.Dial("tcp", dialAddr, tlsConfig)
+		})
 		return originConn, originAddr, err
 	case serverconfigs.ProtocolUDP:
-		addr, err := net.ResolveUDPAddr("udp", originAddr)
+		// UDP无法通过连接判断地址是否可用，所以只使用第一个地址
+		addr, err := net.ResolveUDPAddr("udp", dialAddrs[0])
 		if err != nil {
 			return nil, originAddr, err
 		}
@@ -128,5 +170,39 @@ func OriginConnect(origin *serverconfigs.OriginConfig, serverPort int, remoteAdd
 
 	// TODO 支持从Unix、Pipe、HTTP、HTTPS中读取数据
 
-	return nil, originAddr, errors.New("invalid origin scheme '" + origin.Addr.Protocol.String() + "'")
+	return nil, originAddr, errors.New("invalid origin scheme '" + protocol.String() + "'")
+}
+
+// 解析源站地址，返回展开SRV名称后的源站地址和所有可以连接的地址
+func resolveOriginAddr(originAddr string) (addr string, dialAddrs []string, err error) {
+	var resolver = resolvers.SharedResolver()
+	addr, err = resolver.ResolveSRVAddr(originAddr)
+	if err != nil {
+		return originAddr, nil, err
+	}
+	dialAddrs, err = resolver.ResolveAddrs(addr)
+	return addr, dialAddrs, err
+}
+
+// 依次连接多个地址，直到连接成功
+func dialOriginAddrs(dialAddrs []string, dial func(dialAddr string) (net.Conn, error)) (conn net.Conn, err error) {
+	if len(dialAddrs) == 0 {
+		return nil, errors.New("no address to dial")
+	}
+	for _, dialAddr := range dialAddrs {
+		conn, err = dial(dialAddr)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// 使用IP连接源站时，仍然使用源站域名作为TLS的ServerName
+func originTLSServerName(originAddr string) string {
+	host, _, err := net.SplitHostPort(originAddr)
+	if err != nil || net.ParseIP(host) != nil {
+		return ""
+	}
+	return host
 }
@@ -0,0 +1,64 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDialOriginAddrs(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	// 已经关闭的端口
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var closedAddr = closedListener.Addr().String()
+	_ = closedListener.Close()
+
+	var dialedAddrs = []string{}
+	var dial = func(dialAddr string) (net.Conn, error) {
+		dialedAddrs = append(dialedAddrs, dialAddr)
+		return net.Dial("tcp", dialAddr)
+	}
+
+	conn, err := dialOriginAddrs([]string{closedAddr, listener.Addr().String()}, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if len(dialedAddrs) != 2 || conn.RemoteAddr().String() != listener.Addr().String() {
+		t.Fatal("should fall back to the next address:", dialedAddrs)
+	}
+
+	_, err = dialOriginAddrs([]string{closedAddr}, dial)
+	if err == nil {
+		t.Fatal("expect dial error")
+	}
+
+	_, err = dialOriginAddrs(nil, dial)
+	if err == nil {
+		t.Fatal("expect error without addresses")
+	}
+}
+
+func TestOriginTLSServerName(t *testing.T) {
+	for addr, serverName := range map[string]string{
+		"origin.example.com:443": "origin.example.com",
+		"192.168.1.100:443":      "",
+		"[::1]:443":              "",
+		"origin.example.com":     "",
+	} {
+		if originTLSServerName(addr) != serverName {
+			t.Fatal("unexpected server name for '" + addr + "': " + originTLSServerName(addr))
+		}
+	}
+}
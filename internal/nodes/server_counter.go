@@ -0,0 +1,44 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"github.com/iwind/TeaGo/types"
+	"sync"
+)
+
+// 按网站统计的活跃连接数
+type serverCounter struct {
+	m      map[int64]int64 // serverId => count
+	locker sync.Mutex
+}
+
+func newServerCounter() *serverCounter {
+	return &serverCounter{
+		m: map[int64]int64{},
+	}
+}
+
+// Add 增加或减少某个网站的计数
+func (this *serverCounter) Add(serverId int64, delta int64) {
+	this.locker.Lock()
+	var count = this.m[serverId] + delta
+	if count <= 0 {
+		delete(this.m, serverId)
+	} else {
+		this.m[serverId] = count
+	}
+	this.locker.Unlock()
+}
+
+// Counts 获取所有网站的计数
+func (this *serverCounter) Counts() map[string]int64 {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var result = map[string]int64{}
+	for serverId, count := range this.m {
+		result[types.String(serverId)] = count
+	}
+	return result
+}
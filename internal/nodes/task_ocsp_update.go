@@ -51,6 +51,10 @@ func NewOCSPUpdateTask() *OCSPUpdateTask {
 }
 
 func (this *OCSPUpdateTask) Start() {
+	if teaconst.IsStandalone {
+		return
+	}
+
 	for range this.ticker.C {
 		err := this.Loop()
 		if err != nil {
@@ -41,6 +41,10 @@ func NewSyncAPINodesTask() *SyncAPINodesTask {
 }
 
 func (this *SyncAPINodesTask) Start() {
+	if teaconst.IsStandalone {
+		return
+	}
+
 	this.ticker = time.NewTicker(5 * time.Minute)
 	if Tea.IsTesting() {
 		// 快速测试
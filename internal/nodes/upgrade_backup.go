@@ -0,0 +1,149 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"github.com/iwind/TeaGo/Tea"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 备份目录中记录新增文件的清单
+const upgradeBackupManifest = ".manifest.json"
+
+// UpgradeBackup 升级前备份将被覆盖的文件，用于回滚整个安装包
+type UpgradeBackup struct {
+	targetDir string
+	backupDir string
+}
+
+// NewUpgradeBackup 获取新对象
+func NewUpgradeBackup(targetDir string) *UpgradeBackup {
+	return &UpgradeBackup{
+		targetDir: targetDir,
+		backupDir: Tea.Root + "/data/upgrade-backup",
+	}
+}
+
+// 备份清单
+type upgradeBackupManifestData struct {
+	NewFiles []string `json:"newFiles"` // 升级前不存在的文件
+}
+
+// Backup 将安装包中会覆盖的文件移动到备份目录
+func (this *UpgradeBackup) Backup(zipPath string, stripPrefix string) error {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	err = os.RemoveAll(this.backupDir)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(this.backupDir, 0777)
+	if err != nil {
+		return err
+	}
+
+	var manifest = &upgradeBackupManifestData{}
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		var filename = strings.TrimPrefix(file.Name, stripPrefix)
+		if len(filename) == 0 || strings.HasPrefix(filepath.Clean("/"+filename), "/..") || filename == upgradeBackupManifest {
+			continue
+		}
+
+		var target = this.targetDir + "/" + filename
+		_, err = os.Stat(target)
+		if err != nil {
+			if os.IsNotExist(err) {
+				manifest.NewFiles = append(manifest.NewFiles, filename)
+				continue
+			}
+			return err
+		}
+
+		var backupPath = this.backupDir + "/" + filename
+		err = os.MkdirAll(filepath.Dir(backupPath), 0777)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(target, backupPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(this.backupDir+"/"+upgradeBackupManifest, manifestJSON, 0666)
+}
+
+// Restore 还原备份的文件，并删除升级时新增的文件
+func (this *UpgradeBackup) Restore() error {
+	_, err := os.Stat(this.backupDir)
+	if err != nil {
+		return errors.New("can not find backup of previous version: " + err.Error())
+	}
+
+	// 备份中途失败时没有清单，只还原已经备份的文件
+	var manifest = &upgradeBackupManifestData{}
+	manifestJSON, err := os.ReadFile(this.backupDir + "/" + upgradeBackupManifest)
+	if err == nil {
+		err = json.Unmarshal(manifestJSON, manifest)
+		if err != nil {
+			return errors.New("decode backup manifest failed: " + err.Error())
+		}
+	}
+
+	err = filepath.Walk(this.backupDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		filename, err := filepath.Rel(this.backupDir, path)
+		if err != nil {
+			return err
+		}
+		if filename == upgradeBackupManifest {
+			return nil
+		}
+		var target = this.targetDir + "/" + filepath.ToSlash(filename)
+		err = os.MkdirAll(filepath.Dir(target), 0777)
+		if err != nil {
+			return err
+		}
+		return os.Rename(path, target)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, filename := range manifest.NewFiles {
+		err = os.Remove(this.targetDir + "/" + filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.RemoveAll(this.backupDir)
+}
+
+// Remove 删除备份
+func (this *UpgradeBackup) Remove() error {
+	return os.RemoveAll(this.backupDir)
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/TeaOSLab/EdgeNode/internal/caches"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/iwind/TeaGo/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 等待新进程启动完成的时间
+const upgradeReadyTimeout = 60 * time.Second
+
+// HandOff 热升级：启动新的可执行文件并将监听的端口交给新进程
+// 新进程启动完成后，当前进程停止接收新的连接，并在 drainTimeout 内等待已有连接处理完成后退出
+func (this *UpgradeManager) HandOff(drainTimeout time.Duration) error {
+	if this.isHandingOff {
+		return errors.New("the process is already handing off")
+	}
+	this.isHandingOff = true
+
+	// 在新进程启动之前写入缓存快照，新进程启动时从快照中恢复
+	caches.SharedManager.CloseSnapshots()
+
+	err := this.startNewProcess()
+	if err != nil {
+		caches.SharedManager.ResumeSnapshots()
+		this.isHandingOff = false
+		return err
+	}
+
+	remotelogs.Println("UPGRADE_MANAGER", "new process is ready, draining connections ...")
+
+	// 停止接收新的连接，等待已有连接处理完成后退出
+	events.Notify(events.EventQuit)
+	goman.New(func() {
+		sharedListenerManager.Shutdown(drainTimeout)
+		utils.Exit()
+	})
+
+	return nil
+}
+
+// IsHandingOff 是否正在将端口交给新进程
+func (this *UpgradeManager) IsHandingOff() bool {
+	return this.isHandingOff
+}
+
+// 启动新进程并等待其启动完成
+func (this *UpgradeManager) startNewProcess() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe = filepath.Dir(exe) + "/" + teaconst.ProcessName
+
+	var listenerFiles = sharedListenerManager.Files()
+	defer func() {
+		for _, listenerFile := range listenerFiles {
+			_ = listenerFile.File.Close()
+		}
+	}()
+
+	// 新进程的文件描述符：3 为通知管道，之后依次为监听器
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = readyReader.Close()
+	}()
+
+	var extraFiles = []*os.File{readyWriter}
+	var inheritedFiles = []*InheritedListenerFile{}
+	for index, listenerFile := range listenerFiles {
+		extraFiles = append(extraFiles, listenerFile.File)
+		inheritedFiles = append(inheritedFiles, &InheritedListenerFile{
+			Key: listenerFile.Key,
+			FD:  4 + index,
+		})
+	}
+	inheritedJSON, err := json.Marshal(inheritedFiles)
+	if err != nil {
+		_ = readyWriter.Close()
+		return err
+	}
+
+	// 新进程需要监听本地sock，所以先关闭当前的
+	var sock = nodeInstance.sock
+	_ = sock.Close()
+	var relistenSock = func() {
+		goman.New(func() {
+			err := sock.Listen()
+			if err != nil {
+				remotelogs.Error("UPGRADE_MANAGER", "listen sock failed: "+err.Error())
+			}
+		})
+	}
+
+	var env = []string{}
+	for _, item := range os.Environ() {
+		// 新进程不是由守护进程直接启动的
+		if strings.HasPrefix(item, "EdgeDaemon=") {
+			continue
+		}
+		env = append(env, item)
+	}
+	env = append(env, "EdgeBackground=on", envUpgradeReadyFD+"=3", envInheritedListeners+"="+string(inheritedJSON))
+
+	var cmd = exec.Command(exe)
+	cmd.Env = env
+	cmd.ExtraFiles = extraFiles
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Foreground: false,
+		Setsid:     true,
+	}
+	err = cmd.Start()
+	_ = readyWriter.Close()
+	if err != nil {
+		relistenSock()
+		return err
+	}
+
+	remotelogs.Println("UPGRADE_MANAGER", "started new process '"+types.String(cmd.Process.Pid)+"' with "+types.String(len(listenerFiles))+" listener(s)")
+
+	// 等待新进程通知
+	_ = readyReader.SetReadDeadline(time.Now().Add(upgradeReadyTimeout))
+	var buf = make([]byte, 2)
+	n, err := readyReader.Read(buf)
+	if err != nil || string(buf[:n]) != "ok" {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		relistenSock()
+		if err == nil {
+			err = errors.New("unexpected response '" + string(buf[:n]) + "'")
+		}
+		return errors.New("wait for new process failed: " + err.Error())
+	}
+
+	_ = cmd.Process.Release()
+	return nil
+}
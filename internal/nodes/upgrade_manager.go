@@ -4,31 +4,39 @@ package nodes
 
 import (
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"github.com/TeaOSLab/EdgeCommon/pkg/rpc/pb"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
 	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
 	"github.com/TeaOSLab/EdgeNode/internal/events"
 	"github.com/TeaOSLab/EdgeNode/internal/goman"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
 	"github.com/TeaOSLab/EdgeNode/internal/rpc"
 	"github.com/TeaOSLab/EdgeNode/internal/utils"
-	executils "github.com/TeaOSLab/EdgeNode/internal/utils/exec"
 	"github.com/iwind/TeaGo/Tea"
+	"github.com/iwind/TeaGo/types"
 	stringutil "github.com/iwind/TeaGo/utils/string"
-	"github.com/iwind/gosock/pkg/gosock"
 	"os"
 	"path/filepath"
 	"runtime"
+	"syscall"
 	"time"
 )
 
 var sharedUpgradeManager = NewUpgradeManager()
 
+// 节点升级配置，从 configs/local.yaml 中加载
+var sharedUpgradeConfig = &configs.UpgradeConfig{}
+
 // UpgradeManager 节点升级管理器
 // TODO 需要在集群中设置是否自动更新
 type UpgradeManager struct {
-	isInstalling bool
-	lastFile     string
+	isInstalling     bool
+	isHandingOff     bool
+	lastFile         string
+	lastVersion      string
+	installedVersion string // 刚刚安装的版本
 }
 
 // NewUpgradeManager 获取新对象
@@ -49,6 +57,7 @@ func (this *UpgradeManager) Start() {
 	this.isInstalling = true
 
 	remotelogs.Println("UPGRADE_MANAGER", "upgrading node ...")
+	this.installedVersion = ""
 	err := this.install()
 	if err != nil {
 		remotelogs.Error("UPGRADE_MANAGER", "download failed: "+err.Error())
@@ -57,12 +66,24 @@ func (this *UpgradeManager) Start() {
 		return
 	}
 
-	remotelogs.Println("UPGRADE_MANAGER", "upgrade successfully")
+	// 没有新的版本
+	if len(this.installedVersion) == 0 {
+		this.isInstalling = false
+		return
+	}
+
+	remotelogs.Println("UPGRADE_MANAGER", "upgrade to '"+this.installedVersion+"' successfully")
 
 	goman.New(func() {
-		err = this.restart()
+		// 将端口交给新进程，新进程启动失败时回滚
+		err = this.HandOff(DefaultListenerDrainTimeout)
 		if err != nil {
-			remotelogs.Error("UPGRADE_MANAGER", err.Error())
+			remotelogs.Error("UPGRADE_MANAGER", "start new process failed: "+err.Error())
+
+			err = this.rollback("start new process failed: " + err.Error())
+			if err != nil {
+				remotelogs.Error("UPGRADE_MANAGER", "rollback failed: "+err.Error())
+			}
 		}
 
 		this.isInstalling = false
@@ -84,6 +105,7 @@ func (this *UpgradeManager) install() error {
 				return err
 			}
 			this.lastFile = ""
+			this.startProbation(this.lastVersion)
 			return nil
 		}
 	}
@@ -125,6 +147,7 @@ func (this *UpgradeManager) install() error {
 	var h = md5.New()
 	var sum = ""
 	var filename = ""
+	var version = ""
 	for {
 		resp, err := client.NodeRPC.DownloadNodeInstallationFile(client.Context(), &pb.DownloadNodeInstallationFileRequest{
 			Os:          runtime.GOOS,
@@ -139,6 +162,7 @@ func (this *UpgradeManager) install() error {
 		}
 		sum = resp.Sum
 		filename = resp.Filename
+		version = resp.Version
 		if stringutil.VersionCompare(resp.Version, teaconst.Version) <= 0 {
 			return nil
 		}
@@ -181,12 +205,15 @@ func (this *UpgradeManager) install() error {
 		return err
 	}
 	this.lastFile = zipPath
+	this.lastVersion = version
 
 	// 解压
 	err = this.unzip(zipPath)
 	if err != nil {
 		return err
 	}
+	this.lastFile = ""
+	this.startProbation(version)
 
 	return nil
 }
@@ -201,20 +228,33 @@ func (this *UpgradeManager) unzip(zipPath string) error {
 		}
 	}()
 
-	// 解压
-	var target = Tea.Root
-	if Tea.IsTesting() {
-		// 测试环境下只解压在tmp目录
-		target = Tea.Root + "/tmp"
+	// 校验签名
+	err := this.verify(zipPath)
+	if err != nil {
+		_ = os.Remove(zipPath)
+		return errors.New("verify package failed: " + err.Error())
 	}
 
-	// 先改先前的可执行文件
-	err := os.Rename(target+"/bin/"+teaconst.ProcessName, target+"/bin/."+teaconst.ProcessName+".dist")
-	var hasBackup = err == nil
+	// 解压
+	var target = this.targetDir()
+
+	// 先备份将被覆盖的文件，同时作为回滚时使用的备份
+	var backup = NewUpgradeBackup(target)
+	err = backup.Backup(zipPath, "edge-node/")
+	if err != nil {
+		restoreErr := backup.Restore()
+		if restoreErr != nil {
+			remotelogs.Error("UPGRADE_MANAGER", "restore files failed: "+restoreErr.Error())
+		}
+		return errors.New("backup files failed: " + err.Error())
+	}
 	defer func() {
-		if !isOk && hasBackup {
+		if !isOk {
 			// 失败时还原
-			_ = os.Rename(target+"/bin/."+teaconst.ProcessName+".dist", target+"/bin/"+teaconst.ProcessName)
+			restoreErr := backup.Restore()
+			if restoreErr != nil {
+				remotelogs.Error("UPGRADE_MANAGER", "restore files failed: "+restoreErr.Error())
+			}
 		}
 	}()
 
@@ -229,38 +269,156 @@ func (this *UpgradeManager) unzip(zipPath string) error {
 	return nil
 }
 
-// 重启
-func (this *UpgradeManager) restart() error {
-	// 关闭当前sock，防止无法重启
-	_ = gosock.NewTmpSock(teaconst.ProcessName).Close()
+// 校验安装包签名
+// 没有内置公钥时拒绝安装，除非在本地配置中明确允许安装未签名的升级包
+func (this *UpgradeManager) verify(zipPath string) error {
+	if len(teaconst.UpgradePublicKey) == 0 {
+		if sharedUpgradeConfig.AllowUnsigned {
+			remotelogs.Warn("UPGRADE_MANAGER", "no pinned public key was built in, install unsigned package because 'upgrade.allowUnsigned' is on")
+			return nil
+		}
+		return errors.New("no pinned public key was built in, set 'upgrade.allowUnsigned' in '" + configs.LocalConfigFile + "' to install unsigned packages")
+	}
+	publicKey, err := utils.ParseEd25519PublicKey(teaconst.UpgradePublicKey)
+	if err != nil {
+		return errors.New("invalid pinned public key: " + err.Error())
+	}
+	return utils.NewZipSignatureVerifier(zipPath, publicKey, "edge-node/").Verify()
+}
+
+// 解压的目标目录
+func (this *UpgradeManager) targetDir() string {
+	if Tea.IsTesting() {
+		// 测试环境下只解压在tmp目录
+		return Tea.Root + "/tmp"
+	}
+	return Tea.Root
+}
+
+// 开始试用期
+func (this *UpgradeManager) startProbation(version string) {
+	this.installedVersion = version
+
+	var state = &UpgradeState{
+		FromVersion:     teaconst.Version,
+		ToVersion:       version,
+		Status:          UpgradeStatusProbation,
+		UpgradedAt:      time.Now().Unix(),
+		FailedListeners: sharedListenerManager.CountFailedListeners(),
+	}
+	err := state.Save()
+	if err != nil {
+		remotelogs.Error("UPGRADE_MANAGER", "save upgrade state failed: "+err.Error())
+	}
+}
+
+// 回滚到升级前的所有文件
+func (this *UpgradeManager) rollback(reason string) error {
+	err := NewUpgradeBackup(this.targetDir()).Restore()
+	if err != nil {
+		return err
+	}
+
+	var state = loadUpgradeState()
+	if state == nil {
+		state = &UpgradeState{}
+	}
+	state.Status = UpgradeStatusRolledBack
+	state.Reason = reason
+	err = state.Save()
+	if err != nil {
+		remotelogs.Error("UPGRADE_MANAGER", "save upgrade state failed: "+err.Error())
+	}
+
+	remotelogs.Error("UPGRADE_MANAGER", "rolled back from '"+state.ToVersion+"' to '"+state.FromVersion+"': "+reason)
+	return nil
+}
+
+// CheckStart 新版本启动时检查，如果在试用期内再次启动，说明之前的进程已经崩溃，需要回滚
+func (this *UpgradeManager) CheckStart() {
+	var state = loadUpgradeState()
+	if state == nil || state.Status != UpgradeStatusProbation || state.ToVersion != teaconst.Version {
+		return
+	}
+
+	if !state.IsInProbation() {
+		state.Status = UpgradeStatusOk
+		_ = state.Save()
+		_ = NewUpgradeBackup(this.targetDir()).Remove()
+		return
+	}
 
-	// 重新启动
-	if DaemonIsOn && DaemonPid == os.Getppid() {
-		utils.Exit() // TODO 试着更优雅重启
-	} else {
+	state.Starts++
+	if state.Starts > 1 {
+		err := this.rollback("the new version crashed within probation period")
+		if err != nil {
+			remotelogs.Error("UPGRADE_MANAGER", "rollback failed: "+err.Error())
+			return
+		}
+
+		// 使用老版本替换当前进程
 		exe, err := os.Executable()
+		if err == nil {
+			err = syscall.Exec(filepath.Dir(exe)+"/"+teaconst.ProcessName, os.Args, os.Environ())
+		}
 		if err != nil {
-			return err
+			remotelogs.Error("UPGRADE_MANAGER", "exec previous version failed: "+err.Error())
 		}
+		return
+	}
+	err := state.Save()
+	if err != nil {
+		remotelogs.Error("UPGRADE_MANAGER", "save upgrade state failed: "+err.Error())
+	}
 
-		// quit
-		events.Notify(events.EventQuit)
+	// 正常退出时不计入启动次数
+	events.OnKey(events.EventTerminated, this, func() {
+		var state = loadUpgradeState()
+		if state != nil && state.Status == UpgradeStatusProbation && state.Starts > 0 {
+			state.Starts--
+			_ = state.Save()
+		}
+	})
+}
+
+// WatchProbation 新版本启动端口监听后检查，端口监听失败时回滚，试用期结束后标记为升级成功
+func (this *UpgradeManager) WatchProbation() {
+	var state = loadUpgradeState()
+	if state == nil || !state.IsInProbation() || state.ToVersion != teaconst.Version {
+		return
+	}
 
-		// terminated
-		events.Notify(events.EventTerminated)
+	var failedListeners = sharedListenerManager.CountFailedListeners()
+	if failedListeners > state.FailedListeners {
+		err := this.rollback(types.String(failedListeners-state.FailedListeners) + " listener(s) failed to bind")
+		if err == nil {
+			err = this.HandOff(DefaultListenerDrainTimeout)
+		}
+		if err != nil {
+			remotelogs.Error("UPGRADE_MANAGER", "rollback failed: "+err.Error())
+		}
+		return
+	}
 
-		// 启动
-		exe = filepath.Dir(exe) + "/" + teaconst.ProcessName
+	goman.New(func() {
+		time.Sleep(time.Until(time.Unix(state.UpgradedAt, 0).Add(upgradeProbationPeriod)))
 
-		var cmd = executils.NewCmd(exe, "start")
-		err = cmd.Start()
+		var state = loadUpgradeState()
+		if state == nil || state.Status != UpgradeStatusProbation || state.ToVersion != teaconst.Version {
+			return
+		}
+		state.Status = UpgradeStatusOk
+		err := state.Save()
 		if err != nil {
-			return err
+			remotelogs.Error("UPGRADE_MANAGER", "save upgrade state failed: "+err.Error())
+			return
 		}
+		remotelogs.Println("UPGRADE_MANAGER", "version '"+state.ToVersion+"' passed probation")
 
-		// 退出当前进程
-		time.Sleep(1 * time.Second)
-		os.Exit(0)
-	}
-	return nil
+		// 不再需要回滚
+		err = NewUpgradeBackup(this.targetDir()).Remove()
+		if err != nil {
+			remotelogs.Warn("UPGRADE_MANAGER", "remove upgrade backup failed: "+err.Error())
+		}
+	})
 }
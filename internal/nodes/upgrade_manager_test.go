@@ -3,7 +3,16 @@
 package nodes
 
 import (
+	"archive/zip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/iwind/TeaGo/Tea"
 	_ "github.com/iwind/TeaGo/bootstrap"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -14,3 +23,112 @@ func TestUpgradeManager_install(t *testing.T) {
 	}
 	t.Log("ok")
 }
+
+func TestUpgradeBackup(t *testing.T) {
+	var targetDir = Tea.Root + "/tmp/upgrade-target"
+	_ = os.RemoveAll(targetDir)
+	defer func() {
+		_ = os.RemoveAll(targetDir)
+	}()
+	_ = os.MkdirAll(targetDir+"/bin", 0777)
+	_ = os.WriteFile(targetDir+"/bin/edge-node", []byte("v1"), 0666)
+
+	// 安装包
+	var zipPath = Tea.Root + "/tmp/upgrade-backup-test.zip"
+	defer func() {
+		_ = os.Remove(zipPath)
+	}()
+	fp, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zipWriter = zip.NewWriter(fp)
+	for _, file := range [][2]string{
+		{"edge-node/bin/edge-node", "v2"},
+		{"edge-node/www/", ""},
+		{"edge-node/www/new-file.html", "new"},
+	} {
+		writer, err := zipWriter.Create(file[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = writer.Write([]byte(file[1]))
+	}
+	_ = zipWriter.Close()
+	_ = fp.Close()
+
+	var backup = NewUpgradeBackup(targetDir)
+	err = backup.Backup(zipPath, "edge-node/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = utils.NewUnzip(zipPath, targetDir, "edge-node/").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = backup.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(targetDir + "/bin/edge-node")
+	if string(data) != "v1" {
+		t.Fatal("executable file should be restored, but got:", string(data))
+	}
+	_, err = os.Stat(targetDir + "/www/new-file.html")
+	if !os.IsNotExist(err) {
+		t.Fatal("new file should be removed")
+	}
+}
+
+func TestUpgradeManager_verify(t *testing.T) {
+	var zipPath = filepath.Join(t.TempDir(), "edge-node.zip")
+	fp, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zipWriter = zip.NewWriter(fp)
+	writer, err := zipWriter.Create("edge-node/bin/edge-node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = writer.Write([]byte("v2"))
+	_ = zipWriter.Close()
+	_ = fp.Close()
+
+	var oldPublicKey = teaconst.UpgradePublicKey
+	var oldConfig = sharedUpgradeConfig
+	defer func() {
+		teaconst.UpgradePublicKey = oldPublicKey
+		sharedUpgradeConfig = oldConfig
+	}()
+
+	var manager = NewUpgradeManager()
+
+	// 没有内置公钥时默认拒绝
+	teaconst.UpgradePublicKey = ""
+	sharedUpgradeConfig = &configs.UpgradeConfig{}
+	err = manager.verify(zipPath)
+	if err == nil {
+		t.Fatal("unsigned package should be refused without pinned key")
+	}
+	t.Log("expected error:", err)
+
+	// 明确允许安装未签名的升级包
+	sharedUpgradeConfig = &configs.UpgradeConfig{AllowUnsigned: true}
+	err = manager.verify(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 有内置公钥时总是校验签名
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	teaconst.UpgradePublicKey = base64.StdEncoding.EncodeToString(publicKey)
+	err = manager.verify(zipPath)
+	if err == nil {
+		t.Fatal("unsigned package should be refused with pinned key")
+	}
+}
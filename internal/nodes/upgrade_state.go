@@ -0,0 +1,78 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"encoding/json"
+	"github.com/iwind/TeaGo/Tea"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 升级状态
+const (
+	UpgradeStatusProbation  = "probation"  // 试用期中
+	UpgradeStatusOk         = "ok"         // 升级成功
+	UpgradeStatusRolledBack = "rolledBack" // 已回滚
+)
+
+// 升级后的试用期，在此期间如果新进程启动失败、端口监听失败或者崩溃，则自动回滚
+const upgradeProbationPeriod = 5 * time.Minute
+
+// UpgradeState 最近一次升级的状态
+type UpgradeState struct {
+	FromVersion     string `json:"fromVersion"`
+	ToVersion       string `json:"toVersion"`
+	Status          string `json:"status"`
+	Reason          string `json:"reason"`          // 回滚原因
+	UpgradedAt      int64  `json:"upgradedAt"`      // 升级时间
+	UpdatedAt       int64  `json:"updatedAt"`       // 状态更新时间
+	Starts          int    `json:"starts"`          // 新版本在试用期内的启动次数
+	FailedListeners int    `json:"failedListeners"` // 升级前监听失败的端口数量
+}
+
+// 读取升级状态，不存在时返回nil
+func loadUpgradeState() *UpgradeState {
+	data, err := os.ReadFile(upgradeStatePath())
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var state = &UpgradeState{}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil
+	}
+	return state
+}
+
+// IsInProbation 是否在试用期中
+func (this *UpgradeState) IsInProbation() bool {
+	return this.Status == UpgradeStatusProbation && time.Now().Unix()-this.UpgradedAt < int64(upgradeProbationPeriod.Seconds())
+}
+
+// Save 保存状态
+func (this *UpgradeState) Save() error {
+	this.UpdatedAt = time.Now().Unix()
+
+	data, err := json.Marshal(this)
+	if err != nil {
+		return err
+	}
+
+	var path = upgradeStatePath()
+	err = os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return err
+	}
+	var tmpPath = path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0666)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func upgradeStatePath() string {
+	return Tea.Root + "/data/upgrade.json"
+}
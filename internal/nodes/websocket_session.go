@@ -0,0 +1,216 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"bytes"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/websockets"
+	"github.com/iwind/TeaGo/types"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 边缘节点发送的Ping数据前缀，客户端返回的对应Pong不再转发给源站
+var websocketPingPrefix = []byte("GOEDGE_PING_")
+
+// 当前活跃的Websocket会话数
+var sharedWebsocketCounter = newServerCounter()
+
+// Websocket会话
+// 在客户端和源站之间转发数据帧，同时检查消息尺寸和频率、发送Ping、关闭空闲连接
+type websocketSession struct {
+	clientConn   net.Conn
+	clientReader io.Reader
+	originConn   net.Conn
+	originReader io.Reader
+
+	policy *configs.WebSocketPolicy
+
+	clientLocker sync.Mutex // 向客户端写数据的锁，防止Ping插入到其他帧中间
+	activeAt     int64
+	isClosed     int32
+
+	onSent func(n int64) // 向客户端发送数据后的回调
+}
+
+func newWebsocketSession(clientConn net.Conn, clientReader io.Reader, originConn net.Conn, originReader io.Reader, policy *configs.WebSocketPolicy) *websocketSession {
+	return &websocketSession{
+		clientConn:   clientConn,
+		clientReader: clientReader,
+		originConn:   originConn,
+		originReader: originReader,
+		policy:       policy,
+		activeAt:     time.Now().Unix(),
+	}
+}
+
+// Run 开始转发数据，直到任何一方关闭连接
+func (this *websocketSession) Run() {
+	if this.policy.IdleTimeout > 0 || this.policy.PingInterval > 0 {
+		goman.New(func() {
+			this.keepAlive()
+		})
+	}
+
+	goman.New(func() {
+		_ = this.pipeOrigin()
+		this.Close()
+	})
+
+	var err = this.pipeClient()
+	switch err {
+	case websockets.ErrMessageTooBig:
+		this.closeClient(websockets.CloseMessageTooBig, "message too big")
+	case websockets.ErrTooManyMessages:
+		this.closeClient(websockets.ClosePolicyViolation, "too many messages")
+	}
+	this.Close()
+}
+
+// Close 关闭会话
+func (this *websocketSession) Close() {
+	if !atomic.CompareAndSwapInt32(&this.isClosed, 0, 1) {
+		return
+	}
+	_ = this.clientConn.Close()
+	_ = this.originConn.Close()
+}
+
+// 转发客户端发送的帧
+func (this *websocketSession) pipeClient() error {
+	var limiter = websockets.NewMessageLimiter(this.policy.MaxMessageSize, this.policy.MaxMessagesPerSecond)
+
+	var buf = utils.BytePool4k.Get()
+	defer utils.BytePool4k.Put(buf)
+
+	for {
+		header, err := websockets.ReadFrameHeader(this.clientReader)
+		if err != nil {
+			return err
+		}
+		this.active()
+
+		err = limiter.Check(header)
+		if err != nil {
+			return err
+		}
+
+		// 对边缘节点Ping的响应
+		if header.Opcode == websockets.OpPong {
+			var payload = make([]byte, header.Length)
+			_, err = io.ReadFull(this.clientReader, payload)
+			if err != nil {
+				return err
+			}
+			var data = append([]byte{}, payload...)
+			if header.Masked {
+				websockets.Unmask(header.MaskKey, data, 0)
+			}
+			if bytes.HasPrefix(data, websocketPingPrefix) {
+				continue
+			}
+			_, err = this.originConn.Write(append(header.Raw, payload...))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err = this.originConn.Write(header.Raw)
+		if err != nil {
+			return err
+		}
+		if header.Length > 0 {
+			_, err = io.CopyBuffer(this.originConn, io.LimitReader(this.clientReader, header.Length), buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// 转发源站发送的帧
+func (this *websocketSession) pipeOrigin() error {
+	var buf = utils.BytePool4k.Get()
+	defer utils.BytePool4k.Put(buf)
+
+	for {
+		header, err := websockets.ReadFrameHeader(this.originReader)
+		if err != nil {
+			return err
+		}
+		this.active()
+
+		this.clientLocker.Lock()
+		_, err = this.clientConn.Write(header.Raw)
+		var n int64
+		if err == nil && header.Length > 0 {
+			n, err = io.CopyBuffer(this.clientConn, io.LimitReader(this.originReader, header.Length), buf)
+		}
+		this.clientLocker.Unlock()
+
+		if this.onSent != nil {
+			this.onSent(int64(len(header.Raw)) + n)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 发送Ping并关闭空闲连接
+func (this *websocketSession) keepAlive() {
+	var ticker = time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	var lastPingAt = time.Now().Unix()
+	for range ticker.C {
+		if atomic.LoadInt32(&this.isClosed) == 1 {
+			return
+		}
+
+		var now = time.Now().Unix()
+		if this.policy.IdleTimeout > 0 && now-atomic.LoadInt64(&this.activeAt) >= int64(this.policy.IdleTimeout) {
+			this.closeClient(websockets.CloseGoingAway, "idle timeout")
+			this.Close()
+			return
+		}
+
+		// 正在向客户端发送其他帧时，等下一次再发送Ping
+		if this.policy.PingInterval > 0 && now-lastPingAt >= int64(this.policy.PingInterval) && this.clientLocker.TryLock() {
+			lastPingAt = now
+			_ = this.clientConn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			var err = websockets.WriteFrame(this.clientConn, websockets.OpPing, append(append([]byte{}, websocketPingPrefix...), types.String(now)...), nil)
+			_ = this.clientConn.SetWriteDeadline(time.Time{})
+			this.clientLocker.Unlock()
+			if err != nil {
+				this.Close()
+				return
+			}
+		}
+	}
+}
+
+// 向客户端发送关闭帧
+func (this *websocketSession) closeClient(code int, reason string) {
+	if atomic.LoadInt32(&this.isClosed) == 1 {
+		return
+	}
+	// 正在发送的帧还没有结束时，不能插入关闭帧
+	if !this.clientLocker.TryLock() {
+		return
+	}
+	_ = this.clientConn.SetWriteDeadline(time.Now().Add(1 * time.Second))
+	_ = websockets.WriteFrame(this.clientConn, websockets.OpClose, websockets.ClosePayload(code, reason), nil)
+	this.clientLocker.Unlock()
+}
+
+func (this *websocketSession) active() {
+	atomic.StoreInt64(&this.activeAt, time.Now().Unix())
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package nodes
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/websockets"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 启动一个会话，返回客户端和源站一侧的连接
+func startTestWebsocketSession(t *testing.T, policy *configs.WebSocketPolicy) (clientPeer net.Conn, originPeer net.Conn, session *websocketSession) {
+	clientConn, clientPeer := net.Pipe()
+	originConn, originPeer := net.Pipe()
+	t.Cleanup(func() {
+		_ = clientPeer.Close()
+		_ = originPeer.Close()
+	})
+
+	session = newWebsocketSession(clientConn, bufio.NewReader(clientConn), originConn, bufio.NewReader(originConn), policy)
+	go session.Run()
+	return
+}
+
+func readTestWebsocketFrame(t *testing.T, conn net.Conn) (*websockets.FrameHeader, []byte) {
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	header, err := websockets.ReadFrameHeader(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload = make([]byte, header.Length)
+	_, err = io.ReadFull(conn, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Masked {
+		websockets.Unmask(header.MaskKey, payload, 0)
+	}
+	return header, payload
+}
+
+func TestWebsocketSession_Forward(t *testing.T) {
+	var policy = parseTestLocalConfig(t, `
+websocket:
+  servers:
+    100:
+      maxMessageSize: 1024
+`).WebSocket.PolicyForServer(100)
+	clientPeer, originPeer, _ := startTestWebsocketSession(t, policy)
+	var maskKey = [4]byte{1, 2, 3, 4}
+
+	// 客户端 => 源站
+	go func() {
+		// 边缘节点Ping的响应不会转发给源站
+		_ = websockets.WriteFrame(clientPeer, websockets.OpPong, append(append([]byte{}, websocketPingPrefix...), "1"...), &maskKey)
+		_ = websockets.WriteFrame(clientPeer, websockets.OpText, []byte("hello"), &maskKey)
+	}()
+	header, payload := readTestWebsocketFrame(t, originPeer)
+	if header.Opcode != websockets.OpText || string(payload) != "hello" {
+		t.Fatal("unexpected frame:", header.Opcode, string(payload))
+	}
+
+	// 源站 => 客户端
+	go func() {
+		_ = websockets.WriteFrame(originPeer, websockets.OpBinary, []byte("world"), nil)
+	}()
+	header, payload = readTestWebsocketFrame(t, clientPeer)
+	if header.Opcode != websockets.OpBinary || string(payload) != "world" {
+		t.Fatal("unexpected frame:", header.Opcode, string(payload))
+	}
+}
+
+func TestWebsocketSession_MessageTooBig(t *testing.T) {
+	var policy = parseTestLocalConfig(t, `
+websocket:
+  default:
+    maxMessageSize: 4
+`).WebSocket.PolicyForServer(1)
+	clientPeer, _, session := startTestWebsocketSession(t, policy)
+
+	go func() {
+		_ = websockets.WriteFrame(clientPeer, websockets.OpText, []byte("too big message"), &[4]byte{1, 2, 3, 4})
+	}()
+	header, payload := readTestWebsocketFrame(t, clientPeer)
+	if header.Opcode != websockets.OpClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != websockets.CloseMessageTooBig {
+		t.Fatal("expect close frame, got:", header.Opcode, payload)
+	}
+
+	// 等待会话关闭
+	var before = time.Now()
+	for atomic.LoadInt32(&session.isClosed) == 0 {
+		if time.Since(before) > 3*time.Second {
+			t.Fatal("session should be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebsocketSession_IdleTimeout(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+
+	var policy = parseTestLocalConfig(t, `
+websocket:
+  default:
+    idleTimeout: 1
+`).WebSocket.PolicyForServer(1)
+	clientPeer, _, _ := startTestWebsocketSession(t, policy)
+
+	header, payload := readTestWebsocketFrame(t, clientPeer)
+	if header.Opcode != websockets.OpClose || binary.BigEndian.Uint16(payload) != websockets.CloseGoingAway {
+		t.Fatal("expect close frame, got:", header.Opcode, payload)
+	}
+}
+
+func TestWebsocketSession_InvalidPolicy(t *testing.T) {
+	_, err := configs.ParseLocalConfig([]byte("websocket:\n  default:\n    idleTimeout: 30\n    pingInterval: 60\n"))
+	if err == nil {
+		t.Fatal("ping interval larger than idle timeout should be rejected")
+	}
+}
@@ -316,6 +316,11 @@ Loop:
 		return nil
 	}
 
+	// 独立运行模式下日志已经打印到本地，不再上传
+	if teaconst.IsStandalone {
+		return nil
+	}
+
 	rpcClient, err := rpc.SharedRPC()
 	if err != nil {
 		return err
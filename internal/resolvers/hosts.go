@@ -0,0 +1,104 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package resolvers
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 检查hosts文件是否有变化的间隔
+const hostsCheckInterval = 5 * time.Second
+
+// 从hosts文件中读取的域名和IP对应关系
+// 和系统解析器一样，hosts文件中的域名优先于上游DNS服务器
+type hostsFile struct {
+	path string
+
+	ipMap     map[string][]string // 域名 => IP列表
+	modTime   time.Time
+	checkedAt time.Time
+
+	locker sync.Mutex
+}
+
+func newHostsFile(path string) *hostsFile {
+	return &hostsFile{
+		path:  path,
+		ipMap: map[string][]string{},
+	}
+}
+
+// Lookup 查找域名对应的IP
+func (this *hostsFile) Lookup(host string) []string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var now = time.Now()
+	if now.Sub(this.checkedAt) >= hostsCheckInterval {
+		this.checkedAt = now
+		this.reload()
+	}
+	return this.ipMap[host]
+}
+
+// 文件有变化时重新读取
+func (this *hostsFile) reload() {
+	stat, err := os.Stat(this.path)
+	if err != nil {
+		this.ipMap = map[string][]string{}
+		this.modTime = time.Time{}
+		return
+	}
+	if stat.ModTime().Equal(this.modTime) {
+		return
+	}
+
+	data, err := os.ReadFile(this.path)
+	if err != nil {
+		return
+	}
+	this.modTime = stat.ModTime()
+	this.ipMap = parseHosts(data)
+}
+
+// 解析hosts文件内容
+func parseHosts(data []byte) map[string][]string {
+	var ipMap = map[string][]string{}
+	var scanner = bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var line = scanner.Text()
+		var index = strings.IndexByte(line, '#')
+		if index >= 0 {
+			line = line[:index]
+		}
+		var fields = strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		// 去除IPv6地址中的区域，比如 fe80::1%lo0
+		var ipString = fields[0]
+		index = strings.IndexByte(ipString, '%')
+		if index >= 0 {
+			ipString = ipString[:index]
+		}
+		var ip = net.ParseIP(ipString)
+		if ip == nil {
+			continue
+		}
+
+		for _, name := range fields[1:] {
+			name = strings.TrimSuffix(strings.ToLower(name), ".")
+			ipMap[name] = append(ipMap[name], ip.String())
+		}
+	}
+	return ipMap
+}
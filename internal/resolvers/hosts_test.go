@@ -0,0 +1,81 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package resolvers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseHosts(t *testing.T) {
+	var ipMap = parseHosts([]byte(`
+# comment
+127.0.0.1 localhost
+192.168.1.10   origin.example.com origin # inline comment
+::1 localhost ip6-localhost
+fe80::1%lo0 link.local
+invalid-ip bad.example.com
+`))
+	if len(ipMap["localhost"]) != 2 || ipMap["origin"][0] != "192.168.1.10" || ipMap["link.local"][0] != "fe80::1" {
+		t.Fatal("unexpected result:", ipMap)
+	}
+	if len(ipMap["bad.example.com"]) > 0 {
+		t.Fatal("invalid ip should be ignored")
+	}
+}
+
+func TestResolver_LookupIP_Hosts(t *testing.T) {
+	var server = newTestDNSServer(t)
+	var resolver = newTestResolver(server.Addr())
+	defer func() {
+		_ = resolver.Close()
+	}()
+
+	var path = filepath.Join(t.TempDir(), "hosts")
+	err := os.WriteFile(path, []byte("10.0.0.1 origin.example.com\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver.hosts = newHostsFile(path)
+
+	// hosts文件中的域名不再查询上游DNS
+	ips, err := resolver.LookupIP("Origin.Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || ips[0] != "10.0.0.1" || server.Queries() != 0 {
+		t.Fatal("expect ip from hosts file, got", ips)
+	}
+
+	addrs, err := resolver.ResolveAddrs("origin.example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "10.0.0.1:80" {
+		t.Fatal("unexpected addrs:", addrs)
+	}
+
+	// 不在hosts文件中的域名仍然查询上游DNS
+	_, _ = resolver.LookupIP("www.example.com")
+	if server.Queries() == 0 {
+		t.Fatal("expect upstream query")
+	}
+}
+
+func TestResolver_LookupCNAME_NotFound(t *testing.T) {
+	var server = newTestDNSServer(t)
+	var resolver = newTestResolver(server.Addr())
+	defer func() {
+		_ = resolver.Close()
+	}()
+
+	// 没有CNAME记录时返回空字符串
+	cname, err := resolver.LookupCNAME("origin.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cname) != 0 {
+		t.Fatal("expect empty cname, got", cname)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package resolvers
+
+import (
+	"errors"
+	"github.com/miekg/dns"
+	"strings"
+	"time"
+)
+
+// ProbeDNS 向DNS服务器发送一次查询，检查服务器是否正常应答
+// 只要服务器返回了应答并且不是SERVFAIL，就认为服务器正常，NXDOMAIN、REFUSED 等都表示服务器仍在工作
+func ProbeDNS(addr string, domain string, queryType string, timeout time.Duration) error {
+	qType, ok := dns.StringToType[strings.ToUpper(queryType)]
+	if !ok {
+		return errors.New("invalid query type '" + queryType + "'")
+	}
+
+	var msg = &dns.Msg{}
+	msg.SetQuestion(dns.Fqdn(domain), qType)
+	msg.RecursionDesired = false
+
+	var client = &dns.Client{Net: "udp", Timeout: timeout}
+	resp, _, err := client.Exchange(msg, addr)
+	if err != nil {
+		return err
+	}
+	if resp.Id != msg.Id {
+		return errors.New("unexpected response id")
+	}
+	if resp.Rcode == dns.RcodeServerFailure {
+		return errors.New("server failure")
+	}
+	return nil
+}
@@ -0,0 +1,443 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package resolvers
+
+import (
+	"errors"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/goman"
+	"github.com/miekg/dns"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 最多缓存的记录数量
+const maxResolverCacheItems = 100_000
+
+var errNoServers = errors.New("no dns servers available")
+
+// NotFoundError 域名没有对应的记录
+type NotFoundError struct {
+	name string
+}
+
+func (this *NotFoundError) Error() string {
+	return "lookup '" + this.name + "': no such record"
+}
+
+// IsNotFound 判断错误是否为域名不存在
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// 缓存条目
+type cacheItem struct {
+	name  string
+	qType uint16
+
+	values  []string // IP或者CNAME
+	srvList []*SRV
+	err     error // 否定应答
+
+	ttl       int64
+	expiresAt int64
+	staleAt   int64 // 上游失败时过期结果可使用的截止时间
+
+	hits         int32 // 当前缓存周期内的访问次数
+	isRefreshing int32
+}
+
+// 正在进行中的查询，用来合并同时发起的相同查询
+type resolverCall struct {
+	wg   sync.WaitGroup
+	item *cacheItem
+	err  error
+}
+
+// Resolver 带缓存的域名解析器
+// 按照TTL缓存A/AAAA/SRV/CNAME查询结果，上游DNS失败时在一定时间内继续使用过期的结果，
+// 访问频繁的域名在过期前提前刷新；和系统解析器一样，/etc/hosts 中的域名优先于上游DNS服务器
+type Resolver struct {
+	config  *configs.ResolverConfig
+	servers []string
+	hosts   *hostsFile
+
+	udpClient *dns.Client
+	tcpClient *dns.Client
+
+	cacheMap map[string]*cacheItem // name@type => item
+	callMap  map[string]*resolverCall
+	locker   sync.RWMutex
+
+	ticker *time.Ticker
+}
+
+// NewResolver 获取新对象
+func NewResolver(config *configs.ResolverConfig) *Resolver {
+	var servers = config.Servers
+	if len(servers) == 0 {
+		clientConfig, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err == nil {
+			for _, server := range clientConfig.Servers {
+				servers = append(servers, net.JoinHostPort(server, clientConfig.Port))
+			}
+		}
+	}
+
+	var timeout = time.Duration(config.TimeoutMs) * time.Millisecond
+	var resolver = &Resolver{
+		config:    config,
+		servers:   servers,
+		hosts:     newHostsFile("/etc/hosts"),
+		udpClient: &dns.Client{Net: "udp", Timeout: timeout},
+		tcpClient: &dns.Client{Net: "tcp", Timeout: timeout},
+		cacheMap:  map[string]*cacheItem{},
+		callMap:   map[string]*resolverCall{},
+		ticker:    time.NewTicker(1 * time.Second),
+	}
+
+	goman.New(func() {
+		for range resolver.ticker.C {
+			resolver.refresh()
+		}
+	})
+
+	return resolver
+}
+
+// Servers 上游DNS服务器
+func (this *Resolver) Servers() []string {
+	return this.servers
+}
+
+// LookupIP 查询域名对应的IP，IPv4在前
+func (this *Resolver) LookupIP(host string) ([]string, error) {
+	// 优先使用hosts文件中的IP
+	var hostIPs = this.hosts.Lookup(host)
+	if len(hostIPs) > 0 {
+		return sortIPs(hostIPs), nil
+	}
+
+	var ipv4Item, ipv6Item *cacheItem
+	var ipv4Err, ipv6Err error
+
+	var wg = &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ipv6Item, ipv6Err = this.lookup(host, dns.TypeAAAA)
+	}()
+	ipv4Item, ipv4Err = this.lookup(host, dns.TypeA)
+	wg.Wait()
+
+	var result = []string{}
+	if ipv4Err == nil && ipv4Item.err == nil {
+		result = append(result, ipv4Item.values...)
+	}
+	if ipv6Err == nil && ipv6Item.err == nil {
+		result = append(result, ipv6Item.values...)
+	}
+	if len(result) > 0 {
+		return result, nil
+	}
+
+	// 优先返回上游的错误
+	if ipv4Err != nil {
+		return nil, ipv4Err
+	}
+	if ipv6Err != nil {
+		return nil, ipv6Err
+	}
+	return nil, &NotFoundError{name: host}
+}
+
+// LookupSRV 查询SRV记录
+func (this *Resolver) LookupSRV(name string) ([]*SRV, error) {
+	item, err := this.lookup(name, dns.TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+	if item.err != nil {
+		return nil, item.err
+	}
+	return item.srvList, nil
+}
+
+// LookupCNAME 查询CNAME记录，返回的域名以 . 结尾
+// 没有CNAME记录时返回空字符串
+func (this *Resolver) LookupCNAME(host string) (string, error) {
+	item, err := this.lookup(host, dns.TypeCNAME)
+	if err != nil {
+		return "", err
+	}
+	if item.err != nil {
+		if IsNotFound(item.err) {
+			return "", nil
+		}
+		return "", item.err
+	}
+	return item.values[0], nil
+}
+
+// ResolveAddr 将 host:port 形式的地址解析为 ip:port，只返回第一个候选地址
+func (this *Resolver) ResolveAddr(addr string) (string, error) {
+	addrs, err := this.ResolveAddrs(addr)
+	if err != nil {
+		return "", err
+	}
+	return addrs[0], nil
+}
+
+// ResolveAddrs 将 host:port 形式的地址解析为所有候选的 ip:port，IPv4在前，同类地址随机排序
+// 如果主机名为SRV名称（比如 _http._tcp.example.com），则先从SRV记录中选取目标主机和端口；
+// 普通域名优先使用 /etc/hosts 中的IP，解析失败时返回原地址，交给系统解析器处理
+func (this *Resolver) ResolveAddrs(addr string) ([]string, error) {
+	addr, err := this.ResolveSRVAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil || len(this.servers) == 0 {
+		return []string{addr}, nil
+	}
+
+	ips, err := this.LookupIP(host)
+	if err != nil {
+		return []string{addr}, nil
+	}
+
+	var addrs = []string{}
+	for _, ip := range sortIPs(ips) {
+		addrs = append(addrs, net.JoinHostPort(ip, port))
+	}
+	return addrs, nil
+}
+
+// ResolveSRVAddr 如果地址中的主机名为SRV名称，则返回选取的 目标主机:端口，否则返回原地址
+// SRV记录中的端口优先于地址中的端口
+func (this *Resolver) ResolveSRVAddr(addr string) (string, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if !IsSRVName(host) {
+		return addr, nil
+	}
+
+	srvList, err := this.LookupSRV(host)
+	if err != nil {
+		return "", err
+	}
+	var srv = pickSRV(srvList)
+	if srv == nil {
+		return "", &NotFoundError{name: host}
+	}
+	return net.JoinHostPort(srv.Target, strconv.Itoa(int(srv.Port))), nil
+}
+
+// Close 关闭解析器
+func (this *Resolver) Close() error {
+	this.ticker.Stop()
+	return nil
+}
+
+// 查询并缓存结果
+func (this *Resolver) lookup(name string, qType uint16) (*cacheItem, error) {
+	name = dns.Fqdn(strings.ToLower(name))
+	var key = name + "@" + dns.TypeToString[qType]
+	var now = time.Now().Unix()
+
+	this.locker.RLock()
+	item, ok := this.cacheMap[key]
+	this.locker.RUnlock()
+	if ok && item.expiresAt > now {
+		atomic.AddInt32(&item.hits, 1)
+		return item, nil
+	}
+
+	newItem, err := this.query(key, name, qType)
+	if err != nil {
+		// 上游失败时使用过期的结果
+		if ok && item.staleAt > now {
+			return item, nil
+		}
+		return nil, err
+	}
+	return newItem, nil
+}
+
+// 向上游查询，同时发起的相同查询只执行一次
+func (this *Resolver) query(key string, name string, qType uint16) (*cacheItem, error) {
+	this.locker.Lock()
+	call, ok := this.callMap[key]
+	if ok {
+		this.locker.Unlock()
+		call.wg.Wait()
+		return call.item, call.err
+	}
+	call = &resolverCall{}
+	call.wg.Add(1)
+	this.callMap[key] = call
+	this.locker.Unlock()
+
+	call.item, call.err = this.exchange(name, qType)
+
+	this.locker.Lock()
+	delete(this.callMap, key)
+	if call.err == nil {
+		_, exists := this.cacheMap[key]
+		if exists || len(this.cacheMap) < maxResolverCacheItems {
+			this.cacheMap[key] = call.item
+		}
+	}
+	this.locker.Unlock()
+	call.wg.Done()
+
+	return call.item, call.err
+}
+
+// 依次向上游DNS服务器发送查询
+func (this *Resolver) exchange(name string, qType uint16) (*cacheItem, error) {
+	if len(this.servers) == 0 {
+		return nil, errNoServers
+	}
+
+	var msg = &dns.Msg{}
+	msg.SetQuestion(name, qType)
+	msg.RecursionDesired = true
+
+	var lastErr error
+	for _, server := range this.servers {
+		resp, _, err := this.udpClient.Exchange(msg, server)
+		if err == nil && resp.Truncated {
+			resp, _, err = this.tcpClient.Exchange(msg, server)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		switch resp.Rcode {
+		case dns.RcodeSuccess, dns.RcodeNameError:
+			return this.newCacheItem(name, qType, resp), nil
+		default:
+			lastErr = errors.New("lookup '" + name + "' failed: " + dns.RcodeToString[resp.Rcode])
+		}
+	}
+	return nil, lastErr
+}
+
+// 从应答中构造缓存条目
+func (this *Resolver) newCacheItem(name string, qType uint16, resp *dns.Msg) *cacheItem {
+	var item = &cacheItem{
+		name:  name,
+		qType: qType,
+	}
+
+	var ttl int64 = -1
+	for _, answer := range resp.Answer {
+		if answer.Header().Rrtype != qType {
+			continue
+		}
+		switch record := answer.(type) {
+		case *dns.A:
+			item.values = append(item.values, record.A.String())
+		case *dns.AAAA:
+			item.values = append(item.values, record.AAAA.String())
+		case *dns.CNAME:
+			item.values = append(item.values, record.Target)
+		case *dns.SRV:
+			// "." 表示服务不可用
+			if record.Target == "." {
+				continue
+			}
+			item.srvList = append(item.srvList, &SRV{
+				Target:   strings.TrimSuffix(record.Target, "."),
+				Port:     record.Port,
+				Priority: record.Priority,
+				Weight:   record.Weight,
+			})
+		default:
+			continue
+		}
+		if ttl < 0 || int64(answer.Header().Ttl) < ttl {
+			ttl = int64(answer.Header().Ttl)
+		}
+	}
+
+	if len(item.values) == 0 && len(item.srvList) == 0 {
+		item.err = &NotFoundError{name: strings.TrimSuffix(name, ".")}
+
+		// 否定应答使用SOA中的TTL
+		for _, ns := range resp.Ns {
+			soa, ok := ns.(*dns.SOA)
+			if ok {
+				ttl = int64(soa.Minttl)
+				if int64(soa.Hdr.Ttl) < ttl {
+					ttl = int64(soa.Hdr.Ttl)
+				}
+				break
+			}
+		}
+	}
+
+	if ttl < int64(this.config.MinTTL) {
+		ttl = int64(this.config.MinTTL)
+	} else if ttl > int64(this.config.MaxTTL) {
+		ttl = int64(this.config.MaxTTL)
+	}
+
+	var now = time.Now().Unix()
+	item.ttl = ttl
+	item.expiresAt = now + ttl
+	item.staleAt = item.expiresAt + int64(this.config.StaleTTL)
+	return item
+}
+
+// 清理失效的条目，并提前刷新访问频繁的条目
+func (this *Resolver) refresh() {
+	var now = time.Now().Unix()
+	var hotItems = []*cacheItem{}
+
+	this.locker.Lock()
+	for key, item := range this.cacheMap {
+		if item.staleAt <= now {
+			delete(this.cacheMap, key)
+			continue
+		}
+
+		// 在剩余十分之一TTL时刷新
+		if item.expiresAt > now &&
+			item.expiresAt-now <= item.ttl/10+1 &&
+			atomic.LoadInt32(&item.hits) >= int32(this.config.PrefetchHits) &&
+			atomic.CompareAndSwapInt32(&item.isRefreshing, 0, 1) {
+			hotItems = append(hotItems, item)
+		}
+	}
+	this.locker.Unlock()
+
+	for _, item := range hotItems {
+		var refreshingItem = item
+		go func() {
+			_, err := this.query(refreshingItem.name+"@"+dns.TypeToString[refreshingItem.qType], refreshingItem.name, refreshingItem.qType)
+			if err != nil {
+				// 刷新失败时保留原有条目，以便过期后可以继续使用
+				atomic.StoreInt32(&refreshingItem.isRefreshing, 0)
+			}
+		}()
+	}
+}
+
+// IsSRVName 判断主机名是否为SRV名称，比如 _http._tcp.example.com
+func IsSRVName(host string) bool {
+	return strings.HasPrefix(host, "_") && (strings.Contains(host, "._tcp.") || strings.Contains(host, "._udp."))
+}
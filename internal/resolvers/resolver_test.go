@@ -0,0 +1,306 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package resolvers
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/miekg/dns"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 用于测试的DNS服务器
+type testDNSServer struct {
+	server   *dns.Server
+	queries  int32
+	isFailed int32
+}
+
+func newTestDNSServer(t *testing.T) *testDNSServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testServer = &testDNSServer{}
+	testServer.server = &dns.Server{
+		PacketConn: conn,
+		Handler:    dns.HandlerFunc(testServer.handle),
+	}
+	go func() {
+		_ = testServer.server.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = testServer.server.Shutdown()
+	})
+	return testServer
+}
+
+func (this *testDNSServer) Addr() string {
+	return this.server.PacketConn.LocalAddr().String()
+}
+
+func (this *testDNSServer) Queries() int {
+	return int(atomic.LoadInt32(&this.queries))
+}
+
+func (this *testDNSServer) SetFailed(isFailed bool) {
+	if isFailed {
+		atomic.StoreInt32(&this.isFailed, 1)
+	} else {
+		atomic.StoreInt32(&this.isFailed, 0)
+	}
+}
+
+func (this *testDNSServer) handle(writer dns.ResponseWriter, req *dns.Msg) {
+	atomic.AddInt32(&this.queries, 1)
+
+	var resp = &dns.Msg{}
+	resp.SetReply(req)
+	if atomic.LoadInt32(&this.isFailed) == 1 {
+		resp.Rcode = dns.RcodeServerFailure
+		_ = writer.WriteMsg(resp)
+		return
+	}
+
+	var question = req.Question[0]
+	var header = dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: 60}
+	switch question.Name {
+	case "origin.example.com.":
+		switch question.Qtype {
+		case dns.TypeA:
+			resp.Answer = append(resp.Answer, &dns.A{Hdr: header, A: net.ParseIP("192.168.1.100")})
+		case dns.TypeAAAA:
+			resp.Answer = append(resp.Answer, &dns.AAAA{Hdr: header, AAAA: net.ParseIP("::100")})
+		}
+	case "_http._tcp.example.com.":
+		if question.Qtype == dns.TypeSRV {
+			resp.Answer = append(resp.Answer,
+				&dns.SRV{Hdr: header, Priority: 10, Weight: 1, Port: 8080, Target: "origin.example.com."},
+				&dns.SRV{Hdr: header, Priority: 20, Weight: 1, Port: 8081, Target: "backup.example.com."})
+		}
+	case "www.example.com.":
+		if question.Qtype == dns.TypeCNAME {
+			resp.Answer = append(resp.Answer, &dns.CNAME{Hdr: header, Target: "origin.example.com."})
+		}
+	default:
+		resp.Rcode = dns.RcodeNameError
+	}
+	_ = writer.WriteMsg(resp)
+}
+
+func newTestResolver(addr string) *Resolver {
+	var config = &configs.ResolverConfig{
+		Servers: []string{addr},
+	}
+	_ = config.Init()
+	return NewResolver(config)
+}
+
+func TestResolver_LookupIP(t *testing.T) {
+	var server = newTestDNSServer(t)
+	var resolver = newTestResolver(server.Addr())
+	defer func() {
+		_ = resolver.Close()
+	}()
+
+	for i := 0; i < 3; i++ {
+		ips, err := resolver.LookupIP("origin.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) != 2 || ips[0] != "192.168.1.100" || ips[1] != "::100" {
+			t.Fatal("unexpected ips:", ips)
+		}
+	}
+
+	// A和AAAA各查询一次，其余从缓存中读取
+	if server.Queries() != 2 {
+		t.Fatal("expect 2 queries, got", server.Queries())
+	}
+
+	_, err := resolver.LookupIP("none.example.com")
+	if !IsNotFound(err) {
+		t.Fatal("expect not found error, got", err)
+	}
+}
+
+func TestResolver_Stale(t *testing.T) {
+	var server = newTestDNSServer(t)
+	var resolver = newTestResolver(server.Addr())
+	defer func() {
+		_ = resolver.Close()
+	}()
+
+	_, err := resolver.LookupIP("origin.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 使缓存过期，并让上游失败
+	resolver.locker.Lock()
+	for _, item := range resolver.cacheMap {
+		item.expiresAt = time.Now().Unix() - 1
+	}
+	resolver.locker.Unlock()
+	server.SetFailed(true)
+
+	ips, err := resolver.LookupIP("origin.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 {
+		t.Fatal("expect stale ips, got", ips)
+	}
+
+	// 超出可用时间后返回错误
+	resolver.locker.Lock()
+	for _, item := range resolver.cacheMap {
+		item.staleAt = time.Now().Unix() - 1
+	}
+	resolver.locker.Unlock()
+	_, err = resolver.LookupIP("origin.example.com")
+	if err == nil || IsNotFound(err) {
+		t.Fatal("expect server failure, got", err)
+	}
+}
+
+func TestResolver_Prefetch(t *testing.T) {
+	var server = newTestDNSServer(t)
+	var resolver = newTestResolver(server.Addr())
+	defer func() {
+		_ = resolver.Close()
+	}()
+
+	for i := 0; i < 20; i++ {
+		_, err := resolver.LookupCNAME("www.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var queries = server.Queries()
+
+	// 即将过期的热门域名会被提前刷新
+	resolver.locker.Lock()
+	for _, item := range resolver.cacheMap {
+		item.expiresAt = time.Now().Unix() + 1
+	}
+	resolver.locker.Unlock()
+	resolver.refresh()
+
+	for i := 0; i < 50; i++ {
+		if server.Queries() > queries {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if server.Queries() != queries+1 {
+		t.Fatal("expect prefetch query")
+	}
+}
+
+func TestResolver_ResolveAddr(t *testing.T) {
+	var server = newTestDNSServer(t)
+	var resolver = newTestResolver(server.Addr())
+	defer func() {
+		_ = resolver.Close()
+	}()
+
+	{
+		addr, err := resolver.ResolveAddr("origin.example.com:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != "192.168.1.100:80" {
+			t.Fatal("unexpected addr:", addr)
+		}
+	}
+
+	{
+		addrs, err := resolver.ResolveAddrs("origin.example.com:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addrs) != 2 || addrs[0] != "192.168.1.100:80" || addrs[1] != "[::100]:80" {
+			t.Fatal("unexpected addrs:", addrs)
+		}
+	}
+
+	{
+		// 使用优先级最高的SRV记录
+		addr, err := resolver.ResolveSRVAddr("_http._tcp.example.com:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != "origin.example.com:8080" {
+			t.Fatal("unexpected addr:", addr)
+		}
+
+		addr, err = resolver.ResolveAddr("_http._tcp.example.com:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != "192.168.1.100:8080" {
+			t.Fatal("unexpected addr:", addr)
+		}
+	}
+
+	{
+		// 不存在的域名交给系统解析
+		addr, err := resolver.ResolveAddr("none.example.com:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != "none.example.com:80" {
+			t.Fatal("unexpected addr:", addr)
+		}
+	}
+
+	{
+		_, err := resolver.ResolveAddr("_none._tcp.example.com:80")
+		if err == nil {
+			t.Fatal("srv lookup should fail")
+		}
+	}
+}
+
+func TestProbeDNS(t *testing.T) {
+	var server = newTestDNSServer(t)
+
+	err := ProbeDNS(server.Addr(), "origin.example.com", "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NXDOMAIN 也表示服务器正常
+	err = ProbeDNS(server.Addr(), "none.example.com", "A", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.SetFailed(true)
+	err = ProbeDNS(server.Addr(), "origin.example.com", "A", time.Second)
+	if err == nil {
+		t.Fatal("expect server failure")
+	}
+
+	err = ProbeDNS(server.Addr(), "origin.example.com", "XYZ", time.Second)
+	if err == nil {
+		t.Fatal("invalid query type should be rejected")
+	}
+
+	// 没有应答
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	err = ProbeDNS(conn.LocalAddr().String(), "origin.example.com", "A", 200*time.Millisecond)
+	if err == nil {
+		t.Fatal("expect timeout")
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package resolvers
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"sync/atomic"
+)
+
+var sharedResolver = &atomic.Value{}
+
+func init() {
+	var config = &configs.ResolverConfig{}
+	_ = config.Init()
+	sharedResolver.Store(NewResolver(config))
+}
+
+// SharedResolver 获取当前使用的解析器
+func SharedResolver() *Resolver {
+	return sharedResolver.Load().(*Resolver)
+}
+
+// SetSharedResolver 设置当前使用的解析器，并关闭之前的解析器
+func SetSharedResolver(resolver *Resolver) {
+	if resolver == nil {
+		return
+	}
+	var oldResolver = sharedResolver.Swap(resolver).(*Resolver)
+	if oldResolver != resolver {
+		_ = oldResolver.Close()
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package resolvers
+
+import (
+	"github.com/iwind/TeaGo/rands"
+	"math/rand"
+	"net"
+)
+
+// SRV SRV记录
+type SRV struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+// 按照RFC 2782从SRV记录中选取一个目标：使用优先级最高（Priority最小）的一组，组内按照权重随机选取
+func pickSRV(srvList []*SRV) *SRV {
+	if len(srvList) == 0 {
+		return nil
+	}
+
+	var group = []*SRV{}
+	var totalWeight = 0
+	for _, srv := range srvList {
+		if len(group) > 0 && srv.Priority > group[0].Priority {
+			continue
+		}
+		if len(group) > 0 && srv.Priority < group[0].Priority {
+			group = group[:0]
+			totalWeight = 0
+		}
+		group = append(group, srv)
+		totalWeight += int(srv.Weight)
+	}
+
+	if totalWeight == 0 {
+		return group[rands.Int(0, len(group)-1)]
+	}
+
+	var n = rands.Int(0, totalWeight-1)
+	for _, srv := range group {
+		n -= int(srv.Weight)
+		if n < 0 {
+			return srv
+		}
+	}
+	return group[len(group)-1]
+}
+
+// 对多个IP排序，IPv4在前，同类地址随机排序以便分散连接
+func sortIPs(ips []string) []string {
+	var ipv4List = []string{}
+	var ipv6List = []string{}
+	for _, ip := range ips {
+		var parsedIP = net.ParseIP(ip)
+		if parsedIP != nil && parsedIP.To4() != nil {
+			ipv4List = append(ipv4List, ip)
+		} else {
+			ipv6List = append(ipv6List, ip)
+		}
+	}
+	rand.Shuffle(len(ipv4List), func(i, j int) {
+		ipv4List[i], ipv4List[j] = ipv4List[j], ipv4List[i]
+	})
+	rand.Shuffle(len(ipv6List), func(i, j int) {
+		ipv6List[i], ipv6List[j] = ipv6List[j], ipv6List[i]
+	})
+	return append(ipv4List, ipv6List...)
+}
@@ -162,7 +162,7 @@ func (this *BandwidthStatManager) Loop() error {
 	}
 	this.locker.Unlock()
 
-	if len(pbStats) > 0 {
+	if len(pbStats) > 0 && !teaconst.IsStandalone {
 		// 上传
 		rpcClient, err := rpc.SharedRPC()
 		if err != nil {
@@ -0,0 +1,255 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package stats
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/utils/fasttime"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var SharedCacheStatManager = NewCacheStatManager()
+
+// 缓存请求结果
+const (
+	CacheResultHit    = "hit"
+	CacheResultMiss   = "miss"
+	CacheResultStale  = "stale"
+	CacheResultBypass = "bypass"
+)
+
+// 不使用缓存的原因
+const (
+	CacheBypassNoCacheRef    = "noCacheRef"    // 没有匹配的缓存条件
+	CacheBypassMethod        = "method"        // 请求方法等请求条件不匹配
+	CacheBypassNoCacheHeader = "noCacheHeader" // 请求中带有 no-cache
+	CacheBypassSetCookie     = "setCookie"     // 响应中带有 Set-Cookie
+	CacheBypassSize          = "size"          // 内容尺寸不符合要求
+	CacheBypassStatus        = "status"        // 响应状态码不能缓存
+	CacheBypassAdmission     = "admission"     // 访问频率不足，未通过准入
+	CacheBypassOther         = "other"         // 其他原因
+)
+
+// 内容类型分组
+const (
+	CacheContentTypeHTML    = "html"
+	CacheContentTypeCSS     = "css"
+	CacheContentTypeJS      = "js"
+	CacheContentTypeJSON    = "json"
+	CacheContentTypeImage   = "image"
+	CacheContentTypeVideo   = "video"
+	CacheContentTypeAudio   = "audio"
+	CacheContentTypeFont    = "font"
+	CacheContentTypeText    = "text"
+	CacheContentTypeBinary  = "binary"
+	CacheContentTypeUnknown = "unknown"
+)
+
+const (
+	cacheStatMaxServers = 10000 // 最多统计的网站数量，超出的网站合并到ID为0的网站中
+)
+
+// CacheStat 缓存命中统计
+type CacheStat struct {
+	ServerId      int64            `json:"serverId"`
+	ContentType   string           `json:"contentType"`
+	Hits          int64            `json:"hits"`
+	Misses        int64            `json:"misses"`
+	StaleHits     int64            `json:"staleHits"`
+	Bypasses      int64            `json:"bypasses"`
+	BypassReasons map[string]int64 `json:"bypassReasons"`
+}
+
+// CountRequests 请求总数
+func (this *CacheStat) CountRequests() int64 {
+	return this.Hits + this.Misses + this.StaleHits + this.Bypasses
+}
+
+// HitRatio 命中率，包含陈旧缓存
+func (this *CacheStat) HitRatio() float64 {
+	var total = this.CountRequests()
+	if total == 0 {
+		return 0
+	}
+	return float64(this.Hits+this.StaleHits) / float64(total)
+}
+
+// AsMap 转换为Map
+func (this *CacheStat) AsMap() maps.Map {
+	var reasons = maps.Map{}
+	for reason, count := range this.BypassReasons {
+		reasons[reason] = count
+	}
+	return maps.Map{
+		"serverId":      this.ServerId,
+		"contentType":   this.ContentType,
+		"hits":          this.Hits,
+		"misses":        this.Misses,
+		"staleHits":     this.StaleHits,
+		"bypasses":      this.Bypasses,
+		"bypassReasons": reasons,
+		"hitRatio":      this.HitRatio(),
+	}
+}
+
+func (this *CacheStat) add(result string, bypassReason string) {
+	switch result {
+	case CacheResultHit:
+		this.Hits++
+	case CacheResultStale:
+		this.StaleHits++
+	case CacheResultBypass:
+		this.Bypasses++
+		if this.BypassReasons == nil {
+			this.BypassReasons = map[string]int64{}
+		}
+		this.BypassReasons[bypassReason]++
+	default:
+		this.Misses++
+	}
+}
+
+func (this *CacheStat) clone() *CacheStat {
+	var stat = *this
+	if this.BypassReasons != nil {
+		stat.BypassReasons = map[string]int64{}
+		for reason, count := range this.BypassReasons {
+			stat.BypassReasons[reason] = count
+		}
+	}
+	return &stat
+}
+
+// CacheStatManager 缓存命中率统计
+// 按网站和内容类型分组统计命中、未命中、陈旧缓存和不使用缓存的次数，通过 edge-node cache.stats 查看；
+// 每个网站的请求数和缓存命中数随流量统计上传，分组结果可以通过 ${cache.result} 等请求变量在指标中上传
+type CacheStatManager struct {
+	totalMap  map[string]*CacheStat // serverId@contentType => *CacheStat，启动以来的统计
+	serverIds map[int64]bool
+
+	since  int64
+	locker sync.Mutex
+}
+
+func NewCacheStatManager() *CacheStatManager {
+	return &CacheStatManager{
+		totalMap:  map[string]*CacheStat{},
+		serverIds: map[int64]bool{},
+		since:     fasttime.Now().Unix(),
+	}
+}
+
+// Add 添加一次请求结果
+func (this *CacheStatManager) Add(serverId int64, contentType string, result string, bypassReason string) {
+	var contentTypeBucket = CacheContentTypeBucket(contentType)
+	if result == CacheResultBypass {
+		bypassReason = cacheBypassReason(bypassReason)
+	}
+
+	this.locker.Lock()
+	if !this.serverIds[serverId] {
+		if len(this.serverIds) >= cacheStatMaxServers {
+			serverId = 0
+		} else {
+			this.serverIds[serverId] = true
+		}
+	}
+
+	var key = types.String(serverId) + "@" + contentTypeBucket
+	stat, ok := this.totalMap[key]
+	if !ok {
+		stat = &CacheStat{
+			ServerId:    serverId,
+			ContentType: contentTypeBucket,
+		}
+		this.totalMap[key] = stat
+	}
+	stat.add(result, bypassReason)
+	this.locker.Unlock()
+}
+
+// Stats 启动以来的统计，按请求数从高到低排序
+// serverId 为0时表示所有网站
+func (this *CacheStatManager) Stats(serverId int64) (result []*CacheStat, since int64) {
+	this.locker.Lock()
+	for _, stat := range this.totalMap {
+		if serverId > 0 && stat.ServerId != serverId {
+			continue
+		}
+		result = append(result, stat.clone())
+	}
+	since = this.since
+	this.locker.Unlock()
+
+	sortCacheStats(result)
+	return
+}
+
+// Reset 清空统计
+func (this *CacheStatManager) Reset() {
+	this.locker.Lock()
+	this.totalMap = map[string]*CacheStat{}
+	this.serverIds = map[int64]bool{}
+	this.since = fasttime.Now().Unix()
+	this.locker.Unlock()
+}
+
+// CacheContentTypeBucket 获取内容类型所属的分组
+func CacheContentTypeBucket(contentType string) string {
+	var index = strings.IndexByte(contentType, ';')
+	if index >= 0 {
+		contentType = contentType[:index]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if len(contentType) == 0 {
+		return CacheContentTypeUnknown
+	}
+
+	switch {
+	case contentType == "text/html" || contentType == "application/xhtml+xml":
+		return CacheContentTypeHTML
+	case contentType == "text/css":
+		return CacheContentTypeCSS
+	case strings.Contains(contentType, "javascript") || strings.Contains(contentType, "ecmascript"):
+		return CacheContentTypeJS
+	case contentType == "application/json" || strings.HasSuffix(contentType, "+json"):
+		return CacheContentTypeJSON
+	case strings.HasPrefix(contentType, "image/"):
+		return CacheContentTypeImage
+	case strings.HasPrefix(contentType, "video/") || contentType == "application/vnd.apple.mpegurl" || contentType == "application/x-mpegurl":
+		return CacheContentTypeVideo
+	case strings.HasPrefix(contentType, "audio/"):
+		return CacheContentTypeAudio
+	case strings.HasPrefix(contentType, "font/") || strings.Contains(contentType, "font-"):
+		return CacheContentTypeFont
+	case strings.HasPrefix(contentType, "text/") || strings.HasSuffix(contentType, "xml"):
+		return CacheContentTypeText
+	}
+	return CacheContentTypeBinary
+}
+
+// 限制原因的取值范围
+func cacheBypassReason(reason string) string {
+	switch reason {
+	case CacheBypassNoCacheRef, CacheBypassMethod, CacheBypassNoCacheHeader, CacheBypassSetCookie, CacheBypassSize, CacheBypassStatus, CacheBypassAdmission:
+		return reason
+	}
+	return CacheBypassOther
+}
+
+func sortCacheStats(stats []*CacheStat) {
+	sort.Slice(stats, func(i, j int) bool {
+		var count1 = stats[i].CountRequests()
+		var count2 = stats[j].CountRequests()
+		if count1 == count2 {
+			if stats[i].ServerId == stats[j].ServerId {
+				return stats[i].ContentType < stats[j].ContentType
+			}
+			return stats[i].ServerId < stats[j].ServerId
+		}
+		return count1 > count2
+	})
+}
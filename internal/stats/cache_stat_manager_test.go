@@ -0,0 +1,67 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package stats
+
+import (
+	"github.com/iwind/TeaGo/logs"
+	"testing"
+)
+
+func TestCacheContentTypeBucket(t *testing.T) {
+	for contentType, bucket := range map[string]string{
+		"":                                 CacheContentTypeUnknown,
+		"text/html; charset=utf-8":         CacheContentTypeHTML,
+		"text/css":                         CacheContentTypeCSS,
+		"application/javascript":           CacheContentTypeJS,
+		"application/problem+json":         CacheContentTypeJSON,
+		"image/webp":                       CacheContentTypeImage,
+		"video/mp4":                        CacheContentTypeVideo,
+		"font/woff2":                       CacheContentTypeFont,
+		"text/plain":                       CacheContentTypeText,
+		"application/xml":                  CacheContentTypeText,
+		"application/octet-stream":         CacheContentTypeBinary,
+		"Application/X-Unknown ; a=b; c=d": CacheContentTypeBinary,
+	} {
+		if CacheContentTypeBucket(contentType) != bucket {
+			t.Fatal("bucket of '"+contentType+"' should be", bucket, "but got", CacheContentTypeBucket(contentType))
+		}
+	}
+}
+
+func TestCacheStatManager_Add(t *testing.T) {
+	var manager = NewCacheStatManager()
+	manager.Add(1, "text/html", CacheResultHit, "")
+	manager.Add(1, "text/html", CacheResultHit, "")
+	manager.Add(1, "text/html", CacheResultStale, "")
+	manager.Add(1, "text/html", CacheResultMiss, "")
+	manager.Add(1, "image/png", CacheResultBypass, CacheBypassSetCookie)
+	manager.Add(1, "image/png", CacheResultBypass, "unknown reason")
+
+	stats, _ := manager.Stats(1)
+	for _, stat := range stats {
+		logs.PrintAsJSON(stat.AsMap(), t)
+	}
+	if len(stats) != 2 {
+		t.Fatal("expect 2 stats")
+	}
+	if stats[0].ContentType != CacheContentTypeHTML || stats[0].HitRatio() != 0.75 {
+		t.Fatal("invalid html stat")
+	}
+	if stats[1].BypassReasons[CacheBypassSetCookie] != 1 || stats[1].BypassReasons[CacheBypassOther] != 1 {
+		t.Fatal("invalid bypass reasons")
+	}
+}
+
+func TestCacheStatManager_MaxServers(t *testing.T) {
+	var manager = NewCacheStatManager()
+	for i := 1; i <= cacheStatMaxServers+100; i++ {
+		manager.Add(int64(i), "text/html", CacheResultHit, "")
+	}
+	stats, _ := manager.Stats(0)
+	if len(stats) != cacheStatMaxServers+1 {
+		t.Fatal("expect", cacheStatMaxServers+1, "stats, but got", len(stats))
+	}
+	if stats[0].ServerId != 0 || stats[0].Hits != 100 {
+		t.Fatal("servers over limit should be merged into server 0")
+	}
+}
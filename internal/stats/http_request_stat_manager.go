@@ -4,6 +4,7 @@ import (
 	iplib "github.com/TeaOSLab/EdgeCommon/pkg/iplibrary"
 	"github.com/TeaOSLab/EdgeCommon/pkg/nodeconfigs"
 	"github.com/TeaOSLab/EdgeCommon/pkg/rpc/pb"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
 	"github.com/TeaOSLab/EdgeNode/internal/events"
 	"github.com/TeaOSLab/EdgeNode/internal/goman"
 	"github.com/TeaOSLab/EdgeNode/internal/monitor"
@@ -305,6 +306,11 @@ func (this *HTTPRequestStatManager) Loop() error {
 
 // Upload 上传数据
 func (this *HTTPRequestStatManager) Upload() error {
+	// 独立运行模式下不上传
+	if teaconst.IsStandalone {
+		return nil
+	}
+
 	// 上传统计数据
 	rpcClient, err := rpc.SharedRPC()
 	if err != nil {
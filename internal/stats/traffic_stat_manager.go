@@ -3,6 +3,7 @@ package stats
 import (
 	"github.com/TeaOSLab/EdgeCommon/pkg/nodeconfigs"
 	"github.com/TeaOSLab/EdgeCommon/pkg/rpc/pb"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
 	"github.com/TeaOSLab/EdgeNode/internal/events"
 	"github.com/TeaOSLab/EdgeNode/internal/goman"
 	"github.com/TeaOSLab/EdgeNode/internal/monitor"
@@ -168,6 +169,11 @@ func (this *TrafficStatManager) Add(userId int64, serverId int64, domain string,
 
 // Upload 上传流量
 func (this *TrafficStatManager) Upload() error {
+	// 独立运行模式下不上传
+	if teaconst.IsStandalone {
+		return nil
+	}
+
 	var regionId int64
 	nodeConfig, _ := nodeconfigs.SharedNodeConfig()
 	if nodeConfig != nil {
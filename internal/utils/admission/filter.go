@@ -0,0 +1,180 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package admission
+
+import (
+	"github.com/cespare/xxhash"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Mode = string
+
+const (
+	ModeNthHit  Mode = "nth"     // 在时间窗口内请求达到N次后才写入
+	ModeTinyLFU Mode = "tinylfu" // 和将要被淘汰的内容比较访问频率，频率更高时才写入；淘汰候选从最近写入的内容中抽样，参考 victimFrequency()
+)
+
+const (
+	DefaultMinHits   = 2
+	DefaultWindow    = 3600
+	DefaultMaxMemory = 4 << 20
+
+	defaultVictimSamples = 5
+	defaultResidentSize  = 4096
+)
+
+// Options 选项
+type Options struct {
+	Mode      Mode
+	MinHits   int   // ModeNthHit 模式下写入需要达到的请求次数，最大为15
+	Window    int   // ModeNthHit 模式下的时间窗口（秒）
+	MaxMemory int64 // 频率统计最大占用内存（字节）
+}
+
+// Stat 统计
+type Stat struct {
+	Admitted int64 `json:"admitted"` // 允许写入次数
+	Rejected int64 `json:"rejected"` // 拒绝写入次数
+}
+
+// Filter 缓存写入准入过滤器
+// 使用 CountMinSketch 统计访问频率，只有足够"热"的内容才允许写入缓存，避免只访问一次的内容冲掉热点内容
+type Filter struct {
+	options *Options
+
+	locker      sync.Mutex
+	sketch      *CountMinSketch
+	windowStart int64
+
+	// 最近写入的内容Hash，ModeTinyLFU 模式下从中随机抽取作为淘汰候选
+	residents     []uint64
+	residentIndex int
+	residentCount int
+
+	admitted int64
+	rejected int64
+}
+
+// NewFilter 获取新对象
+func NewFilter(options *Options) *Filter {
+	if options == nil {
+		options = &Options{}
+	} else {
+		var optionsCopy = *options
+		options = &optionsCopy
+	}
+	if options.Mode != ModeTinyLFU {
+		options.Mode = ModeNthHit
+	}
+	if options.MinHits <= 0 {
+		options.MinHits = DefaultMinHits
+	} else if options.MinHits > sketchMaxCount {
+		options.MinHits = sketchMaxCount
+	}
+	if options.Window <= 0 {
+		options.Window = DefaultWindow
+	}
+	if options.MaxMemory <= 0 {
+		options.MaxMemory = DefaultMaxMemory
+	}
+
+	var filter = &Filter{
+		options:     options,
+		sketch:      NewCountMinSketch(options.MaxMemory),
+		windowStart: time.Now().Unix(),
+	}
+	if options.Mode == ModeTinyLFU {
+		filter.residents = make([]uint64, defaultResidentSize)
+	}
+	return filter
+}
+
+// Record 记录一次访问
+func (this *Filter) Record(key string) {
+	var hash = xxhash.Sum64String(key)
+
+	this.locker.Lock()
+	this.checkWindow()
+	this.sketch.Increment(hash)
+	this.locker.Unlock()
+}
+
+// Admit 检查是否允许写入
+// storageIsFull 表示缓存存储是否已接近容量上限，ModeTinyLFU 模式下未满时总是允许写入
+func (this *Filter) Admit(key string, storageIsFull bool) bool {
+	var hash = xxhash.Sum64String(key)
+
+	this.locker.Lock()
+	var ok bool
+	if this.options.Mode == ModeTinyLFU {
+		ok = !storageIsFull || this.sketch.Estimate(hash) > this.victimFrequency()
+		if ok {
+			this.residents[this.residentIndex] = hash
+			this.residentIndex = (this.residentIndex + 1) % len(this.residents)
+			if this.residentCount < len(this.residents) {
+				this.residentCount++
+			}
+		}
+	} else {
+		this.checkWindow()
+		ok = this.sketch.Estimate(hash) >= this.options.MinHits
+	}
+	this.locker.Unlock()
+
+	if ok {
+		atomic.AddInt64(&this.admitted, 1)
+	} else {
+		atomic.AddInt64(&this.rejected, 1)
+	}
+	return ok
+}
+
+// Stat 获取统计
+func (this *Filter) Stat() *Stat {
+	return &Stat{
+		Admitted: atomic.LoadInt64(&this.admitted),
+		Rejected: atomic.LoadInt64(&this.rejected),
+	}
+}
+
+// Mode 过滤模式
+func (this *Filter) Mode() Mode {
+	return this.options.Mode
+}
+
+// MemorySize 占用的内存尺寸（字节）
+func (this *Filter) MemorySize() int64 {
+	return this.sketch.MemorySize() + int64(len(this.residents))*8
+}
+
+// 时间窗口结束后清空计数
+func (this *Filter) checkWindow() {
+	if this.options.Mode != ModeNthHit {
+		return
+	}
+	var now = time.Now().Unix()
+	if now-this.windowStart >= int64(this.options.Window) {
+		this.sketch.Reset()
+		this.windowStart = now
+	}
+}
+
+// 随机抽取若干已写入的内容，以其中最低的访问频率作为淘汰候选的频率
+// 这里只是近似值：过滤器不知道缓存存储实际的淘汰顺序，抽样范围是最近允许写入的 defaultResidentSize 个内容，
+// 其中可能包括已经被存储淘汰或者删除的内容；它们的频率通常较低，所以结果偏向于允许写入，不会比存储未满时更严格
+func (this *Filter) victimFrequency() int {
+	if this.residentCount == 0 {
+		return 0
+	}
+	var min = sketchMaxCount
+	for i := 0; i < defaultVictimSamples; i++ {
+		var frequency = this.sketch.Estimate(this.residents[rand.Intn(this.residentCount)])
+		if frequency < min {
+			min = frequency
+		}
+	}
+	return min
+}
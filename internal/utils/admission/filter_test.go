@@ -0,0 +1,162 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package admission_test
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/utils/admission"
+	"strconv"
+	"testing"
+)
+
+func TestCountMinSketch(t *testing.T) {
+	var sketch = admission.NewCountMinSketch(1 << 10)
+	if sketch.MemorySize() > 1<<10 {
+		t.Fatal("memory size should not be greater than 1KB, got", sketch.MemorySize())
+	}
+
+	for i := 0; i < 20; i++ {
+		sketch.Increment(12345)
+	}
+	if sketch.Estimate(12345) != 15 {
+		t.Fatal("expect 15, got", sketch.Estimate(12345))
+	}
+	if sketch.Estimate(54321) > 1 {
+		t.Fatal("expect 0, got", sketch.Estimate(54321))
+	}
+
+	sketch.Reset()
+	if sketch.Estimate(12345) != 0 {
+		t.Fatal("expect 0 after reset")
+	}
+}
+
+func TestCountMinSketch_Halve(t *testing.T) {
+	var sketch = admission.NewCountMinSketch(1 << 10)
+	for i := 0; i < 8; i++ {
+		sketch.Increment(1)
+	}
+
+	// 大量不同的访问后旧的频率减半
+	for i := uint64(2); i < 100000; i++ {
+		sketch.Increment(i * 0x9E3779B97F4A7C15)
+	}
+	var count = sketch.Estimate(1)
+	t.Log("count:", count)
+	if count >= 8 {
+		t.Fatal("expect count to be halved")
+	}
+}
+
+func TestFilter_NthHit(t *testing.T) {
+	var filter = admission.NewFilter(&admission.Options{
+		Mode:    admission.ModeNthHit,
+		MinHits: 3,
+	})
+
+	for i := 1; i <= 3; i++ {
+		filter.Record("https://example.com/a")
+		var ok = filter.Admit("https://example.com/a", false)
+		if ok != (i == 3) {
+			t.Fatal("unexpected result at hit", i)
+		}
+	}
+
+	var stat = filter.Stat()
+	if stat.Admitted != 1 || stat.Rejected != 2 {
+		t.Fatalf("unexpected stat: %+v", stat)
+	}
+}
+
+func TestFilter_TinyLFU(t *testing.T) {
+	var filter = admission.NewFilter(&admission.Options{
+		Mode: admission.ModeTinyLFU,
+	})
+
+	// 存储未满时总是允许写入
+	for i := 0; i < 100; i++ {
+		var key = "https://example.com/hot" + strconv.Itoa(i)
+		for j := 0; j < 5; j++ {
+			filter.Record(key)
+		}
+		if !filter.Admit(key, false) {
+			t.Fatal("expect admitted")
+		}
+	}
+
+	// 存储已满时只访问一次的内容不能替换热点内容
+	filter.Record("https://example.com/cold")
+	if filter.Admit("https://example.com/cold", true) {
+		t.Fatal("cold key should be rejected")
+	}
+
+	for j := 0; j < 10; j++ {
+		filter.Record("https://example.com/hotter")
+	}
+	if !filter.Admit("https://example.com/hotter", true) {
+		t.Fatal("hotter key should be admitted")
+	}
+}
+
+// 淘汰候选是从最近写入的内容中抽样的近似值
+func TestFilter_TinyLFU_VictimSampling(t *testing.T) {
+	var admitKeys = func(filter *admission.Filter, prefix string, count int, hits int) {
+		for i := 0; i < count; i++ {
+			var key = prefix + strconv.Itoa(i)
+			for j := 0; j < hits; j++ {
+				filter.Record(key)
+			}
+			if !filter.Admit(key, false) {
+				t.Fatal("expect admitted")
+			}
+		}
+	}
+	var admitWithHits = func(filter *admission.Filter, key string, hits int) bool {
+		for j := 0; j < hits; j++ {
+			filter.Record(key)
+		}
+		return filter.Admit(key, true)
+	}
+
+	// 已写入的都是热点内容时，频率更低的内容不能写入
+	{
+		var filter = admission.NewFilter(&admission.Options{Mode: admission.ModeTinyLFU})
+		admitKeys(filter, "https://example.com/hot", 100, 5)
+		if admitWithHits(filter, "https://example.com/a", 3) {
+			t.Fatal("key less frequent than all residents should be rejected")
+		}
+		if !admitWithHits(filter, "https://example.com/b", 6) {
+			t.Fatal("key more frequent than all residents should be admitted")
+		}
+	}
+
+	// 已写入的大部分是冷门内容时，抽样几乎总能抽到冷门内容，稍热一些的内容即可写入
+	{
+		var filter = admission.NewFilter(&admission.Options{Mode: admission.ModeTinyLFU})
+		admitKeys(filter, "https://example.com/hot", 100, 5)
+		admitKeys(filter, "https://example.com/cold", 3000, 1)
+		if !admitWithHits(filter, "https://example.com/a", 2) {
+			t.Fatal("key hotter than most residents should be admitted")
+		}
+	}
+
+	// 只从最近写入的 4096 个内容中抽样，更早写入的冷门内容不再作为淘汰候选
+	{
+		var filter = admission.NewFilter(&admission.Options{Mode: admission.ModeTinyLFU})
+		admitKeys(filter, "https://example.com/cold", 3000, 1)
+		admitKeys(filter, "https://example.com/hot", 4096, 5)
+		if admitWithHits(filter, "https://example.com/a", 2) {
+			t.Fatal("old residents should not be sampled")
+		}
+	}
+}
+
+func BenchmarkFilter_Record(b *testing.B) {
+	var filter = admission.NewFilter(nil)
+	b.RunParallel(func(pb *testing.PB) {
+		var i = 0
+		for pb.Next() {
+			i++
+			filter.Record("https://example.com/" + strconv.Itoa(i%10000))
+		}
+	})
+}
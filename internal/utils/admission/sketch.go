@@ -0,0 +1,101 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package admission
+
+const (
+	sketchDepth       = 4
+	sketchMaxCount    = 15                 // 4位计数器最大值
+	sketchResetMask   = 0x7777777777777777 // 计数器减半后去掉溢出到相邻计数器的位
+	sketchMinWidth    = 64
+	countersPerWord   = 16
+	sketchSampleRatio = 10 // 增加次数达到 宽度*此倍数 后所有计数器减半
+)
+
+// CountMinSketch 使用4位计数器的Count-Min Sketch，用于估算访问频率
+// 每一行有 width 个计数器，共 sketchDepth 行，占用内存为 width*sketchDepth/2 字节
+type CountMinSketch struct {
+	table []uint64
+	width uint64 // 每行计数器数量，为2的幂
+	words uint64 // 每行占用的uint64数量
+
+	additions  uint64
+	sampleSize uint64
+}
+
+// NewCountMinSketch 获取新对象
+// maxMemory 为最大占用内存（字节）
+func NewCountMinSketch(maxMemory int64) *CountMinSketch {
+	var width uint64 = sketchMinWidth
+	for int64(width*2)*sketchDepth/2 <= maxMemory {
+		width *= 2
+	}
+
+	var words = width / countersPerWord
+	return &CountMinSketch{
+		table:      make([]uint64, words*sketchDepth),
+		width:      width,
+		words:      words,
+		sampleSize: width * sketchSampleRatio,
+	}
+}
+
+// Increment 增加某个Hash的计数
+func (this *CountMinSketch) Increment(hash uint64) {
+	var added = false
+	for i := 0; i < sketchDepth; i++ {
+		var wordIndex, shift = this.locate(hash, i)
+		var count = (this.table[wordIndex] >> shift) & 0xF
+		if count < sketchMaxCount {
+			this.table[wordIndex] += 1 << shift
+			added = true
+		}
+	}
+
+	if added {
+		this.additions++
+		if this.additions >= this.sampleSize {
+			this.halve()
+		}
+	}
+}
+
+// Estimate 估算某个Hash的计数
+func (this *CountMinSketch) Estimate(hash uint64) int {
+	var min uint64 = sketchMaxCount
+	for i := 0; i < sketchDepth; i++ {
+		var wordIndex, shift = this.locate(hash, i)
+		var count = (this.table[wordIndex] >> shift) & 0xF
+		if count < min {
+			min = count
+		}
+	}
+	return int(min)
+}
+
+// Reset 清空所有计数
+func (this *CountMinSketch) Reset() {
+	for i := range this.table {
+		this.table[i] = 0
+	}
+	this.additions = 0
+}
+
+// MemorySize 占用的内存尺寸（字节）
+func (this *CountMinSketch) MemorySize() int64 {
+	return int64(len(this.table)) * 8
+}
+
+// 所有计数器减半，让旧的访问频率逐渐失效
+func (this *CountMinSketch) halve() {
+	for i := range this.table {
+		this.table[i] = (this.table[i] >> 1) & sketchResetMask
+	}
+	this.additions /= 2
+}
+
+// 计算某一行中计数器所在的位置
+func (this *CountMinSketch) locate(hash uint64, row int) (wordIndex uint64, shift uint64) {
+	var h = (hash>>32 + uint64(row+1)*(hash&0xFFFFFFFF|1)) * 0x9E3779B97F4A7C15
+	var counterIndex = (h >> 32) & (this.width - 1)
+	return uint64(row)*this.words + counterIndex/countersPerWord, (counterIndex % countersPerWord) * 4
+}
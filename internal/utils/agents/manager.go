@@ -63,6 +63,11 @@ func (this *Manager) Start() {
 		remotelogs.Error("AGENT_MANAGER", "load failed: "+err.Error())
 	}
 
+	// 独立运行模式下只使用本地数据
+	if teaconst.IsStandalone {
+		return
+	}
+
 	// 先从API获取
 	err = this.LoopAll()
 	if err != nil {
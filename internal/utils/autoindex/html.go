@@ -0,0 +1,121 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package autoindex
+
+import (
+	"html"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteHTML 输出HTML格式的目录列表
+// 点击表头可以按照对应的字段排序，排序和分页通过 sort、order、page 参数传递
+func WriteHTML(writer io.Writer, listing *Listing) error {
+	var title = "Index of " + html.EscapeString(listing.Path)
+
+	var builder = &strings.Builder{}
+	builder.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8"/>
+<title>` + title + `</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; font-size: 14px; margin: 2em; }
+table { border-collapse: collapse; min-width: 60%; }
+th, td { text-align: left; padding: 0.3em 1.5em 0.3em 0; }
+th a { color: inherit; }
+td.size, td.mtime { white-space: nowrap; color: #666; }
+.pages { margin-top: 1em; }
+.pages a, .pages span { margin-right: 0.5em; }
+</style>
+</head>
+<body>
+<h1>` + title + `</h1>
+<table>
+<thead><tr>`)
+
+	for _, column := range []struct {
+		field string
+		name  string
+	}{
+		{SortName, "Name"},
+		{SortSize, "Size"},
+		{SortMTime, "Last Modified"},
+	} {
+		var order = OrderAsc
+		var arrow = ""
+		if listing.Sort == column.field {
+			if listing.Order == OrderAsc {
+				order = OrderDesc
+				arrow = " &uarr;"
+			} else {
+				arrow = " &darr;"
+			}
+		}
+		builder.WriteString(`<th><a href="` + html.EscapeString(pageQuery(column.field, order, 1)) + `">` + column.name + arrow + `</a></th>`)
+	}
+	builder.WriteString("</tr></thead>\n<tbody>\n")
+
+	if listing.Path != "/" {
+		builder.WriteString(`<tr><td><a href="../">../</a></td><td class="size">-</td><td class="mtime"></td></tr>` + "\n")
+	}
+
+	for _, entry := range listing.Entries {
+		var href = url.PathEscape(entry.Name)
+		var name = html.EscapeString(entry.Name)
+		var size = "-"
+		if entry.Type == TypeDir {
+			href += "/"
+			name += "/"
+		} else {
+			size = formatSize(entry.Size)
+		}
+		builder.WriteString(`<tr><td><a href="` + html.EscapeString(href) + `">` + name + `</a></td><td class="size">` + size + `</td><td class="mtime">` + time.Unix(entry.MTime, 0).UTC().Format("2006-01-02 15:04:05") + "</td></tr>\n")
+	}
+	builder.WriteString("</tbody>\n</table>\n")
+
+	if listing.Pages > 1 {
+		builder.WriteString(`<div class="pages">`)
+		for page := 1; page <= listing.Pages; page++ {
+			if page == listing.Page {
+				builder.WriteString("<span>" + strconv.Itoa(page) + "</span>")
+			} else {
+				builder.WriteString(`<a href="` + html.EscapeString(pageQuery(listing.Sort, listing.Order, page)) + `">` + strconv.Itoa(page) + "</a>")
+			}
+		}
+		builder.WriteString("</div>\n")
+	}
+	builder.WriteString("</body>\n</html>")
+
+	_, err := io.WriteString(writer, builder.String())
+	return err
+}
+
+func pageQuery(sortField string, order string, page int) string {
+	var query = "?sort=" + sortField + "&order=" + order
+	if page > 1 {
+		query += "&page=" + strconv.Itoa(page)
+	}
+	return query
+}
+
+// 格式化文件尺寸
+func formatSize(size int64) string {
+	if size < 1024 {
+		return strconv.FormatInt(size, 10)
+	}
+	var units = []string{"K", "M", "G", "T", "P"}
+	var value = float64(size)
+	var unit = ""
+	for _, u := range units {
+		value /= 1024
+		unit = u
+		if value < 1024 {
+			break
+		}
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + unit
+}
@@ -0,0 +1,216 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package autoindex
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	TypeFile    = "file"
+	TypeDir     = "dir"
+	TypeSymlink = "symlink"
+
+	SortName  = "name"
+	SortSize  = "size"
+	SortMTime = "mtime"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// Entry 目录中的一个条目
+type Entry struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	MTime int64  `json:"mtime"`
+	Type  string `json:"type"`
+}
+
+// Options 列出目录的选项
+type Options struct {
+	ShowHidden bool   // 是否显示以 . 开头的文件
+	Sort       string // 排序字段：name、size、mtime
+	Order      string // 排序方式：asc、desc
+	Page       int    // 页码，从1开始
+	PageSize   int    // 每页条目数
+}
+
+// Listing 目录列表
+type Listing struct {
+	Path     string   `json:"path"`
+	Total    int      `json:"total"`
+	Page     int      `json:"page"`
+	PageSize int      `json:"pageSize"`
+	Pages    int      `json:"pages"`
+	Sort     string   `json:"sort"`
+	Order    string   `json:"order"`
+	Entries  []*Entry `json:"entries"`
+}
+
+// ReadDir 读取目录列表
+// 目录总是排在文件之前，超出页码范围时返回空列表
+func ReadDir(dir string, options *Options) (*Listing, error) {
+	if options == nil {
+		options = &Options{}
+	}
+
+	var sortField = options.Sort
+	switch sortField {
+	case SortName, SortSize, SortMTime:
+	default:
+		sortField = SortName
+	}
+	var order = options.Order
+	if order != OrderDesc {
+		order = OrderAsc
+	}
+	var pageSize = options.PageSize
+	if pageSize <= 0 {
+		pageSize = 1000
+	}
+	var page = options.Page
+	if page <= 0 {
+		page = 1
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries = make([]*Entry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		var name = dirEntry.Name()
+		if !options.ShowHidden && strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		info, err := dirEntry.Info()
+		if err != nil {
+			// 文件可能已经被删除
+			continue
+		}
+
+		var entry = &Entry{
+			Name:  name,
+			MTime: info.ModTime().Unix(),
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			entry.Type = TypeSymlink
+
+			// 显示链接目标的类型和尺寸
+			targetInfo, err := os.Stat(filepath.Join(dir, name))
+			if err == nil {
+				if targetInfo.IsDir() {
+					entry.Type = TypeDir
+				} else if targetInfo.Mode().IsRegular() {
+					entry.Size = targetInfo.Size()
+				}
+			}
+		case info.IsDir():
+			entry.Type = TypeDir
+		case info.Mode().IsRegular():
+			entry.Type = TypeFile
+			entry.Size = info.Size()
+		default:
+			// 不显示设备、管道等特殊文件
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		var entry1 = entries[i]
+		var entry2 = entries[j]
+		if (entry1.Type == TypeDir) != (entry2.Type == TypeDir) {
+			return entry1.Type == TypeDir
+		}
+
+		var less bool
+		switch sortField {
+		case SortSize:
+			if entry1.Size == entry2.Size {
+				return entry1.Name < entry2.Name
+			}
+			less = entry1.Size < entry2.Size
+		case SortMTime:
+			if entry1.MTime == entry2.MTime {
+				return entry1.Name < entry2.Name
+			}
+			less = entry1.MTime < entry2.MTime
+		default:
+			less = entry1.Name < entry2.Name
+		}
+		if order == OrderDesc {
+			return !less
+		}
+		return less
+	})
+
+	var total = len(entries)
+	var pages = (total + pageSize - 1) / pageSize
+	var start = (page - 1) * pageSize
+	if start > total {
+		start = total
+	}
+	var end = start + pageSize
+	if end > total {
+		end = total
+	}
+
+	return &Listing{
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+		Pages:    pages,
+		Sort:     sortField,
+		Order:    order,
+		Entries:  entries[start:end],
+	}, nil
+}
+
+// IsSubPath 检查路径是否在根目录之内，用来防止通过 ..、符号链接等访问根目录之外的文件
+func IsSubPath(root string, path string) bool {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(realRoot, realPath)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// IsHiddenPath 检查根目录之下的路径中是否有以 . 开头的部分，比如 /.git/objects
+func IsHiddenPath(root string, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	for _, piece := range strings.Split(filepath.ToSlash(rel), "/") {
+		if piece != "." && piece != ".." && strings.HasPrefix(piece, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package autoindex_test
+
+import (
+	"bytes"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/autoindex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func prepareDir(t *testing.T) string {
+	var dir = t.TempDir()
+	for i, name := range []string{"b.txt", "a.txt", "c.txt", ".hidden"} {
+		var path = filepath.Join(dir, name)
+		err := os.WriteFile(path, bytes.Repeat([]byte{'a'}, (i+1)*100), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var mtime = time.Now().Add(time.Duration(-i) * time.Hour)
+		err = os.Chtimes(path, mtime, mtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.Mkdir(filepath.Join(dir, "z"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func entryNames(listing *autoindex.Listing) string {
+	var names = []string{}
+	for _, entry := range listing.Entries {
+		names = append(names, entry.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestReadDir(t *testing.T) {
+	var dir = prepareDir(t)
+
+	for _, testCase := range []struct {
+		options *autoindex.Options
+		names   string
+	}{
+		{&autoindex.Options{}, "z,a.txt,b.txt,c.txt"},
+		{&autoindex.Options{ShowHidden: true}, "z,.hidden,a.txt,b.txt,c.txt"},
+		{&autoindex.Options{Order: autoindex.OrderDesc}, "z,c.txt,b.txt,a.txt"},
+		{&autoindex.Options{Sort: autoindex.SortSize, Order: autoindex.OrderDesc}, "z,c.txt,a.txt,b.txt"},
+		{&autoindex.Options{Sort: autoindex.SortMTime}, "z,c.txt,a.txt,b.txt"},
+		{&autoindex.Options{PageSize: 3, Page: 2}, "c.txt"},
+		{&autoindex.Options{PageSize: 3, Page: 3}, ""},
+	} {
+		listing, err := autoindex.ReadDir(dir, testCase.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entryNames(listing) != testCase.names {
+			t.Fatalf("%+v: expect '%s', got '%s'", testCase.options, testCase.names, entryNames(listing))
+		}
+	}
+
+	listing, err := autoindex.ReadDir(dir, &autoindex.Options{PageSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listing.Total != 4 || listing.Pages != 2 {
+		t.Fatal("unexpected pages:", listing.Total, listing.Pages)
+	}
+	if listing.Entries[0].Type != autoindex.TypeDir || listing.Entries[1].Type != autoindex.TypeFile || listing.Entries[1].Size != 200 {
+		t.Fatalf("unexpected entries: %+v, %+v", listing.Entries[0], listing.Entries[1])
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	var dir = prepareDir(t)
+	err := os.WriteFile(filepath.Join(dir, "<script>.txt"), []byte("1"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listing, err := autoindex.ReadDir(dir, &autoindex.Options{PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing.Path = "/files/"
+
+	var buf = &bytes.Buffer{}
+	err = autoindex.WriteHTML(buf, listing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content = buf.String()
+	if strings.Contains(content, "<script>") {
+		t.Fatal("file name should be escaped")
+	}
+	for _, s := range []string{"Index of /files/", `href="z/"`, `href="../"`, "page=" + strconv.Itoa(listing.Pages)} {
+		if !strings.Contains(content, s) {
+			t.Fatal("expect '" + s + "' in html")
+		}
+	}
+}
+
+func TestIsSubPath(t *testing.T) {
+	var dir = prepareDir(t)
+	var outside = t.TempDir()
+	err := os.Symlink(outside, filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, result := range map[string]bool{
+		dir:                                 true,
+		filepath.Join(dir, "z"):             true,
+		filepath.Join(dir, "z", ".."):       true,
+		filepath.Join(dir, ".."):            false,
+		filepath.Join(dir, "z", "..", ".."): false,
+		filepath.Join(dir, "link"):          false,
+		filepath.Join(dir, "not-found"):     false,
+	} {
+		if autoindex.IsSubPath(dir, path) != result {
+			t.Fatal(path, "expect", result)
+		}
+	}
+}
+
+func TestIsHiddenPath(t *testing.T) {
+	var root = filepath.Join("/", "data", "www")
+	for path, result := range map[string]bool{
+		root:                                   false,
+		filepath.Join(root, "a", "b"):          false,
+		filepath.Join(root, "a.b"):             false,
+		filepath.Join(root, ".git"):            true,
+		filepath.Join(root, ".git", "objects"): true,
+		filepath.Join(root, "a", ".svn", "b"):  true,
+	} {
+		if autoindex.IsHiddenPath(root, path) != result {
+			t.Fatal(path, "expect", result)
+		}
+	}
+
+	// 根目录本身以 . 开头
+	if autoindex.IsHiddenPath(filepath.Join("/", ".data", "www"), filepath.Join("/", ".data", "www", "a")) {
+		t.Fatal("root should not be checked")
+	}
+}
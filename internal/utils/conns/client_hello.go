@@ -0,0 +1,233 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package connutils
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	tlsRecordTypeHandshake    = 0x16
+	tlsHandshakeTypeHello     = 0x01
+	tlsExtensionServerName    = 0x00
+	tlsExtensionALPN          = 0x10
+	tlsMaxClientHelloLength   = 64 * 1024
+	tlsRecordHeaderLength     = 5
+	tlsHandshakeHeaderLength  = 4
+	tlsServerNameTypeHostName = 0x00
+)
+
+var ErrNotClientHello = errors.New("not a tls client hello")
+
+// ClientHello 从TLS ClientHello中解析出的信息
+type ClientHello struct {
+	ServerName string   // SNI
+	ALPN       []string // 客户端支持的应用层协议
+}
+
+// PeekClientHello 读取连接中的TLS ClientHello，但不终止TLS
+// 返回的连接会重新读到已经读取的数据，可以原样转发给源站或者交给 tls.Server 处理
+// 如果读取的数据不是ClientHello，仍然返回可以重新读取数据的连接，以及 ErrNotClientHello
+func PeekClientHello(conn net.Conn, timeout time.Duration) (*ClientHello, net.Conn, error) {
+	if timeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		defer func() {
+			_ = conn.SetReadDeadline(time.Time{})
+		}()
+	}
+
+	var data = []byte{}
+	var handshake = []byte{}
+	var handshakeLength = -1
+	for {
+		// 记录头
+		var header = make([]byte, tlsRecordHeaderLength)
+		n, err := io.ReadFull(conn, header)
+		data = append(data, header[:n]...)
+		if err != nil {
+			return nil, NewPeekedConn(conn, data), err
+		}
+		if header[0] != tlsRecordTypeHandshake || header[1] != 0x03 {
+			return nil, NewPeekedConn(conn, data), ErrNotClientHello
+		}
+		var recordLength = int(binary.BigEndian.Uint16(header[3:]))
+		if recordLength == 0 || len(data)+recordLength > tlsMaxClientHelloLength {
+			return nil, NewPeekedConn(conn, data), ErrNotClientHello
+		}
+
+		// 记录内容
+		var fragment = make([]byte, recordLength)
+		n, err = io.ReadFull(conn, fragment)
+		data = append(data, fragment[:n]...)
+		if err != nil {
+			return nil, NewPeekedConn(conn, data), err
+		}
+		handshake = append(handshake, fragment...)
+
+		// ClientHello可能分布在多个记录中
+		if handshakeLength < 0 && len(handshake) >= tlsHandshakeHeaderLength {
+			if handshake[0] != tlsHandshakeTypeHello {
+				return nil, NewPeekedConn(conn, data), ErrNotClientHello
+			}
+			handshakeLength = int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+			if handshakeLength+tlsHandshakeHeaderLength > tlsMaxClientHelloLength {
+				return nil, NewPeekedConn(conn, data), ErrNotClientHello
+			}
+		}
+		if handshakeLength >= 0 && len(handshake) >= handshakeLength+tlsHandshakeHeaderLength {
+			break
+		}
+	}
+
+	hello, err := ParseClientHello(handshake[tlsHandshakeHeaderLength : handshakeLength+tlsHandshakeHeaderLength])
+	return hello, NewPeekedConn(conn, data), err
+}
+
+// ParseClientHello 解析ClientHello消息体（不包含记录头和握手消息头）
+func ParseClientHello(body []byte) (*ClientHello, error) {
+	var reader = &helloReader{data: body}
+
+	// 版本和随机数
+	if !reader.skip(2 + 32) {
+		return nil, ErrNotClientHello
+	}
+
+	// Session ID
+	if !reader.skipVector(1) {
+		return nil, ErrNotClientHello
+	}
+
+	// 加密套件
+	if !reader.skipVector(2) {
+		return nil, ErrNotClientHello
+	}
+
+	// 压缩方法
+	if !reader.skipVector(1) {
+		return nil, ErrNotClientHello
+	}
+
+	var hello = &ClientHello{}
+
+	// 没有扩展
+	if reader.isEmpty() {
+		return hello, nil
+	}
+
+	extensions, ok := reader.readVector(2)
+	if !ok {
+		return nil, ErrNotClientHello
+	}
+	var extensionsReader = &helloReader{data: extensions}
+	for !extensionsReader.isEmpty() {
+		extensionType, ok := extensionsReader.readUint16()
+		if !ok {
+			return nil, ErrNotClientHello
+		}
+		extensionData, ok := extensionsReader.readVector(2)
+		if !ok {
+			return nil, ErrNotClientHello
+		}
+
+		switch extensionType {
+		case tlsExtensionServerName:
+			var extensionReader = &helloReader{data: extensionData}
+			nameList, ok := extensionReader.readVector(2)
+			if !ok {
+				return nil, ErrNotClientHello
+			}
+			var nameListReader = &helloReader{data: nameList}
+			for !nameListReader.isEmpty() {
+				nameType, ok := nameListReader.readUint8()
+				if !ok {
+					return nil, ErrNotClientHello
+				}
+				name, ok := nameListReader.readVector(2)
+				if !ok {
+					return nil, ErrNotClientHello
+				}
+				if nameType == tlsServerNameTypeHostName && len(hello.ServerName) == 0 {
+					hello.ServerName = strings.TrimSuffix(strings.ToLower(string(name)), ".")
+				}
+			}
+		case tlsExtensionALPN:
+			var extensionReader = &helloReader{data: extensionData}
+			protoList, ok := extensionReader.readVector(2)
+			if !ok {
+				return nil, ErrNotClientHello
+			}
+			var protoListReader = &helloReader{data: protoList}
+			for !protoListReader.isEmpty() {
+				proto, ok := protoListReader.readVector(1)
+				if !ok {
+					return nil, ErrNotClientHello
+				}
+				hello.ALPN = append(hello.ALPN, string(proto))
+			}
+		}
+	}
+
+	return hello, nil
+}
+
+// 按照TLS编码规则读取数据
+type helloReader struct {
+	data []byte
+}
+
+func (this *helloReader) isEmpty() bool {
+	return len(this.data) == 0
+}
+
+func (this *helloReader) skip(n int) bool {
+	if len(this.data) < n {
+		return false
+	}
+	this.data = this.data[n:]
+	return true
+}
+
+func (this *helloReader) readUint8() (uint8, bool) {
+	if len(this.data) < 1 {
+		return 0, false
+	}
+	var v = this.data[0]
+	this.data = this.data[1:]
+	return v, true
+}
+
+func (this *helloReader) readUint16() (uint16, bool) {
+	if len(this.data) < 2 {
+		return 0, false
+	}
+	var v = binary.BigEndian.Uint16(this.data)
+	this.data = this.data[2:]
+	return v, true
+}
+
+// 读取以 lengthBytes 个字节长度为前缀的数据
+func (this *helloReader) readVector(lengthBytes int) ([]byte, bool) {
+	if len(this.data) < lengthBytes {
+		return nil, false
+	}
+	var length = 0
+	for i := 0; i < lengthBytes; i++ {
+		length = length<<8 | int(this.data[i])
+	}
+	if len(this.data) < lengthBytes+length {
+		return nil, false
+	}
+	var v = this.data[lengthBytes : lengthBytes+length]
+	this.data = this.data[lengthBytes+length:]
+	return v, true
+}
+
+func (this *helloReader) skipVector(lengthBytes int) bool {
+	_, ok := this.readVector(lengthBytes)
+	return ok
+}
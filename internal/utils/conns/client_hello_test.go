@@ -0,0 +1,130 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package connutils_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	connutils "github.com/TeaOSLab/EdgeNode/internal/utils/conns"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var template = &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "www.example.com"},
+		DNSNames:     []string{"www.example.com"},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+	}
+	certData, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{certData},
+		PrivateKey:  key,
+	}
+}
+
+func TestPeekClientHello(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer func() {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	}()
+
+	var clientErr = make(chan error, 1)
+	go func() {
+		var client = tls.Client(clientConn, &tls.Config{
+			ServerName:         "WWW.Example.com",
+			NextProtos:         []string{"h2", "http/1.1"},
+			InsecureSkipVerify: true,
+		})
+		err := client.Handshake()
+		if err == nil {
+			_, err = client.Write([]byte("hello"))
+		}
+		clientErr <- err
+	}()
+
+	hello, peekedConn, err := connutils.PeekClientHello(serverConn, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hello.ServerName != "www.example.com" {
+		t.Fatal("unexpected server name:", hello.ServerName)
+	}
+	if len(hello.ALPN) != 2 || hello.ALPN[0] != "h2" || hello.ALPN[1] != "http/1.1" {
+		t.Fatal("unexpected alpn:", hello.ALPN)
+	}
+
+	// 预读的数据可以继续完成握手
+	var server = tls.Server(peekedConn, &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t)},
+	})
+	var buf = make([]byte, 5)
+	_, err = io.ReadFull(server, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatal("unexpected data:", string(buf))
+	}
+	err = <-clientErr
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPeekClientHello_NotTLS(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer func() {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	}()
+
+	go func() {
+		_, _ = clientConn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	}()
+
+	_, peekedConn, err := connutils.PeekClientHello(serverConn, 5*time.Second)
+	if err != connutils.ErrNotClientHello {
+		t.Fatal("expect ErrNotClientHello, got", err)
+	}
+
+	// 已读取的数据不会丢失
+	var buf = make([]byte, 5)
+	_, err = io.ReadFull(peekedConn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "GET /" {
+		t.Fatal("unexpected data:", string(buf))
+	}
+}
+
+func TestParseClientHello_Invalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		make([]byte, 10),
+		append(make([]byte, 34), 0xFF),
+	} {
+		_, err := connutils.ParseClientHello(data)
+		if err == nil {
+			t.Fatal("invalid client hello should be rejected")
+		}
+	}
+}
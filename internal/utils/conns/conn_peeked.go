@@ -0,0 +1,36 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package connutils
+
+import (
+	"bytes"
+	"io"
+	"net"
+)
+
+// PeekedConn 已经预先读取了部分数据的连接，读取时先返回预读的数据
+type PeekedConn struct {
+	net.Conn
+
+	reader io.Reader
+}
+
+func NewPeekedConn(rawConn net.Conn, peekedData []byte) *PeekedConn {
+	var reader io.Reader = rawConn
+	if len(peekedData) > 0 {
+		reader = io.MultiReader(bytes.NewReader(peekedData), rawConn)
+	}
+	return &PeekedConn{
+		Conn:   rawConn,
+		reader: reader,
+	}
+}
+
+func (this *PeekedConn) Read(b []byte) (n int, err error) {
+	return this.reader.Read(b)
+}
+
+// RawConn 原始连接
+func (this *PeekedConn) RawConn() net.Conn {
+	return this.Conn
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package hashring
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/utils/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas 每个节点默认的虚拟节点数量
+const DefaultReplicas = 160
+
+type point struct {
+	hash uint64
+	id   int64
+}
+
+// Ring 一致性Hash环
+// 同一个Key总是映射到同一个节点，节点增减或者暂时不可用时，只影响映射到这个节点上的Key
+// 节点使用ID表示，使用字符串等作为成员时，可以由调用者将成员映射为固定的ID；不能在多个协程中同时修改
+type Ring struct {
+	replicas int
+	points   []point
+	ids      map[int64]bool
+}
+
+// NewRing 获取新对象
+func NewRing(replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return &Ring{
+		replicas: replicas,
+		ids:      map[int64]bool{},
+	}
+}
+
+// Add 添加节点
+func (this *Ring) Add(id int64) {
+	if this.ids[id] {
+		return
+	}
+	this.ids[id] = true
+
+	for i := 0; i < this.replicas; i++ {
+		this.points = append(this.points, point{
+			hash: hashKey(strconv.FormatInt(id, 10) + "#" + strconv.Itoa(i)),
+			id:   id,
+		})
+	}
+	sort.Slice(this.points, func(i, j int) bool {
+		// Hash冲突时按ID排序，保证添加顺序不同时结果一致
+		if this.points[i].hash == this.points[j].hash {
+			return this.points[i].id < this.points[j].id
+		}
+		return this.points[i].hash < this.points[j].hash
+	})
+}
+
+// Len 节点数量
+func (this *Ring) Len() int {
+	return len(this.ids)
+}
+
+// Get 查找Key对应的节点
+// filter 用来跳过不可用的节点，跳过时沿着环继续查找下一个节点，为nil时表示所有节点都可用
+func (this *Ring) Get(key string, filter func(id int64) bool) (id int64, ok bool) {
+	var count = len(this.points)
+	if count == 0 {
+		return 0, false
+	}
+
+	var hash = hashKey(key)
+	var index = sort.Search(count, func(i int) bool {
+		return this.points[i].hash >= hash
+	})
+
+	var skippedIds map[int64]bool
+	for i := 0; i < count; i++ {
+		var p = this.points[(index+i)%count]
+		if filter == nil {
+			return p.id, true
+		}
+		if skippedIds[p.id] {
+			continue
+		}
+		if filter(p.id) {
+			return p.id, true
+		}
+		if skippedIds == nil {
+			skippedIds = map[int64]bool{}
+		}
+		skippedIds[p.id] = true
+		if len(skippedIds) == len(this.ids) {
+			break
+		}
+	}
+	return 0, false
+}
+
+// 在FNV的基础上再做一次混淆，使相近的Key在环上分布得更均匀
+func hashKey(key string) uint64 {
+	var h = fnv.HashString(key)
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
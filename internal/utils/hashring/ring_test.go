@@ -0,0 +1,105 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package hashring_test
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/utils/hashring"
+	"strconv"
+	"testing"
+)
+
+func TestRing_Get(t *testing.T) {
+	var ring = hashring.NewRing(0)
+	_, ok := ring.Get("192.168.1.1", nil)
+	if ok {
+		t.Fatal("empty ring should not return any node")
+	}
+
+	for _, id := range []int64{1, 2, 3} {
+		ring.Add(id)
+	}
+	ring.Add(1) // 重复添加
+	if ring.Len() != 3 {
+		t.Fatal("expect 3 nodes, got", ring.Len())
+	}
+
+	// 同一个Key总是得到同一个节点
+	first, ok := ring.Get("192.168.1.1", nil)
+	if !ok {
+		t.Fatal("expect a node")
+	}
+	for i := 0; i < 10; i++ {
+		id, _ := ring.Get("192.168.1.1", nil)
+		if id != first {
+			t.Fatal("expect", first, "got", id)
+		}
+	}
+
+	// 分布基本均匀
+	var countMap = map[int64]int{}
+	var keys = 30000
+	for i := 0; i < keys; i++ {
+		id, _ := ring.Get("10.0."+strconv.Itoa(i/256)+"."+strconv.Itoa(i%256), nil)
+		countMap[id]++
+	}
+	for id, count := range countMap {
+		if count < keys/5 {
+			t.Fatal("node", id, "got too few keys:", count)
+		}
+	}
+}
+
+func TestRing_Filter(t *testing.T) {
+	var ring = hashring.NewRing(0)
+	for _, id := range []int64{1, 2, 3} {
+		ring.Add(id)
+	}
+
+	var mapping = map[string]int64{}
+	for i := 0; i < 1000; i++ {
+		var key = "10.0.0." + strconv.Itoa(i)
+		mapping[key], _ = ring.Get(key, nil)
+	}
+
+	// 一个节点不可用时，只有映射到这个节点上的Key会改变
+	var filter = func(id int64) bool {
+		return id != 2
+	}
+	for key, oldId := range mapping {
+		id, ok := ring.Get(key, filter)
+		if !ok || id == 2 {
+			t.Fatal("unavailable node should be skipped")
+		}
+		if oldId != 2 && id != oldId {
+			t.Fatal("key '"+key+"' should not be moved:", oldId, "=>", id)
+		}
+	}
+
+	// 所有节点都不可用
+	_, ok := ring.Get("10.0.0.1", func(id int64) bool {
+		return false
+	})
+	if ok {
+		t.Fatal("expect no node")
+	}
+}
+
+func TestRing_Order(t *testing.T) {
+	// 添加顺序不影响结果，以便在不同节点上使用同样的成员时结果一致
+	var ring1 = hashring.NewRing(0)
+	var ring2 = hashring.NewRing(0)
+	for _, id := range []int64{1, 2, 3} {
+		ring1.Add(id)
+	}
+	for _, id := range []int64{3, 1, 2} {
+		ring2.Add(id)
+	}
+	for i := 0; i < 1000; i++ {
+		var key = "https://example.com/" + strconv.Itoa(i)
+		id1, _ := ring1.Get(key, nil)
+		id2, _ := ring2.Get(key, nil)
+		if id1 != id2 {
+			t.Fatal("key '"+key+"' should be mapped to same node:", id1, id2)
+		}
+	}
+}
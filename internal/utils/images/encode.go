@@ -0,0 +1,87 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package images
+
+import (
+	"errors"
+	"github.com/iwind/gowebp"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// Transform 按照选项缩放和裁剪图片
+func Transform(img image.Image, options *TransformOptions) image.Image {
+	var bounds = img.Bounds()
+	width, height, srcRect := options.TargetSize(bounds.Dx(), bounds.Dy())
+	srcRect = srcRect.Add(bounds.Min)
+	if width == bounds.Dx() && height == bounds.Dy() && srcRect == bounds {
+		return img
+	}
+
+	var dst = image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, srcRect, draw.Src, nil)
+	return dst
+}
+
+// Encode 将图片编码为指定格式
+func Encode(writer io.Writer, img image.Image, format string, quality int) error {
+	if quality <= 0 || quality > 100 {
+		quality = 80
+	}
+
+	switch format {
+	case FormatJPEG:
+		return jpeg.Encode(writer, flatten(img), &jpeg.Options{Quality: quality})
+	case FormatPNG:
+		return png.Encode(writer, img)
+	case FormatWebP:
+		return gowebp.Encode(writer, img, &gowebp.Options{
+			Lossless: false,
+			Quality:  float32(quality),
+			Exact:    true,
+		})
+	}
+	return errors.New("unsupported image format '" + format + "'")
+}
+
+// FormatWithContentType 根据Content-Type获取默认输出格式，不支持的类型返回空
+// GIF只转换第一帧，输出为PNG
+func FormatWithContentType(contentType string) string {
+	var semicolonIndex = strings.Index(contentType, ";")
+	if semicolonIndex >= 0 {
+		contentType = contentType[:semicolonIndex]
+	}
+	switch strings.ToLower(strings.TrimSpace(contentType)) {
+	case "image/jpeg", "image/jpg", "image/pjpeg":
+		return FormatJPEG
+	case "image/png", "image/gif":
+		return FormatPNG
+	case "image/webp":
+		return FormatWebP
+	}
+	return ""
+}
+
+// ContentType 输出格式对应的Content-Type
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// JPEG不支持透明，将透明区域填充为白色
+func flatten(img image.Image) image.Image {
+	switch img.(type) {
+	case *image.YCbCr, *image.Gray, *image.CMYK:
+		return img
+	}
+
+	var bounds = img.Bounds()
+	var dst = image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,214 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package images
+
+import (
+	"errors"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 缩放方式
+const (
+	FitContain = "contain" // 等比缩放到宽高范围之内
+	FitCover   = "cover"   // 等比缩放到覆盖宽高，不裁剪
+	FitCrop    = "crop"    // 等比缩放到覆盖宽高，并从中间裁剪为指定宽高
+)
+
+// 输出格式
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+)
+
+var ErrInvalidOptions = errors.New("invalid image transform options")
+
+// TransformLimits 转换限制，用来防止使用过大的尺寸消耗资源
+type TransformLimits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxDPR    float64
+}
+
+// TransformOptions 图片转换选项
+type TransformOptions struct {
+	Width   int     // 宽度，0表示根据高度等比计算
+	Height  int     // 高度，0表示根据宽度等比计算
+	Fit     string  // 缩放方式
+	DPR     float64 // 设备像素比，实际尺寸为宽高乘以DPR
+	Quality int     // 质量，0表示使用默认质量
+	Format  string  // 输出格式，为空表示和原图保持一致
+}
+
+// ParseTransformOptions 解析转换选项
+// 格式为 width=200,height=100,fit=cover,dpr=2,quality=80,format=webp，也可以使用缩写 w、h、q、f
+func ParseTransformOptions(s string, limits *TransformLimits) (*TransformOptions, error) {
+	var options = &TransformOptions{
+		Fit: FitContain,
+		DPR: 1,
+	}
+
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return nil, ErrInvalidOptions
+	}
+
+	for _, piece := range strings.Split(s, ",") {
+		piece = strings.TrimSpace(piece)
+		if len(piece) == 0 {
+			continue
+		}
+		var eqIndex = strings.IndexAny(piece, "=:")
+		if eqIndex <= 0 {
+			return nil, errors.New("invalid image transform option '" + piece + "'")
+		}
+		var name = strings.ToLower(strings.TrimSpace(piece[:eqIndex]))
+		var value = strings.ToLower(strings.TrimSpace(piece[eqIndex+1:]))
+
+		var err error
+		switch name {
+		case "width", "w":
+			options.Width, err = strconv.Atoi(value)
+			if err != nil || options.Width < 0 {
+				return nil, errors.New("invalid image width '" + value + "'")
+			}
+		case "height", "h":
+			options.Height, err = strconv.Atoi(value)
+			if err != nil || options.Height < 0 {
+				return nil, errors.New("invalid image height '" + value + "'")
+			}
+		case "fit":
+			switch value {
+			case FitContain, FitCover, FitCrop:
+				options.Fit = value
+			default:
+				return nil, errors.New("invalid image fit '" + value + "'")
+			}
+		case "dpr":
+			options.DPR, err = strconv.ParseFloat(value, 64)
+			if err != nil || options.DPR <= 0 || math.IsNaN(options.DPR) || math.IsInf(options.DPR, 0) {
+				return nil, errors.New("invalid image dpr '" + value + "'")
+			}
+		case "quality", "q":
+			options.Quality, err = strconv.Atoi(value)
+			if err != nil || options.Quality < 1 || options.Quality > 100 {
+				return nil, errors.New("invalid image quality '" + value + "'")
+			}
+		case "format", "f":
+			if value == "jpg" {
+				value = FormatJPEG
+			}
+			switch value {
+			case FormatJPEG, FormatPNG, FormatWebP:
+				options.Format = value
+			default:
+				return nil, errors.New("invalid image format '" + value + "'")
+			}
+		default:
+			return nil, errors.New("unknown image transform option '" + name + "'")
+		}
+	}
+
+	// 检查尺寸限制
+	if limits != nil {
+		if limits.MaxDPR > 0 && options.DPR > limits.MaxDPR {
+			return nil, errors.New("image dpr should not be greater than " + strconv.FormatFloat(limits.MaxDPR, 'f', -1, 64))
+		}
+		var width, height = options.scaledSize()
+		if limits.MaxWidth > 0 && width > limits.MaxWidth {
+			return nil, errors.New("image width should not be greater than " + strconv.Itoa(limits.MaxWidth))
+		}
+		if limits.MaxHeight > 0 && height > limits.MaxHeight {
+			return nil, errors.New("image height should not be greater than " + strconv.Itoa(limits.MaxHeight))
+		}
+	}
+
+	return options, nil
+}
+
+// Key 用于区分缓存的唯一标识
+func (this *TransformOptions) Key() string {
+	var width, height = this.scaledSize()
+	return "w" + strconv.Itoa(width) +
+		"_h" + strconv.Itoa(height) +
+		"_" + this.Fit +
+		"_q" + strconv.Itoa(this.Quality) +
+		"_" + this.Format
+}
+
+// TargetSize 根据原图尺寸计算缩放后的尺寸，以及需要从原图中截取的区域
+// 不会放大图片
+func (this *TransformOptions) TargetSize(srcWidth int, srcHeight int) (width int, height int, srcRect image.Rectangle) {
+	srcRect = image.Rect(0, 0, srcWidth, srcHeight)
+	if srcWidth <= 0 || srcHeight <= 0 {
+		return srcWidth, srcHeight, srcRect
+	}
+
+	var boxWidth, boxHeight = this.scaledSize()
+	if boxWidth == 0 && boxHeight == 0 {
+		return srcWidth, srcHeight, srcRect
+	}
+
+	var scaleX = float64(boxWidth) / float64(srcWidth)
+	var scaleY = float64(boxHeight) / float64(srcHeight)
+	var scale float64
+	switch {
+	case boxWidth == 0:
+		scale = scaleY
+	case boxHeight == 0:
+		scale = scaleX
+	case this.Fit == FitCover || this.Fit == FitCrop:
+		scale = math.Max(scaleX, scaleY)
+	default:
+		scale = math.Min(scaleX, scaleY)
+	}
+	if scale > 1 {
+		scale = 1
+	}
+
+	width = roundSize(float64(srcWidth) * scale)
+	height = roundSize(float64(srcHeight) * scale)
+
+	// 从中间裁剪
+	if this.Fit == FitCrop && boxWidth > 0 && boxHeight > 0 {
+		if width > boxWidth {
+			width = boxWidth
+		}
+		if height > boxHeight {
+			height = boxHeight
+		}
+		var cropWidth = int(math.Min(float64(srcWidth), math.Round(float64(width)/scale)))
+		var cropHeight = int(math.Min(float64(srcHeight), math.Round(float64(height)/scale)))
+		var left = (srcWidth - cropWidth) / 2
+		var top = (srcHeight - cropHeight) / 2
+		srcRect = image.Rect(left, top, left+cropWidth, top+cropHeight)
+	}
+
+	return
+}
+
+// 乘以DPR之后的宽高
+func (this *TransformOptions) scaledSize() (width int, height int) {
+	var dpr = this.DPR
+	if dpr <= 0 {
+		dpr = 1
+	}
+	if this.Width > 0 {
+		width = roundSize(float64(this.Width) * dpr)
+	}
+	if this.Height > 0 {
+		height = roundSize(float64(this.Height) * dpr)
+	}
+	return
+}
+
+func roundSize(f float64) int {
+	var size = int(math.Round(f))
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
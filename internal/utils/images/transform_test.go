@@ -0,0 +1,136 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package images_test
+
+import (
+	"bytes"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/images"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestParseTransformOptions(t *testing.T) {
+	var limits = &images.TransformLimits{
+		MaxWidth:  1000,
+		MaxHeight: 1000,
+		MaxDPR:    3,
+	}
+
+	{
+		options, err := images.ParseTransformOptions("width=200,h=100,fit=crop,dpr=2,q=75,format=jpg", limits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if options.Width != 200 || options.Height != 100 || options.Fit != images.FitCrop || options.DPR != 2 || options.Quality != 75 || options.Format != images.FormatJPEG {
+			t.Fatalf("unexpected options: %+v", options)
+		}
+		if options.Key() != "w400_h200_crop_q75_jpeg" {
+			t.Fatal("unexpected key:", options.Key())
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"width=abc",
+		"width=-1",
+		"width=600,dpr=2", // 超出最大宽度
+		"height=2000",
+		"dpr=4",
+		"quality=0",
+		"format=bmp",
+		"fit=fill",
+		"rotate=90",
+	} {
+		_, err := images.ParseTransformOptions(s, limits)
+		if err == nil {
+			t.Fatal("'" + s + "' should be rejected")
+		}
+	}
+}
+
+func TestTransformOptions_TargetSize(t *testing.T) {
+	for _, testCase := range []struct {
+		options       string
+		width, height int
+		rect          image.Rectangle
+	}{
+		{"w=200", 200, 100, image.Rect(0, 0, 400, 200)},
+		{"h=50", 100, 50, image.Rect(0, 0, 400, 200)},
+		{"w=100,h=100,fit=contain", 100, 50, image.Rect(0, 0, 400, 200)},
+		{"w=100,h=100,fit=cover", 200, 100, image.Rect(0, 0, 400, 200)},
+		{"w=100,h=100,fit=crop", 100, 100, image.Rect(100, 0, 300, 200)},
+		{"w=800", 400, 200, image.Rect(0, 0, 400, 200)}, // 不放大
+		{"w=800,h=100,fit=crop", 400, 100, image.Rect(0, 50, 400, 150)},
+	} {
+		options, err := images.ParseTransformOptions(testCase.options, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		width, height, rect := options.TargetSize(400, 200)
+		if width != testCase.width || height != testCase.height || rect != testCase.rect {
+			t.Fatal(testCase.options, "unexpected result:", width, height, rect)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	var src = image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 200; y++ {
+			src.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+
+	options, err := images.ParseTransformOptions("w=100,h=100,fit=crop", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst = images.Transform(src, options)
+	if dst.Bounds().Dx() != 100 || dst.Bounds().Dy() != 100 {
+		t.Fatal("unexpected size:", dst.Bounds())
+	}
+
+	{
+		var buf = &bytes.Buffer{}
+		err = images.Encode(buf, dst, images.FormatJPEG, 80)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := jpeg.Decode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Dx() != 100 {
+			t.Fatal("unexpected jpeg size:", img.Bounds())
+		}
+	}
+
+	{
+		var buf = &bytes.Buffer{}
+		err = images.Encode(buf, dst, images.FormatPNG, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = png.Decode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFormatWithContentType(t *testing.T) {
+	for contentType, format := range map[string]string{
+		"image/jpeg":               images.FormatJPEG,
+		"image/png; charset=utf-8": images.FormatPNG,
+		"image/gif":                images.FormatPNG,
+		"image/webp":               images.FormatWebP,
+		"text/html":                "",
+	} {
+		if images.FormatWithContentType(contentType) != format {
+			t.Fatal("unexpected format for", contentType)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package rangeutils
+
+// SliceRange 获取第 index 个分片的范围
+// total 大于0时，最后一个分片的结束位置不会超出内容长度
+func SliceRange(index int64, sliceSize int64, total int64) Range {
+	var r = NewRange(index*sliceSize, (index+1)*sliceSize-1)
+	if total > 0 && r[1] > total-1 {
+		r[1] = total - 1
+	}
+	return r
+}
+
+// SliceIndexes 获取当前范围覆盖的第一个和最后一个分片序号
+func (this Range) SliceIndexes(sliceSize int64) (first int64, last int64) {
+	return this[0] / sliceSize, this[1] / sliceSize
+}
+
+// Intersect 获取和另外一个范围的交集
+func (this Range) Intersect(r Range) (result Range, ok bool) {
+	result = this
+	if r[0] > result[0] {
+		result[0] = r[0]
+	}
+	if r[1] < result[1] {
+		result[1] = r[1]
+	}
+	return result, result[0] <= result[1]
+}
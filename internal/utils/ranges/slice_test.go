@@ -0,0 +1,43 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package rangeutils_test
+
+import (
+	rangeutils "github.com/TeaOSLab/EdgeNode/internal/utils/ranges"
+	"github.com/iwind/TeaGo/assert"
+	"testing"
+)
+
+func TestSliceRange(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	a.IsTrue(rangeutils.SliceRange(0, 1024, 0) == rangeutils.NewRange(0, 1023))
+	a.IsTrue(rangeutils.SliceRange(2, 1024, 0) == rangeutils.NewRange(2048, 3071))
+	a.IsTrue(rangeutils.SliceRange(2, 1024, 2500) == rangeutils.NewRange(2048, 2499))
+}
+
+func TestRange_SliceIndexes(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	first, last := rangeutils.NewRange(0, 1023).SliceIndexes(1024)
+	a.IsTrue(first == 0 && last == 0)
+
+	first, last = rangeutils.NewRange(1000, 5000).SliceIndexes(1024)
+	a.IsTrue(first == 0 && last == 4)
+
+	first, last = rangeutils.NewRange(1024, 2048).SliceIndexes(1024)
+	a.IsTrue(first == 1 && last == 2)
+}
+
+func TestRange_Intersect(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	r, ok := rangeutils.NewRange(100, 5000).Intersect(rangeutils.NewRange(1024, 2047))
+	a.IsTrue(ok && r == rangeutils.NewRange(1024, 2047))
+
+	r, ok = rangeutils.NewRange(100, 1500).Intersect(rangeutils.NewRange(1024, 2047))
+	a.IsTrue(ok && r == rangeutils.NewRange(1024, 1500))
+
+	_, ok = rangeutils.NewRange(100, 500).Intersect(rangeutils.NewRange(1024, 2047))
+	a.IsFalse(ok)
+}
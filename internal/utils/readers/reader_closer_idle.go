@@ -0,0 +1,49 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package readers
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var ErrIdleTimeout = errors.New("read idle timeout")
+
+// IdleTimeoutReaderCloser 在指定时间内没有读到数据时自动关闭的Reader
+// 用于长时间保持的流式响应，只限制两次数据之间的间隔，不限制总的读取时间
+type IdleTimeoutReaderCloser struct {
+	rawReader io.ReadCloser
+	timeout   time.Duration
+	timer     *time.Timer
+	isTimeout int32
+}
+
+func NewIdleTimeoutReaderCloser(rawReader io.ReadCloser, timeout time.Duration) *IdleTimeoutReaderCloser {
+	var reader = &IdleTimeoutReaderCloser{
+		rawReader: rawReader,
+		timeout:   timeout,
+	}
+	reader.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&reader.isTimeout, 1)
+		_ = rawReader.Close()
+	})
+	return reader
+}
+
+func (this *IdleTimeoutReaderCloser) Read(p []byte) (n int, err error) {
+	n, err = this.rawReader.Read(p)
+	if n > 0 && atomic.LoadInt32(&this.isTimeout) == 0 {
+		this.timer.Reset(this.timeout)
+	}
+	if err != nil && err != io.EOF && atomic.LoadInt32(&this.isTimeout) == 1 {
+		err = ErrIdleTimeout
+	}
+	return
+}
+
+func (this *IdleTimeoutReaderCloser) Close() error {
+	this.timer.Stop()
+	return this.rawReader.Close()
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package readers_test
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/utils/readers"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeoutReaderCloser(t *testing.T) {
+	pipeReader, pipeWriter := io.Pipe()
+	var reader = readers.NewIdleTimeoutReaderCloser(pipeReader, 200*time.Millisecond)
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	go func() {
+		// 每次写入的间隔都小于超时时间
+		for i := 0; i < 5; i++ {
+			time.Sleep(100 * time.Millisecond)
+			_, err := pipeWriter.Write([]byte("data"))
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var buf = make([]byte, 16)
+	for i := 0; i < 5; i++ {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "data" {
+			t.Fatal("unexpected data:", string(buf[:n]))
+		}
+	}
+
+	// 超时没有数据
+	_, err := reader.Read(buf)
+	if err != readers.ErrIdleTimeout {
+		t.Fatal("expect idle timeout, got", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package segments
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// Entry 读取到的条目
+// 条目内容是段文件中的一段连续区域，可以直接通过文件和偏移量读取
+type Entry struct {
+	seg        *segment
+	offset     int64
+	size       int64
+	expiresAt  int64
+	modifiedAt int64
+
+	isClosed int32
+}
+
+// Size 内容尺寸
+func (this *Entry) Size() int64 {
+	return this.size
+}
+
+// ExpiresAt 过期时间
+func (this *Entry) ExpiresAt() int64 {
+	return this.expiresAt
+}
+
+// ModifiedAt 写入时间
+func (this *Entry) ModifiedAt() int64 {
+	return this.modifiedAt
+}
+
+// File 内容所在的段文件
+func (this *Entry) File() *os.File {
+	return this.seg.fp
+}
+
+// Offset 内容在段文件中的位置
+func (this *Entry) Offset() int64 {
+	return this.offset
+}
+
+// ReadAt 读取从 off 开始的内容
+func (this *Entry) ReadAt(p []byte, off int64) (n int, err error) {
+	if off >= this.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > this.size-off {
+		p = p[:this.size-off]
+		n, err = this.seg.fp.ReadAt(p, this.offset+off)
+		if err == nil {
+			err = io.EOF
+		}
+		return
+	}
+	return this.seg.fp.ReadAt(p, this.offset+off)
+}
+
+// Close 结束读取
+func (this *Entry) Close() error {
+	if atomic.CompareAndSwapInt32(&this.isClosed, 0, 1) {
+		this.seg.release()
+	}
+	return nil
+}
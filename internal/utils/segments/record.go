@@ -0,0 +1,116 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package segments
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// 记录结构：
+//
+//	[magic 2] | [flags 1] | [key length 2] | [value length 4] | [expires at 8] | [modified at 8] | [crc32 4] | [key] | [value]
+//
+// crc32 是对 crc32 之前的Header和 key、value 的校验
+const (
+	recordMagic      uint16 = 0x4753 // GS
+	recordHeaderSize        = 2 + 1 + 2 + 4 + 8 + 8 + 4
+
+	recordFlagTombstone byte = 1 // 删除标记
+
+	MaxKeySize = 1<<16 - 1
+)
+
+var errInvalidRecord = errors.New("invalid record")
+
+type recordHeader struct {
+	flags      byte
+	keySize    int
+	valueSize  int64
+	expiresAt  int64
+	modifiedAt int64
+	crc        uint32
+}
+
+func (this *recordHeader) IsTombstone() bool {
+	return this.flags&recordFlagTombstone == recordFlagTombstone
+}
+
+func (this *recordHeader) RecordSize() int64 {
+	return int64(recordHeaderSize+this.keySize) + this.valueSize
+}
+
+// 编码一条完整的记录
+func encodeRecord(flags byte, key string, value []byte, expiresAt int64, modifiedAt int64) []byte {
+	var buf = make([]byte, recordHeaderSize+len(key)+len(value))
+	binary.BigEndian.PutUint16(buf[0:], recordMagic)
+	buf[2] = flags
+	binary.BigEndian.PutUint16(buf[3:], uint16(len(key)))
+	binary.BigEndian.PutUint32(buf[5:], uint32(len(value)))
+	binary.BigEndian.PutUint64(buf[9:], uint64(expiresAt))
+	binary.BigEndian.PutUint64(buf[17:], uint64(modifiedAt))
+	copy(buf[recordHeaderSize:], key)
+	copy(buf[recordHeaderSize+len(key):], value)
+
+	var crc = crc32.NewIEEE()
+	_, _ = crc.Write(buf[:25])
+	_, _ = crc.Write(buf[recordHeaderSize:])
+	binary.BigEndian.PutUint32(buf[25:], crc.Sum32())
+	return buf
+}
+
+// 解析记录Header
+func decodeRecordHeader(buf []byte) (*recordHeader, error) {
+	if len(buf) < recordHeaderSize || binary.BigEndian.Uint16(buf) != recordMagic {
+		return nil, errInvalidRecord
+	}
+	var header = &recordHeader{
+		flags:      buf[2],
+		keySize:    int(binary.BigEndian.Uint16(buf[3:])),
+		valueSize:  int64(binary.BigEndian.Uint32(buf[5:])),
+		expiresAt:  int64(binary.BigEndian.Uint64(buf[9:])),
+		modifiedAt: int64(binary.BigEndian.Uint64(buf[17:])),
+		crc:        binary.BigEndian.Uint32(buf[25:]),
+	}
+	if header.keySize == 0 {
+		return nil, errInvalidRecord
+	}
+	return header, nil
+}
+
+// 读取 offset 处的记录
+// verify 为 true 时读取全部内容并校验crc32，否则只读取Header和Key
+func readRecord(r io.ReaderAt, offset int64, verify bool) (header *recordHeader, key string, err error) {
+	var headerBuf = make([]byte, recordHeaderSize)
+	_, err = r.ReadAt(headerBuf, offset)
+	if err != nil {
+		return nil, "", err
+	}
+	header, err = decodeRecordHeader(headerBuf)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var readSize = int64(header.keySize)
+	if verify {
+		readSize += header.valueSize
+	}
+	var data = make([]byte, readSize)
+	_, err = r.ReadAt(data, offset+recordHeaderSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if verify {
+		var crc = crc32.NewIEEE()
+		_, _ = crc.Write(headerBuf[:25])
+		_, _ = crc.Write(data)
+		if crc.Sum32() != header.crc {
+			return nil, "", errInvalidRecord
+		}
+	}
+
+	return header, string(data[:header.keySize]), nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package segments
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const segmentFileSuffix = ".seg"
+
+// 段文件
+// 只能在末尾追加记录，已写满的段文件只读
+type segment struct {
+	id   int64
+	path string
+	fp   *os.File
+
+	size    int64 // 已写入的尺寸
+	garbage int64 // 已失效的记录尺寸
+
+	refs      int32 // 正在读取的数量
+	isRemoved int32
+}
+
+func segmentPath(dir string, id int64) string {
+	return filepath.Join(dir, strconv.FormatInt(id, 10)+segmentFileSuffix)
+}
+
+// 从文件名中解析段ID
+func parseSegmentId(filename string) (id int64, ok bool) {
+	if !strings.HasSuffix(filename, segmentFileSuffix) {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(strings.TrimSuffix(filename, segmentFileSuffix), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+func openSegment(dir string, id int64) (*segment, error) {
+	var path = segmentPath(dir, id)
+	fp, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fp.Stat()
+	if err != nil {
+		_ = fp.Close()
+		return nil, err
+	}
+	return &segment{
+		id:   id,
+		path: path,
+		fp:   fp,
+		size: stat.Size(),
+	}, nil
+}
+
+// 增加引用
+func (this *segment) acquire() {
+	atomic.AddInt32(&this.refs, 1)
+}
+
+// 释放引用，已删除的段文件在没有引用后关闭
+func (this *segment) release() {
+	if atomic.AddInt32(&this.refs, -1) == 0 && atomic.LoadInt32(&this.isRemoved) == 1 {
+		_ = this.fp.Close()
+	}
+}
+
+// 删除段文件
+// 已经打开的读取不受影响，在最后一个读取结束后关闭文件
+func (this *segment) remove() error {
+	atomic.StoreInt32(&this.isRemoved, 1)
+	var err = os.Remove(this.path)
+	if atomic.LoadInt32(&this.refs) == 0 {
+		_ = this.fp.Close()
+	}
+	return err
+}
+
+// 截断文件
+func (this *segment) truncate(size int64) error {
+	err := this.fp.Truncate(size)
+	if err != nil {
+		return err
+	}
+	this.size = size
+	return nil
+}
+
+func (this *segment) garbageRatio() float64 {
+	if this.size <= 0 {
+		return 0
+	}
+	return float64(this.garbage) / float64(this.size)
+}
@@ -0,0 +1,565 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package segments
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultSegmentSize int64 = 256 << 20
+
+var ErrNotFound = errors.New("segment item not found")
+var ErrStoreClosed = errors.New("segment store closed")
+
+// Options 存储选项
+type Options struct {
+	SegmentSize int64 // 单个段文件最大尺寸
+}
+
+// Stat 统计信息
+type Stat struct {
+	Count       int   // 有效条目数
+	Segments    int   // 段文件数
+	TotalSize   int64 // 段文件总尺寸
+	GarbageSize int64 // 已失效的记录尺寸
+}
+
+// 索引中的条目
+type item struct {
+	segmentId  int64
+	offset     int64 // 记录在段文件中的位置
+	recordSize int64
+	valueSize  int64
+	expiresAt  int64
+	modifiedAt int64
+}
+
+func (this *item) valueOffset(keySize int) int64 {
+	return this.offset + recordHeaderSize + int64(keySize)
+}
+
+// Store 段文件存储
+// 把小对象依次追加到较大的段文件中，在内存中保存每个Key对应的位置；
+// 启动时通过扫描段文件重建索引，最后一个段文件末尾不完整的记录会被截断
+type Store struct {
+	dir     string
+	options *Options
+
+	locker   sync.RWMutex
+	segments map[int64]*segment
+	active   *segment
+	maxId    int64
+
+	index      map[string]*item
+	tombstones map[string]int64 // key => 删除标记所在段ID，用来在压缩时保留仍然需要的删除标记
+
+	isClosed bool
+}
+
+// Open 打开存储目录
+func Open(dir string, options *Options) (*Store, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultSegmentSize
+	}
+
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return nil, err
+	}
+
+	var store = &Store{
+		dir:        dir,
+		options:    options,
+		segments:   map[int64]*segment{},
+		index:      map[string]*item{},
+		tombstones: map[string]int64{},
+	}
+	err = store.load()
+	if err != nil {
+		store.closeFiles()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Put 写入条目
+func (this *Store) Put(key string, expiresAt int64, value []byte) error {
+	if len(key) == 0 || len(key) > MaxKeySize {
+		return errors.New("invalid key size")
+	}
+	if int64(len(value)) > this.options.SegmentSize {
+		return errors.New("value too large")
+	}
+
+	var record = encodeRecord(0, key, value, expiresAt, time.Now().Unix())
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if this.isClosed {
+		return ErrStoreClosed
+	}
+
+	segmentId, offset, err := this.appendRecord(record)
+	if err != nil {
+		return err
+	}
+	this.setItem(key, &item{
+		segmentId:  segmentId,
+		offset:     offset,
+		recordSize: int64(len(record)),
+		valueSize:  int64(len(value)),
+		expiresAt:  expiresAt,
+		modifiedAt: time.Now().Unix(),
+	})
+	delete(this.tombstones, key)
+	return nil
+}
+
+// Get 读取条目
+// useStale 为 true 时可以读取已过期但还没有被清除的条目；读取结束后需要调用 Entry.Close()
+func (this *Store) Get(key string, useStale bool) (*Entry, error) {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	if this.isClosed {
+		return nil, ErrStoreClosed
+	}
+
+	i, ok := this.index[key]
+	if !ok || (!useStale && i.expiresAt < time.Now().Unix()) {
+		return nil, ErrNotFound
+	}
+	seg, ok := this.segments[i.segmentId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	seg.acquire()
+	return &Entry{
+		seg:        seg,
+		offset:     i.valueOffset(len(key)),
+		size:       i.valueSize,
+		expiresAt:  i.expiresAt,
+		modifiedAt: i.modifiedAt,
+	}, nil
+}
+
+// Exist 检查条目是否存在
+func (this *Store) Exist(key string) bool {
+	this.locker.RLock()
+	i, ok := this.index[key]
+	this.locker.RUnlock()
+	return ok && i.expiresAt >= time.Now().Unix()
+}
+
+// Delete 删除条目
+func (this *Store) Delete(key string) error {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if this.isClosed {
+		return ErrStoreClosed
+	}
+
+	_, ok := this.index[key]
+	if !ok {
+		return nil
+	}
+	return this.deleteKey(key)
+}
+
+// DeletePrefix 删除以某个前缀开头的所有条目
+func (this *Store) DeletePrefix(prefix string) (count int, err error) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if this.isClosed {
+		return 0, ErrStoreClosed
+	}
+
+	for key := range this.index {
+		if strings.HasPrefix(key, prefix) {
+			err = this.deleteKey(key)
+			if err != nil {
+				return
+			}
+			count++
+		}
+	}
+	return
+}
+
+// PurgeExpired 从索引中清除过期的条目
+// 过期的记录在重建索引时会被忽略，所以不需要写入删除标记
+func (this *Store) PurgeExpired(limit int) (count int) {
+	var now = time.Now().Unix()
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	for key, i := range this.index {
+		if i.expiresAt < now {
+			this.removeItem(key, i)
+			count++
+			if limit > 0 && count >= limit {
+				break
+			}
+		}
+	}
+	return
+}
+
+// Compact 压缩段文件
+// 将失效记录比例超过 garbageRatio 的只读段文件中的有效记录复制到当前段文件，然后删除旧的段文件
+func (this *Store) Compact(garbageRatio float64) (compacted int, err error) {
+	this.locker.RLock()
+	var candidates = []*segment{}
+	for _, seg := range this.segments {
+		if seg != this.active && seg.garbageRatio() >= garbageRatio {
+			candidates = append(candidates, seg)
+		}
+	}
+	this.locker.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].id < candidates[j].id
+	})
+
+	for _, seg := range candidates {
+		err = this.compactSegment(seg)
+		if err != nil {
+			return
+		}
+		compacted++
+	}
+	return
+}
+
+// Stat 统计信息
+func (this *Store) Stat() *Stat {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	var stat = &Stat{
+		Count:    len(this.index),
+		Segments: len(this.segments),
+	}
+	for _, seg := range this.segments {
+		stat.TotalSize += seg.size
+		stat.GarbageSize += seg.garbage
+	}
+	return stat
+}
+
+// Keys 遍历所有的Key
+func (this *Store) Keys(f func(key string) bool) {
+	this.locker.RLock()
+	var keys = make([]string, 0, len(this.index))
+	for key := range this.index {
+		keys = append(keys, key)
+	}
+	this.locker.RUnlock()
+
+	for _, key := range keys {
+		if !f(key) {
+			return
+		}
+	}
+}
+
+// Reset 清除所有条目和段文件
+func (this *Store) Reset() error {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if this.isClosed {
+		return ErrStoreClosed
+	}
+
+	for _, seg := range this.segments {
+		_ = seg.remove()
+	}
+	this.segments = map[int64]*segment{}
+	this.active = nil
+	this.index = map[string]*item{}
+	this.tombstones = map[string]int64{}
+	return nil
+}
+
+// Close 关闭存储
+func (this *Store) Close() error {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if this.isClosed {
+		return nil
+	}
+	this.isClosed = true
+
+	if this.active != nil {
+		_ = this.active.fp.Sync()
+	}
+	this.closeFiles()
+	return nil
+}
+
+// 从段文件中重建索引
+func (this *Store) load() error {
+	dirEntries, err := os.ReadDir(this.dir)
+	if err != nil {
+		return err
+	}
+
+	var ids = []int64{}
+	for _, entry := range dirEntries {
+		if entry.IsDir() {
+			continue
+		}
+		id, ok := parseSegmentId(entry.Name())
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	var now = time.Now().Unix()
+	for index, id := range ids {
+		seg, err := openSegment(this.dir, id)
+		if err != nil {
+			return err
+		}
+		this.segments[id] = seg
+		this.maxId = id
+
+		// 只有最后一个段文件可能有未写完的记录，需要完整校验
+		var isLast = index == len(ids)-1
+		var offset int64
+		for offset < seg.size {
+			header, key, err := readRecord(seg.fp, offset, isLast)
+			if err != nil || offset+header.RecordSize() > seg.size {
+				break
+			}
+
+			var recordSize = header.RecordSize()
+			if header.IsTombstone() {
+				oldItem, ok := this.index[key]
+				if ok {
+					this.removeItem(key, oldItem)
+				}
+				this.tombstones[key] = id
+				seg.garbage += recordSize
+			} else if header.expiresAt < now {
+				seg.garbage += recordSize
+			} else {
+				this.setItem(key, &item{
+					segmentId:  id,
+					offset:     offset,
+					recordSize: recordSize,
+					valueSize:  header.valueSize,
+					expiresAt:  header.expiresAt,
+					modifiedAt: header.modifiedAt,
+				})
+				delete(this.tombstones, key)
+			}
+			offset += recordSize
+		}
+
+		// 截断不完整的记录
+		if offset < seg.size {
+			err = seg.truncate(offset)
+			if err != nil {
+				return err
+			}
+		}
+
+		if isLast {
+			this.active = seg
+		}
+	}
+
+	return nil
+}
+
+// 在当前段文件末尾追加记录
+func (this *Store) appendRecord(record []byte) (segmentId int64, offset int64, err error) {
+	if this.active == nil || this.active.size+int64(len(record)) > this.options.SegmentSize {
+		err = this.rotate()
+		if err != nil {
+			return
+		}
+	}
+
+	var seg = this.active
+	offset = seg.size
+	_, err = seg.fp.WriteAt(record, offset)
+	if err != nil {
+		// 防止写入一半的数据影响后续的记录
+		_ = seg.truncate(offset)
+		return
+	}
+	seg.size += int64(len(record))
+	return seg.id, offset, nil
+}
+
+// 创建新的段文件
+func (this *Store) rotate() error {
+	seg, err := openSegment(this.dir, this.maxId+1)
+	if err != nil {
+		return err
+	}
+	if this.active != nil {
+		_ = this.active.fp.Sync()
+	}
+	this.maxId = seg.id
+	this.segments[seg.id] = seg
+	this.active = seg
+	return nil
+}
+
+func (this *Store) setItem(key string, i *item) {
+	oldItem, ok := this.index[key]
+	if ok {
+		this.markGarbage(oldItem)
+	}
+	this.index[key] = i
+}
+
+func (this *Store) removeItem(key string, i *item) {
+	this.markGarbage(i)
+	delete(this.index, key)
+}
+
+func (this *Store) markGarbage(i *item) {
+	seg, ok := this.segments[i.segmentId]
+	if ok {
+		seg.garbage += i.recordSize
+	}
+}
+
+// 写入删除标记并从索引中删除
+func (this *Store) deleteKey(key string) error {
+	var record = encodeRecord(recordFlagTombstone, key, nil, 0, time.Now().Unix())
+	segmentId, _, err := this.appendRecord(record)
+	if err != nil {
+		return err
+	}
+
+	i, ok := this.index[key]
+	if ok {
+		this.removeItem(key, i)
+	}
+	this.tombstones[key] = segmentId
+
+	// 删除标记本身不包含有效数据
+	this.segments[segmentId].garbage += int64(len(record))
+	return nil
+}
+
+// 压缩单个段文件
+func (this *Store) compactSegment(seg *segment) error {
+	// 找出仍然有效的条目和删除标记
+	this.locker.RLock()
+	var liveKeys = map[string]*item{}
+	for key, i := range this.index {
+		if i.segmentId == seg.id {
+			liveKeys[key] = i
+		}
+	}
+	var hasOlderSegments = false
+	for id := range this.segments {
+		if id < seg.id {
+			hasOlderSegments = true
+			break
+		}
+	}
+	var tombstoneKeys = []string{}
+	for key, segmentId := range this.tombstones {
+		if segmentId == seg.id {
+			tombstoneKeys = append(tombstoneKeys, key)
+		}
+	}
+	this.locker.RUnlock()
+
+	// 复制有效的记录，读取时不加锁，写入前再检查条目是否已经改变
+	for key, i := range liveKeys {
+		var record = make([]byte, i.recordSize)
+		_, err := seg.fp.ReadAt(record, i.offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		this.locker.Lock()
+		if this.isClosed {
+			this.locker.Unlock()
+			return ErrStoreClosed
+		}
+		if this.index[key] == i {
+			segmentId, offset, err := this.appendRecord(record)
+			if err != nil {
+				this.locker.Unlock()
+				return err
+			}
+			var newItem = *i
+			newItem.segmentId = segmentId
+			newItem.offset = offset
+			this.setItem(key, &newItem)
+		}
+		this.locker.Unlock()
+	}
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if this.isClosed {
+		return ErrStoreClosed
+	}
+
+	// 更早的段文件中可能还有被删除的Key，需要保留删除标记，防止重建索引时恢复
+	for _, key := range tombstoneKeys {
+		segmentId, ok := this.tombstones[key]
+		if !ok || segmentId != seg.id {
+			continue
+		}
+		if !hasOlderSegments {
+			delete(this.tombstones, key)
+			continue
+		}
+		var record = encodeRecord(recordFlagTombstone, key, nil, 0, time.Now().Unix())
+		newSegmentId, _, err := this.appendRecord(record)
+		if err != nil {
+			return err
+		}
+		this.segments[newSegmentId].garbage += int64(len(record))
+		this.tombstones[key] = newSegmentId
+	}
+
+	// 同步后再删除旧文件，防止复制的数据丢失
+	if this.active != nil {
+		err := this.active.fp.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	delete(this.segments, seg.id)
+	if this.active == seg {
+		this.active = nil
+	}
+	return seg.remove()
+}
+
+func (this *Store) closeFiles() {
+	for _, seg := range this.segments {
+		_ = seg.fp.Close()
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package segments_test
+
+import (
+	"bytes"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/segments"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func readEntry(t *testing.T, store *segments.Store, key string) []byte {
+	entry, err := store.Get(key, false)
+	if err != nil {
+		t.Fatal(key, err)
+	}
+	defer func() {
+		_ = entry.Close()
+	}()
+	var data = make([]byte, entry.Size())
+	_, err = entry.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestStore_PutGet(t *testing.T) {
+	store, err := segments.Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	var expiresAt = time.Now().Unix() + 3600
+	err = store.Put("a", expiresAt, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put("b", expiresAt, []byte("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put("a", expiresAt, []byte("hello2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(readEntry(t, store, "a")) != "hello2" || string(readEntry(t, store, "b")) != "world" {
+		t.Fatal("unexpected value")
+	}
+
+	err = store.Delete("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get("b", false)
+	if err != segments.ErrNotFound {
+		t.Fatal("'b' should be deleted")
+	}
+
+	err = store.Put("c", time.Now().Unix()-1, []byte("expired"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Exist("c") {
+		t.Fatal("'c' should be expired")
+	}
+	if store.PurgeExpired(0) != 1 {
+		t.Fatal("expect 1 expired item")
+	}
+
+	count, err := store.DeletePrefix("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || store.Stat().Count != 0 {
+		t.Fatal("expect all items deleted")
+	}
+}
+
+func TestStore_Recover(t *testing.T) {
+	var dir = t.TempDir()
+	store, err := segments.Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expiresAt = time.Now().Unix() + 3600
+	for i := 0; i < 10; i++ {
+		err = store.Put("key"+strconv.Itoa(i), expiresAt, bytes.Repeat([]byte{byte(i)}, 100))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.Delete("key3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+
+	// 模拟写入到一半时崩溃
+	fp, err := os.OpenFile(filepath.Join(dir, "1.seg"), os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = fp.Write([]byte{0x47, 0x53, 0, 0, 3, 0, 0})
+	_ = fp.Close()
+
+	store, err = segments.Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	if store.Stat().Count != 9 {
+		t.Fatal("expect 9 items, got", store.Stat().Count)
+	}
+	if store.Exist("key3") {
+		t.Fatal("'key3' should be deleted")
+	}
+	if !bytes.Equal(readEntry(t, store, "key9"), bytes.Repeat([]byte{9}, 100)) {
+		t.Fatal("unexpected value")
+	}
+
+	// 截断后可以继续写入
+	err = store.Put("key10", expiresAt, []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(readEntry(t, store, "key10")) != "new" {
+		t.Fatal("unexpected value")
+	}
+}
+
+func TestStore_Compact(t *testing.T) {
+	var dir = t.TempDir()
+	store, err := segments.Open(dir, &segments.Options{SegmentSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expiresAt = time.Now().Unix() + 3600
+	for i := 0; i < 40; i++ {
+		err = store.Put("key"+strconv.Itoa(i%10), expiresAt, bytes.Repeat([]byte{byte(i)}, 100))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.Delete("key0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 正在读取的条目不受压缩影响
+	entry, err := store.Get("key1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var before = store.Stat()
+	compacted, err := store.Compact(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var after = store.Stat()
+	t.Logf("compacted: %d, before: %+v, after: %+v", compacted, before, after)
+	if compacted == 0 || after.TotalSize >= before.TotalSize {
+		t.Fatal("expect segments compacted")
+	}
+
+	var data = make([]byte, entry.Size())
+	_, err = entry.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bytes.Repeat([]byte{31}, 100)) {
+		t.Fatal("unexpected value of opened entry")
+	}
+	_ = entry.Close()
+
+	for i := 1; i < 10; i++ {
+		if !bytes.Equal(readEntry(t, store, "key"+strconv.Itoa(i)), bytes.Repeat([]byte{byte(30 + i)}, 100)) {
+			t.Fatal("unexpected value of key", i)
+		}
+	}
+	_ = store.Close()
+
+	// 压缩后重建索引
+	store, err = segments.Open(dir, &segments.Options{SegmentSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	if store.Exist("key0") {
+		t.Fatal("'key0' should be deleted")
+	}
+	if store.Stat().Count != 9 {
+		t.Fatal("expect 9 items, got", store.Stat().Count)
+	}
+	if !bytes.Equal(readEntry(t, store, "key5"), bytes.Repeat([]byte{35}, 100)) {
+		t.Fatal("unexpected value after reopen")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package sitemaps
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// MaxSize 单个站点地图解压后的最大尺寸
+const MaxSize = 50 << 20
+
+// Sitemap 站点地图解析结果
+type Sitemap struct {
+	URLs     []string // 页面URL
+	Sitemaps []string // 站点地图索引中的子站点地图URL
+}
+
+type xmlLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// 同时兼容 urlset 和 sitemapindex 两种格式
+type xmlSitemap struct {
+	URLs     []xmlLoc `xml:"url"`
+	Sitemaps []xmlLoc `xml:"sitemap"`
+}
+
+// Parse 解析站点地图
+// 支持XML格式（包括站点地图索引）和每行一个URL的文本格式，支持gzip压缩；文本格式中空行和以 # 开头的行会被忽略
+func Parse(reader io.Reader) (*Sitemap, error) {
+	var bufReader = bufio.NewReader(reader)
+	magic, _ := bufReader.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(bufReader)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = gzipReader.Close()
+		}()
+		bufReader = bufio.NewReader(gzipReader)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(bufReader, MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxSize {
+		return nil, errors.New("sitemap is too large")
+	}
+
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte{'<'}) {
+		return parseXML(data)
+	}
+	return parseText(data), nil
+}
+
+func parseXML(data []byte) (*Sitemap, error) {
+	var result = &xmlSitemap{}
+	err := xml.Unmarshal(data, result)
+	if err != nil {
+		return nil, errors.New("decode xml failed: " + err.Error())
+	}
+
+	var sitemap = &Sitemap{}
+	for _, u := range result.URLs {
+		var loc = strings.TrimSpace(u.Loc)
+		if len(loc) > 0 {
+			sitemap.URLs = append(sitemap.URLs, loc)
+		}
+	}
+	for _, s := range result.Sitemaps {
+		var loc = strings.TrimSpace(s.Loc)
+		if len(loc) > 0 {
+			sitemap.Sitemaps = append(sitemap.Sitemaps, loc)
+		}
+	}
+	return sitemap, nil
+}
+
+func parseText(data []byte) *Sitemap {
+	var sitemap = &Sitemap{}
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		sitemap.URLs = append(sitemap.URLs, string(line))
+	}
+	return sitemap
+}
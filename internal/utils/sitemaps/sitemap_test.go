@@ -0,0 +1,66 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package sitemaps_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/sitemaps"
+	"strings"
+	"testing"
+)
+
+func TestParse_URLSet(t *testing.T) {
+	sitemap, err := sitemaps.Parse(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>https://example.com/</loc><lastmod>2023-01-01</lastmod></url>
+	<url><loc> https://example.com/a.html </loc></url>
+</urlset>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sitemap.URLs) != 2 || sitemap.URLs[1] != "https://example.com/a.html" || len(sitemap.Sitemaps) != 0 {
+		t.Fatalf("unexpected result: %+v", sitemap)
+	}
+}
+
+func TestParse_Index(t *testing.T) {
+	var buf = &bytes.Buffer{}
+	var writer = gzip.NewWriter(buf)
+	_, _ = writer.Write([]byte(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>https://example.com/sitemap1.xml</loc></sitemap>
+	<sitemap><loc>https://example.com/sitemap2.xml.gz</loc></sitemap>
+</sitemapindex>`))
+	_ = writer.Close()
+
+	sitemap, err := sitemaps.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sitemap.Sitemaps) != 2 || len(sitemap.URLs) != 0 {
+		t.Fatalf("unexpected result: %+v", sitemap)
+	}
+}
+
+func TestParse_Text(t *testing.T) {
+	sitemap, err := sitemaps.Parse(strings.NewReader(`
+# comment
+https://example.com/a
+
+https://example.com/b
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sitemap.URLs) != 2 || sitemap.URLs[0] != "https://example.com/a" {
+		t.Fatalf("unexpected result: %+v", sitemap)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	_, err := sitemaps.Parse(strings.NewReader(`<urlset><url><loc>https://example.com/</url>`))
+	if err == nil {
+		t.Fatal("invalid xml should fail")
+	}
+	t.Log(err)
+}
@@ -0,0 +1,213 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package snapshots
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// 快照文件结构：
+//
+//	[ magic ] [ record ] [ record ] ...
+//
+// 每条记录：
+//
+//	[record size 4] [crc32 4] [expiresAt 8] [modifiedAt 8] [hits 8] [status 2] [key length 2] [header length 4] [body length 4] [key] [header] [body]
+const (
+	magic             = "GESNAP01"
+	recordPrefixSize  = 8
+	recordHeaderSize  = 8 + 8 + 8 + 2 + 2 + 4 + 4
+	maxRecordSize     = 1 << 30
+	maxKeyLength      = 1<<16 - 1
+	writerBufferSize  = 256 << 10
+	tmpFileNameSuffix = ".tmp"
+)
+
+var ErrInvalidFile = errors.New("invalid snapshot file")
+var ErrBrokenRecord = errors.New("broken snapshot record")
+
+// Record 快照中的一条记录
+type Record struct {
+	Key        string
+	ExpiresAt  int64
+	ModifiedAt int64
+	Hits       int64
+	Status     int
+	Header     []byte
+	Body       []byte
+}
+
+// Writer 快照写入器
+// 先写入临时文件，关闭时再替换正式文件，避免写入中途退出导致快照损坏
+type Writer struct {
+	path   string
+	fp     *os.File
+	writer *bufio.Writer
+
+	count int
+	size  int64
+}
+
+// Create 创建快照文件
+func Create(path string) (*Writer, error) {
+	fp, err := os.OpenFile(path+tmpFileNameSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	var writer = &Writer{
+		path:   path,
+		fp:     fp,
+		writer: bufio.NewWriterSize(fp, writerBufferSize),
+	}
+	_, err = writer.writer.WriteString(magic)
+	if err != nil {
+		_ = writer.Discard()
+		return nil, err
+	}
+	writer.size = int64(len(magic))
+	return writer, nil
+}
+
+// Write 写入一条记录
+func (this *Writer) Write(record *Record) error {
+	if len(record.Key) > maxKeyLength {
+		return errors.New("key is too long")
+	}
+	var recordSize = recordHeaderSize + len(record.Key) + len(record.Header) + len(record.Body)
+	if recordSize > maxRecordSize {
+		return errors.New("record is too large")
+	}
+
+	var header = make([]byte, recordPrefixSize+recordHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(recordSize))
+	binary.BigEndian.PutUint64(header[8:], uint64(record.ExpiresAt))
+	binary.BigEndian.PutUint64(header[16:], uint64(record.ModifiedAt))
+	binary.BigEndian.PutUint64(header[24:], uint64(record.Hits))
+	binary.BigEndian.PutUint16(header[32:], uint16(record.Status))
+	binary.BigEndian.PutUint16(header[34:], uint16(len(record.Key)))
+	binary.BigEndian.PutUint32(header[36:], uint32(len(record.Header)))
+	binary.BigEndian.PutUint32(header[40:], uint32(len(record.Body)))
+
+	var crc = crc32.NewIEEE()
+	_, _ = crc.Write(header[recordPrefixSize:])
+	_, _ = crc.Write([]byte(record.Key))
+	_, _ = crc.Write(record.Header)
+	_, _ = crc.Write(record.Body)
+	binary.BigEndian.PutUint32(header[4:], crc.Sum32())
+
+	for _, data := range [][]byte{header, []byte(record.Key), record.Header, record.Body} {
+		_, err := this.writer.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	this.count++
+	this.size += int64(recordPrefixSize + recordSize)
+	return nil
+}
+
+// Count 已写入的记录数
+func (this *Writer) Count() int {
+	return this.count
+}
+
+// Size 已写入的尺寸
+func (this *Writer) Size() int64 {
+	return this.size
+}
+
+// Close 完成写入并替换正式文件
+func (this *Writer) Close() error {
+	err := this.writer.Flush()
+	if err == nil {
+		err = this.fp.Sync()
+	}
+	var closeErr = this.fp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(this.path + tmpFileNameSuffix)
+		return err
+	}
+	return os.Rename(this.path+tmpFileNameSuffix, this.path)
+}
+
+// Discard 放弃写入
+func (this *Writer) Discard() error {
+	_ = this.fp.Close()
+	return os.Remove(this.path + tmpFileNameSuffix)
+}
+
+// Read 依次读取快照中的记录
+// 遇到损坏的记录时停止读取并返回 ErrBrokenRecord，之前的记录仍然有效；callback 返回false时停止读取
+func Read(path string, callback func(record *Record) (goNext bool)) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	var reader = bufio.NewReaderSize(fp, writerBufferSize)
+	var magicBytes = make([]byte, len(magic))
+	_, err = io.ReadFull(reader, magicBytes)
+	if err != nil || string(magicBytes) != magic {
+		return ErrInvalidFile
+	}
+
+	var prefix = make([]byte, recordPrefixSize)
+	for {
+		_, err = io.ReadFull(reader, prefix)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return ErrBrokenRecord
+		}
+
+		var recordSize = int(binary.BigEndian.Uint32(prefix))
+		if recordSize < recordHeaderSize || recordSize > maxRecordSize {
+			return ErrBrokenRecord
+		}
+		var data = make([]byte, recordSize)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return ErrBrokenRecord
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(prefix[4:]) {
+			return ErrBrokenRecord
+		}
+
+		var keyLength = int(binary.BigEndian.Uint16(data[26:]))
+		var headerLength = int(binary.BigEndian.Uint32(data[28:]))
+		var bodyLength = int(binary.BigEndian.Uint32(data[32:]))
+		if recordHeaderSize+keyLength+headerLength+bodyLength != recordSize {
+			return ErrBrokenRecord
+		}
+
+		var offset = recordHeaderSize
+		var record = &Record{
+			ExpiresAt:  int64(binary.BigEndian.Uint64(data)),
+			ModifiedAt: int64(binary.BigEndian.Uint64(data[8:])),
+			Hits:       int64(binary.BigEndian.Uint64(data[16:])),
+			Status:     int(binary.BigEndian.Uint16(data[24:])),
+			Key:        string(data[offset : offset+keyLength]),
+		}
+		offset += keyLength
+		record.Header = data[offset : offset+headerLength]
+		offset += headerLength
+		record.Body = data[offset : offset+bodyLength]
+
+		if !callback(record) {
+			return nil
+		}
+	}
+}
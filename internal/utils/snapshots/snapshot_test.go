@@ -0,0 +1,97 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package snapshots_test
+
+import (
+	"github.com/TeaOSLab/EdgeNode/internal/utils/snapshots"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestWriter_Read(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "memory.snapshot")
+	writer, err := snapshots.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		err = writer.Write(&snapshots.Record{
+			Key:       "https://example.com/" + strconv.Itoa(i),
+			ExpiresAt: int64(i),
+			Hits:      int64(i * 10),
+			Status:    200,
+			Header:    []byte("Content-Type:text/html\n"),
+			Body:      []byte("hello" + strconv.Itoa(i)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 关闭之前正式文件不存在
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Fatal("snapshot file should not exist before closing")
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count = 0
+	err = snapshots.Read(path, func(record *snapshots.Record) (goNext bool) {
+		if record.Key != "https://example.com/"+strconv.Itoa(count) || record.Hits != int64(count*10) || record.Status != 200 || string(record.Body) != "hello"+strconv.Itoa(count) {
+			t.Fatalf("unexpected record: %+v", record)
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 10 {
+		t.Fatal("expect 10 records, got", count)
+	}
+}
+
+func TestRead_Broken(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "memory.snapshot")
+	writer, err := snapshots.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		err = writer.Write(&snapshots.Record{Key: "key" + strconv.Itoa(i), Body: []byte("value")})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 截断最后一条记录
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Truncate(path, stat.Size()-2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count = 0
+	err = snapshots.Read(path, func(record *snapshots.Record) (goNext bool) {
+		count++
+		return true
+	})
+	if err != snapshots.ErrBrokenRecord {
+		t.Fatal("expect broken record error, got", err)
+	}
+	if count != 2 {
+		t.Fatal("expect 2 records, got", count)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package websockets
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// 帧类型
+const (
+	OpContinuation byte = 0x0
+	OpText         byte = 0x1
+	OpBinary       byte = 0x2
+	OpClose        byte = 0x8
+	OpPing         byte = 0x9
+	OpPong         byte = 0xA
+)
+
+// 关闭代码
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+)
+
+// MaxControlPayloadLength 控制帧最大数据长度
+const MaxControlPayloadLength = 125
+
+var ErrInvalidFrame = errors.New("invalid websocket frame")
+
+// FrameHeader 帧头部
+type FrameHeader struct {
+	Fin     bool
+	Opcode  byte
+	Masked  bool
+	MaskKey [4]byte
+	Length  int64
+
+	Raw []byte // 原始头部数据，用于原样转发
+}
+
+// IsControl 是否为控制帧
+func (this *FrameHeader) IsControl() bool {
+	return this.Opcode&0x8 != 0
+}
+
+// ReadFrameHeader 读取帧头部，读取后 reader 中剩余的数据为帧的数据部分
+func ReadFrameHeader(reader io.Reader) (*FrameHeader, error) {
+	var raw = make([]byte, 2, 14)
+	_, err := io.ReadFull(reader, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var header = &FrameHeader{
+		Fin:    raw[0]&0x80 != 0,
+		Opcode: raw[0] & 0x0F,
+		Masked: raw[1]&0x80 != 0,
+	}
+
+	// 扩展长度
+	var length = int64(raw[1] & 0x7F)
+	var extraLength = 0
+	switch length {
+	case 126:
+		extraLength = 2
+	case 127:
+		extraLength = 8
+	}
+	if header.Masked {
+		extraLength += 4
+	}
+	if extraLength > 0 {
+		raw = raw[:2+extraLength]
+		_, err = io.ReadFull(reader, raw[2:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var offset = 2
+	switch length {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(raw[offset:]))
+		offset += 2
+	case 127:
+		var length64 = binary.BigEndian.Uint64(raw[offset:])
+		if length64 > 1<<63-1 {
+			return nil, ErrInvalidFrame
+		}
+		length = int64(length64)
+		offset += 8
+	}
+	if header.Masked {
+		copy(header.MaskKey[:], raw[offset:offset+4])
+	}
+	header.Length = length
+	header.Raw = raw
+
+	if header.IsControl() && (!header.Fin || length > MaxControlPayloadLength) {
+		return nil, ErrInvalidFrame
+	}
+	return header, nil
+}
+
+// Unmask 解码（或者编码）数据，offset 为 data 在帧数据中的位置
+func Unmask(key [4]byte, data []byte, offset int64) {
+	for i := range data {
+		data[i] ^= key[(offset+int64(i))%4]
+	}
+}
+
+// WriteFrame 写入一个完整的帧
+// 客户端发送给服务端的帧需要使用 mask
+func WriteFrame(writer io.Writer, opcode byte, payload []byte, maskKey *[4]byte) error {
+	var frame = make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+
+	var maskBit byte
+	if maskKey != nil {
+		maskBit = 0x80
+	}
+
+	var length = len(payload)
+	switch {
+	case length <= 125:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+
+	var offset = len(frame)
+	if maskKey != nil {
+		frame = append(frame, maskKey[:]...)
+		offset += 4
+	}
+	frame = append(frame, payload...)
+	if maskKey != nil {
+		Unmask(*maskKey, frame[offset:], 0)
+	}
+
+	_, err := writer.Write(frame)
+	return err
+}
+
+// ClosePayload 构造关闭帧的数据
+func ClosePayload(code int, reason string) []byte {
+	var payload = make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	if len(payload)+len(reason) > MaxControlPayloadLength {
+		reason = reason[:MaxControlPayloadLength-len(payload)]
+	}
+	return append(payload, reason...)
+}
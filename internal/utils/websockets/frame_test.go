@@ -0,0 +1,102 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package websockets_test
+
+import (
+	"bytes"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/websockets"
+	"io"
+	"testing"
+)
+
+func TestWriteFrame_ReadFrameHeader(t *testing.T) {
+	for _, length := range []int{0, 10, 125, 126, 1000, 70000} {
+		for _, maskKey := range []*[4]byte{nil, {1, 2, 3, 4}} {
+			var payload = bytes.Repeat([]byte{'a'}, length)
+			var buf = &bytes.Buffer{}
+			err := websockets.WriteFrame(buf, websockets.OpBinary, payload, maskKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var frameLength = buf.Len()
+
+			header, err := websockets.ReadFrameHeader(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !header.Fin || header.Opcode != websockets.OpBinary || header.Length != int64(length) || header.Masked != (maskKey != nil) {
+				t.Fatalf("unexpected header: %+v", header)
+			}
+			if len(header.Raw)+buf.Len() != frameLength {
+				t.Fatal("unexpected raw header length:", len(header.Raw))
+			}
+
+			data, err := io.ReadAll(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Masked {
+				websockets.Unmask(header.MaskKey, data, 0)
+			}
+			if !bytes.Equal(data, payload) {
+				t.Fatal("unexpected payload")
+			}
+		}
+	}
+}
+
+func TestReadFrameHeader_Invalid(t *testing.T) {
+	// 控制帧不能超过125字节
+	var buf = &bytes.Buffer{}
+	_ = websockets.WriteFrame(buf, websockets.OpPing, make([]byte, 126), nil)
+	_, err := websockets.ReadFrameHeader(buf)
+	if err != websockets.ErrInvalidFrame {
+		t.Fatal("expect invalid frame error, got", err)
+	}
+
+	// 数据不完整
+	_, err = websockets.ReadFrameHeader(bytes.NewReader([]byte{0x82, 126, 0}))
+	if err == nil {
+		t.Fatal("expect error")
+	}
+}
+
+func TestClosePayload(t *testing.T) {
+	var payload = websockets.ClosePayload(websockets.CloseMessageTooBig, "too big")
+	if payload[0] != 0x03 || payload[1] != 0xF1 || string(payload[2:]) != "too big" {
+		t.Fatal("unexpected payload:", payload)
+	}
+	if len(websockets.ClosePayload(websockets.CloseNormal, string(make([]byte, 200)))) != websockets.MaxControlPayloadLength {
+		t.Fatal("close payload should be truncated")
+	}
+}
+
+func TestMessageLimiter(t *testing.T) {
+	{
+		var limiter = websockets.NewMessageLimiter(100, 0)
+		if limiter.Check(&websockets.FrameHeader{Opcode: websockets.OpText, Length: 60}) != nil {
+			t.Fatal("should pass")
+		}
+		if limiter.Check(&websockets.FrameHeader{Opcode: websockets.OpPing, Fin: true, Length: 60}) != nil {
+			t.Fatal("control frames should pass")
+		}
+		if limiter.Check(&websockets.FrameHeader{Opcode: websockets.OpContinuation, Fin: true, Length: 60}) != websockets.ErrMessageTooBig {
+			t.Fatal("fragmented message should be counted as a whole")
+		}
+		if limiter.Check(&websockets.FrameHeader{Opcode: websockets.OpText, Fin: true, Length: 60}) != nil {
+			t.Fatal("new message should pass")
+		}
+	}
+
+	{
+		var limiter = websockets.NewMessageLimiter(0, 3)
+		for i := 0; i < 3; i++ {
+			if limiter.Check(&websockets.FrameHeader{Opcode: websockets.OpText, Fin: true, Length: 1}) != nil {
+				t.Fatal("should pass")
+			}
+		}
+		if limiter.Check(&websockets.FrameHeader{Opcode: websockets.OpText, Fin: true, Length: 1}) != websockets.ErrTooManyMessages {
+			t.Fatal("expect too many messages")
+		}
+	}
+}
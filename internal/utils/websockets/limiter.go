@@ -0,0 +1,62 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package websockets
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrMessageTooBig = errors.New("websocket message too big")
+var ErrTooManyMessages = errors.New("too many websocket messages")
+
+// MessageLimiter 单个连接的消息尺寸和频率限制
+// 消息可能分成多个帧发送，尺寸按照整个消息计算
+type MessageLimiter struct {
+	maxMessageSize       int64
+	maxMessagesPerSecond int
+
+	messageSize int64
+
+	windowStart   time.Time
+	countInWindow int
+}
+
+// NewMessageLimiter 获取新对象，参数为0表示不限制
+func NewMessageLimiter(maxMessageSize int64, maxMessagesPerSecond int) *MessageLimiter {
+	return &MessageLimiter{
+		maxMessageSize:       maxMessageSize,
+		maxMessagesPerSecond: maxMessagesPerSecond,
+	}
+}
+
+// Check 检查一个帧是否超出限制
+// 控制帧不计入限制
+func (this *MessageLimiter) Check(header *FrameHeader) error {
+	if header.IsControl() {
+		return nil
+	}
+
+	// 新消息
+	if header.Opcode != OpContinuation {
+		this.messageSize = 0
+
+		if this.maxMessagesPerSecond > 0 {
+			var now = time.Now()
+			if now.Sub(this.windowStart) >= time.Second {
+				this.windowStart = now
+				this.countInWindow = 0
+			}
+			this.countInWindow++
+			if this.countInWindow > this.maxMessagesPerSecond {
+				return ErrTooManyMessages
+			}
+		}
+	}
+
+	this.messageSize += header.Length
+	if this.maxMessageSize > 0 && this.messageSize > this.maxMessageSize {
+		return ErrMessageTooBig
+	}
+	return nil
+}
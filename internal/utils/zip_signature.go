@@ -0,0 +1,187 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package utils
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	ZipChecksumsFile  = "SHA256SUMS"     // 安装包中的文件清单
+	ZipSignatureFile  = "SHA256SUMS.sig" // 文件清单的签名
+	maxZipChecksumLen = 1 << 20
+)
+
+// ParseEd25519PublicKey 解析Base64编码的Ed25519公钥
+func ParseEd25519PublicKey(publicKeyString string) (ed25519.PublicKey, error) {
+	publicKeyString = strings.TrimSpace(publicKeyString)
+	if len(publicKeyString) == 0 {
+		return nil, errors.New("public key should not be empty")
+	}
+	data, err := base64.StdEncoding.DecodeString(publicKeyString)
+	if err != nil {
+		return nil, errors.New("decode public key failed: " + err.Error())
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key size")
+	}
+	return data, nil
+}
+
+// ZipSignatureVerifier 校验安装包的签名
+// 安装包中需要包含文件清单 SHA256SUMS 和使用Ed25519私钥对清单的签名 SHA256SUMS.sig，
+// 清单中每行的格式为 "SHA256  文件名"，安装包中除了这两个文件以外的所有文件都必须在清单中且校验和一致
+type ZipSignatureVerifier struct {
+	zipFile   string
+	publicKey ed25519.PublicKey
+	prefix    string
+}
+
+// NewZipSignatureVerifier 获取新对象
+// prefix 为清单和签名文件在安装包中所在的目录，比如 "edge-node/"
+func NewZipSignatureVerifier(zipFile string, publicKey ed25519.PublicKey, prefix string) *ZipSignatureVerifier {
+	return &ZipSignatureVerifier{
+		zipFile:   zipFile,
+		publicKey: publicKey,
+		prefix:    prefix,
+	}
+}
+
+// Verify 校验签名和文件
+func (this *ZipSignatureVerifier) Verify() error {
+	if len(this.publicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+
+	reader, err := zip.OpenReader(this.zipFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	var fileMap = map[string]*zip.File{} // name => file
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		fileMap[file.Name] = file
+	}
+
+	// 校验清单签名
+	var checksumsFile = fileMap[this.prefix+ZipChecksumsFile]
+	var signatureFile = fileMap[this.prefix+ZipSignatureFile]
+	if checksumsFile == nil || signatureFile == nil {
+		return errors.New("package is not signed")
+	}
+	checksumsData, err := this.readFile(checksumsFile)
+	if err != nil {
+		return err
+	}
+	signatureData, err := this.readFile(signatureFile)
+	if err != nil {
+		return err
+	}
+	signature, err := this.decodeSignature(signatureData)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(this.publicKey, checksumsData, signature) {
+		return errors.New("invalid package signature")
+	}
+
+	// 校验文件
+	var checksumMap = map[string]string{} // name => sha256
+	var scanner = bufio.NewScanner(bytes.NewReader(checksumsData))
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		var pieces = strings.Fields(line)
+		if len(pieces) != 2 {
+			return errors.New("invalid checksum line '" + line + "'")
+		}
+		checksumMap[this.prefix+strings.TrimPrefix(pieces[1], "*")] = strings.ToLower(pieces[0])
+	}
+
+	for name, file := range fileMap {
+		if name == checksumsFile.Name || name == signatureFile.Name {
+			continue
+		}
+		expectedSum, ok := checksumMap[name]
+		if !ok {
+			return errors.New("unsigned file '" + name + "'")
+		}
+		delete(checksumMap, name)
+
+		sum, err := this.sumFile(file)
+		if err != nil {
+			return err
+		}
+		if sum != expectedSum {
+			return errors.New("checksum mismatch for file '" + name + "'")
+		}
+	}
+	for name := range checksumMap {
+		return errors.New("missing file '" + name + "'")
+	}
+
+	return nil
+}
+
+func (this *ZipSignatureVerifier) readFile(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > maxZipChecksumLen {
+		return nil, errors.New("file '" + file.Name + "' is too large")
+	}
+	fileReader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = fileReader.Close()
+	}()
+	return io.ReadAll(io.LimitReader(fileReader, maxZipChecksumLen))
+}
+
+func (this *ZipSignatureVerifier) sumFile(file *zip.File) (string, error) {
+	fileReader, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = fileReader.Close()
+	}()
+
+	var h = sha256.New()
+	_, err = io.Copy(h, fileReader)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 签名可以是原始数据或者Base64编码
+func (this *ZipSignatureVerifier) decodeSignature(data []byte) ([]byte, error) {
+	if len(data) == ed25519.SignatureSize {
+		return data, nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.New("decode signature failed: " + err.Error())
+	}
+	if len(signature) != ed25519.SignatureSize {
+		return nil, errors.New("invalid signature size")
+	}
+	return signature, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Liuxiangchao iwind.liu@gmail.com. All rights reserved.
+
+package utils
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createSignedZip(t *testing.T, privateKey ed25519.PrivateKey, files map[string]string, extraFiles map[string]string) string {
+	var checksums = ""
+	for name, content := range files {
+		var sum = sha256.Sum256([]byte(content))
+		checksums += hex.EncodeToString(sum[:]) + "  " + name + "\n"
+	}
+	var signature = ed25519.Sign(privateKey, []byte(checksums))
+
+	var path = filepath.Join(t.TempDir(), "edge-node.zip")
+	fp, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var writer = zip.NewWriter(fp)
+	var addFile = func(name string, content string) {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range files {
+		addFile("edge-node/"+name, content)
+	}
+	for name, content := range extraFiles {
+		addFile("edge-node/"+name, content)
+	}
+	addFile("edge-node/"+ZipChecksumsFile, checksums)
+	addFile("edge-node/"+ZipSignatureFile, base64.StdEncoding.EncodeToString(signature))
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = fp.Close()
+	return path
+}
+
+func TestZipSignatureVerifier_Verify(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var files = map[string]string{
+		"bin/edge-node":    "binary",
+		"configs/api.yaml": "config",
+	}
+
+	// 正常
+	{
+		var path = createSignedZip(t, privateKey, files, nil)
+		err = NewZipSignatureVerifier(path, publicKey, "edge-node/").Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 额外的文件
+	{
+		var path = createSignedZip(t, privateKey, files, map[string]string{"bin/evil": "evil"})
+		err = NewZipSignatureVerifier(path, publicKey, "edge-node/").Verify()
+		if err == nil {
+			t.Fatal("unsigned file should be rejected")
+		}
+		t.Log(err)
+	}
+
+	// 其他的密钥
+	{
+		otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var path = createSignedZip(t, privateKey, files, nil)
+		err = NewZipSignatureVerifier(path, otherPublicKey, "edge-node/").Verify()
+		if err == nil {
+			t.Fatal("signature should be invalid")
+		}
+		t.Log(err)
+	}
+}
+
+func TestParseEd25519PublicKey(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseEd25519PublicKey(base64.StdEncoding.EncodeToString(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(publicKey) {
+		t.Fatal("key mismatch")
+	}
+
+	_, err = ParseEd25519PublicKey("")
+	if err == nil {
+		t.Fatal("empty key should be rejected")
+	}
+}
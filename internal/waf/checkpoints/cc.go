@@ -1,12 +1,11 @@
 package checkpoints
 
 import (
-	"github.com/TeaOSLab/EdgeNode/internal/ttlcache"
+	"github.com/TeaOSLab/EdgeNode/internal/counters"
 	"github.com/TeaOSLab/EdgeNode/internal/waf/requests"
 	"github.com/iwind/TeaGo/maps"
 	"github.com/iwind/TeaGo/types"
 	"regexp"
-	"sync"
 	"time"
 )
 
@@ -14,34 +13,15 @@ import (
 // TODO implement more traffic rules
 type CCCheckpoint struct {
 	Checkpoint
-
-	cache *ttlcache.Cache
-	once  sync.Once
 }
 
 func (this *CCCheckpoint) Init() {
 
 }
 
-func (this *CCCheckpoint) Start() {
-	if this.cache != nil {
-		this.cache.Destroy()
-	}
-	this.cache = ttlcache.NewCache()
-}
-
 func (this *CCCheckpoint) RequestValue(req requests.Request, param string, options maps.Map, ruleId int64) (value interface{}, hasRequestBody bool, sysErr error, userErr error) {
 	value = 0
 
-	if this.cache == nil {
-		this.once.Do(func() {
-			this.Start()
-		})
-		if this.cache == nil {
-			return
-		}
-	}
-
 	periodString, ok := options["period"]
 	if !ok {
 		return
@@ -114,7 +94,9 @@ func (this *CCCheckpoint) RequestValue(req requests.Request, param string, optio
 		if len(key) == 0 {
 			key = req.WAFRemoteIP()
 		}
-		value = this.cache.IncreaseInt64(types.String(ruleId)+"@"+key, int64(1), time.Now().Unix()+period, false)
+
+		// 计数器可以是本地的，也可以是集群共享的
+		value = counters.SharedCounter().Increase("WAF-CC1-"+types.String(ruleId)+"-"+key, 1, time.Now().Unix()+period, false)
 	}
 
 	return
@@ -201,10 +183,3 @@ func (this *CCCheckpoint) Options() []OptionInterface {
 
 	return options
 }
-
-func (this *CCCheckpoint) Stop() {
-	if this.cache != nil {
-		this.cache.Destroy()
-		this.cache = nil
-	}
-}
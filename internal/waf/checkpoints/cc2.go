@@ -4,7 +4,7 @@ package checkpoints
 
 import (
 	"fmt"
-	"github.com/TeaOSLab/EdgeNode/internal/ttlcache"
+	"github.com/TeaOSLab/EdgeNode/internal/counters"
 	"github.com/TeaOSLab/EdgeNode/internal/waf/requests"
 	"github.com/TeaOSLab/EdgeNode/internal/zero"
 	"github.com/iwind/TeaGo/maps"
@@ -14,8 +14,6 @@ import (
 	"time"
 )
 
-var ccCache = ttlcache.NewCache()
-
 var commonFileExtensionsMap = map[string]zero.Zero{
 	".ico":   zero.New(),
 	".jpg":   zero.New(),
@@ -73,7 +71,9 @@ func (this *CC2Checkpoint) RequestValue(req requests.Request, param string, opti
 
 	var expiresAt = time.Now().Unix() + period
 	var ccKey = "WAF-CC-" + types.String(ruleId) + "-" + strings.Join(keyValues, "@")
-	value = ccCache.IncreaseInt64(ccKey, 1, expiresAt, false)
+	// 计数器可以是本地的，也可以是集群共享的
+	var counter = counters.SharedCounter()
+	value = counter.Increase(ccKey, 1, expiresAt, false)
 
 	// 基于指纹统计
 	var enableFingerprint = true
@@ -92,7 +92,7 @@ func (this *CC2Checkpoint) RequestValue(req requests.Request, param string, opti
 				fpKeyValues = append(fpKeyValues, req.Format(types.String(key)))
 			}
 			var fpCCKey = "WAF-CC-" + types.String(ruleId) + "-" + strings.Join(fpKeyValues, "@")
-			var fpValue = ccCache.IncreaseInt64(fpCCKey, 1, expiresAt, false)
+			var fpValue = counter.Increase(fpCCKey, 1, expiresAt, false)
 			if fpValue > value.(int64) {
 				value = fpValue
 			}
@@ -1,12 +1,27 @@
 package checkpoints
 
 import (
+	"github.com/TeaOSLab/EdgeNode/internal/counters"
 	"github.com/TeaOSLab/EdgeNode/internal/waf/requests"
 	"github.com/iwind/TeaGo/maps"
 	"net/http"
+	"strings"
 	"testing"
 )
 
+type testCCCounter struct {
+	keys []string
+}
+
+func (this *testCCCounter) Increase(key string, delta int64, expiresAt int64, extend bool) int64 {
+	this.keys = append(this.keys, key)
+	return int64(len(this.keys))
+}
+
+func (this *testCCCounter) Close() error {
+	return nil
+}
+
 func TestCCCheckpoint_RequestValue(t *testing.T) {
 	raw, err := http.NewRequest(http.MethodGet, "http://teaos.cn/", nil)
 	if err != nil {
@@ -41,3 +56,32 @@ func TestCCCheckpoint_RequestValue(t *testing.T) {
 	req.WAFRaw().RemoteAddr = "127.0.0.2"
 	t.Log(checkpoint.RequestValue(req, "requests", options, 1))
 }
+
+func TestCCCheckpoint_SharedCounter(t *testing.T) {
+	var counter = &testCCCounter{}
+	counters.SetSharedCounter(counter)
+	defer counters.SetSharedCounter(counters.NewLocalCounter())
+
+	raw, err := http.NewRequest(http.MethodGet, "http://teaos.cn/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := requests.NewTestRequest(raw)
+	req.WAFRaw().RemoteAddr = "127.0.0.1"
+
+	checkpoint := new(CCCheckpoint)
+	checkpoint.Init()
+
+	var options = maps.Map{
+		"period": "5",
+	}
+	for i := 1; i <= 3; i++ {
+		value, _, _, _ := checkpoint.RequestValue(req, "requests", options, 1)
+		if value != int64(i) {
+			t.Fatal("expect", i, "got", value)
+		}
+	}
+	if len(counter.keys) != 3 || !strings.HasPrefix(counter.keys[0], "WAF-CC1-1-") {
+		t.Fatal("requests should be counted by shared counter:", counter.keys)
+	}
+}
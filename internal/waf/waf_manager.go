@@ -1,9 +1,11 @@
 package waf
 
 import (
+	"encoding/json"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/firewallconfigs"
 	"github.com/TeaOSLab/EdgeNode/internal/errors"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/cespare/xxhash"
 	"strconv"
 	"sync"
 )
@@ -12,14 +14,16 @@ var SharedWAFManager = NewWAFManager()
 
 // WAFManager WAF管理器
 type WAFManager struct {
-	mapping map[int64]*WAF // policyId => WAF
-	locker  sync.RWMutex
+	mapping  map[int64]*WAF   // policyId => WAF
+	versions map[int64]uint64 // policyId => 策略内容哈希
+	locker   sync.RWMutex
 }
 
 // NewWAFManager 获取新对象
 func NewWAFManager() *WAFManager {
 	return &WAFManager{
-		mapping: map[int64]*WAF{},
+		mapping:  map[int64]*WAF{},
+		versions: map[int64]uint64{},
 	}
 }
 
@@ -29,7 +33,28 @@ func (this *WAFManager) UpdatePolicies(policies []*firewallconfigs.HTTPFirewallP
 	defer this.locker.Unlock()
 
 	m := map[int64]*WAF{}
+	var versions = map[int64]uint64{}
 	for _, p := range policies {
+		if p == nil {
+			continue
+		}
+
+		// 没有变化的策略直接使用原有的WAF，不需要重新初始化
+		var version uint64
+		policyJSON, err := json.Marshal(p)
+		if err == nil {
+			version = xxhash.Sum64(policyJSON)
+			oldVersion, ok := this.versions[p.Id]
+			if ok && oldVersion == version {
+				oldWAF, ok := this.mapping[p.Id]
+				if ok {
+					m[p.Id] = oldWAF
+					versions[p.Id] = version
+					continue
+				}
+			}
+		}
+
 		w, err := this.ConvertWAF(p)
 		if w != nil {
 			m[p.Id] = w
@@ -38,8 +63,12 @@ func (this *WAFManager) UpdatePolicies(policies []*firewallconfigs.HTTPFirewallP
 			remotelogs.Error("WAF", "initialize policy '"+strconv.FormatInt(p.Id, 10)+"' failed: "+err.Error())
 			continue
 		}
+		if version > 0 {
+			versions[p.Id] = version
+		}
 	}
 	this.mapping = m
+	this.versions = versions
 }
 
 // FindWAF 查找WAF
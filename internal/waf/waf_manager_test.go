@@ -43,3 +43,38 @@ func TestWAFManager_convert(t *testing.T) {
 
 	logs.PrintAsJSON(w, t)
 }
+
+func TestWAFManager_UpdatePolicies_Reuse(t *testing.T) {
+	var manager = waf.NewWAFManager()
+	var newPolicy = func(name string) *firewallconfigs.HTTPFirewallPolicy {
+		return &firewallconfigs.HTTPFirewallPolicy{
+			Id:   1,
+			IsOn: true,
+			Name: name,
+		}
+	}
+
+	manager.UpdatePolicies([]*firewallconfigs.HTTPFirewallPolicy{newPolicy("a")})
+	var w1 = manager.FindWAF(1)
+	if w1 == nil {
+		t.Fatal("waf should not be nil")
+	}
+
+	// same policy
+	manager.UpdatePolicies([]*firewallconfigs.HTTPFirewallPolicy{newPolicy("a")})
+	if manager.FindWAF(1) != w1 {
+		t.Fatal("waf should be reused")
+	}
+
+	// changed policy
+	manager.UpdatePolicies([]*firewallconfigs.HTTPFirewallPolicy{newPolicy("b")})
+	if manager.FindWAF(1) == w1 {
+		t.Fatal("waf should be rebuilt")
+	}
+
+	// removed policy
+	manager.UpdatePolicies(nil)
+	if manager.FindWAF(1) != nil {
+		t.Fatal("waf should be removed")
+	}
+}